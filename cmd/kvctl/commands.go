@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/felipeascari/kv-store/pkg/client"
+)
+
+const (
+	// watchRetryInterval is the wait before resuming a watch the server ended.
+	watchRetryInterval = time.Second
+	// releaseTimeout bounds the release of a lock once its command is done.
+	releaseTimeout = 10 * time.Second
+	// commandWaitDelay is how long a command gets to exit after being terminated.
+	commandWaitDelay = 10 * time.Second
+)
+
+func runGet(ctx context.Context, a *app, fs *flag.FlagSet, args []string) error {
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+
+	ctx, cancel := a.bound(ctx)
+	defer cancel()
+
+	entry, err := a.client.Get(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return a.out.entry(entry)
+}
+
+// runSet writes a key from its argument, a file or stdin. Values are stored as JSON: the
+// argument is stored as a string unless it is valid JSON, files and stdin must hold JSON.
+// -string stores any value as a string, -content-type stores it byte for byte.
+func runSet(ctx context.Context, a *app, fs *flag.FlagSet, args []string) error {
+	file := fs.String("file", "", "read the value from a file, - for stdin")
+	ttl := fs.Duration("ttl", 0, "expire the key after this duration, rounded up to the second")
+	contentType := fs.String("content-type", "", "store the value byte for byte with this content type instead of as JSON")
+	asString := fs.Bool("string", false, "store the value as a JSON string, without the trailing newline of files and stdin")
+	ifMatch := fs.Int64("if-match", 0, "only write the key if it is at this version")
+	ifNoneMatch := fs.Bool("if-none-match", false, "only write the key if it does not exist")
+	if err := parse(fs, args, 1, 2); err != nil {
+		return err
+	}
+
+	switch {
+	case fs.NArg() == 2 && *file != "":
+		return &usageError{message: "value and -file cannot both be set"}
+	case *asString && *contentType != "":
+		return &usageError{message: "-string and -content-type cannot both be set"}
+	case *ttl < 0:
+		return &usageError{message: "-ttl must not be negative"}
+	case *ifMatch < 0:
+		return &usageError{message: "-if-match must be a positive version"}
+	}
+
+	data, fromArg, err := a.input(fs.Arg(1), *file)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := a.bound(ctx)
+	defer cancel()
+
+	opts := client.SetOptions{TTL: *ttl, IfMatch: *ifMatch, IfNoneMatch: *ifNoneMatch}
+
+	var entry client.Entry
+	if *contentType != "" {
+		entry, err = a.client.SetRaw(ctx, fs.Arg(0), *contentType, data, opts)
+	} else {
+		var value json.RawMessage
+		if value, err = jsonValue(data, fromArg, *asString); err != nil {
+			return err
+		}
+		entry, err = a.client.Set(ctx, fs.Arg(0), value, opts)
+	}
+	if err != nil {
+		return err
+	}
+	return a.out.written(entry)
+}
+
+func runDel(ctx context.Context, a *app, fs *flag.FlagSet, args []string) error {
+	ifMatch := fs.Int64("if-match", 0, "only delete the key if it is at this version")
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+
+	ctx, cancel := a.bound(ctx)
+	defer cancel()
+
+	return a.client.Delete(ctx, fs.Arg(0), *ifMatch)
+}
+
+// runList lists every key starting with the prefix, following the pages of the server.
+func runList(ctx context.Context, a *app, fs *flag.FlagSet, args []string) error {
+	limit := fs.Int("limit", 0, "list at most this many keys, all of them when zero")
+	if err := parse(fs, args, 0, 1); err != nil {
+		return err
+	}
+	if *limit < 0 {
+		return &usageError{message: "-limit must not be negative"}
+	}
+
+	ctx, cancel := a.bound(ctx)
+	defer cancel()
+
+	var keys []string
+	cursor := ""
+	for {
+		pageLimit := 0
+		if *limit > 0 {
+			pageLimit = *limit - len(keys)
+		}
+
+		page, next, err := a.client.List(ctx, fs.Arg(0), cursor, pageLimit)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, page...)
+
+		if next == "" || (*limit > 0 && len(keys) >= *limit) {
+			break
+		}
+		cursor = next
+	}
+	return a.out.keys(keys)
+}
+
+// runWatch prints the changes of the keys starting with the prefix until interrupted,
+// resuming after the last change seen when the server ends the watch.
+func runWatch(ctx context.Context, a *app, fs *flag.FlagSet, args []string) error {
+	after := fs.Int64("revision", -1, "print the changes after this revision first, only new ones when negative")
+	if err := parse(fs, args, 0, 1); err != nil {
+		return err
+	}
+
+	var revision *int64
+	if *after >= 0 {
+		revision = after
+	}
+
+	first := true
+	for {
+		last, err := a.client.Watch(ctx, fs.Arg(0), revision, func(e client.Event) error {
+			defer func() { first = false }()
+			return a.out.event(e, first)
+		})
+		switch {
+		case ctx.Err() != nil:
+			return nil
+		case !errors.Is(err, client.ErrWatchEnded):
+			return err
+		}
+
+		if revision != nil || last > 0 {
+			revision = &last
+		}
+		_, _ = fmt.Fprintf(a.stderr, "kvctl: %v, resuming\n", err)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(watchRetryInterval):
+		}
+	}
+}
+
+// runExport writes the export to a file, through a temporary file so that a failed export,
+// including one the server ends with an error record, leaves the previous file untouched, or
+// to stdout.
+func runExport(ctx context.Context, a *app, fs *flag.FlagSet, args []string) error {
+	file := fs.String("file", "", "write the export to a file instead of stdout")
+	if err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+
+	ctx, cancel := a.bound(ctx)
+	defer cancel()
+
+	if *file == "" || *file == "-" {
+		return a.client.Export(ctx, a.out.w)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(*file), "."+filepath.Base(*file)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if err := a.client.Export(ctx, tmp); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), *file)
+}
+
+func runImport(ctx context.Context, a *app, fs *flag.FlagSet, args []string) error {
+	file := fs.String("file", "", "read the export from a file instead of stdin")
+	mode := fs.String("mode", "overwrite", "overwrite existing keys, or skip-existing to keep them")
+	if err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+
+	r := a.stdin
+	if *file != "" && *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		r = f
+	}
+
+	ctx, cancel := a.bound(ctx)
+	defer cancel()
+
+	result, err := a.client.Import(ctx, r, *mode)
+	if err != nil {
+		return err
+	}
+	return a.out.imported(result)
+}
+
+// runLock runs a command while holding a lock, refreshing its lease until the command exits,
+// and exits with the exit code of the command. The command is terminated if the lock is lost.
+func runLock(ctx context.Context, a *app, fs *flag.FlagSet, args []string) error {
+	ttl := fs.Duration("ttl", 30*time.Second, "lease of the lock, refreshed while the command runs")
+	wait := fs.Duration("wait", 0, "give up when the lock is still held after this duration, wait until interrupted when zero")
+	if err := parse(fs, args, 2, -1); err != nil {
+		return err
+	}
+	if *ttl < time.Second {
+		return &usageError{message: "-ttl must be at least a second"}
+	}
+
+	name, argv := fs.Arg(0), fs.Args()[1:]
+	if argv[0] == "--" {
+		argv = argv[1:]
+	}
+	if len(argv) == 0 {
+		return &usageError{message: "missing command"}
+	}
+
+	lockCtx, cancel := context.WithCancel(ctx)
+	if *wait > 0 {
+		lockCtx, cancel = context.WithTimeout(ctx, *wait)
+	}
+	lease, err := a.client.Lock(lockCtx, name, *ttl)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	runCtx, stop := context.WithCancelCause(ctx)
+	defer stop(nil)
+
+	go func() {
+		if err := <-lease.KeepAlive(runCtx); err != nil {
+			stop(err)
+		}
+	}()
+
+	cmd := exec.CommandContext(runCtx, argv[0], argv[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = a.stdin, a.out.w, a.stderr
+	cmd.Cancel = func() error { return cmd.Process.Signal(syscall.SIGTERM) }
+	cmd.WaitDelay = commandWaitDelay
+	runErr := cmd.Run()
+
+	lost := context.Cause(runCtx)
+	stop(nil)
+
+	if errors.Is(lost, client.ErrLockLost) {
+		return fmt.Errorf("%w, %s was terminated", lost, argv[0])
+	}
+
+	releaseCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), releaseTimeout)
+	defer cancel()
+	if err := lease.Release(releaseCtx); err != nil {
+		_, _ = fmt.Fprintf(a.stderr, "kvctl: release lock %q: %v\n", name, err)
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(runErr, &exitErr) && exitErr.ExitCode() > 0:
+		return &exitStatus{code: exitErr.ExitCode()}
+	default:
+		return runErr
+	}
+}
+
+// parse parses the flags of a command and checks it has between min and max arguments, any
+// number above min when max is negative.
+func parse(fs *flag.FlagSet, args []string, minArgs, maxArgs int) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return &exitStatus{code: exitOK}
+		}
+		return &exitStatus{code: exitUsage}
+	}
+
+	switch n := fs.NArg(); {
+	case n < minArgs:
+		return &usageError{message: "missing arguments"}
+	case maxArgs >= 0 && n > maxArgs:
+		return &usageError{message: "too many arguments"}
+	default:
+		return nil
+	}
+}
+
+// input returns a value from its argument, a file, or stdin when neither is set or either
+// is -, and whether it came from the argument.
+func (a *app) input(arg, file string) ([]byte, bool, error) {
+	switch {
+	case arg != "" && arg != "-":
+		return []byte(arg), true, nil
+	case file != "" && file != "-":
+		data, err := os.ReadFile(file)
+		return data, false, err
+	default:
+		data, err := io.ReadAll(a.stdin)
+		return data, false, err
+	}
+}
+
+// jsonValue returns the JSON value of the input of set.
+func jsonValue(data []byte, fromArg, asString bool) (json.RawMessage, error) {
+	switch {
+	case asString:
+		if !fromArg {
+			data = bytes.TrimSuffix(bytes.TrimSuffix(data, []byte("\n")), []byte("\r"))
+		}
+		return json.Marshal(string(data))
+	case json.Valid(data):
+		return data, nil
+	case fromArg:
+		return json.Marshal(string(data))
+	default:
+		return nil, errors.New("value is not valid JSON, set -string to store it as a string or -content-type to store it raw")
+	}
+}
@@ -0,0 +1,208 @@
+// Command kvctl is a command-line client for the key-value store.
+//
+//	kvctl [global flags] <command> [flags] [arguments]
+//
+// Global flags default to the KVCTL_ADDR, KVCTL_TOKEN, KVCTL_NAMESPACE, KVCTL_OUTPUT and
+// KVCTL_TIMEOUT environment variables. Flags go before arguments.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/felipeascari/kv-store/pkg/client"
+	"github.com/felipeascari/kv-store/pkg/environment"
+)
+
+// Exit codes, so scripts can tell missing keys and conflicts apart from other failures.
+const (
+	exitOK       = 0
+	exitError    = 1
+	exitUsage    = 2
+	exitNotFound = 3
+	exitConflict = 4
+)
+
+var commands = []command{
+	{name: "get", args: "<key>", summary: "Print a key", run: runGet},
+	{name: "set", args: "<key> [value]", summary: "Write a key from an argument, a file or stdin", run: runSet},
+	{name: "del", args: "<key>", summary: "Delete a key", run: runDel},
+	{name: "list", args: "[prefix]", summary: "List the keys starting with a prefix", run: runList},
+	{name: "watch", args: "[prefix]", summary: "Print the changes of the keys starting with a prefix", run: runWatch},
+	{name: "export", args: "", summary: "Write an NDJSON export of every key", run: runExport},
+	{name: "import", args: "", summary: "Load an NDJSON export", run: runImport},
+	{name: "lock", args: "<name> -- <command> [arguments]", summary: "Run a command while holding a lock", run: runLock},
+}
+
+type (
+	command struct {
+		name    string
+		args    string
+		summary string
+		run     func(ctx context.Context, a *app, fs *flag.FlagSet, args []string) error
+	}
+
+	// app is what commands run with: the client, the output and the global flags.
+	app struct {
+		client  *client.Client
+		out     printer
+		stdin   io.Reader
+		stderr  io.Writer
+		timeout time.Duration
+	}
+
+	// usageError reports invalid arguments, printed along with the usage of the command.
+	usageError struct {
+		message string
+	}
+
+	// exitStatus is the exit code of a command run by kvctl, which kvctl exits with.
+	exitStatus struct {
+		code int
+	}
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	global := flag.NewFlagSet("kvctl", flag.ContinueOnError)
+	global.SetOutput(stderr)
+	global.Usage = func() { usage(stderr, global) }
+
+	addr := global.String("addr", environment.LoadEnv("KVCTL_ADDR", client.DefaultAddr), "server address")
+	token := global.String("token", environment.LoadEnv("KVCTL_TOKEN", ""), "bearer token sent with every request")
+	namespace := global.String("namespace", environment.LoadEnv("KVCTL_NAMESPACE", ""),
+		"namespace of the keys, the default one when empty")
+	output := global.String("o", environment.LoadEnv("KVCTL_OUTPUT", formatTable), "output format: table, json or raw")
+	timeout := global.Duration("timeout", envDuration("KVCTL_TIMEOUT"),
+		"request timeout, none when zero (watch and lock are not bound)")
+
+	if err := global.Parse(args); err != nil {
+		return exitUsage
+	}
+	if global.NArg() == 0 {
+		global.Usage()
+		return exitUsage
+	}
+	if !isFormat(*output) {
+		_, _ = fmt.Fprintf(stderr, "kvctl: invalid output format %q\n", *output)
+		return exitUsage
+	}
+
+	cmd, ok := findCommand(global.Arg(0))
+	if !ok {
+		_, _ = fmt.Fprintf(stderr, "kvctl: unknown command %q\n", global.Arg(0))
+		global.Usage()
+		return exitUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	a := &app{
+		client:  client.New(*addr, client.Options{Token: *token, Namespace: *namespace}),
+		out:     printer{format: *output, w: stdout},
+		stdin:   stdin,
+		stderr:  stderr,
+		timeout: *timeout,
+	}
+
+	err := cmd.run(ctx, a, cmd.flags(a), global.Args()[1:])
+
+	var usageErr *usageError
+	if errors.As(err, &usageErr) {
+		_, _ = fmt.Fprintf(stderr, "kvctl %s: %s\nusage: kvctl %s %s\n", cmd.name, usageErr.message, cmd.name, cmd.args)
+		return exitUsage
+	}
+	return exitCode(err, stderr)
+}
+
+// exitCode returns the exit code of the error a command returned, printing it.
+func exitCode(err error, stderr io.Writer) int {
+	if err == nil {
+		return exitOK
+	}
+
+	var status *exitStatus
+	if errors.As(err, &status) {
+		return status.code
+	}
+
+	_, _ = fmt.Fprintf(stderr, "kvctl: %v\n", err)
+	switch {
+	case errors.Is(err, client.ErrNotFound):
+		return exitNotFound
+	case errors.Is(err, client.ErrConflict):
+		return exitConflict
+	default:
+		return exitError
+	}
+}
+
+func usage(w io.Writer, global *flag.FlagSet) {
+	_, _ = fmt.Fprintln(w, "usage: kvctl [global flags] <command> [flags] [arguments]")
+	_, _ = fmt.Fprintln(w, "\nCommands:")
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands {
+		_, _ = fmt.Fprintf(tw, "  %s %s\t%s\n", cmd.name, cmd.args, cmd.summary)
+	}
+	_ = tw.Flush()
+
+	_, _ = fmt.Fprintln(w, "\nGlobal flags:")
+	global.PrintDefaults()
+	_, _ = fmt.Fprintln(w, "\nRun kvctl <command> -h for the flags of a command.")
+	_, _ = fmt.Fprintf(w, "Exit codes: %d missing key, %d conflict, %d usage, %d other errors.\n",
+		exitNotFound, exitConflict, exitUsage, exitError)
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+// flags returns the flag set of a command, which prints its usage on parse errors.
+func (c command) flags(a *app) *flag.FlagSet {
+	fs := flag.NewFlagSet("kvctl "+c.name, flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(a.stderr, "usage: kvctl %s [flags] %s\n", c.name, c.args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// bound returns ctx bound by the request timeout, if any.
+func (a *app) bound(ctx context.Context) (context.Context, context.CancelFunc) {
+	if a.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, a.timeout)
+}
+
+func (e *usageError) Error() string {
+	return e.message
+}
+
+func (e *exitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
+func envDuration(name string) time.Duration {
+	d, _ := time.ParseDuration(environment.LoadEnv(name, "0"))
+	return d
+}
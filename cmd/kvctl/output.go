@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/felipeascari/kv-store/pkg/client"
+)
+
+// Output formats. Tables are for people, JSON is for tools, and raw prints values alone, JSON
+// strings unquoted and raw values byte for byte, for shell pipelines.
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+	formatRaw   = "raw"
+)
+
+type printer struct {
+	format string
+	w      io.Writer
+}
+
+func isFormat(format string) bool {
+	return slices.Contains([]string{formatTable, formatJSON, formatRaw}, format)
+}
+
+// entry prints a key read with get.
+func (p printer) entry(e client.Entry) error {
+	switch p.format {
+	case formatJSON:
+		return p.json(e)
+	case formatRaw:
+		return p.raw(e)
+	default:
+		return p.table([]string{"KEY", "VERSION", "EXPIRES", "VALUE"},
+			[]string{e.Key, strconv.FormatInt(e.Version, 10), expires(e.ExpiresAt), value(e)})
+	}
+}
+
+// written prints a key written with set.
+func (p printer) written(e client.Entry) error {
+	switch p.format {
+	case formatJSON:
+		e.Data = nil
+		return p.json(e)
+	case formatRaw:
+		_, err := fmt.Fprintln(p.w, e.Version)
+		return err
+	default:
+		return p.table([]string{"KEY", "VERSION", "EXPIRES"},
+			[]string{e.Key, strconv.FormatInt(e.Version, 10), expires(e.ExpiresAt)})
+	}
+}
+
+// keys prints the keys found with list.
+func (p printer) keys(keys []string) error {
+	switch p.format {
+	case formatJSON:
+		if keys == nil {
+			keys = []string{}
+		}
+		return p.json(keys)
+	case formatRaw:
+		for _, key := range keys {
+			if _, err := fmt.Fprintln(p.w, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		rows := make([][]string, len(keys))
+		for i, key := range keys {
+			rows[i] = []string{key}
+		}
+		return p.table([]string{"KEY"}, rows...)
+	}
+}
+
+// event prints a change seen with watch as soon as it comes, so tables are not aligned and
+// JSON is printed a change per line.
+func (p printer) event(e client.Event, first bool) error {
+	if p.format == formatJSON {
+		return json.NewEncoder(p.w).Encode(e)
+	}
+
+	if first && p.format == formatTable {
+		if _, err := fmt.Fprintln(p.w, "REVISION\tTYPE\tKEY\tVALUE"); err != nil {
+			return err
+		}
+	}
+
+	v := string(e.Value)
+	if e.ContentType != "" {
+		v = "<" + e.ContentType + ">"
+	}
+	_, err := fmt.Fprintf(p.w, "%d\t%s\t%s\t%s\n", e.Revision, e.Type, e.Key, v)
+	return err
+}
+
+func (p printer) imported(result client.ImportResult) error {
+	switch p.format {
+	case formatJSON:
+		return p.json(result)
+	case formatRaw:
+		_, err := fmt.Fprintln(p.w, result.Imported)
+		return err
+	default:
+		return p.table([]string{"MODE", "IMPORTED", "SKIPPED", "EXPIRED"}, []string{
+			result.Mode, strconv.Itoa(result.Imported), strconv.Itoa(result.Skipped), strconv.Itoa(result.Expired),
+		})
+	}
+}
+
+func (p printer) table(header []string, rows ...[]string) error {
+	tw := tabwriter.NewWriter(p.w, 0, 0, 2, ' ', 0)
+	for _, row := range append([][]string{header}, rows...) {
+		for i, cell := range row {
+			sep := "\t"
+			if i == len(row)-1 {
+				sep = "\n"
+			}
+			if _, err := io.WriteString(tw, cell+sep); err != nil {
+				return err
+			}
+		}
+	}
+	return tw.Flush()
+}
+
+func (p printer) json(v any) error {
+	encoder := json.NewEncoder(p.w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// raw prints a value alone: raw values as they are, JSON strings unquoted and other JSON
+// values as they are, followed by a newline.
+func (p printer) raw(e client.Entry) error {
+	if e.Value == nil {
+		_, err := p.w.Write(e.Data)
+		return err
+	}
+
+	var s string
+	if err := json.Unmarshal(e.Value, &s); err == nil {
+		_, err := fmt.Fprintln(p.w, s)
+		return err
+	}
+	_, err := fmt.Fprintln(p.w, string(e.Value))
+	return err
+}
+
+// value returns a value for a table: JSON on a line, or the size and type of a raw value.
+func value(e client.Entry) string {
+	if e.Value == nil {
+		return fmt.Sprintf("<%d bytes of %s>", len(e.Data), e.ContentType)
+	}
+	return string(e.Value)
+}
+
+func expires(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Local().Format(time.RFC3339)
+}
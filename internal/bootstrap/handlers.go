@@ -1,29 +1,103 @@
 package bootstrap
 
 import (
+	"github.com/felipeascari/kv-store/internal/handler/backup"
+	"github.com/felipeascari/kv-store/internal/handler/batch"
+	"github.com/felipeascari/kv-store/internal/handler/collection"
 	"github.com/felipeascari/kv-store/internal/handler/delete"
+	"github.com/felipeascari/kv-store/internal/handler/history"
+	"github.com/felipeascari/kv-store/internal/handler/increment"
+	"github.com/felipeascari/kv-store/internal/handler/list"
+	"github.com/felipeascari/kv-store/internal/handler/namespace"
+	"github.com/felipeascari/kv-store/internal/handler/patch"
+	"github.com/felipeascari/kv-store/internal/handler/query"
+	"github.com/felipeascari/kv-store/internal/handler/raw"
+	"github.com/felipeascari/kv-store/internal/handler/restore"
 	"github.com/felipeascari/kv-store/internal/handler/retrieve"
 	"github.com/felipeascari/kv-store/internal/handler/save"
+	"github.com/felipeascari/kv-store/internal/handler/stats"
+	"github.com/felipeascari/kv-store/internal/handler/tx"
+	"github.com/felipeascari/kv-store/internal/handler/watch"
+	backupUseCase "github.com/felipeascari/kv-store/internal/usecase/backup"
+	batchUseCase "github.com/felipeascari/kv-store/internal/usecase/batch"
+	collectionUseCase "github.com/felipeascari/kv-store/internal/usecase/collection"
 	deleteUseCase "github.com/felipeascari/kv-store/internal/usecase/delete"
+	historyUseCase "github.com/felipeascari/kv-store/internal/usecase/history"
+	incrementUseCase "github.com/felipeascari/kv-store/internal/usecase/increment"
+	listUseCase "github.com/felipeascari/kv-store/internal/usecase/list"
+	namespaceUseCase "github.com/felipeascari/kv-store/internal/usecase/namespace"
+	patchUseCase "github.com/felipeascari/kv-store/internal/usecase/patch"
+	queryUseCase "github.com/felipeascari/kv-store/internal/usecase/query"
+	restoreUseCase "github.com/felipeascari/kv-store/internal/usecase/restore"
 	retrieveUseCase "github.com/felipeascari/kv-store/internal/usecase/retrieve"
 	saveUseCase "github.com/felipeascari/kv-store/internal/usecase/save"
+	statsUseCase "github.com/felipeascari/kv-store/internal/usecase/stats"
+	txUseCase "github.com/felipeascari/kv-store/internal/usecase/tx"
+	watchUseCase "github.com/felipeascari/kv-store/internal/usecase/watch"
 	"github.com/felipeascari/kv-store/pkg/storage"
+	pkgwatch "github.com/felipeascari/kv-store/pkg/watch"
 )
 
 type Handlers struct {
-	Save     *save.Handler
-	Retrieve *retrieve.Handler
-	Delete   *delete.Handler
+	Save       *save.Handler
+	Raw        *raw.Handler
+	Retrieve   *retrieve.Handler
+	Delete     *delete.Handler
+	Increment  *increment.Handler
+	Patch      *patch.Handler
+	Collection *collection.Handler
+	List       *list.Handler
+	Query      *query.Handler
+	Batch      *batch.Handler
+	Stats      *stats.Handler
+	Tx         *tx.Handler
+	Namespace  *namespace.Handler
+	History    *history.Handler
+	Restore    *restore.Handler
+	Watch      *watch.Handler
+	Backup     *backup.Handler
 }
 
-func NewHandlers(store storage.Store) *Handlers {
-	saveUC := saveUseCase.NewUseCase(store)
-	retrieveUC := retrieveUseCase.NewUseCase(store)
-	deleteUC := deleteUseCase.NewUseCase(store)
+// NewHandlers wires the handlers to store. Key operations go through a Namespaced view of
+// keys, store with the layers that only apply to them, while stats and the namespace
+// registry work on the store as a whole, as do backups.
+func NewHandlers(store, keys storage.Store, bus *pkgwatch.Bus) *Handlers {
+	namespaced := storage.NewNamespaced(keys)
+
+	saveUC := saveUseCase.NewUseCase(namespaced, bus)
+	retrieveUC := retrieveUseCase.NewUseCase(namespaced)
+	deleteUC := deleteUseCase.NewUseCase(namespaced, bus)
+	incrementUC := incrementUseCase.NewUseCase(namespaced, bus)
+	patchUC := patchUseCase.NewUseCase(namespaced, bus)
+	collectionUC := collectionUseCase.NewUseCase(namespaced)
+	listUC := listUseCase.NewUseCase(namespaced)
+	queryUC := queryUseCase.NewUseCase(namespaced)
+	batchUC := batchUseCase.NewUseCase(namespaced, bus)
+	statsUC := statsUseCase.NewUseCase(store, bus)
+	txUC := txUseCase.NewUseCase(namespaced, bus)
+	namespaceUC := namespaceUseCase.NewUseCase(store)
+	historyUC := historyUseCase.NewUseCase(namespaced, bus)
+	restoreUC := restoreUseCase.NewUseCase(namespaced, bus)
+	watchUC := watchUseCase.NewUseCase(bus)
+	backupUC := backupUseCase.NewUseCase(store, bus)
 
 	return &Handlers{
-		Save:     save.New(saveUC),
-		Retrieve: retrieve.New(retrieveUC),
-		Delete:   delete.New(deleteUC),
+		Save:       save.New(saveUC),
+		Raw:        raw.New(saveUC),
+		Retrieve:   retrieve.New(retrieveUC),
+		Delete:     delete.New(deleteUC),
+		Increment:  increment.New(incrementUC),
+		Patch:      patch.New(patchUC),
+		Collection: collection.New(collectionUC),
+		List:       list.New(listUC),
+		Query:      query.New(queryUC),
+		Batch:      batch.New(batchUC),
+		Stats:      stats.New(statsUC),
+		Tx:         tx.New(txUC),
+		Namespace:  namespace.New(namespaceUC),
+		History:    history.New(historyUC),
+		Restore:    restore.New(restoreUC),
+		Watch:      watch.New(watchUC),
+		Backup:     backup.New(backupUC),
 	}
 }
@@ -3,28 +3,78 @@ package bootstrap
 import (
 	"net/http"
 
+	"github.com/felipeascari/kv-store/pkg/config"
 	pkghttp "github.com/felipeascari/kv-store/pkg/http"
 	"github.com/felipeascari/kv-store/pkg/middleware"
 	"github.com/felipeascari/kv-store/pkg/storage"
+	"github.com/felipeascari/kv-store/pkg/watch"
 	"github.com/go-chi/chi/v5"
 )
 
-func setupRouter(kvStore storage.Store) *chi.Mux {
+func setupRouter(kvStore, keyStore storage.Store, bus *watch.Bus, cfg config.ServerConfig) *chi.Mux {
 	r := chi.NewRouter()
 
 	middleware.Setup(r)
+	timeout := middleware.RequestTimeout(cfg.RequestTimeout)
 
-	r.Get("/health", func(w http.ResponseWriter, _ *http.Request) {
+	r.With(timeout).Get("/health", func(w http.ResponseWriter, _ *http.Request) {
 		pkghttp.JSON(w, http.StatusOK, map[string]string{"status": "ok"})
 	})
 
-	handlers := NewHandlers(kvStore)
+	handlers := NewHandlers(kvStore, keyStore, bus)
 
 	r.Route("/api", func(r chi.Router) {
-		r.Post("/keys", handlers.Save.Handle)
-		r.Get("/keys/{key}", handlers.Retrieve.Handle)
-		r.Delete("/keys/{key}", handlers.Delete.Handle)
+		keyRoutes(r, handlers, timeout)
+		r.With(timeout).Get("/stats", handlers.Stats.Handle)
+
+		r.Route("/ns/{namespace}", func(r chi.Router) {
+			r.Use(handlers.Namespace.Scope)
+			keyRoutes(r, handlers, timeout)
+		})
+
+		r.Route("/admin/namespaces", func(r chi.Router) {
+			r.Use(timeout)
+			r.Post("/", handlers.Namespace.Create)
+			r.Get("/", handlers.Namespace.List)
+			r.Delete("/{namespace}", handlers.Namespace.Delete)
+		})
+
+		// Exports and imports stream every key, they are not bound by the request deadline
+		r.Get("/admin/export", handlers.Backup.Export)
+		r.Post("/admin/import", handlers.Backup.Import)
 	})
 
 	return r
 }
+
+// keyRoutes registers the key operations, served for the default namespace under /api and
+// for the others under /api/ns/{namespace}. Every one of them is bound by the request deadline
+// but watches, which are long-lived streams.
+func keyRoutes(r chi.Router, handlers *Handlers, timeout func(http.Handler) http.Handler) {
+	r.Get("/watch", handlers.Watch.Handle)
+
+	r = r.With(timeout)
+	r.Post("/keys", handlers.Save.Handle)
+	r.Get("/keys", handlers.List.Handle)
+	r.Get("/query", handlers.Query.Handle)
+	r.Get("/keys/{key}", handlers.Retrieve.Handle)
+	r.Put("/keys/{key}", handlers.Raw.Handle)
+	r.Delete("/keys/{key}", handlers.Delete.Handle)
+	r.Post("/keys/{key}/restore", handlers.Restore.Handle)
+	r.Patch("/keys/{key}", handlers.Patch.Handle)
+	r.Post("/keys/{key}/incr", handlers.Increment.Handle)
+	r.Get("/keys/{key}/versions", handlers.History.List)
+	r.Post("/keys/{key}/versions/{version}/restore", handlers.History.Restore)
+	r.Post("/keys/{key}/hash", handlers.Collection.HashSet)
+	r.Get("/keys/{key}/hash", handlers.Collection.HashGetAll)
+	r.Get("/keys/{key}/hash/{field}", handlers.Collection.HashGet)
+	r.Post("/keys/{key}/list", handlers.Collection.ListPush)
+	r.Post("/keys/{key}/list/pop", handlers.Collection.ListPop)
+	r.Get("/keys/{key}/list", handlers.Collection.ListRange)
+	r.Post("/keys/{key}/set", handlers.Collection.SetAdd)
+	r.Get("/keys/{key}/set", handlers.Collection.SetMembers)
+	r.Post("/keys/{key}/zset", handlers.Collection.SortedSetAdd)
+	r.Get("/keys/{key}/zset", handlers.Collection.SortedSetRange)
+	r.Post("/batch", handlers.Batch.Handle)
+	r.Post("/tx", handlers.Tx.Handle)
+}
@@ -1,7 +1,10 @@
 package bootstrap
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"time"
 
@@ -9,6 +12,7 @@ import (
 	"github.com/felipeascari/kv-store/pkg/lock"
 	"github.com/felipeascari/kv-store/pkg/logger"
 	"github.com/felipeascari/kv-store/pkg/storage"
+	"github.com/felipeascari/kv-store/pkg/watch"
 	"github.com/go-chi/chi/v5"
 	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
@@ -20,6 +24,12 @@ type Server struct {
 	logger      *zap.Logger
 	store       storage.Store
 	redisClient *redis.Client
+	// encrypted is the encryption layer under store, nil unless encryption is enabled.
+	encrypted *storage.Encrypted
+	bus       *watch.Bus
+	// softDeleting is the layer key operations go through above store, nil unless soft
+	// deletes are enabled.
+	softDeleting *storage.SoftDeleting
 }
 
 func NewServer() (*Server, error) {
@@ -32,49 +42,201 @@ func NewServer() (*Server, error) {
 		return nil, fmt.Errorf("failed to load config: %w", err)
 	}
 
-	kvStore, redisClient, err := createStorage(cfg.Storage)
+	kvStore, redisClient, encrypted, err := createStorage(cfg.Storage)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create storage: %w", err)
 	}
 
-	router := setupRouter(kvStore)
+	bus, err := createWatchBus(cfg.Watch, redisClient, encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create watch bus: %w", err)
+	}
+
+	// Expirations are reported by the memory reaper, Redis announces them to the bus itself
+	if notifier, ok := kvStore.(storage.ExpiryNotifier); ok {
+		notifier.NotifyExpired(func(key string) {
+			if e, ok := watch.ExpireEvent(key); ok {
+				bus.Publish(context.Background(), e)
+			}
+		})
+	}
+
+	// Soft deletes only apply to key operations: the namespace registry deletes for good
+	keyStore := kvStore
+	var softDeleting *storage.SoftDeleting
+	if sd := cfg.Storage.SoftDelete; sd.Retention > 0 {
+		softDeleting = storage.NewSoftDeleting(kvStore, storage.SoftDeleteOptions{Retention: sd.Retention})
+		if sd.PurgeInterval > 0 {
+			softDeleting.StartPurger(sd.PurgeInterval)
+		}
+		keyStore = softDeleting
+	}
+
+	router := setupRouter(kvStore, keyStore, bus, cfg.Server)
 
 	addr := fmt.Sprintf(":%s", cfg.Server.Port)
 
 	logger.Logger().Info("initialized storage", zap.String("type", cfg.Storage.Type.String()))
 
 	return &Server{
-		router:      router,
-		addr:        addr,
-		logger:      logger.Logger(),
-		store:       kvStore,
-		redisClient: redisClient,
+		router:       router,
+		addr:         addr,
+		logger:       logger.Logger(),
+		store:        kvStore,
+		redisClient:  redisClient,
+		encrypted:    encrypted,
+		bus:          bus,
+		softDeleting: softDeleting,
 	}, nil
 }
 
-func createStorage(cfg config.StorageConfig) (storage.Store, *redis.Client, error) {
+func createStorage(cfg config.StorageConfig) (storage.Store, *redis.Client, *storage.Encrypted, error) {
 	switch cfg.Type {
 	case storage.TypeRedis:
-		redisStore, err := storage.NewRedis(cfg.Redis.Addr, cfg.Redis.Password, cfg.Redis.DB)
+		redisStore, err := storage.NewRedisWithOptions(storage.RedisOptions{
+			Addr:                 cfg.Redis.Addr,
+			Password:             cfg.Redis.Password,
+			DB:                   cfg.Redis.DB,
+			Indexes:              cfg.Indexes,
+			History:              cfg.History,
+			Compression:          cfg.Redis.Compression,
+			CompressionThreshold: cfg.Redis.CompressionThreshold,
+		})
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
+		}
+
+		if cfg.Redis.MigrateStrings {
+			migrated, err := redisStore.MigrateStrings(context.Background())
+			if err != nil {
+				_ = redisStore.Close()
+				return nil, nil, nil, fmt.Errorf("failed to migrate string keys: %w", err)
+			}
+			logger.Logger().Info("migrated string keys", zap.Int("keys", migrated))
+		}
+
+		// Encrypt values right before they reach Redis, so the layers above,
+		// including the local cache, work on plaintext
+		var (
+			backend   storage.Store = redisStore
+			encrypted *storage.Encrypted
+			keyring   *storage.Keyring
+		)
+		if enc := cfg.Redis.Encryption; enc.KeyFile != "" {
+			// Ciphertext cannot be indexed, so queries would never match
+			if len(cfg.Indexes) > 0 {
+				_ = redisStore.Close()
+				return nil, nil, nil, errors.New("INDEXES cannot be used with REDIS_ENCRYPTION_KEY_FILE")
+			}
+			keyring, err = storage.LoadKeyring(enc.KeyFile)
+			if err != nil {
+				_ = redisStore.Close()
+				return nil, nil, nil, err
+			}
+			encrypted = storage.NewEncrypted(redisStore, keyring, storage.EncryptionOptions{
+				ReencryptOnRead: enc.ReencryptOnRead,
+			})
+			if enc.ReencryptInterval > 0 {
+				encrypted.StartReencryption(enc.ReencryptInterval)
+			}
+			backend = encrypted
 		}
 
 		// Wrap Redis store with distributed locking (fencing tokens)
 		// This prevents zombie processes and ensures consistency
 		lockMgr := lock.NewManager(
-			lock.NewRedisLock(redisStore.Client(), 5*time.Second),
+			lock.NewRedisLock(redisStore.Client(), 5*time.Second).WithTokenScope(storage.KeyNamespace),
 		)
-		lockedStore := storage.NewLockedStore(redisStore, lockMgr)
+		lockedStore := storage.NewLockedStore(backend, lockMgr)
+
+		// Capture every write in a Redis Stream, encrypted like the values themselves
+		if changes := cfg.Redis.ChangeStream; changes.Stream != "" {
+			lockedStore.WithChangeStream(storage.NewChangeStream(storage.ChangeStreamOptions{
+				Stream:  changes.Stream,
+				MaxLen:  changes.MaxLen,
+				Keyring: keyring,
+			}))
+		}
+
+		if cache := cfg.Redis.Cache; cache.MaxKeys > 0 || cache.MaxBytes > 0 {
+			tieredStore, err := storage.NewTiered(lockedStore, redisStore.Client(), storage.TieredOptions{
+				MaxKeys:  cache.MaxKeys,
+				MaxBytes: cache.MaxBytes,
+				TTL:      cache.TTL,
+				Channel:  cache.Channel,
+			})
+			if err != nil {
+				if encrypted != nil {
+					_ = encrypted.Close()
+				}
+				_ = redisStore.Close()
+				return nil, nil, nil, err
+			}
+			return tieredStore, redisStore.Client(), encrypted, nil
+		}
 
-		return lockedStore, redisStore.Client(), nil
+		return lockedStore, redisStore.Client(), encrypted, nil
 
 	case storage.TypeMemory:
-		return storage.NewMemory(), nil, nil
+		memoryStore, err := createMemoryStorage(cfg.Memory, cfg.Indexes, cfg.History)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if cfg.Memory.ReaperInterval > 0 {
+			memoryStore.StartReaper(cfg.Memory.ReaperInterval)
+		}
+		return memoryStore, nil, nil, nil
+
+	case storage.TypeDisk:
+		diskStore, err := storage.OpenDisk(storage.DiskOptions{
+			Dir:           cfg.Disk.DataDir,
+			MaxFileSize:   cfg.Disk.MaxFileSize,
+			MergeInterval: cfg.Disk.MergeInterval,
+			Fsync:         cfg.Disk.Fsync,
+		})
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return diskStore, nil, nil, nil
 
 	default:
-		return nil, nil, fmt.Errorf("unknown storage type: %s", cfg.Type)
+		return nil, nil, nil, fmt.Errorf("unknown storage type: %s", cfg.Type)
+	}
+}
+
+// createWatchBus returns a bus shared between instances through Redis when the storage is
+// Redis, and one local to this instance otherwise. With encryption, the values are encrypted
+// on pub/sub as well.
+func createWatchBus(cfg config.WatchConfig, redisClient *redis.Client, encrypted *storage.Encrypted) (*watch.Bus, error) {
+	opts := watch.Options{History: cfg.History, Buffer: cfg.Buffer, Channel: cfg.Channel}
+	if encrypted != nil {
+		opts.Keyring = encrypted.Keyring()
+	}
+	if redisClient != nil {
+		return watch.NewRedisBus(redisClient, opts)
+	}
+	return watch.NewBus(opts), nil
+}
+
+func createMemoryStorage(cfg config.MemoryConfig, indexes []storage.Index, history int) (*storage.Memory, error) {
+	opts := storage.MemoryOptions{
+		Shards:   cfg.Shards,
+		MaxKeys:  cfg.MaxKeys,
+		MaxBytes: cfg.MaxBytes,
+		Eviction: cfg.Eviction,
+		Indexes:  indexes,
+		History:  history,
+	}
+
+	if cfg.DataDir == "" {
+		return storage.NewMemoryWithOptions(opts)
 	}
+
+	return storage.OpenMemory(opts, storage.PersistenceOptions{
+		Dir:              cfg.DataDir,
+		Fsync:            cfg.Fsync,
+		SnapshotInterval: cfg.SnapshotInterval,
+	})
 }
 
 func (s *Server) Start() error {
@@ -89,6 +251,22 @@ func (s *Server) Address() string {
 func (s *Server) Shutdown() {
 	s.logger.Info("shutting down server")
 
+	if s.softDeleting != nil {
+		_ = s.softDeleting.Close()
+	}
+
+	_ = s.bus.Close()
+
+	if closer, ok := s.store.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			s.logger.Error("failed to close storage", zap.Error(err))
+		}
+	}
+
+	if s.encrypted != nil {
+		_ = s.encrypted.Close()
+	}
+
 	if s.redisClient != nil {
 		if err := s.redisClient.Close(); err != nil {
 			s.logger.Error("failed to close Redis connection", zap.Error(err))
@@ -0,0 +1,28 @@
+package backup
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type (
+	// Record is a line of an export. JSON values are held in Value and raw bytes in ContentType
+	// and Data. An export that fails midway ends with a record holding only Error, which
+	// imports reject.
+	Record struct {
+		Key         string          `json:"key,omitempty"`
+		Value       json.RawMessage `json:"value,omitempty"`
+		ContentType string          `json:"content_type,omitempty"`
+		Data        []byte          `json:"data,omitempty"`
+		Version     int64           `json:"version,omitempty"`
+		ExpiresAt   *time.Time      `json:"expires_at,omitempty"`
+		Error       string          `json:"error,omitempty"`
+	}
+
+	ImportResponse struct {
+		Mode     string `json:"mode"`
+		Imported int    `json:"imported"`
+		Skipped  int    `json:"skipped"`
+		Expired  int    `json:"expired"`
+	}
+)
@@ -0,0 +1,193 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/felipeascari/kv-store/internal/usecase/backup"
+	pkghttp "github.com/felipeascari/kv-store/pkg/http"
+	"github.com/felipeascari/kv-store/pkg/storage"
+)
+
+const (
+	// SnapshotHeader tells whether an export is a snapshot of a single point in time, "true",
+	// or was read a page at a time while the keys kept changing, "false".
+	SnapshotHeader = "X-Export-Snapshot"
+
+	ndjsonContentType = "application/x-ndjson"
+)
+
+type (
+	Handler struct {
+		useCase backup.UseCase
+	}
+
+	// recordError reports a record of an import that cannot be read.
+	recordError struct {
+		line int
+		err  error
+	}
+)
+
+func New(useCase backup.UseCase) *Handler {
+	return &Handler{
+		useCase: useCase,
+	}
+}
+
+// Export serves GET /admin/export, streaming every key as a line of NDJSON.
+func (h *Handler) Export(w http.ResponseWriter, r *http.Request) {
+	encoder := json.NewEncoder(w)
+	started := false
+	w.Header().Set(SnapshotHeader, strconv.FormatBool(h.useCase.Snapshot()))
+
+	err := h.useCase.Export(r.Context(), func(key string, entry storage.Entry) error {
+		record, err := toRecord(key, entry)
+		if err != nil {
+			return err
+		}
+
+		if !started {
+			w.Header().Set("Content-Type", ndjsonContentType)
+			w.Header().Set("Content-Disposition", `attachment; filename="kv-store.ndjson"`)
+			started = true
+		}
+		return encoder.Encode(record)
+	})
+	if err == nil {
+		if !started {
+			w.Header().Set("Content-Type", ndjsonContentType)
+		}
+		return
+	}
+
+	// The status is already sent, so the export is marked as failed for imports to reject it
+	if started {
+		_ = encoder.Encode(Record{Error: "export failed"})
+		return
+	}
+	writeError(w, err, "failed to export keys")
+}
+
+// Import serves POST /admin/import, writing the keys of an export with ?mode=overwrite, the
+// default, or ?mode=skip-existing.
+func (h *Handler) Import(w http.ResponseWriter, r *http.Request) {
+	mode := storage.ImportOverwrite
+	if raw := r.URL.Query().Get("mode"); raw != "" {
+		mode = storage.ImportMode(raw)
+	}
+	if !mode.IsValid() {
+		pkghttp.BadRequest(w, "mode must be overwrite or skip-existing")
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	line := 0
+
+	result, err := h.useCase.Import(r.Context(), mode, func() (backup.Record, error) {
+		var record Record
+		if err := decoder.Decode(&record); err != nil {
+			if errors.Is(err, io.EOF) {
+				return backup.Record{}, io.EOF
+			}
+			return backup.Record{}, &recordError{line: line + 1, err: err}
+		}
+		line++
+
+		imported, err := fromRecord(record)
+		if err != nil {
+			return backup.Record{}, &recordError{line: line, err: err}
+		}
+		return imported, nil
+	})
+	if err != nil {
+		var recErr *recordError
+		switch {
+		case errors.As(err, &recErr):
+			pkghttp.BadRequest(w, fmt.Sprintf("%s (%d keys imported before it)", recErr, result.Imported))
+		default:
+			writeError(w, err, fmt.Sprintf("failed to import keys (%d keys imported)", result.Imported))
+		}
+		return
+	}
+
+	pkghttp.JSON(w, http.StatusOK, ImportResponse{
+		Mode:     mode.String(),
+		Imported: result.Imported,
+		Skipped:  result.Skipped,
+		Expired:  result.Expired,
+	})
+}
+
+func (e *recordError) Error() string {
+	return fmt.Sprintf("record %d: %v", e.line, e.err)
+}
+
+func (e *recordError) Unwrap() error {
+	return e.err
+}
+
+func writeError(w http.ResponseWriter, err error, msg string) {
+	switch {
+	case errors.Is(err, storage.ErrOutOfMemory):
+		pkghttp.InsufficientStorage(w, "memory limit reached")
+	case errors.Is(err, context.DeadlineExceeded):
+		pkghttp.GatewayTimeout(w, "request timed out")
+	default:
+		pkghttp.InternalServerError(w, msg)
+	}
+}
+
+func toRecord(key string, entry storage.Entry) (Record, error) {
+	record := Record{Key: key, Version: entry.Version}
+	if !entry.ExpiresAt.IsZero() {
+		expiresAt := entry.ExpiresAt.UTC()
+		record.ExpiresAt = &expiresAt
+	}
+
+	if blob, ok := entry.Value.(storage.Blob); ok {
+		record.ContentType, record.Data = blob.ContentType, blob.Data
+		return record, nil
+	}
+
+	value, err := json.Marshal(entry.Value)
+	if err != nil {
+		return Record{}, err
+	}
+	record.Value = value
+	return record, nil
+}
+
+func fromRecord(record Record) (backup.Record, error) {
+	switch {
+	case record.Error != "":
+		return backup.Record{}, fmt.Errorf("export is incomplete: %s", record.Error)
+	case record.Key == "":
+		return backup.Record{}, errors.New("key is required")
+	case record.ContentType == "" && record.Value == nil:
+		return backup.Record{}, errors.New("value or content_type is required")
+	}
+	if err := storage.ValidateImportKey(record.Key); err != nil {
+		return backup.Record{}, err
+	}
+
+	imported := backup.Record{Key: record.Key}
+	if record.ExpiresAt != nil {
+		imported.Entry.ExpiresAt = *record.ExpiresAt
+	}
+
+	if record.ContentType != "" {
+		imported.Entry.Value = storage.Blob{ContentType: record.ContentType, Data: record.Data}
+		return imported, nil
+	}
+
+	if err := json.Unmarshal(record.Value, &imported.Entry.Value); err != nil {
+		return backup.Record{}, err
+	}
+	return imported, nil
+}
@@ -0,0 +1,28 @@
+package batch
+
+import "time"
+
+type (
+	Request struct {
+		Op    string `json:"op"`
+		Items []Item `json:"items"`
+	}
+
+	Item struct {
+		Key       string `json:"key"`
+		Value     any    `json:"value,omitempty"`
+		ExpiresIn *int64 `json:"expires_in,omitempty"`
+	}
+
+	Response struct {
+		Results []Result `json:"results"`
+	}
+
+	Result struct {
+		Key       string     `json:"key"`
+		Value     any        `json:"value,omitempty"`
+		Version   int64      `json:"version,omitempty"`
+		ExpiresAt *time.Time `json:"expires_at,omitempty"`
+		Error     string     `json:"error,omitempty"`
+	}
+)
@@ -0,0 +1,113 @@
+package batch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/felipeascari/kv-store/internal/usecase/batch"
+	pkghttp "github.com/felipeascari/kv-store/pkg/http"
+	"github.com/felipeascari/kv-store/pkg/storage"
+)
+
+const maxItems = 1000
+
+type Handler struct {
+	useCase batch.UseCase
+}
+
+func New(useCase batch.UseCase) *Handler {
+	return &Handler{
+		useCase: useCase,
+	}
+}
+
+func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) {
+	var req Request
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		pkghttp.BadRequest(w, "invalid request body")
+		return
+	}
+
+	items, err := toItems(req.Items)
+	if err != nil {
+		pkghttp.BadRequest(w, err.Error())
+		return
+	}
+
+	results, err := h.useCase.Execute(r.Context(), batch.Operation(req.Op), items)
+	if err != nil {
+		if errors.Is(err, batch.ErrUnknownOperation) {
+			pkghttp.BadRequest(w, "op must be one of get, set or delete")
+			return
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			pkghttp.GatewayTimeout(w, "request timed out")
+			return
+		}
+		pkghttp.InternalServerError(w, "failed to execute batch")
+		return
+	}
+
+	resp := Response{Results: make([]Result, len(results))}
+	for i, result := range results {
+		resp.Results[i] = toResult(result)
+	}
+
+	pkghttp.JSON(w, http.StatusOK, resp)
+}
+
+func toItems(reqItems []Item) ([]storage.BatchItem, error) {
+	if len(reqItems) == 0 || len(reqItems) > maxItems {
+		return nil, fmt.Errorf("items must contain between 1 and %d entries", maxItems)
+	}
+
+	items := make([]storage.BatchItem, len(reqItems))
+	for i, item := range reqItems {
+		if item.Key == "" {
+			return nil, fmt.Errorf("items[%d]: key is required", i)
+		}
+
+		items[i] = storage.BatchItem{Key: item.Key, Value: item.Value}
+
+		if item.ExpiresIn != nil {
+			ttl, err := pkghttp.ExpiresIn(*item.ExpiresIn)
+			if err != nil {
+				return nil, fmt.Errorf("items[%d]: %w", i, err)
+			}
+			items[i].TTL = ttl
+		}
+	}
+
+	return items, nil
+}
+
+func toResult(result storage.BatchResult) Result {
+	if result.Err != nil {
+		msg := "internal error"
+		switch {
+		case errors.Is(result.Err, storage.ErrKeyNotFound):
+			msg = "key not found"
+		case errors.Is(result.Err, storage.ErrOutOfMemory):
+			msg = "memory limit reached"
+		case errors.Is(result.Err, storage.ErrReservedKey):
+			msg = result.Err.Error()
+		}
+		return Result{Key: result.Key, Error: msg}
+	}
+
+	res := Result{
+		Key:     result.Key,
+		Value:   result.Entry.Value,
+		Version: result.Entry.Version,
+	}
+	if !result.Entry.ExpiresAt.IsZero() {
+		expiresAt := result.Entry.ExpiresAt.UTC()
+		res.ExpiresAt = &expiresAt
+	}
+
+	return res
+}
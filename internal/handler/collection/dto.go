@@ -0,0 +1,71 @@
+package collection
+
+type (
+	HashSetRequest struct {
+		Fields map[string]any `json:"fields"`
+	}
+
+	ListPushRequest struct {
+		Values []any `json:"values"`
+	}
+
+	SetAddRequest struct {
+		Members []string `json:"members"`
+	}
+
+	SortedSetAddRequest struct {
+		Members []ScoredMember `json:"members"`
+	}
+
+	ScoredMember struct {
+		Member string   `json:"member"`
+		Score  *float64 `json:"score"`
+	}
+
+	// AddResponse reports how many fields or members a write added, the others being updated.
+	AddResponse struct {
+		Key   string `json:"key"`
+		Added int    `json:"added"`
+	}
+
+	HashFieldResponse struct {
+		Key   string `json:"key"`
+		Field string `json:"field"`
+		Value any    `json:"value"`
+	}
+
+	HashResponse struct {
+		Key    string         `json:"key"`
+		Fields map[string]any `json:"fields"`
+	}
+
+	ListLengthResponse struct {
+		Key    string `json:"key"`
+		Length int    `json:"length"`
+	}
+
+	ListElementResponse struct {
+		Key   string `json:"key"`
+		Value any    `json:"value"`
+	}
+
+	ListResponse struct {
+		Key    string `json:"key"`
+		Values []any  `json:"values"`
+	}
+
+	SetResponse struct {
+		Key     string   `json:"key"`
+		Members []string `json:"members"`
+	}
+
+	SortedSetResponse struct {
+		Key     string               `json:"key"`
+		Members []ScoredMemberResult `json:"members"`
+	}
+
+	ScoredMemberResult struct {
+		Member string  `json:"member"`
+		Score  float64 `json:"score"`
+	}
+)
@@ -0,0 +1,256 @@
+package collection
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/felipeascari/kv-store/internal/usecase/collection"
+	pkghttp "github.com/felipeascari/kv-store/pkg/http"
+	"github.com/felipeascari/kv-store/pkg/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxItems bounds how many fields, values or members a single write may carry.
+const maxItems = 1000
+
+type Handler struct {
+	useCase collection.UseCase
+}
+
+func New(useCase collection.UseCase) *Handler {
+	return &Handler{
+		useCase: useCase,
+	}
+}
+
+func (h *Handler) HashSet(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+
+	var req HashSetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		pkghttp.BadRequest(w, "invalid request body")
+		return
+	}
+	if len(req.Fields) == 0 || len(req.Fields) > maxItems {
+		pkghttp.BadRequest(w, fmt.Sprintf("fields must contain between 1 and %d entries", maxItems))
+		return
+	}
+
+	added, err := h.useCase.HashSet(r.Context(), key, req.Fields)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	pkghttp.JSON(w, http.StatusOK, AddResponse{Key: key, Added: added})
+}
+
+func (h *Handler) HashGet(w http.ResponseWriter, r *http.Request) {
+	key, field := chi.URLParam(r, "key"), chi.URLParam(r, "field")
+
+	value, err := h.useCase.HashGet(r.Context(), key, field)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	pkghttp.JSON(w, http.StatusOK, HashFieldResponse{Key: key, Field: field, Value: value})
+}
+
+func (h *Handler) HashGetAll(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+
+	fields, err := h.useCase.HashGetAll(r.Context(), key)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	pkghttp.JSON(w, http.StatusOK, HashResponse{Key: key, Fields: fields})
+}
+
+func (h *Handler) ListPush(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+
+	var req ListPushRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		pkghttp.BadRequest(w, "invalid request body")
+		return
+	}
+	if len(req.Values) == 0 || len(req.Values) > maxItems {
+		pkghttp.BadRequest(w, fmt.Sprintf("values must contain between 1 and %d entries", maxItems))
+		return
+	}
+
+	length, err := h.useCase.ListPush(r.Context(), key, req.Values)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	pkghttp.JSON(w, http.StatusOK, ListLengthResponse{Key: key, Length: length})
+}
+
+func (h *Handler) ListPop(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+
+	value, err := h.useCase.ListPop(r.Context(), key)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	pkghttp.JSON(w, http.StatusOK, ListElementResponse{Key: key, Value: value})
+}
+
+func (h *Handler) ListRange(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+
+	start, stop, err := parseRange(r)
+	if err != nil {
+		pkghttp.BadRequest(w, err.Error())
+		return
+	}
+
+	values, err := h.useCase.ListRange(r.Context(), key, start, stop)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	pkghttp.JSON(w, http.StatusOK, ListResponse{Key: key, Values: values})
+}
+
+func (h *Handler) SetAdd(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+
+	var req SetAddRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		pkghttp.BadRequest(w, "invalid request body")
+		return
+	}
+	if len(req.Members) == 0 || len(req.Members) > maxItems {
+		pkghttp.BadRequest(w, fmt.Sprintf("members must contain between 1 and %d entries", maxItems))
+		return
+	}
+
+	added, err := h.useCase.SetAdd(r.Context(), key, req.Members)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	pkghttp.JSON(w, http.StatusOK, AddResponse{Key: key, Added: added})
+}
+
+func (h *Handler) SetMembers(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+
+	members, err := h.useCase.SetMembers(r.Context(), key)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	pkghttp.JSON(w, http.StatusOK, SetResponse{Key: key, Members: members})
+}
+
+func (h *Handler) SortedSetAdd(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+
+	var req SortedSetAddRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		pkghttp.BadRequest(w, "invalid request body")
+		return
+	}
+	if len(req.Members) == 0 || len(req.Members) > maxItems {
+		pkghttp.BadRequest(w, fmt.Sprintf("members must contain between 1 and %d entries", maxItems))
+		return
+	}
+
+	members := make([]storage.ScoredMember, len(req.Members))
+	for i, member := range req.Members {
+		if member.Score == nil {
+			pkghttp.BadRequest(w, fmt.Sprintf("members[%d]: score is required", i))
+			return
+		}
+		members[i] = storage.ScoredMember{Member: member.Member, Score: *member.Score}
+	}
+
+	added, err := h.useCase.SortedSetAdd(r.Context(), key, members)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	pkghttp.JSON(w, http.StatusOK, AddResponse{Key: key, Added: added})
+}
+
+func (h *Handler) SortedSetRange(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+
+	start, stop, err := parseRange(r)
+	if err != nil {
+		pkghttp.BadRequest(w, err.Error())
+		return
+	}
+
+	members, err := h.useCase.SortedSetRange(r.Context(), key, start, stop)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resp := SortedSetResponse{Key: key, Members: make([]ScoredMemberResult, len(members))}
+	for i, member := range members {
+		resp.Members[i] = ScoredMemberResult{Member: member.Member, Score: member.Score}
+	}
+
+	pkghttp.JSON(w, http.StatusOK, resp)
+}
+
+// parseRange reads the inclusive start and stop indexes of a range, 0 and -1 by default.
+func parseRange(r *http.Request) (int, int, error) {
+	query := r.URL.Query()
+
+	start, stop := 0, -1
+	if raw := query.Get("start"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			return 0, 0, errors.New("start must be an integer")
+		}
+		start = parsed
+	}
+	if raw := query.Get("stop"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			return 0, 0, errors.New("stop must be an integer")
+		}
+		stop = parsed
+	}
+
+	return start, stop, nil
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, storage.ErrKeyNotFound):
+		pkghttp.NotFound(w, "key not found")
+	case errors.Is(err, storage.ErrFieldNotFound):
+		pkghttp.NotFound(w, "field not found")
+	case errors.Is(err, storage.ErrWrongType):
+		pkghttp.Conflict(w, err.Error())
+	case errors.Is(err, storage.ErrReservedKey):
+		pkghttp.BadRequest(w, err.Error())
+	case errors.Is(err, storage.ErrCollectionsUnsupported):
+		pkghttp.NotImplemented(w, "collections are not available for this storage")
+	case errors.Is(err, context.DeadlineExceeded):
+		pkghttp.GatewayTimeout(w, "request timed out")
+	default:
+		pkghttp.InternalServerError(w, "internal server error")
+	}
+}
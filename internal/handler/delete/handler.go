@@ -1,6 +1,7 @@
 package delete
 
 import (
+	"context"
 	"errors"
 	"net/http"
 
@@ -27,12 +28,24 @@ func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := h.useCase.Execute(key)
+	err := h.useCase.Execute(r.Context(), key, pkghttp.Precondition(r))
 	if err != nil {
 		if errors.Is(err, storage.ErrKeyNotFound) {
 			pkghttp.NotFound(w, "key not found")
 			return
 		}
+		if errors.Is(err, storage.ErrVersionMismatch) {
+			pkghttp.PreconditionFailed(w, "version mismatch")
+			return
+		}
+		if errors.Is(err, storage.ErrReservedKey) {
+			pkghttp.BadRequest(w, err.Error())
+			return
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			pkghttp.GatewayTimeout(w, "request timed out")
+			return
+		}
 		pkghttp.InternalServerError(w, "internal server error")
 		return
 	}
@@ -0,0 +1,22 @@
+package history
+
+import "time"
+
+type (
+	Version struct {
+		Version   int64      `json:"version"`
+		WrittenAt *time.Time `json:"written_at,omitempty"`
+	}
+
+	ListResponse struct {
+		Key      string    `json:"key"`
+		Versions []Version `json:"versions"`
+	}
+
+	RestoreResponse struct {
+		Key          string     `json:"key"`
+		Version      int64      `json:"version"`
+		RestoredFrom int64      `json:"restored_from"`
+		ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	}
+)
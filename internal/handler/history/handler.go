@@ -0,0 +1,102 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/felipeascari/kv-store/internal/usecase/history"
+	pkghttp "github.com/felipeascari/kv-store/pkg/http"
+	"github.com/felipeascari/kv-store/pkg/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+type Handler struct {
+	useCase history.UseCase
+}
+
+func New(useCase history.UseCase) *Handler {
+	return &Handler{
+		useCase: useCase,
+	}
+}
+
+// List serves GET /keys/{key}/versions.
+func (h *Handler) List(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+	if key == "" {
+		pkghttp.BadRequest(w, "key is required")
+		return
+	}
+
+	revisions, err := h.useCase.List(r.Context(), key)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	versions := make([]Version, len(revisions))
+	for i, revision := range revisions {
+		versions[i] = Version{Version: revision.Version}
+		if !revision.WrittenAt.IsZero() {
+			writtenAt := revision.WrittenAt.UTC()
+			versions[i].WrittenAt = &writtenAt
+		}
+	}
+
+	pkghttp.JSON(w, http.StatusOK, ListResponse{Key: key, Versions: versions})
+}
+
+// Restore serves POST /keys/{key}/versions/{version}/restore.
+func (h *Handler) Restore(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+	if key == "" {
+		pkghttp.BadRequest(w, "key is required")
+		return
+	}
+
+	version, err := strconv.ParseInt(chi.URLParam(r, "version"), 10, 64)
+	if err != nil || version <= 0 {
+		pkghttp.BadRequest(w, "version must be a positive integer")
+		return
+	}
+
+	entry, err := h.useCase.Restore(r.Context(), key, version)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resp := RestoreResponse{
+		Key:          key,
+		Version:      entry.Version,
+		RestoredFrom: version,
+	}
+	if !entry.ExpiresAt.IsZero() {
+		expiresAt := entry.ExpiresAt.UTC()
+		resp.ExpiresAt = &expiresAt
+	}
+
+	w.Header().Set("ETag", pkghttp.ETag(entry.Version))
+	pkghttp.JSON(w, http.StatusOK, resp)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, storage.ErrKeyNotFound):
+		pkghttp.NotFound(w, "key not found")
+	case errors.Is(err, storage.ErrVersionNotFound):
+		pkghttp.NotFound(w, "version not found")
+	case errors.Is(err, storage.ErrHistoryUnsupported):
+		pkghttp.NotImplemented(w, "version history is not enabled for this storage")
+	case errors.Is(err, storage.ErrReservedKey):
+		pkghttp.BadRequest(w, err.Error())
+	case errors.Is(err, storage.ErrOutOfMemory):
+		pkghttp.InsufficientStorage(w, "memory limit reached")
+	case errors.Is(err, context.DeadlineExceeded):
+		pkghttp.GatewayTimeout(w, "request timed out")
+	default:
+		pkghttp.InternalServerError(w, "internal server error")
+	}
+}
@@ -0,0 +1,17 @@
+package increment
+
+import "time"
+
+type (
+	// Request is optional, an empty body increments by one.
+	Request struct {
+		By *float64 `json:"by"`
+	}
+
+	Response struct {
+		Key       string     `json:"key"`
+		Value     any        `json:"value"`
+		Version   int64      `json:"version"`
+		ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	}
+)
@@ -0,0 +1,78 @@
+package increment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/felipeascari/kv-store/internal/usecase/increment"
+	pkghttp "github.com/felipeascari/kv-store/pkg/http"
+	"github.com/felipeascari/kv-store/pkg/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+type Handler struct {
+	useCase increment.UseCase
+}
+
+func New(useCase increment.UseCase) *Handler {
+	return &Handler{
+		useCase: useCase,
+	}
+}
+
+func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+	if key == "" {
+		pkghttp.BadRequest(w, "key is required")
+		return
+	}
+
+	var req Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		pkghttp.BadRequest(w, "invalid request body")
+		return
+	}
+
+	delta := 1.0
+	if req.By != nil {
+		delta = *req.By
+	}
+
+	entry, err := h.useCase.Execute(r.Context(), key, delta)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotNumeric) {
+			pkghttp.Conflict(w, "value is not a number")
+			return
+		}
+		if errors.Is(err, storage.ErrOutOfMemory) {
+			pkghttp.InsufficientStorage(w, "memory limit reached")
+			return
+		}
+		if errors.Is(err, storage.ErrReservedKey) {
+			pkghttp.BadRequest(w, err.Error())
+			return
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			pkghttp.GatewayTimeout(w, "request timed out")
+			return
+		}
+		pkghttp.InternalServerError(w, "failed to increment key")
+		return
+	}
+
+	resp := Response{
+		Key:     key,
+		Value:   entry.Value,
+		Version: entry.Version,
+	}
+	if !entry.ExpiresAt.IsZero() {
+		expiresAt := entry.ExpiresAt.UTC()
+		resp.ExpiresAt = &expiresAt
+	}
+
+	w.Header().Set("ETag", pkghttp.ETag(entry.Version))
+	pkghttp.JSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,6 @@
+package list
+
+type Response struct {
+	Keys   []string `json:"keys"`
+	Cursor string   `json:"cursor,omitempty"`
+}
@@ -0,0 +1,62 @@
+package list
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/felipeascari/kv-store/internal/usecase/list"
+	pkghttp "github.com/felipeascari/kv-store/pkg/http"
+	"github.com/felipeascari/kv-store/pkg/storage"
+)
+
+const maxLimit = 1000
+
+type Handler struct {
+	useCase list.UseCase
+}
+
+func New(useCase list.UseCase) *Handler {
+	return &Handler{
+		useCase: useCase,
+	}
+}
+
+func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit := storage.DefaultScanLimit
+	if raw := query.Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 || parsed > maxLimit {
+			pkghttp.BadRequest(w, "limit must be between 1 and 1000")
+			return
+		}
+		limit = parsed
+	}
+
+	result, err := h.useCase.Execute(r.Context(), query.Get("prefix"), query.Get("cursor"), limit)
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidCursor) {
+			pkghttp.BadRequest(w, "invalid cursor")
+			return
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			pkghttp.GatewayTimeout(w, "request timed out")
+			return
+		}
+		pkghttp.InternalServerError(w, "internal server error")
+		return
+	}
+
+	keys := result.Keys
+	if keys == nil {
+		keys = []string{}
+	}
+
+	pkghttp.JSON(w, http.StatusOK, Response{
+		Keys:   keys,
+		Cursor: result.Cursor,
+	})
+}
@@ -0,0 +1,23 @@
+package namespace
+
+import "time"
+
+type (
+	Request struct {
+		Name string `json:"name"`
+	}
+
+	Namespace struct {
+		Name      string    `json:"name"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+
+	ListResponse struct {
+		Namespaces []Namespace `json:"namespaces"`
+	}
+
+	DeleteResponse struct {
+		Name        string `json:"name"`
+		DeletedKeys int    `json:"deleted_keys"`
+	}
+)
@@ -0,0 +1,109 @@
+package namespace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/felipeascari/kv-store/internal/usecase/namespace"
+	pkghttp "github.com/felipeascari/kv-store/pkg/http"
+	"github.com/felipeascari/kv-store/pkg/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+type Handler struct {
+	useCase namespace.UseCase
+}
+
+func New(useCase namespace.UseCase) *Handler {
+	return &Handler{
+		useCase: useCase,
+	}
+}
+
+func (h *Handler) Create(w http.ResponseWriter, r *http.Request) {
+	var req Request
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		pkghttp.BadRequest(w, "invalid request body")
+		return
+	}
+
+	info, err := h.useCase.Create(r.Context(), req.Name)
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidNamespace) {
+			pkghttp.BadRequest(w, err.Error())
+			return
+		}
+		if errors.Is(err, storage.ErrNamespaceExists) {
+			pkghttp.Conflict(w, "namespace already exists")
+			return
+		}
+		writeError(w, err, "failed to create namespace")
+		return
+	}
+
+	pkghttp.JSON(w, http.StatusCreated, toNamespace(info))
+}
+
+func (h *Handler) List(w http.ResponseWriter, r *http.Request) {
+	infos, err := h.useCase.List(r.Context())
+	if err != nil {
+		writeError(w, err, "failed to list namespaces")
+		return
+	}
+
+	resp := ListResponse{Namespaces: make([]Namespace, len(infos))}
+	for i, info := range infos {
+		resp.Namespaces[i] = toNamespace(info)
+	}
+
+	pkghttp.JSON(w, http.StatusOK, resp)
+}
+
+func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "namespace")
+
+	deleted, err := h.useCase.Delete(r.Context(), name)
+	if err != nil {
+		if errors.Is(err, storage.ErrNamespaceNotFound) {
+			pkghttp.NotFound(w, "namespace not found")
+			return
+		}
+		writeError(w, err, "failed to delete namespace")
+		return
+	}
+
+	pkghttp.JSON(w, http.StatusOK, DeleteResponse{Name: name, DeletedKeys: deleted})
+}
+
+// Scope is a middleware scoping the request to the namespace of the route, which must exist.
+func (h *Handler) Scope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "namespace")
+
+		if _, err := h.useCase.Get(r.Context(), name); err != nil {
+			if errors.Is(err, storage.ErrNamespaceNotFound) {
+				pkghttp.NotFound(w, "namespace not found")
+				return
+			}
+			writeError(w, err, "internal server error")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(storage.WithNamespace(r.Context(), name)))
+	})
+}
+
+func writeError(w http.ResponseWriter, err error, msg string) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		pkghttp.GatewayTimeout(w, "request timed out")
+		return
+	}
+	pkghttp.InternalServerError(w, msg)
+}
+
+func toNamespace(info storage.NamespaceInfo) Namespace {
+	return Namespace{Name: info.Name, CreatedAt: info.CreatedAt}
+}
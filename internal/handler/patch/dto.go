@@ -0,0 +1,10 @@
+package patch
+
+import "time"
+
+type Response struct {
+	Key       string     `json:"key"`
+	Value     any        `json:"value"`
+	Version   int64      `json:"version"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
@@ -0,0 +1,127 @@
+package patch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/felipeascari/kv-store/internal/usecase/patch"
+	pkghttp "github.com/felipeascari/kv-store/pkg/http"
+	"github.com/felipeascari/kv-store/pkg/jsonpatch"
+	"github.com/felipeascari/kv-store/pkg/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	contentTypeJSONPatch  = "application/json-patch+json"
+	contentTypeMergePatch = "application/merge-patch+json"
+
+	// maxBodySize bounds the patch documents accepted.
+	maxBodySize = 1 << 20
+)
+
+var errUnsupportedMediaType = errors.New("content type must be " + contentTypeJSONPatch + " or " + contentTypeMergePatch)
+
+type Handler struct {
+	useCase patch.UseCase
+}
+
+func New(useCase patch.UseCase) *Handler {
+	return &Handler{
+		useCase: useCase,
+	}
+}
+
+func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+	if key == "" {
+		pkghttp.BadRequest(w, "key is required")
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		pkghttp.BadRequest(w, "invalid request body")
+		return
+	}
+
+	patcher, err := parsePatch(r.Header.Get("Content-Type"), body)
+	if err != nil {
+		if errors.Is(err, errUnsupportedMediaType) {
+			w.Header().Set("Accept-Patch", contentTypeJSONPatch+", "+contentTypeMergePatch)
+			pkghttp.UnsupportedMediaType(w, err.Error())
+			return
+		}
+		pkghttp.BadRequest(w, err.Error())
+		return
+	}
+
+	entry, err := h.useCase.Execute(r.Context(), key, patcher, pkghttp.Precondition(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrKeyNotFound):
+			pkghttp.NotFound(w, "key not found")
+		case errors.Is(err, storage.ErrVersionMismatch):
+			pkghttp.PreconditionFailed(w, "version mismatch")
+		case errors.Is(err, storage.ErrBlobValue):
+			pkghttp.Conflict(w, "cannot patch a binary value")
+		case errors.Is(err, jsonpatch.ErrTestFailed):
+			pkghttp.Conflict(w, err.Error())
+		case errors.Is(err, jsonpatch.ErrPathNotFound), errors.Is(err, jsonpatch.ErrInvalidPatch):
+			pkghttp.UnprocessableEntity(w, err.Error())
+		case errors.Is(err, storage.ErrOutOfMemory):
+			pkghttp.InsufficientStorage(w, "memory limit reached")
+		case errors.Is(err, storage.ErrReservedKey):
+			pkghttp.BadRequest(w, err.Error())
+		case errors.Is(err, context.DeadlineExceeded):
+			pkghttp.GatewayTimeout(w, "request timed out")
+		default:
+			pkghttp.InternalServerError(w, "failed to patch key")
+		}
+		return
+	}
+
+	resp := Response{
+		Key:     key,
+		Value:   entry.Value,
+		Version: entry.Version,
+	}
+	if !entry.ExpiresAt.IsZero() {
+		expiresAt := entry.ExpiresAt.UTC()
+		resp.ExpiresAt = &expiresAt
+	}
+
+	w.Header().Set("ETag", pkghttp.ETag(entry.Version))
+	pkghttp.JSON(w, http.StatusOK, resp)
+}
+
+// parsePatch builds the patcher for the body according to its media type.
+func parsePatch(contentType string, body []byte) (patch.Patcher, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, errUnsupportedMediaType
+	}
+
+	switch strings.ToLower(mediaType) {
+	case contentTypeJSONPatch:
+		ops, err := jsonpatch.Decode(body)
+		if err != nil {
+			return nil, err
+		}
+		return ops.Apply, nil
+	case contentTypeMergePatch:
+		var doc any
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return nil, errors.New("invalid merge patch")
+		}
+		return func(current any) (any, error) {
+			return jsonpatch.MergePatch(current, doc)
+		}, nil
+	default:
+		return nil, errUnsupportedMediaType
+	}
+}
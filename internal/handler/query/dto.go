@@ -0,0 +1,17 @@
+package query
+
+import "time"
+
+type (
+	Item struct {
+		Key       string     `json:"key"`
+		Value     any        `json:"value"`
+		Version   int64      `json:"version"`
+		ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	}
+
+	Response struct {
+		Items  []Item `json:"items"`
+		Cursor string `json:"cursor,omitempty"`
+	}
+)
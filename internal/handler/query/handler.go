@@ -0,0 +1,102 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/felipeascari/kv-store/internal/usecase/query"
+	pkghttp "github.com/felipeascari/kv-store/pkg/http"
+	"github.com/felipeascari/kv-store/pkg/storage"
+)
+
+const maxLimit = 1000
+
+type Handler struct {
+	useCase query.UseCase
+}
+
+func New(useCase query.UseCase) *Handler {
+	return &Handler{
+		useCase: useCase,
+	}
+}
+
+func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	limit := storage.DefaultScanLimit
+	if raw := params.Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 || parsed > maxLimit {
+			pkghttp.BadRequest(w, "limit must be between 1 and 1000")
+			return
+		}
+		limit = parsed
+	}
+
+	where, err := parseWhere(params["where"])
+	if err != nil {
+		pkghttp.BadRequest(w, err.Error())
+		return
+	}
+
+	result, err := h.useCase.Execute(r.Context(), storage.Query{
+		Prefix: params.Get("prefix"),
+		Where:  where,
+		Cursor: params.Get("cursor"),
+		Limit:  limit,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNoIndex):
+			pkghttp.BadRequest(w, "no index covers the query, declare one in INDEXES")
+		case errors.Is(err, storage.ErrInvalidCursor):
+			pkghttp.BadRequest(w, "invalid cursor")
+		case errors.Is(err, storage.ErrQueryUnsupported):
+			pkghttp.NotImplemented(w, "queries are not available for this storage")
+		case errors.Is(err, context.DeadlineExceeded):
+			pkghttp.GatewayTimeout(w, "request timed out")
+		default:
+			pkghttp.InternalServerError(w, "internal server error")
+		}
+		return
+	}
+
+	items := make([]Item, len(result.Items))
+	for i, match := range result.Items {
+		items[i] = Item{
+			Key:     match.Key,
+			Value:   match.Entry.Value,
+			Version: match.Entry.Version,
+		}
+		if !match.Entry.ExpiresAt.IsZero() {
+			expiresAt := match.Entry.ExpiresAt.UTC()
+			items[i].ExpiresAt = &expiresAt
+		}
+	}
+
+	pkghttp.JSON(w, http.StatusOK, Response{
+		Items:  items,
+		Cursor: result.Cursor,
+	})
+}
+
+// parseWhere parses path=value conditions, such as team=infra or address.city=Lisbon.
+func parseWhere(raw []string) ([]storage.Condition, error) {
+	if len(raw) == 0 {
+		return nil, errors.New("at least one where condition is required")
+	}
+
+	conditions := make([]storage.Condition, len(raw))
+	for i, condition := range raw {
+		path, value, ok := strings.Cut(condition, "=")
+		if !ok || path == "" {
+			return nil, errors.New("where must be path=value")
+		}
+		conditions[i] = storage.Condition{Path: path, Value: value}
+	}
+	return conditions, nil
+}
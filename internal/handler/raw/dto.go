@@ -0,0 +1,11 @@
+package raw
+
+import "time"
+
+type Response struct {
+	Key         string     `json:"key"`
+	ContentType string     `json:"content_type"`
+	Size        int        `json:"size"`
+	Version     int64      `json:"version"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
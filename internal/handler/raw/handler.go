@@ -0,0 +1,114 @@
+package raw
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/felipeascari/kv-store/internal/usecase/save"
+	pkghttp "github.com/felipeascari/kv-store/pkg/http"
+	"github.com/felipeascari/kv-store/pkg/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// maxBodySize bounds the size of a raw value.
+	maxBodySize = 32 << 20
+
+	defaultContentType = "application/octet-stream"
+)
+
+// Handler stores the request body as it is, along with its Content-Type, through the save use case.
+type Handler struct {
+	useCase save.UseCase
+}
+
+func New(useCase save.UseCase) *Handler {
+	return &Handler{
+		useCase: useCase,
+	}
+}
+
+func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+	if key == "" {
+		pkghttp.BadRequest(w, "key is required")
+		return
+	}
+
+	ttl, err := parseTTL(r)
+	if err != nil {
+		pkghttp.BadRequest(w, err.Error())
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			pkghttp.RequestEntityTooLarge(w, fmt.Sprintf("body must be at most %d bytes", maxBodySize))
+			return
+		}
+		pkghttp.BadRequest(w, "invalid request body")
+		return
+	}
+
+	blob := storage.Blob{ContentType: r.Header.Get("Content-Type"), Data: data}
+	if blob.ContentType == "" {
+		blob.ContentType = defaultContentType
+	}
+
+	version, err := h.useCase.Execute(r.Context(), key, blob, ttl, pkghttp.Precondition(r))
+	if err != nil {
+		if errors.Is(err, storage.ErrVersionMismatch) {
+			pkghttp.PreconditionFailed(w, "version mismatch")
+			return
+		}
+		if errors.Is(err, storage.ErrOutOfMemory) {
+			pkghttp.InsufficientStorage(w, "memory limit reached")
+			return
+		}
+		if errors.Is(err, storage.ErrReservedKey) {
+			pkghttp.BadRequest(w, err.Error())
+			return
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			pkghttp.GatewayTimeout(w, "request timed out")
+			return
+		}
+		pkghttp.InternalServerError(w, "failed to save key")
+		return
+	}
+
+	resp := Response{
+		Key:         key,
+		ContentType: blob.ContentType,
+		Size:        len(data),
+		Version:     version,
+	}
+	if ttl > 0 {
+		expiresAt := time.Now().Add(ttl).UTC()
+		resp.ExpiresAt = &expiresAt
+	}
+
+	w.Header().Set("ETag", pkghttp.ETag(version))
+	pkghttp.JSON(w, http.StatusCreated, resp)
+}
+
+// parseTTL reads the optional expires_in query parameter, in seconds.
+func parseTTL(r *http.Request) (time.Duration, error) {
+	raw := r.URL.Query().Get("expires_in")
+	if raw == "" {
+		return 0, nil
+	}
+
+	seconds, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return 0, pkghttp.ErrExpiresIn
+	}
+	return pkghttp.ExpiresIn(seconds)
+}
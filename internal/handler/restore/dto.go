@@ -0,0 +1,9 @@
+package restore
+
+import "time"
+
+type Response struct {
+	Key       string     `json:"key"`
+	Version   int64      `json:"version"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
@@ -0,0 +1,61 @@
+package restore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/felipeascari/kv-store/internal/usecase/restore"
+	pkghttp "github.com/felipeascari/kv-store/pkg/http"
+	"github.com/felipeascari/kv-store/pkg/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+type Handler struct {
+	useCase restore.UseCase
+}
+
+func New(useCase restore.UseCase) *Handler {
+	return &Handler{
+		useCase: useCase,
+	}
+}
+
+// Handle serves POST /keys/{key}/restore.
+func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+	if key == "" {
+		pkghttp.BadRequest(w, "key is required")
+		return
+	}
+
+	entry, err := h.useCase.Execute(r.Context(), key)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrKeyNotFound):
+			pkghttp.NotFound(w, "no deleted key to restore")
+		case errors.Is(err, storage.ErrKeyExists):
+			pkghttp.Conflict(w, "key was written again since it was deleted")
+		case errors.Is(err, storage.ErrSoftDeleteUnsupported):
+			pkghttp.NotImplemented(w, "soft deletes are not enabled")
+		case errors.Is(err, storage.ErrReservedKey):
+			pkghttp.BadRequest(w, err.Error())
+		case errors.Is(err, storage.ErrOutOfMemory):
+			pkghttp.InsufficientStorage(w, "memory limit reached")
+		case errors.Is(err, context.DeadlineExceeded):
+			pkghttp.GatewayTimeout(w, "request timed out")
+		default:
+			pkghttp.InternalServerError(w, "internal server error")
+		}
+		return
+	}
+
+	resp := Response{Key: key, Version: entry.Version}
+	if !entry.ExpiresAt.IsZero() {
+		expiresAt := entry.ExpiresAt.UTC()
+		resp.ExpiresAt = &expiresAt
+	}
+
+	w.Header().Set("ETag", pkghttp.ETag(entry.Version))
+	pkghttp.JSON(w, http.StatusOK, resp)
+}
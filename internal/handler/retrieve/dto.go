@@ -1,6 +1,13 @@
 package retrieve
 
+import "time"
+
 type Response struct {
-	Key   string `json:"key"`
-	Value any    `json:"value"`
+	Key       string     `json:"key"`
+	Value     any        `json:"value"`
+	Version   int64      `json:"version"`
+	TTL       *int64     `json:"ttl,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// WrittenAt is only set for past versions read with ?version= or ?at=.
+	WrittenAt *time.Time `json:"written_at,omitempty"`
 }
@@ -1,8 +1,11 @@
 package retrieve
 
 import (
+	"context"
 	"errors"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/felipeascari/kv-store/internal/usecase/retrieve"
 	pkghttp "github.com/felipeascari/kv-store/pkg/http"
@@ -27,18 +30,129 @@ func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	value, err := h.useCase.Execute(key)
+	params := r.URL.Query()
+	if params.Has("version") || params.Has("at") {
+		h.handleRevision(w, r, key)
+		return
+	}
+
+	entry, err := h.useCase.Execute(r.Context(), key)
 	if err != nil {
+		if errors.Is(err, storage.ErrKeyDeleted) {
+			pkghttp.Gone(w, "key was deleted")
+			return
+		}
 		if errors.Is(err, storage.ErrKeyNotFound) {
 			pkghttp.NotFound(w, "key not found")
 			return
 		}
+		if errors.Is(err, storage.ErrReservedKey) {
+			pkghttp.BadRequest(w, err.Error())
+			return
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			pkghttp.GatewayTimeout(w, "request timed out")
+			return
+		}
 		pkghttp.InternalServerError(w, "internal server error")
 		return
 	}
 
-	pkghttp.JSON(w, http.StatusOK, Response{
-		Key:   key,
-		Value: value,
-	})
+	if blob, ok := entry.Value.(storage.Blob); ok {
+		writeBlob(w, blob, entry)
+		return
+	}
+
+	resp := Response{
+		Key:     key,
+		Value:   entry.Value,
+		Version: entry.Version,
+	}
+	if !entry.ExpiresAt.IsZero() {
+		ttl := int64(entry.TTL().Round(time.Second) / time.Second)
+		expiresAt := entry.ExpiresAt.UTC()
+		resp.TTL = &ttl
+		resp.ExpiresAt = &expiresAt
+	}
+
+	w.Header().Set("ETag", pkghttp.ETag(entry.Version))
+	pkghttp.JSON(w, http.StatusOK, resp)
+}
+
+// handleRevision serves a past version of the key, selected by number with ?version=
+// or by time with ?at=.
+func (h *Handler) handleRevision(w http.ResponseWriter, r *http.Request, key string) {
+	params := r.URL.Query()
+	if params.Has("version") && params.Has("at") {
+		pkghttp.BadRequest(w, "version and at cannot be combined")
+		return
+	}
+
+	var (
+		revision storage.Revision
+		err      error
+	)
+	if params.Has("version") {
+		version, parseErr := strconv.ParseInt(params.Get("version"), 10, 64)
+		if parseErr != nil || version <= 0 {
+			pkghttp.BadRequest(w, "version must be a positive integer")
+			return
+		}
+		revision, err = h.useCase.ExecuteVersion(r.Context(), key, version)
+	} else {
+		at, parseErr := time.Parse(time.RFC3339, params.Get("at"))
+		if parseErr != nil {
+			pkghttp.BadRequest(w, "at must be an RFC 3339 timestamp")
+			return
+		}
+		revision, err = h.useCase.ExecuteAt(r.Context(), key, at)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrKeyNotFound):
+			pkghttp.NotFound(w, "key not found")
+		case errors.Is(err, storage.ErrVersionNotFound):
+			pkghttp.NotFound(w, "version not found")
+		case errors.Is(err, storage.ErrHistoryUnsupported):
+			pkghttp.NotImplemented(w, "version history is not enabled for this storage")
+		case errors.Is(err, storage.ErrReservedKey):
+			pkghttp.BadRequest(w, err.Error())
+		case errors.Is(err, context.DeadlineExceeded):
+			pkghttp.GatewayTimeout(w, "request timed out")
+		default:
+			pkghttp.InternalServerError(w, "internal server error")
+		}
+		return
+	}
+
+	entry := storage.Entry{Value: revision.Value, Version: revision.Version}
+	if blob, ok := revision.Value.(storage.Blob); ok {
+		writeBlob(w, blob, entry)
+		return
+	}
+
+	resp := Response{
+		Key:     key,
+		Value:   revision.Value,
+		Version: revision.Version,
+	}
+	if !revision.WrittenAt.IsZero() {
+		writtenAt := revision.WrittenAt.UTC()
+		resp.WrittenAt = &writtenAt
+	}
+
+	w.Header().Set("ETag", pkghttp.ETag(revision.Version))
+	pkghttp.JSON(w, http.StatusOK, resp)
+}
+
+// writeBlob replies with a raw value byte for byte, under the content type it was stored with.
+func writeBlob(w http.ResponseWriter, blob storage.Blob, entry storage.Entry) {
+	w.Header().Set("Content-Type", blob.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(blob.Data)))
+	w.Header().Set("ETag", pkghttp.ETag(entry.Version))
+	if !entry.ExpiresAt.IsZero() {
+		w.Header().Set("Expires", entry.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(blob.Data)
 }
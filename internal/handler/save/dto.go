@@ -1,13 +1,19 @@
 package save
 
+import "time"
+
 type (
 	Request struct {
-		Key   string `json:"key"`
-		Value any    `json:"value"`
+		Key       string     `json:"key"`
+		Value     any        `json:"value"`
+		ExpiresIn *int64     `json:"expires_in,omitempty"`
+		ExpiresAt *time.Time `json:"expires_at,omitempty"`
 	}
 
 	Response struct {
-		Key   string `json:"key"`
-		Value any    `json:"value"`
+		Key       string     `json:"key"`
+		Value     any        `json:"value"`
+		Version   int64      `json:"version"`
+		ExpiresAt *time.Time `json:"expires_at,omitempty"`
 	}
 )
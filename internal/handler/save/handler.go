@@ -1,11 +1,15 @@
 package save
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/felipeascari/kv-store/internal/usecase/save"
 	pkghttp "github.com/felipeascari/kv-store/pkg/http"
+	"github.com/felipeascari/kv-store/pkg/storage"
 )
 
 type Handler struct {
@@ -31,13 +35,59 @@ func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.useCase.Execute(req.Key, req.Value); err != nil {
+	ttl, err := parseTTL(req, time.Now())
+	if err != nil {
+		pkghttp.BadRequest(w, err.Error())
+		return
+	}
+
+	version, err := h.useCase.Execute(r.Context(), req.Key, req.Value, ttl, pkghttp.Precondition(r))
+	if err != nil {
+		if errors.Is(err, storage.ErrVersionMismatch) {
+			pkghttp.PreconditionFailed(w, "version mismatch")
+			return
+		}
+		if errors.Is(err, storage.ErrOutOfMemory) {
+			pkghttp.InsufficientStorage(w, "memory limit reached")
+			return
+		}
+		if errors.Is(err, storage.ErrReservedKey) {
+			pkghttp.BadRequest(w, err.Error())
+			return
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			pkghttp.GatewayTimeout(w, "request timed out")
+			return
+		}
 		pkghttp.InternalServerError(w, "failed to save key")
 		return
 	}
 
-	pkghttp.JSON(w, http.StatusCreated, Response{
-		Key:   req.Key,
-		Value: req.Value,
-	})
+	resp := Response{
+		Key:     req.Key,
+		Value:   req.Value,
+		Version: version,
+	}
+	if ttl > 0 {
+		expiresAt := time.Now().Add(ttl).UTC()
+		resp.ExpiresAt = &expiresAt
+	}
+
+	w.Header().Set("ETag", pkghttp.ETag(version))
+	pkghttp.JSON(w, http.StatusCreated, resp)
+}
+
+// parseTTL converts the optional expires_in (seconds) or expires_at fields into a TTL.
+// A zero TTL means the key never expires.
+func parseTTL(req Request, now time.Time) (time.Duration, error) {
+	switch {
+	case req.ExpiresIn != nil && req.ExpiresAt != nil:
+		return 0, errors.New("expires_in and expires_at are mutually exclusive")
+	case req.ExpiresIn != nil:
+		return pkghttp.ExpiresIn(*req.ExpiresIn)
+	case req.ExpiresAt != nil:
+		return pkghttp.ExpiresAt(*req.ExpiresAt, now)
+	default:
+		return 0, nil
+	}
 }
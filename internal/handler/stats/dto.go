@@ -0,0 +1,36 @@
+package stats
+
+type (
+	// Response inlines the memory stats, for backward compatibility, when the storage has any.
+	Response struct {
+		*MemoryResponse
+		Compression *CompressionResponse `json:"compression,omitempty"`
+		Watch       *WatchResponse       `json:"watch,omitempty"`
+	}
+
+	MemoryResponse struct {
+		Keys        int64  `json:"keys"`
+		Bytes       int64  `json:"bytes"`
+		MaxKeys     int64  `json:"max_keys,omitempty"`
+		MaxBytes    int64  `json:"max_bytes,omitempty"`
+		Eviction    string `json:"eviction_policy"`
+		Evictions   uint64 `json:"evictions"`
+		Expirations uint64 `json:"expirations"`
+	}
+
+	CompressionResponse struct {
+		Algorithm  string  `json:"algorithm"`
+		Threshold  int     `json:"threshold"`
+		Compressed uint64  `json:"compressed"`
+		Skipped    uint64  `json:"skipped"`
+		BytesIn    uint64  `json:"bytes_in"`
+		BytesOut   uint64  `json:"bytes_out"`
+		Ratio      float64 `json:"ratio"`
+	}
+
+	WatchResponse struct {
+		Revision int64 `json:"revision"`
+		// PublishFailures counts the changes this replica could not publish to watchers.
+		PublishFailures uint64 `json:"publish_failures"`
+	}
+)
@@ -0,0 +1,64 @@
+package stats
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/felipeascari/kv-store/internal/usecase/stats"
+	pkghttp "github.com/felipeascari/kv-store/pkg/http"
+)
+
+type Handler struct {
+	useCase stats.UseCase
+}
+
+func New(useCase stats.UseCase) *Handler {
+	return &Handler{
+		useCase: useCase,
+	}
+}
+
+func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) {
+	result, err := h.useCase.Execute(r.Context())
+	if err != nil {
+		if errors.Is(err, stats.ErrUnsupported) {
+			pkghttp.NotImplemented(w, "stats are not available for this storage")
+			return
+		}
+		pkghttp.InternalServerError(w, "internal server error")
+		return
+	}
+
+	var resp Response
+	if m := result.Memory; m != nil {
+		resp.MemoryResponse = &MemoryResponse{
+			Keys:        m.Keys,
+			Bytes:       m.Bytes,
+			MaxKeys:     m.MaxKeys,
+			MaxBytes:    m.MaxBytes,
+			Eviction:    m.Eviction.String(),
+			Evictions:   m.Evictions,
+			Expirations: m.Expirations,
+		}
+	}
+	if c := result.Compression; c != nil {
+		resp.Compression = &CompressionResponse{
+			Algorithm:  c.Algorithm.String(),
+			Threshold:  c.Threshold,
+			Compressed: c.Compressed,
+			Skipped:    c.Skipped,
+			BytesIn:    c.BytesIn,
+			BytesOut:   c.BytesOut,
+			Ratio:      c.Ratio(),
+		}
+	}
+
+	if b := result.Watch; b != nil {
+		resp.Watch = &WatchResponse{
+			Revision:        b.Revision,
+			PublishFailures: b.Failures,
+		}
+	}
+
+	pkghttp.JSON(w, http.StatusOK, resp)
+}
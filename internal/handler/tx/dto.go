@@ -0,0 +1,40 @@
+package tx
+
+import "time"
+
+type (
+	Request struct {
+		Checks []Check `json:"checks"`
+		Ops    []Op    `json:"ops"`
+	}
+
+	// Check requires the key to be at version, 0 meaning the key must not exist.
+	Check struct {
+		Key     string `json:"key"`
+		Version *int64 `json:"version"`
+	}
+
+	Op struct {
+		Op        string `json:"op"`
+		Key       string `json:"key"`
+		Value     any    `json:"value,omitempty"`
+		ExpiresIn *int64 `json:"expires_in,omitempty"`
+	}
+
+	Response struct {
+		Results []Result `json:"results"`
+	}
+
+	Result struct {
+		Key       string     `json:"key"`
+		Version   int64      `json:"version"`
+		ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	}
+
+	CheckFailedResponse struct {
+		Error    string `json:"error"`
+		Key      string `json:"key"`
+		Expected int64  `json:"expected_version"`
+		Actual   int64  `json:"actual_version"`
+	}
+)
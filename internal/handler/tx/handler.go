@@ -0,0 +1,128 @@
+package tx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/felipeascari/kv-store/internal/usecase/tx"
+	pkghttp "github.com/felipeascari/kv-store/pkg/http"
+	"github.com/felipeascari/kv-store/pkg/storage"
+)
+
+const maxOps = 1000
+
+type Handler struct {
+	useCase tx.UseCase
+}
+
+func New(useCase tx.UseCase) *Handler {
+	return &Handler{
+		useCase: useCase,
+	}
+}
+
+func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) {
+	var req Request
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		pkghttp.BadRequest(w, "invalid request body")
+		return
+	}
+
+	transaction, err := toTransaction(req)
+	if err != nil {
+		pkghttp.BadRequest(w, err.Error())
+		return
+	}
+
+	results, err := h.useCase.Execute(r.Context(), transaction)
+	if err != nil {
+		var check *storage.TxCheckError
+		if errors.As(err, &check) {
+			pkghttp.JSON(w, http.StatusPreconditionFailed, CheckFailedResponse{
+				Error:    "check failed",
+				Key:      check.Key,
+				Expected: check.Expected,
+				Actual:   check.Actual,
+			})
+			return
+		}
+		if errors.Is(err, storage.ErrOutOfMemory) {
+			pkghttp.InsufficientStorage(w, "memory limit reached")
+			return
+		}
+		if errors.Is(err, storage.ErrReservedKey) {
+			pkghttp.BadRequest(w, err.Error())
+			return
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			pkghttp.GatewayTimeout(w, "request timed out")
+			return
+		}
+		pkghttp.InternalServerError(w, "failed to execute transaction")
+		return
+	}
+
+	resp := Response{Results: make([]Result, len(results))}
+	for i, result := range results {
+		resp.Results[i] = Result{Key: result.Key, Version: result.Version}
+		if !result.ExpiresAt.IsZero() {
+			expiresAt := result.ExpiresAt.UTC()
+			resp.Results[i].ExpiresAt = &expiresAt
+		}
+	}
+
+	pkghttp.JSON(w, http.StatusOK, resp)
+}
+
+func toTransaction(req Request) (storage.Transaction, error) {
+	if len(req.Ops) == 0 || len(req.Ops) > maxOps {
+		return storage.Transaction{}, fmt.Errorf("ops must contain between 1 and %d entries", maxOps)
+	}
+	if len(req.Checks) > maxOps {
+		return storage.Transaction{}, fmt.Errorf("checks must contain at most %d entries", maxOps)
+	}
+
+	transaction := storage.Transaction{
+		Checks: make([]storage.TxCheck, len(req.Checks)),
+		Ops:    make([]storage.TxOp, len(req.Ops)),
+	}
+
+	for i, check := range req.Checks {
+		if check.Key == "" {
+			return storage.Transaction{}, fmt.Errorf("checks[%d]: key is required", i)
+		}
+		if check.Version == nil || *check.Version < 0 {
+			return storage.Transaction{}, fmt.Errorf("checks[%d]: version is required, 0 meaning the key must not exist", i)
+		}
+		transaction.Checks[i] = storage.TxCheck{Key: check.Key, Version: *check.Version}
+	}
+
+	for i, op := range req.Ops {
+		if op.Key == "" {
+			return storage.Transaction{}, fmt.Errorf("ops[%d]: key is required", i)
+		}
+
+		txOp := storage.TxOp{Type: storage.TxOpType(op.Op), Key: op.Key, Value: op.Value}
+		switch txOp.Type {
+		case storage.TxSet:
+			if op.ExpiresIn != nil {
+				ttl, err := pkghttp.ExpiresIn(*op.ExpiresIn)
+				if err != nil {
+					return storage.Transaction{}, fmt.Errorf("ops[%d]: %w", i, err)
+				}
+				txOp.TTL = ttl
+			}
+		case storage.TxDelete:
+		default:
+			return storage.Transaction{}, fmt.Errorf("ops[%d]: op must be set or delete", i)
+		}
+
+		transaction.Ops[i] = txOp
+	}
+
+	return transaction, nil
+}
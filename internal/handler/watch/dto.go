@@ -0,0 +1,15 @@
+package watch
+
+import "time"
+
+type Event struct {
+	Revision int64  `json:"revision"`
+	Type     string `json:"type"`
+	Key      string `json:"key"`
+	// Value holds raw values in base64, along with their ContentType.
+	Value       any        `json:"value,omitempty"`
+	ContentType string     `json:"content_type,omitempty"`
+	Version     int64      `json:"version,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Timestamp   time.Time  `json:"timestamp"`
+}
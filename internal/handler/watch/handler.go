@@ -0,0 +1,212 @@
+package watch
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/felipeascari/kv-store/internal/usecase/watch"
+	pkghttp "github.com/felipeascari/kv-store/pkg/http"
+	"github.com/felipeascari/kv-store/pkg/storage"
+	pkgwatch "github.com/felipeascari/kv-store/pkg/watch"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// RevisionHeader carries the revision a watch starts after, to resume from if no change
+	// arrives before the client disconnects.
+	RevisionHeader = "X-Watch-Revision"
+
+	// keepAliveInterval is how often idle streams are written to, so proxies keep them open.
+	keepAliveInterval = 15 * time.Second
+	writeTimeout      = 10 * time.Second
+)
+
+type Handler struct {
+	useCase  watch.UseCase
+	upgrader websocket.Upgrader
+}
+
+func New(useCase watch.UseCase) *Handler {
+	return &Handler{
+		useCase: useCase,
+	}
+}
+
+// Handle serves GET /watch, streaming the changes of the keys starting with ?prefix= over
+// WebSocket when the request is an upgrade and as server-sent events otherwise. ?revision=,
+// or the Last-Event-ID header sent by reconnecting event sources, resumes after a revision.
+func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) {
+	after, err := resumeRevision(r)
+	if err != nil {
+		pkghttp.BadRequest(w, "revision must be a non-negative integer")
+		return
+	}
+
+	sub, err := h.useCase.Execute(r.Context(), r.URL.Query().Get("prefix"), after)
+	if err != nil {
+		if errors.Is(err, pkgwatch.ErrCompacted) {
+			pkghttp.Gone(w, "revision is no longer available")
+			return
+		}
+		pkghttp.InternalServerError(w, "internal server error")
+		return
+	}
+	defer sub.Close()
+
+	if websocket.IsWebSocketUpgrade(r) {
+		h.serveWebSocket(w, r, sub)
+		return
+	}
+	serveEvents(w, r, sub)
+}
+
+// serveEvents streams the changes as server-sent events, each with its revision as ID.
+// When the subscription ends an error event says why before the stream is closed.
+func serveEvents(w http.ResponseWriter, r *http.Request, sub *pkgwatch.Subscription) {
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set(RevisionHeader, strconv.FormatInt(sub.Revision(), 10))
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		var err error
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			err = writeEvent(w, rc, ": keep-alive\n\n")
+		case e, ok := <-sub.Events():
+			if !ok {
+				if err := sub.Err(); err != nil {
+					data, _ := json.Marshal(pkghttp.NewErrorResponse(err.Error()))
+					_ = writeEvent(w, rc, "event: error\ndata: %s\n\n", data)
+				}
+				return
+			}
+
+			data, _ := json.Marshal(toEvent(e))
+			err = writeEvent(w, rc, "id: %d\nevent: %s\ndata: %s\n\n", e.Revision, e.Type, data)
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, rc *http.ResponseController, format string, args ...any) error {
+	if _, err := fmt.Fprintf(w, format, args...); err != nil {
+		return err
+	}
+	return rc.Flush()
+}
+
+// serveWebSocket sends every change as a JSON text message. When the subscription ends the
+// connection is closed with the reason.
+func (h *Handler) serveWebSocket(w http.ResponseWriter, r *http.Request, sub *pkgwatch.Subscription) {
+	header := http.Header{RevisionHeader: {strconv.FormatInt(sub.Revision(), 10)}}
+	conn, err := h.upgrader.Upgrade(w, r, header)
+	if err != nil {
+		return
+	}
+	defer func() { _ = conn.Close() }()
+
+	// Messages from the client are discarded, reading only handles control frames and
+	// notices when the client goes away.
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		var err error
+
+		select {
+		case <-gone:
+			return
+		case <-keepAlive.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout))
+		case e, ok := <-sub.Events():
+			if !ok {
+				closeWebSocket(conn, sub.Err())
+				return
+			}
+
+			_ = conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			err = conn.WriteJSON(toEvent(e))
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// closeWebSocket tells the client why its subscription ended.
+func closeWebSocket(conn *websocket.Conn, err error) {
+	reason := "watch closed"
+	if err != nil {
+		reason = err.Error()
+	}
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, reason)
+	_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeTimeout))
+}
+
+// resumeRevision returns the revision to resume after, nil to only watch new changes.
+func resumeRevision(r *http.Request) (*int64, error) {
+	raw := r.URL.Query().Get("revision")
+	if raw == "" {
+		raw = r.Header.Get("Last-Event-ID")
+	}
+	if raw == "" {
+		return nil, nil
+	}
+
+	revision, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || revision < 0 {
+		return nil, strconv.ErrSyntax
+	}
+	return &revision, nil
+}
+
+func toEvent(e pkgwatch.Event) Event {
+	event := Event{
+		Revision:  e.Revision,
+		Type:      string(e.Type),
+		Key:       e.Key,
+		Value:     e.Value,
+		Version:   e.Version,
+		Timestamp: e.Timestamp.UTC(),
+	}
+	if blob, ok := e.Value.(storage.Blob); ok {
+		event.Value = base64.StdEncoding.EncodeToString(blob.Data)
+		event.ContentType = blob.ContentType
+	}
+	if !e.ExpiresAt.IsZero() {
+		expiresAt := e.ExpiresAt.UTC()
+		event.ExpiresAt = &expiresAt
+	}
+	return event
+}
@@ -0,0 +1,149 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/felipeascari/kv-store/pkg/storage"
+	"github.com/felipeascari/kv-store/pkg/watch"
+)
+
+// importBatchSize is the number of keys overwritten per batch.
+const importBatchSize = 100
+
+type (
+	UseCase struct {
+		store  storage.Store
+		events watch.Publisher
+	}
+
+	// Record is a key to import. Its version is not kept: imported keys get the next version
+	// of the store.
+	Record struct {
+		Key   string
+		Entry storage.Entry
+	}
+
+	// Result counts the imported records. Expired counts the records whose key expired
+	// since it was exported, which are not imported.
+	Result struct {
+		Imported int
+		Skipped  int
+		Expired  int
+	}
+
+	// importer writes the records of an import, overwriting keys a batch at a time.
+	importer struct {
+		UseCase
+		mode    storage.ImportMode
+		pending []storage.BatchItem
+		result  Result
+	}
+)
+
+func NewUseCase(s storage.Store, events watch.Publisher) UseCase {
+	return UseCase{store: s, events: events}
+}
+
+// Export calls fn with every stored key and its entry, see storage.Export.
+func (u UseCase) Export(ctx context.Context, fn func(key string, entry storage.Entry) error) error {
+	return storage.Export(ctx, u.store, fn)
+}
+
+// Snapshot reports whether exports are taken as of a single point in time.
+func (u UseCase) Snapshot() bool {
+	return storage.ExportsSnapshot(u.store)
+}
+
+// Import writes the records returned by next until it returns io.EOF. The records read
+// before an error are imported, and the result counts them along with the error.
+func (u UseCase) Import(ctx context.Context, mode storage.ImportMode, next func() (Record, error)) (Result, error) {
+	if !mode.IsValid() {
+		return Result{}, storage.ErrInvalidImportMode
+	}
+
+	im := &importer{UseCase: u, mode: mode}
+	for {
+		record, err := next()
+		if err != nil {
+			if flushErr := im.flush(ctx); flushErr != nil {
+				return im.result, flushErr
+			}
+			if errors.Is(err, io.EOF) {
+				return im.result, nil
+			}
+			return im.result, err
+		}
+
+		if err := im.add(ctx, record); err != nil {
+			return im.result, err
+		}
+	}
+}
+
+// add writes a record, or queues it when overwriting. Records with an expiration get the
+// time they have left.
+func (im *importer) add(ctx context.Context, record Record) error {
+	var ttl time.Duration
+	if !record.Entry.ExpiresAt.IsZero() {
+		if ttl = time.Until(record.Entry.ExpiresAt); ttl <= 0 {
+			im.result.Expired++
+			return nil
+		}
+	}
+
+	if im.mode == storage.ImportOverwrite {
+		im.pending = append(im.pending, storage.BatchItem{Key: record.Key, Value: record.Entry.Value, TTL: ttl})
+		if len(im.pending) < importBatchSize {
+			return nil
+		}
+		return im.flush(ctx)
+	}
+
+	version, err := im.store.CompareAndSwap(ctx, record.Key, 0, record.Entry.Value, ttl)
+	if errors.Is(err, storage.ErrVersionMismatch) {
+		im.result.Skipped++
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	im.result.Imported++
+	im.publish(ctx, record.Key, storage.Entry{Value: record.Entry.Value, Version: version, ExpiresAt: record.Entry.ExpiresAt})
+	return nil
+}
+
+// flush overwrites the queued keys.
+func (im *importer) flush(ctx context.Context) error {
+	if len(im.pending) == 0 {
+		return nil
+	}
+
+	results, err := im.store.BatchSave(ctx, im.pending)
+	im.pending = im.pending[:0]
+	if err != nil {
+		return err
+	}
+
+	for _, result := range results {
+		if result.Err != nil {
+			return result.Err
+		}
+		im.result.Imported++
+		im.publish(ctx, result.Key, result.Entry)
+	}
+	return nil
+}
+
+// publish announces the write of an imported key to the watchers of its namespace. The
+// store's own keys are not watched.
+func (im *importer) publish(ctx context.Context, stored string, entry storage.Entry) {
+	namespace, key, ok := storage.LocalKey(stored)
+	if !ok {
+		return
+	}
+	im.events.Publish(ctx, watch.PutEvent(storage.WithNamespace(ctx, namespace), key, entry))
+}
@@ -0,0 +1,70 @@
+package batch
+
+import (
+	"context"
+	"errors"
+
+	"github.com/felipeascari/kv-store/pkg/storage"
+	"github.com/felipeascari/kv-store/pkg/watch"
+)
+
+const (
+	OpGet    Operation = "get"
+	OpSet    Operation = "set"
+	OpDelete Operation = "delete"
+)
+
+var ErrUnknownOperation = errors.New("unknown batch operation")
+
+type (
+	Operation string
+
+	UseCase struct {
+		store  storage.Store
+		events watch.Publisher
+	}
+)
+
+func NewUseCase(s storage.Store, events watch.Publisher) UseCase {
+	return UseCase{store: s, events: events}
+}
+
+// Execute applies op to every item in one storage round trip. Values and TTLs are ignored for get and delete.
+func (u UseCase) Execute(ctx context.Context, op Operation, items []storage.BatchItem) ([]storage.BatchResult, error) {
+	switch op {
+	case OpSet:
+		results, err := u.store.BatchSave(ctx, items)
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range results {
+			if result.Err == nil {
+				u.events.Publish(ctx, watch.PutEvent(ctx, result.Key, result.Entry))
+			}
+		}
+		return results, nil
+	case OpGet:
+		return u.store.BatchRetrieve(ctx, keys(items))
+	case OpDelete:
+		results, err := u.store.BatchDelete(ctx, keys(items))
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range results {
+			if result.Err == nil {
+				u.events.Publish(ctx, watch.DeleteEvent(ctx, result.Key))
+			}
+		}
+		return results, nil
+	default:
+		return nil, ErrUnknownOperation
+	}
+}
+
+func keys(items []storage.BatchItem) []string {
+	keys := make([]string, len(items))
+	for i, item := range items {
+		keys[i] = item.Key
+	}
+	return keys
+}
@@ -0,0 +1,103 @@
+package collection
+
+import (
+	"context"
+
+	"github.com/felipeascari/kv-store/pkg/storage"
+)
+
+type UseCase struct {
+	store storage.Store
+}
+
+func NewUseCase(s storage.Store) UseCase {
+	return UseCase{store: s}
+}
+
+func (u UseCase) HashSet(ctx context.Context, key string, fields map[string]any) (int, error) {
+	c, err := u.collections()
+	if err != nil {
+		return 0, err
+	}
+	return c.HashSet(ctx, key, fields)
+}
+
+func (u UseCase) HashGet(ctx context.Context, key, field string) (any, error) {
+	c, err := u.collections()
+	if err != nil {
+		return nil, err
+	}
+	return c.HashGet(ctx, key, field)
+}
+
+func (u UseCase) HashGetAll(ctx context.Context, key string) (map[string]any, error) {
+	c, err := u.collections()
+	if err != nil {
+		return nil, err
+	}
+	return c.HashGetAll(ctx, key)
+}
+
+func (u UseCase) ListPush(ctx context.Context, key string, values []any) (int, error) {
+	c, err := u.collections()
+	if err != nil {
+		return 0, err
+	}
+	return c.ListPush(ctx, key, values)
+}
+
+func (u UseCase) ListPop(ctx context.Context, key string) (any, error) {
+	c, err := u.collections()
+	if err != nil {
+		return nil, err
+	}
+	return c.ListPop(ctx, key)
+}
+
+func (u UseCase) ListRange(ctx context.Context, key string, start, stop int) ([]any, error) {
+	c, err := u.collections()
+	if err != nil {
+		return nil, err
+	}
+	return c.ListRange(ctx, key, start, stop)
+}
+
+func (u UseCase) SetAdd(ctx context.Context, key string, members []string) (int, error) {
+	c, err := u.collections()
+	if err != nil {
+		return 0, err
+	}
+	return c.SetAdd(ctx, key, members)
+}
+
+func (u UseCase) SetMembers(ctx context.Context, key string) ([]string, error) {
+	c, err := u.collections()
+	if err != nil {
+		return nil, err
+	}
+	return c.SetMembers(ctx, key)
+}
+
+func (u UseCase) SortedSetAdd(ctx context.Context, key string, members []storage.ScoredMember) (int, error) {
+	c, err := u.collections()
+	if err != nil {
+		return 0, err
+	}
+	return c.SortedSetAdd(ctx, key, members)
+}
+
+func (u UseCase) SortedSetRange(ctx context.Context, key string, start, stop int) ([]storage.ScoredMember, error) {
+	c, err := u.collections()
+	if err != nil {
+		return nil, err
+	}
+	return c.SortedSetRange(ctx, key, start, stop)
+}
+
+func (u UseCase) collections() (storage.Collections, error) {
+	c, ok := u.store.(storage.Collections)
+	if !ok {
+		return nil, storage.ErrCollectionsUnsupported
+	}
+	return c, nil
+}
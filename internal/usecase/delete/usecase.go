@@ -1,15 +1,39 @@
 package delete
 
-import "github.com/felipeascari/kv-store/pkg/storage"
+import (
+	"context"
+
+	"github.com/felipeascari/kv-store/pkg/storage"
+	"github.com/felipeascari/kv-store/pkg/watch"
+)
 
 type UseCase struct {
-	store storage.Store
+	store  storage.Store
+	events watch.Publisher
+}
+
+func NewUseCase(s storage.Store, events watch.Publisher) UseCase {
+	return UseCase{store: s, events: events}
 }
 
-func NewUseCase(s storage.Store) UseCase {
-	return UseCase{store: s}
+func (u UseCase) Execute(ctx context.Context, key string, cond storage.Precondition) error {
+	if err := u.delete(ctx, key, cond); err != nil {
+		return err
+	}
+
+	u.events.Publish(ctx, watch.DeleteEvent(ctx, key))
+	return nil
 }
 
-func (u UseCase) Execute(key string) error {
-	return u.store.Delete(key)
+func (u UseCase) delete(ctx context.Context, key string, cond storage.Precondition) error {
+	if cond.IsZero() {
+		return u.store.Delete(ctx, key)
+	}
+
+	expected, err := storage.ResolveDeleteVersion(ctx, u.store, key, cond)
+	if err != nil {
+		return err
+	}
+
+	return u.store.CompareAndDelete(ctx, key, expected)
 }
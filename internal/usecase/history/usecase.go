@@ -0,0 +1,45 @@
+package history
+
+import (
+	"context"
+
+	"github.com/felipeascari/kv-store/pkg/storage"
+	"github.com/felipeascari/kv-store/pkg/watch"
+)
+
+type UseCase struct {
+	store  storage.Store
+	events watch.Publisher
+}
+
+func NewUseCase(s storage.Store, events watch.Publisher) UseCase {
+	return UseCase{store: s, events: events}
+}
+
+// List returns the versions kept for the key, newest first.
+func (u UseCase) List(ctx context.Context, key string) ([]storage.Revision, error) {
+	historian, ok := u.store.(storage.Historian)
+	if !ok {
+		return nil, storage.ErrHistoryUnsupported
+	}
+	return historian.History(ctx, key)
+}
+
+// Restore writes the value the key had at the given version as its new version, keeping
+// its expiration. It returns the updated entry.
+func (u UseCase) Restore(ctx context.Context, key string, version int64) (storage.Entry, error) {
+	revision, err := storage.RetrieveVersion(ctx, u.store, key, version)
+	if err != nil {
+		return storage.Entry{}, err
+	}
+
+	entry, err := u.store.Update(ctx, key, func(_ storage.Entry) (any, error) {
+		return revision.Value, nil
+	})
+	if err != nil {
+		return storage.Entry{}, err
+	}
+
+	u.events.Publish(ctx, watch.PutEvent(ctx, key, entry))
+	return entry, nil
+}
@@ -0,0 +1,28 @@
+package increment
+
+import (
+	"context"
+
+	"github.com/felipeascari/kv-store/pkg/storage"
+	"github.com/felipeascari/kv-store/pkg/watch"
+)
+
+type UseCase struct {
+	store  storage.Store
+	events watch.Publisher
+}
+
+func NewUseCase(s storage.Store, events watch.Publisher) UseCase {
+	return UseCase{store: s, events: events}
+}
+
+// Execute atomically adds delta to the numeric value of the key, a negative delta decrementing it.
+func (u UseCase) Execute(ctx context.Context, key string, delta float64) (storage.Entry, error) {
+	entry, err := u.store.Increment(ctx, key, delta)
+	if err != nil {
+		return storage.Entry{}, err
+	}
+
+	u.events.Publish(ctx, watch.PutEvent(ctx, key, entry))
+	return entry, nil
+}
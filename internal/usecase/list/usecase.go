@@ -0,0 +1,19 @@
+package list
+
+import (
+	"context"
+
+	"github.com/felipeascari/kv-store/pkg/storage"
+)
+
+type UseCase struct {
+	store storage.Store
+}
+
+func NewUseCase(s storage.Store) UseCase {
+	return UseCase{store: s}
+}
+
+func (u UseCase) Execute(ctx context.Context, prefix, cursor string, limit int) (storage.ScanResult, error) {
+	return u.store.Scan(ctx, prefix, cursor, limit)
+}
@@ -0,0 +1,32 @@
+package namespace
+
+import (
+	"context"
+
+	"github.com/felipeascari/kv-store/pkg/storage"
+)
+
+type UseCase struct {
+	namespaces *storage.Namespaces
+}
+
+func NewUseCase(s storage.Store) UseCase {
+	return UseCase{namespaces: storage.NewNamespaces(s)}
+}
+
+func (u UseCase) Create(ctx context.Context, name string) (storage.NamespaceInfo, error) {
+	return u.namespaces.Create(ctx, name)
+}
+
+func (u UseCase) Get(ctx context.Context, name string) (storage.NamespaceInfo, error) {
+	return u.namespaces.Get(ctx, name)
+}
+
+func (u UseCase) List(ctx context.Context) ([]storage.NamespaceInfo, error) {
+	return u.namespaces.List(ctx)
+}
+
+// Delete removes the namespace and every key in it, returning how many keys were deleted.
+func (u UseCase) Delete(ctx context.Context, name string) (int, error) {
+	return u.namespaces.Delete(ctx, name)
+}
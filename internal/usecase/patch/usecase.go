@@ -0,0 +1,43 @@
+package patch
+
+import (
+	"context"
+
+	"github.com/felipeascari/kv-store/pkg/storage"
+	"github.com/felipeascari/kv-store/pkg/watch"
+)
+
+type (
+	UseCase struct {
+		store  storage.Store
+		events watch.Publisher
+	}
+
+	// Patcher computes the new document from the current one without modifying it.
+	Patcher func(doc any) (any, error)
+)
+
+func NewUseCase(s storage.Store, events watch.Publisher) UseCase {
+	return UseCase{store: s, events: events}
+}
+
+// Execute applies the patch to the current value of the key atomically and returns the
+// updated entry. The precondition is checked against the version the patch is applied to,
+// so a failed check never writes.
+func (u UseCase) Execute(ctx context.Context, key string, patch Patcher, cond storage.Precondition) (storage.Entry, error) {
+	entry, err := u.store.Update(ctx, key, func(current storage.Entry) (any, error) {
+		if !cond.Allows(current.Version) {
+			return nil, storage.ErrVersionMismatch
+		}
+		if _, ok := current.Value.(storage.Blob); ok {
+			return nil, storage.ErrBlobValue
+		}
+		return patch(current.Value)
+	})
+	if err != nil {
+		return storage.Entry{}, err
+	}
+
+	u.events.Publish(ctx, watch.PutEvent(ctx, key, entry))
+	return entry, nil
+}
@@ -0,0 +1,23 @@
+package query
+
+import (
+	"context"
+
+	"github.com/felipeascari/kv-store/pkg/storage"
+)
+
+type UseCase struct {
+	store storage.Store
+}
+
+func NewUseCase(s storage.Store) UseCase {
+	return UseCase{store: s}
+}
+
+func (u UseCase) Execute(ctx context.Context, q storage.Query) (storage.QueryResult, error) {
+	querier, ok := u.store.(storage.Querier)
+	if !ok {
+		return storage.QueryResult{}, storage.ErrQueryUnsupported
+	}
+	return querier.Query(ctx, q)
+}
@@ -0,0 +1,33 @@
+package restore
+
+import (
+	"context"
+
+	"github.com/felipeascari/kv-store/pkg/storage"
+	"github.com/felipeascari/kv-store/pkg/watch"
+)
+
+type UseCase struct {
+	store  storage.Store
+	events watch.Publisher
+}
+
+func NewUseCase(s storage.Store, events watch.Publisher) UseCase {
+	return UseCase{store: s, events: events}
+}
+
+// Execute brings back a deleted key with the value and expiration it had when it was deleted.
+func (u UseCase) Execute(ctx context.Context, key string) (storage.Entry, error) {
+	undeleter, ok := u.store.(storage.Undeleter)
+	if !ok {
+		return storage.Entry{}, storage.ErrSoftDeleteUnsupported
+	}
+
+	entry, err := undeleter.Undelete(ctx, key)
+	if err != nil {
+		return storage.Entry{}, err
+	}
+
+	u.events.Publish(ctx, watch.PutEvent(ctx, key, entry))
+	return entry, nil
+}
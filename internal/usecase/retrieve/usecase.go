@@ -1,6 +1,11 @@
 package retrieve
 
-import "github.com/felipeascari/kv-store/pkg/storage"
+import (
+	"context"
+	"time"
+
+	"github.com/felipeascari/kv-store/pkg/storage"
+)
 
 type UseCase struct {
 	store storage.Store
@@ -10,6 +15,16 @@ func NewUseCase(s storage.Store) UseCase {
 	return UseCase{store: s}
 }
 
-func (u UseCase) Execute(key string) (any, error) {
-	return u.store.Retrieve(key)
+func (u UseCase) Execute(ctx context.Context, key string) (storage.Entry, error) {
+	return u.store.Retrieve(ctx, key)
+}
+
+// ExecuteVersion returns the given version of the key from its history.
+func (u UseCase) ExecuteVersion(ctx context.Context, key string, version int64) (storage.Revision, error) {
+	return storage.RetrieveVersion(ctx, u.store, key, version)
+}
+
+// ExecuteAt returns the version the key had at the given time.
+func (u UseCase) ExecuteAt(ctx context.Context, key string, at time.Time) (storage.Revision, error) {
+	return storage.RetrieveAt(ctx, u.store, key, at)
 }
@@ -1,15 +1,48 @@
 package save
 
-import "github.com/felipeascari/kv-store/pkg/storage"
+import (
+	"context"
+	"time"
+
+	"github.com/felipeascari/kv-store/pkg/storage"
+	"github.com/felipeascari/kv-store/pkg/watch"
+)
 
 type UseCase struct {
-	store storage.Store
+	store  storage.Store
+	events watch.Publisher
 }
 
-func NewUseCase(s storage.Store) UseCase {
-	return UseCase{store: s}
+func NewUseCase(s storage.Store, events watch.Publisher) UseCase {
+	return UseCase{store: s, events: events}
 }
 
-func (u UseCase) Execute(key string, value any) error {
-	return u.store.Save(key, value)
+// Execute saves the value and returns its new version. A non-zero precondition
+// turns the write into a compare-and-swap that fails with storage.ErrVersionMismatch.
+func (u UseCase) Execute(ctx context.Context, key string, value any, ttl time.Duration, cond storage.Precondition) (int64, error) {
+	version, err := u.write(ctx, key, value, ttl, cond)
+	if err != nil {
+		return 0, err
+	}
+
+	entry := storage.Entry{Value: value, Version: version}
+	if ttl > 0 {
+		entry.ExpiresAt = time.Now().Add(ttl)
+	}
+	u.events.Publish(ctx, watch.PutEvent(ctx, key, entry))
+
+	return version, nil
+}
+
+func (u UseCase) write(ctx context.Context, key string, value any, ttl time.Duration, cond storage.Precondition) (int64, error) {
+	if cond.IsZero() {
+		return u.store.Save(ctx, key, value, ttl)
+	}
+
+	expected, err := storage.ResolveVersion(ctx, u.store, key, cond)
+	if err != nil {
+		return 0, err
+	}
+
+	return u.store.CompareAndSwap(ctx, key, expected, value, ttl)
 }
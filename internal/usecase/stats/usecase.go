@@ -0,0 +1,62 @@
+package stats
+
+import (
+	"context"
+	"errors"
+
+	"github.com/felipeascari/kv-store/pkg/storage"
+	"github.com/felipeascari/kv-store/pkg/watch"
+)
+
+var ErrUnsupported = errors.New("storage does not report stats")
+
+type (
+	// provider is implemented by backends that account for their memory usage.
+	provider interface {
+		Stats() storage.MemoryStats
+	}
+
+	// compressionProvider is implemented by backends that compress values.
+	compressionProvider interface {
+		CompressionStats() (storage.CompressionStats, bool)
+	}
+
+	// Result holds the stats the storage and the watch bus report, nil for the ones they do not.
+	Result struct {
+		Memory      *storage.MemoryStats
+		Compression *storage.CompressionStats
+		Watch       *watch.Stats
+	}
+
+	UseCase struct {
+		store storage.Store
+		bus   *watch.Bus
+	}
+)
+
+func NewUseCase(s storage.Store, bus *watch.Bus) UseCase {
+	return UseCase{store: s, bus: bus}
+}
+
+func (u UseCase) Execute(_ context.Context) (Result, error) {
+	var result Result
+	if p, ok := u.store.(provider); ok {
+		stats := p.Stats()
+		result.Memory = &stats
+	}
+	if p, ok := u.store.(compressionProvider); ok {
+		if stats, ok := p.CompressionStats(); ok {
+			result.Compression = &stats
+		}
+	}
+
+	if u.bus != nil {
+		stats := u.bus.Stats()
+		result.Watch = &stats
+	}
+
+	if result.Memory == nil && result.Compression == nil && result.Watch == nil {
+		return Result{}, ErrUnsupported
+	}
+	return result, nil
+}
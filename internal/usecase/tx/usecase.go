@@ -0,0 +1,35 @@
+package tx
+
+import (
+	"context"
+
+	"github.com/felipeascari/kv-store/pkg/storage"
+	"github.com/felipeascari/kv-store/pkg/watch"
+)
+
+type UseCase struct {
+	store  storage.Store
+	events watch.Publisher
+}
+
+func NewUseCase(s storage.Store, events watch.Publisher) UseCase {
+	return UseCase{store: s, events: events}
+}
+
+// Execute applies every operation of the transaction if all of its checks hold, or none of them.
+func (u UseCase) Execute(ctx context.Context, tx storage.Transaction) ([]storage.TxResult, error) {
+	results, err := u.store.Transact(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, op := range tx.Ops {
+		if op.Type == storage.TxDelete {
+			u.events.Publish(ctx, watch.DeleteEvent(ctx, op.Key))
+			continue
+		}
+		entry := storage.Entry{Value: op.Value, Version: results[i].Version, ExpiresAt: results[i].ExpiresAt}
+		u.events.Publish(ctx, watch.PutEvent(ctx, op.Key, entry))
+	}
+	return results, nil
+}
@@ -0,0 +1,27 @@
+package watch
+
+import (
+	"context"
+
+	"github.com/felipeascari/kv-store/pkg/storage"
+	pkgwatch "github.com/felipeascari/kv-store/pkg/watch"
+)
+
+type UseCase struct {
+	bus *pkgwatch.Bus
+}
+
+func NewUseCase(bus *pkgwatch.Bus) UseCase {
+	return UseCase{bus: bus}
+}
+
+// Execute watches the keys of the namespace of ctx starting with prefix. Without a revision
+// it only sees the changes from now on, otherwise it resumes after that revision and fails
+// with pkgwatch.ErrCompacted if the changes since are no longer kept.
+func (u UseCase) Execute(ctx context.Context, prefix string, after *int64) (*pkgwatch.Subscription, error) {
+	match := pkgwatch.Matcher(storage.NamespaceFromContext(ctx), prefix)
+	if after == nil {
+		return u.bus.Subscribe(match), nil
+	}
+	return u.bus.Resume(*after, match)
+}
@@ -0,0 +1,427 @@
+// Package client is a Go client for the HTTP API of the key-value store.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultAddr = "http://localhost:8080"
+
+	// revisionHeader tells the revision a watch starts after.
+	revisionHeader = "X-Watch-Revision"
+	ndjsonType     = "application/x-ndjson"
+)
+
+var (
+	// ErrNotFound is wrapped by the errors of requests for keys that do not exist or were deleted.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is wrapped by the errors of writes rejected because the key changed or is
+	// of another type, including failed If-Match and If-None-Match conditions.
+	ErrConflict = errors.New("conflict")
+	// ErrWatchEnded is wrapped by the errors of watches the server ended, for instance because
+	// the watcher fell behind. Watching again after the last revision seen resumes it.
+	ErrWatchEnded = errors.New("watch ended")
+	// ErrExportFailed is wrapped by the errors of exports the server could not finish. The keys
+	// written before it are not a complete export.
+	ErrExportFailed = errors.New("export did not complete")
+)
+
+type (
+	// Options configures a Client.
+	Options struct {
+		// Token is sent as a bearer token, for servers behind an authenticating proxy.
+		Token string
+		// Namespace scopes the key operations to a namespace. Empty for the default one.
+		Namespace string
+		// HTTPClient defaults to a client without timeout, requests are bound by their context.
+		HTTPClient *http.Client
+	}
+
+	Client struct {
+		addr      string
+		token     string
+		namespace string
+		http      *http.Client
+	}
+
+	// Error is an error reply of the server. It wraps ErrNotFound for 404 and 410, and
+	// ErrConflict for 409 and 412.
+	Error struct {
+		Status  int
+		Message string
+	}
+
+	// Entry is a key with its value. JSON values are held in Value, raw values in
+	// ContentType and Data.
+	Entry struct {
+		Key         string          `json:"key"`
+		Value       json.RawMessage `json:"value,omitempty"`
+		ContentType string          `json:"content_type,omitempty"`
+		Data        []byte          `json:"data,omitempty"`
+		Version     int64           `json:"version"`
+		ExpiresAt   *time.Time      `json:"expires_at,omitempty"`
+	}
+
+	// SetOptions are the expiration and conditions of a write.
+	SetOptions struct {
+		// TTL is rounded up to the second. Zero keeps the key until it is deleted.
+		TTL time.Duration
+		// IfMatch only writes the key if it is at this version.
+		IfMatch int64
+		// IfNoneMatch only writes the key if it does not exist.
+		IfNoneMatch bool
+	}
+
+	// Event is a change to a watched key.
+	Event struct {
+		Revision    int64           `json:"revision"`
+		Type        string          `json:"type"`
+		Key         string          `json:"key"`
+		Value       json.RawMessage `json:"value,omitempty"`
+		ContentType string          `json:"content_type,omitempty"`
+		Version     int64           `json:"version,omitempty"`
+		ExpiresAt   *time.Time      `json:"expires_at,omitempty"`
+		Timestamp   time.Time       `json:"timestamp"`
+	}
+
+	ImportResult struct {
+		Mode     string `json:"mode"`
+		Imported int    `json:"imported"`
+		Skipped  int    `json:"skipped"`
+		Expired  int    `json:"expired"`
+	}
+
+	errorResponse struct {
+		Error string `json:"error"`
+	}
+)
+
+// New returns a client of the server at addr, such as DefaultAddr.
+func New(addr string, opts Options) *Client {
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{}
+	}
+	return &Client{
+		addr:      strings.TrimSuffix(addr, "/"),
+		token:     opts.Token,
+		namespace: opts.Namespace,
+		http:      opts.HTTPClient,
+	}
+}
+
+// Get returns the current value of key.
+func (c *Client) Get(ctx context.Context, key string) (Entry, error) {
+	resp, err := c.do(ctx, http.MethodGet, c.keyPath(key), nil, nil)
+	if err != nil {
+		return Entry{}, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	version, _ := strconv.ParseInt(strings.Trim(resp.Header.Get("ETag"), `"`), 10, 64)
+	contentType := resp.Header.Get("Content-Type")
+
+	// Raw values are returned as they were stored, JSON values as a document describing the key
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == "application/json" {
+		var entry Entry
+		if err := json.Unmarshal(body, &entry); err == nil && entry.Key == key && entry.Version == version {
+			if entry.Value == nil {
+				entry.Value = json.RawMessage("null")
+			}
+			return entry, nil
+		}
+	}
+
+	entry := Entry{Key: key, ContentType: contentType, Data: body, Version: version}
+	if expires, err := http.ParseTime(resp.Header.Get("Expires")); err == nil {
+		entry.ExpiresAt = &expires
+	}
+	return entry, nil
+}
+
+// Set writes a JSON value and returns the key with its new version.
+func (c *Client) Set(ctx context.Context, key string, value json.RawMessage, opts SetOptions) (Entry, error) {
+	if !json.Valid(value) {
+		return Entry{}, errors.New("value is not valid JSON")
+	}
+
+	req := map[string]any{"key": key, "value": value}
+	if opts.TTL > 0 {
+		req["expires_in"] = seconds(opts.TTL)
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	header := opts.header()
+	header.Set("Content-Type", "application/json")
+
+	var entry Entry
+	err = c.call(ctx, http.MethodPost, c.path("/keys"), header, bytes.NewReader(body), &entry)
+	return entry, err
+}
+
+// SetRaw writes a raw value, stored byte for byte with its content type, and returns the key
+// with its new version.
+func (c *Client) SetRaw(ctx context.Context, key, contentType string, data []byte, opts SetOptions) (Entry, error) {
+	path := c.keyPath(key)
+	if opts.TTL > 0 {
+		path += "?expires_in=" + strconv.FormatInt(seconds(opts.TTL), 10)
+	}
+
+	header := opts.header()
+	header.Set("Content-Type", contentType)
+
+	var entry Entry
+	if err := c.call(ctx, http.MethodPut, path, header, bytes.NewReader(data), &entry); err != nil {
+		return Entry{}, err
+	}
+	entry.Data = data
+	return entry, nil
+}
+
+// Delete deletes key, if it is at version ifMatch when not zero.
+func (c *Client) Delete(ctx context.Context, key string, ifMatch int64) error {
+	return c.call(ctx, http.MethodDelete, c.keyPath(key), SetOptions{IfMatch: ifMatch}.header(), nil, nil)
+}
+
+// List returns a page of at most limit keys starting with prefix, and the cursor of the next
+// page, empty after the last one.
+func (c *Client) List(ctx context.Context, prefix, cursor string, limit int) ([]string, string, error) {
+	query := url.Values{"prefix": {prefix}}
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+
+	var page struct {
+		Keys   []string `json:"keys"`
+		Cursor string   `json:"cursor"`
+	}
+	err := c.call(ctx, http.MethodGet, c.path("/keys")+"?"+query.Encode(), nil, nil, &page)
+	return page.Keys, page.Cursor, err
+}
+
+// Watch calls fn with the changes of the keys starting with prefix, after revision when
+// set or from now on otherwise, until ctx is done, fn fails or the server ends the watch.
+// It returns the revision of the last change seen along with the error, for resuming.
+func (c *Client) Watch(ctx context.Context, prefix string, revision *int64, fn func(Event) error) (int64, error) {
+	query := url.Values{"prefix": {prefix}}
+	if revision != nil {
+		query.Set("revision", strconv.FormatInt(*revision, 10))
+	}
+
+	header := http.Header{"Accept": {"text/event-stream"}}
+	resp, err := c.do(ctx, http.MethodGet, c.path("/watch")+"?"+query.Encode(), header, nil)
+	if err != nil {
+		if revision != nil {
+			return *revision, err
+		}
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	last, _ := strconv.ParseInt(resp.Header.Get(revisionHeader), 10, 64)
+
+	var event, data string
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, math.MaxInt32)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		case line == "" && data != "":
+			if event == "error" {
+				var reply errorResponse
+				_ = json.Unmarshal([]byte(data), &reply)
+				return last, fmt.Errorf("%w: %s", ErrWatchEnded, reply.Error)
+			}
+
+			var e Event
+			if err := json.Unmarshal([]byte(data), &e); err != nil {
+				return last, err
+			}
+			if err := fn(e); err != nil {
+				return last, err
+			}
+			last = e.Revision
+			event, data = "", ""
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return last, err
+	}
+	if err := scanner.Err(); err != nil {
+		return last, fmt.Errorf("%w: %w", ErrWatchEnded, err)
+	}
+	return last, ErrWatchEnded
+}
+
+// Export writes an NDJSON export of every key of the server to w. An export the server fails
+// midway still replies 200 but ends with an error record: the lines before it are written and
+// the error wraps ErrExportFailed.
+func (c *Client) Export(ctx context.Context, w io.Writer) error {
+	resp, err := c.do(ctx, http.MethodGet, "/api/admin/export", http.Header{"Accept": {ndjsonType}}, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			if failed := exportFailure(line); failed != nil {
+				return failed
+			}
+			if _, err := w.Write(line); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Import loads an NDJSON export with mode overwrite or skip-existing.
+func (c *Client) Import(ctx context.Context, r io.Reader, mode string) (ImportResult, error) {
+	path := "/api/admin/import?" + url.Values{"mode": {mode}}.Encode()
+
+	var result ImportResult
+	err := c.call(ctx, http.MethodPost, path, http.Header{"Content-Type": {ndjsonType}}, r, &result)
+	return result, err
+}
+
+// exportFailure returns the error of the record ending a failed export, nil for the records of
+// keys, which always start with their key.
+func exportFailure(line []byte) error {
+	if !bytes.HasPrefix(line, []byte(`{"error"`)) {
+		return nil
+	}
+
+	var record struct {
+		Key   string `json:"key"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(line, &record); err != nil || record.Key != "" {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrExportFailed, record.Error)
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return http.StatusText(e.Status)
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	switch e.Status {
+	case http.StatusNotFound, http.StatusGone:
+		return ErrNotFound
+	case http.StatusConflict, http.StatusPreconditionFailed:
+		return ErrConflict
+	default:
+		return nil
+	}
+}
+
+// call sends a request and decodes the JSON reply into out, unless out is nil.
+func (c *Client) call(ctx context.Context, method, path string, header http.Header, body io.Reader, out any) error {
+	resp, err := c.do(ctx, method, path, header, body)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// do sends a request and returns the response of a successful one. Error replies are
+// returned as an *Error.
+func (c *Client) do(ctx context.Context, method, path string, header http.Header, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.addr+path, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < http.StatusBadRequest {
+		return resp, nil
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var reply errorResponse
+	_ = json.NewDecoder(resp.Body).Decode(&reply)
+	return nil, &Error{Status: resp.StatusCode, Message: reply.Error}
+}
+
+// path returns the path of a key route, in the namespace of the client.
+func (c *Client) path(route string) string {
+	if c.namespace == "" {
+		return "/api" + route
+	}
+	return "/api/ns/" + url.PathEscape(c.namespace) + route
+}
+
+func (c *Client) keyPath(key string) string {
+	return c.path("/keys/" + url.PathEscape(key))
+}
+
+func (o SetOptions) header() http.Header {
+	header := make(http.Header)
+	if o.IfMatch > 0 {
+		header.Set("If-Match", strconv.Quote(strconv.FormatInt(o.IfMatch, 10)))
+	}
+	if o.IfNoneMatch {
+		header.Set("If-None-Match", "*")
+	}
+	return header
+}
+
+// seconds rounds a duration up to the second, as expirations are set in seconds.
+func seconds(d time.Duration) int64 {
+	return int64((d + time.Second - 1) / time.Second)
+}
@@ -0,0 +1,335 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/felipeascari/kv-store/pkg/client"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeServer serves the JSON key routes of the API from a map, with their conditions. Like
+// the server, it never gives a version out twice: keys created again start past the floor.
+type fakeServer struct {
+	mu    sync.Mutex
+	keys  map[string]fakeEntry
+	floor int64
+}
+
+type fakeEntry struct {
+	value   json.RawMessage
+	version int64
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should report error replies as not found and conflict errors", func(t *testing.T) {
+		var auth string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			auth = r.Header.Get("Authorization")
+			status, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/keys/"))
+			w.WriteHeader(status)
+			_, _ = fmt.Fprintf(w, `{"error":"status %d"}`, status)
+		}))
+		defer srv.Close()
+
+		c := client.New(srv.URL, client.Options{Token: "secret"})
+
+		statuses := map[int]error{
+			http.StatusNotFound:           client.ErrNotFound,
+			http.StatusGone:               client.ErrNotFound,
+			http.StatusConflict:           client.ErrConflict,
+			http.StatusPreconditionFailed: client.ErrConflict,
+		}
+		for status, want := range statuses {
+			_, err := c.Get(ctx, strconv.Itoa(status))
+			require.ErrorIs(t, err, want)
+
+			var apiErr *client.Error
+			require.ErrorAs(t, err, &apiErr)
+			require.Equal(t, status, apiErr.Status)
+			require.Equal(t, fmt.Sprintf("status %d", status), apiErr.Message)
+		}
+
+		_, err := c.Get(ctx, "500")
+		require.Error(t, err)
+		require.NotErrorIs(t, err, client.ErrNotFound)
+		require.NotErrorIs(t, err, client.ErrConflict)
+		require.Equal(t, "Bearer secret", auth)
+	})
+
+	t.Run("should tell JSON values apart from raw values", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("ETag", `"3"`)
+			switch r.URL.Path {
+			case "/api/ns/acme/keys/doc":
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = io.WriteString(w, `{"key":"doc","value":{"a":1},"version":3}`)
+			case "/api/ns/acme/keys/raw.json":
+				// A raw value that happens to be JSON is returned as it is
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = io.WriteString(w, `{"a":1}`)
+			default:
+				w.Header().Set("Content-Type", "image/png")
+				w.Header().Set("Expires", "Sun, 18 Oct 2026 10:00:00 GMT")
+				_, _ = io.WriteString(w, "PNG")
+			}
+		}))
+		defer srv.Close()
+
+		c := client.New(srv.URL, client.Options{Namespace: "acme"})
+
+		doc, err := c.Get(ctx, "doc")
+		require.NoError(t, err)
+		require.JSONEq(t, `{"a":1}`, string(doc.Value))
+		require.Equal(t, int64(3), doc.Version)
+		require.Nil(t, doc.Data)
+
+		raw, err := c.Get(ctx, "raw.json")
+		require.NoError(t, err)
+		require.Nil(t, raw.Value)
+		require.Equal(t, []byte(`{"a":1}`), raw.Data)
+
+		img, err := c.Get(ctx, "img")
+		require.NoError(t, err)
+		require.Equal(t, "image/png", img.ContentType)
+		require.Equal(t, []byte("PNG"), img.Data)
+		require.Equal(t, int64(3), img.Version)
+		require.NotNil(t, img.ExpiresAt)
+	})
+
+	t.Run("should watch changes and return the revision to resume after", func(t *testing.T) {
+		var revisions []string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			revisions = append(revisions, r.URL.Query().Get("revision"))
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("X-Watch-Revision", "4")
+			_, _ = io.WriteString(w, ": keep-alive\n\n")
+			_, _ = io.WriteString(w, "id: 5\nevent: put\ndata: {\"revision\":5,\"type\":\"put\",\"key\":\"a\",\"value\":1}\n\n")
+			_, _ = io.WriteString(w, "id: 6\nevent: delete\ndata: {\"revision\":6,\"type\":\"delete\",\"key\":\"a\"}\n\n")
+			_, _ = io.WriteString(w, "event: error\ndata: {\"error\":\"watcher fell behind\"}\n\n")
+		}))
+		defer srv.Close()
+
+		c := client.New(srv.URL, client.Options{})
+
+		var events []client.Event
+		last, err := c.Watch(ctx, "a", nil, func(e client.Event) error {
+			events = append(events, e)
+			return nil
+		})
+		require.ErrorIs(t, err, client.ErrWatchEnded)
+		require.ErrorContains(t, err, "watcher fell behind")
+		require.Equal(t, int64(6), last)
+		require.Len(t, events, 2)
+		require.Equal(t, "put", events[0].Type)
+		require.JSONEq(t, "1", string(events[0].Value))
+		require.Equal(t, "delete", events[1].Type)
+
+		stop := errors.New("stop")
+		last, err = c.Watch(ctx, "a", &last, func(client.Event) error { return stop })
+		require.ErrorIs(t, err, stop)
+		require.Equal(t, int64(4), last)
+		require.Equal(t, []string{"", "6"}, revisions)
+	})
+
+	t.Run("should fail exports the server ends with an error", func(t *testing.T) {
+		fail := false
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			_, _ = io.WriteString(w, `{"key":"a","value":{"error":"not a failure"},"version":1}`+"\n")
+			if fail {
+				_, _ = io.WriteString(w, `{"error":"export failed"}`+"\n")
+			}
+		}))
+		defer srv.Close()
+
+		c := client.New(srv.URL, client.Options{})
+
+		var out strings.Builder
+		require.NoError(t, c.Export(ctx, &out))
+		require.Equal(t, 1, strings.Count(out.String(), "\n"))
+
+		fail = true
+		out.Reset()
+		err := c.Export(ctx, &out)
+		require.ErrorIs(t, err, client.ErrExportFailed)
+		require.ErrorContains(t, err, "export failed")
+		require.NotContains(t, out.String(), `{"error":"export failed"}`)
+	})
+
+	t.Run("should hold a lock until it is released", func(t *testing.T) {
+		fake := &fakeServer{keys: make(map[string]fakeEntry)}
+		srv := httptest.NewServer(fake)
+		defer srv.Close()
+
+		c := client.New(srv.URL, client.Options{})
+
+		lease, err := c.Lock(ctx, "job", time.Minute)
+		require.NoError(t, err)
+		require.Equal(t, "lock:job", lease.Key())
+
+		waitCtx, cancel := context.WithTimeout(ctx, 300*time.Millisecond)
+		defer cancel()
+		_, err = c.Lock(waitCtx, "job", time.Minute)
+		require.ErrorIs(t, err, client.ErrConflict)
+
+		require.NoError(t, lease.Refresh(ctx))
+		require.NoError(t, lease.Release(ctx))
+		_, err = c.Get(ctx, "lock:job")
+		require.ErrorIs(t, err, client.ErrNotFound)
+
+		next, err := c.Lock(ctx, "job", time.Minute)
+		require.NoError(t, err)
+		require.NoError(t, next.Release(ctx))
+	})
+
+	t.Run("should catch up with a refresh whose reply was lost", func(t *testing.T) {
+		fake := &fakeServer{keys: make(map[string]fakeEntry)}
+		srv := httptest.NewServer(fake)
+		defer srv.Close()
+
+		c := client.New(srv.URL, client.Options{})
+
+		lease, err := c.Lock(ctx, "job", time.Minute)
+		require.NoError(t, err)
+
+		// The server applied a refresh of the lease but its reply never arrived
+		fake.mu.Lock()
+		entry := fake.keys["lock:job"]
+		entry.version++
+		fake.keys["lock:job"] = entry
+		fake.mu.Unlock()
+
+		require.NoError(t, lease.Refresh(ctx))
+		require.NoError(t, lease.Release(ctx))
+	})
+
+	t.Run("should keep the lease alive through transient errors", func(t *testing.T) {
+		fake := &fakeServer{keys: make(map[string]fakeEntry)}
+		var failing atomic.Bool
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if failing.Load() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			fake.ServeHTTP(w, r)
+		}))
+		defer srv.Close()
+
+		c := client.New(srv.URL, client.Options{})
+
+		lease, err := c.Lock(ctx, "job", 300*time.Millisecond)
+		require.NoError(t, err)
+
+		keepCtx, cancel := context.WithCancel(ctx)
+		lost := lease.KeepAlive(keepCtx)
+
+		failing.Store(true)
+		time.Sleep(250 * time.Millisecond)
+		failing.Store(false)
+		time.Sleep(150 * time.Millisecond)
+		cancel()
+		require.NoError(t, <-lost)
+
+		fake.mu.Lock()
+		delete(fake.keys, "lock:job")
+		fake.mu.Unlock()
+		require.ErrorIs(t, <-lease.KeepAlive(ctx), client.ErrLockLost)
+	})
+
+	t.Run("should report a lock taken by another holder as lost", func(t *testing.T) {
+		fake := &fakeServer{keys: make(map[string]fakeEntry)}
+		srv := httptest.NewServer(fake)
+		defer srv.Close()
+
+		c := client.New(srv.URL, client.Options{})
+
+		lease, err := c.Lock(ctx, "job", time.Minute)
+		require.NoError(t, err)
+
+		// The lease runs out and another holder takes the lock
+		fake.mu.Lock()
+		fake.floor = fake.keys["lock:job"].version
+		delete(fake.keys, "lock:job")
+		fake.mu.Unlock()
+		other, err := c.Lock(ctx, "job", time.Minute)
+		require.NoError(t, err)
+
+		require.ErrorIs(t, lease.Refresh(ctx), client.ErrLockLost)
+		require.ErrorIs(t, lease.Release(ctx), client.ErrLockLost)
+
+		_, err = c.Get(ctx, "lock:job")
+		require.NoError(t, err)
+		require.NoError(t, other.Release(ctx))
+		require.ErrorIs(t, lease.Release(ctx), client.ErrLockLost)
+	})
+}
+
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := strings.TrimPrefix(r.URL.Path, "/api/keys/")
+	if r.Method == http.MethodPost {
+		var req struct {
+			Key   string          `json:"key"`
+			Value json.RawMessage `json:"value"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		key = req.Key
+
+		current, exists := s.keys[key]
+		if (r.Header.Get("If-None-Match") == "*" && exists) || !s.matches(r, current) {
+			s.fail(w, http.StatusPreconditionFailed, "version mismatch")
+			return
+		}
+
+		s.keys[key] = fakeEntry{value: req.Value, version: max(current.version, s.floor) + 1}
+		s.write(w, key)
+		return
+	}
+
+	current, exists := s.keys[key]
+	switch {
+	case !exists:
+		s.fail(w, http.StatusNotFound, "key not found")
+	case r.Method == http.MethodGet:
+		s.write(w, key)
+	case !s.matches(r, current):
+		s.fail(w, http.StatusPreconditionFailed, "version mismatch")
+	default:
+		s.floor = max(s.floor, current.version)
+		delete(s.keys, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *fakeServer) matches(r *http.Request, current fakeEntry) bool {
+	ifMatch := r.Header.Get("If-Match")
+	return ifMatch == "" || ifMatch == strconv.Quote(strconv.FormatInt(current.version, 10))
+}
+
+func (s *fakeServer) write(w http.ResponseWriter, key string) {
+	entry := s.keys[key]
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", strconv.Quote(strconv.FormatInt(entry.version, 10)))
+	_ = json.NewEncoder(w).Encode(map[string]any{"key": key, "value": entry.value, "version": entry.version})
+}
+
+func (s *fakeServer) fail(w http.ResponseWriter, status int, msg string) {
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
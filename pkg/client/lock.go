@@ -0,0 +1,184 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// A lock is a key created only if it does not exist, with the lease as its expiration, so a
+// holder that dies loses the lock once the lease runs out. The value of the key holds a random
+// token and the owner of the holder. Holders refresh the lease before it runs out and delete
+// the key to release it, both only if the key is still at the version of their last write.
+// The server never gives a version out twice, not even to a key created again after it was
+// deleted or expired, so the version acts as a fencing token the server checks atomically
+// with the write.
+const (
+	// LockKeyPrefix is prepended to the name of a lock to get its key.
+	LockKeyPrefix = "lock:"
+
+	lockRetryInterval = 200 * time.Millisecond
+)
+
+// ErrLockLost is returned when refreshing or releasing a lock whose lease ran out and that
+// was taken by another holder, or deleted, since. Other errors, such as network errors, do
+// not tell whether the lock is still held.
+var ErrLockLost = errors.New("lock lost")
+
+type (
+	// Lease is a lock held by this client.
+	Lease struct {
+		client *Client
+		key    string
+		value  json.RawMessage
+		token  string
+		ttl    time.Duration
+
+		mu sync.Mutex
+		// version is the version of the last write of the lock by this lease.
+		version int64
+	}
+
+	lockValue struct {
+		Owner      string    `json:"owner"`
+		Token      string    `json:"token"`
+		AcquiredAt time.Time `json:"acquired_at"`
+	}
+)
+
+// Lock takes the lock name for a lease of ttl, retrying while another holder has it until
+// ctx is done. The lock is reported as a conflict when ctx ends while it is held.
+func (c *Client) Lock(ctx context.Context, name string, ttl time.Duration) (*Lease, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+
+	hostname, _ := os.Hostname()
+	value, err := json.Marshal(lockValue{
+		Owner:      fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+		Token:      hex.EncodeToString(token),
+		AcquiredAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	key := LockKeyPrefix + name
+	for {
+		entry, err := c.Set(ctx, key, value, SetOptions{TTL: ttl, IfNoneMatch: true})
+		if err == nil {
+			return &Lease{client: c, key: key, value: value, token: hex.EncodeToString(token), ttl: ttl, version: entry.Version}, nil
+		}
+		if !errors.Is(err, ErrConflict) {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("lock %q is held: %w", name, err)
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
+
+// Key returns the key of the lock.
+func (l *Lease) Key() string {
+	return l.key
+}
+
+// Refresh extends the lease by its ttl.
+func (l *Lease) Refresh(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	refresh := func() error {
+		entry, err := l.client.Set(ctx, l.key, l.value, SetOptions{TTL: l.ttl, IfMatch: l.version})
+		if err == nil {
+			l.version = entry.Version
+		}
+		return err
+	}
+
+	err := refresh()
+	if errors.Is(err, ErrConflict) {
+		if err := l.catchUp(ctx); err != nil {
+			return err
+		}
+		err = refresh()
+	}
+	return l.lost(err)
+}
+
+// KeepAlive refreshes the lease at a third of its ttl until ctx is done or the lock is lost,
+// which it reports on the returned channel before closing it. Refreshes failing for another
+// reason, such as the server being unreachable, are retried at the next tick.
+func (l *Lease) KeepAlive(ctx context.Context) <-chan error {
+	lost := make(chan error, 1)
+	go func() {
+		defer close(lost)
+
+		ticker := time.NewTicker(l.ttl / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if err := l.Refresh(ctx); errors.Is(err, ErrLockLost) && ctx.Err() == nil {
+				lost <- err
+				return
+			}
+		}
+	}()
+	return lost
+}
+
+// Release deletes the lock if it is still held by this lease.
+func (l *Lease) Release(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err := l.client.Delete(ctx, l.key, l.version)
+	if errors.Is(err, ErrConflict) {
+		if err := l.catchUp(ctx); err != nil {
+			return err
+		}
+		err = l.client.Delete(ctx, l.key, l.version)
+	}
+	return l.lost(err)
+}
+
+// catchUp catches up with a write of this lease whose reply was lost, such as a refresh that
+// timed out after the server applied it, taking the version of the lock if it still holds the
+// token of this lease. A write retried at that version fails if the lock changed hands since.
+func (l *Lease) catchUp(ctx context.Context) error {
+	current, err := l.client.Get(ctx, l.key)
+	if err != nil {
+		return l.lost(err)
+	}
+
+	var value lockValue
+	if err := json.Unmarshal(current.Value, &value); err != nil || value.Token != l.token {
+		return ErrLockLost
+	}
+	l.version = current.Version
+	return nil
+}
+
+// lost reports the requests that fail because the lock changed hands as ErrLockLost.
+func (l *Lease) lost(err error) error {
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrConflict) {
+		return fmt.Errorf("%w: %w", ErrLockLost, err)
+	}
+	return err
+}
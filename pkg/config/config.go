@@ -2,35 +2,142 @@ package config
 
 import (
 	"strconv"
+	"time"
 
 	"github.com/felipeascari/kv-store/pkg/environment"
 	"github.com/felipeascari/kv-store/pkg/storage"
+	"github.com/felipeascari/kv-store/pkg/watch"
 )
 
 type (
 	Config struct {
 		Storage StorageConfig
 		Server  ServerConfig
+		Watch   WatchConfig
 	}
 
 	StorageConfig struct {
-		Type  storage.Type
-		Redis RedisConfig
+		Type   storage.Type
+		Redis  RedisConfig
+		Memory MemoryConfig
+		Disk   DiskConfig
+		// Indexes declares the secondary indexes maintained by the Redis and memory backends.
+		Indexes []storage.Index
+		// History is the number of versions the Redis and memory backends keep per key, zero disables it.
+		History int
+		// SoftDelete keeps deleted keys restorable for a while when its Retention is set.
+		SoftDelete SoftDeleteConfig
+	}
+
+	// SoftDeleteConfig enables soft deletes when Retention is set: deleted keys leave a tombstone
+	// that is purged every PurgeInterval once Retention has passed.
+	SoftDeleteConfig struct {
+		Retention     time.Duration
+		PurgeInterval time.Duration
 	}
 
 	RedisConfig struct {
 		Addr     string
 		Password string
 		DB       int
+		Cache    RedisCacheConfig
+		// Compression compresses values of at least CompressionThreshold bytes.
+		Compression          storage.Compression
+		CompressionThreshold int
+		Encryption           EncryptionConfig
+		ChangeStream         ChangeStreamConfig
+		// MigrateStrings converts the keys stored as plain strings by older releases on startup.
+		MigrateStrings bool
+	}
+
+	// ChangeStreamConfig enables change data capture when Stream is set: every write is appended
+	// to that Redis Stream, trimmed to about MaxLen entries.
+	ChangeStreamConfig struct {
+		Stream string
+		MaxLen int64
+	}
+
+	// EncryptionConfig enables encryption at rest when KeyFile is set. The key file is read once
+	// on startup, see storage.LoadKeyring for its format.
+	EncryptionConfig struct {
+		KeyFile         string
+		ReencryptOnRead bool
+		// ReencryptInterval is how often every value is checked for re-encryption, zero disables it.
+		ReencryptInterval time.Duration
+	}
+
+	// RedisCacheConfig enables the local cache in front of Redis when MaxKeys or MaxBytes is set.
+	RedisCacheConfig struct {
+		MaxKeys  int64
+		MaxBytes int64
+		TTL      time.Duration
+		Channel  string
+	}
+
+	MemoryConfig struct {
+		Shards int
+		// MaxKeys and MaxBytes bound the store, zero means unlimited.
+		MaxKeys        int64
+		MaxBytes       int64
+		Eviction       storage.EvictionPolicy
+		ReaperInterval time.Duration
+		// DataDir enables persistence when set: writes are logged there and replayed on startup.
+		DataDir          string
+		Fsync            storage.FsyncPolicy
+		SnapshotInterval time.Duration
+	}
+
+	DiskConfig struct {
+		DataDir       string
+		MaxFileSize   int64
+		MergeInterval time.Duration
+		Fsync         storage.FsyncPolicy
 	}
 
 	ServerConfig struct {
-		Port string
+		Port           string
+		RequestTimeout time.Duration
+	}
+
+	// WatchConfig configures the change bus behind the watch API. With Redis storage changes
+	// are shared between instances on Channel.
+	WatchConfig struct {
+		// History is the number of changes kept for watchers to resume from.
+		History int
+		// Buffer is the number of changes a watcher can fall behind before it is disconnected.
+		Buffer  int
+		Channel string
 	}
 )
 
 func Load() (*Config, error) {
 	redisDB, _ := strconv.Atoi(environment.LoadEnv("REDIS_DB", "0"))
+	cacheMaxKeys, _ := strconv.ParseInt(environment.LoadEnv("REDIS_CACHE_MAX_KEYS", "0"), 10, 64)
+	cacheMaxBytes, _ := strconv.ParseInt(environment.LoadEnv("REDIS_CACHE_MAX_BYTES", "0"), 10, 64)
+	compressionThreshold, _ := strconv.Atoi(environment.LoadEnv("REDIS_COMPRESSION_THRESHOLD", strconv.Itoa(storage.DefaultCompressionThreshold)))
+	cacheTTL, _ := time.ParseDuration(environment.LoadEnv("REDIS_CACHE_TTL", "1m"))
+	reencryptOnRead, _ := strconv.ParseBool(environment.LoadEnv("REDIS_ENCRYPTION_REENCRYPT_ON_READ", "true"))
+	reencryptInterval, _ := time.ParseDuration(environment.LoadEnv("REDIS_ENCRYPTION_REENCRYPT_INTERVAL", "0"))
+	migrateStrings, _ := strconv.ParseBool(environment.LoadEnv("REDIS_MIGRATE_STRINGS", "false"))
+	changeStreamMaxLen, _ := strconv.ParseInt(environment.LoadEnv("REDIS_CHANGE_STREAM_MAXLEN", strconv.Itoa(storage.DefaultChangeStreamMaxLen)), 10, 64)
+	requestTimeout, _ := time.ParseDuration(environment.LoadEnv("SERVER_REQUEST_TIMEOUT", "30s"))
+	memoryShards, _ := strconv.Atoi(environment.LoadEnv("MEMORY_SHARDS", strconv.Itoa(storage.DefaultMemoryShards)))
+	memoryMaxKeys, _ := strconv.ParseInt(environment.LoadEnv("MEMORY_MAX_KEYS", "0"), 10, 64)
+	memoryMaxBytes, _ := strconv.ParseInt(environment.LoadEnv("MEMORY_MAX_BYTES", "0"), 10, 64)
+	reaperInterval, _ := time.ParseDuration(environment.LoadEnv("MEMORY_REAPER_INTERVAL", "1s"))
+	snapshotInterval, _ := time.ParseDuration(environment.LoadEnv("MEMORY_SNAPSHOT_INTERVAL", "5m"))
+	diskMaxFileSize, _ := strconv.ParseInt(environment.LoadEnv("DISK_MAX_FILE_SIZE", "67108864"), 10, 64)
+	diskMergeInterval, _ := time.ParseDuration(environment.LoadEnv("DISK_MERGE_INTERVAL", "10m"))
+	history, _ := strconv.Atoi(environment.LoadEnv("HISTORY_VERSIONS", "0"))
+	watchHistory, _ := strconv.Atoi(environment.LoadEnv("WATCH_HISTORY", strconv.Itoa(watch.DefaultHistory)))
+	watchBuffer, _ := strconv.Atoi(environment.LoadEnv("WATCH_BUFFER", strconv.Itoa(watch.DefaultBuffer)))
+	softDeleteRetention, _ := time.ParseDuration(environment.LoadEnv("SOFT_DELETE_RETENTION", "0"))
+	purgeInterval, _ := time.ParseDuration(environment.LoadEnv("SOFT_DELETE_PURGE_INTERVAL", "1m"))
+
+	indexes, err := storage.ParseIndexes(environment.LoadEnv("INDEXES", ""))
+	if err != nil {
+		return nil, err
+	}
 
 	return &Config{
 		Storage: StorageConfig{
@@ -39,10 +146,56 @@ func Load() (*Config, error) {
 				Addr:     environment.LoadEnv("REDIS_ADDR", "localhost:6379"),
 				Password: environment.LoadEnv("REDIS_PASSWORD", ""),
 				DB:       redisDB,
+				Cache: RedisCacheConfig{
+					MaxKeys:  cacheMaxKeys,
+					MaxBytes: cacheMaxBytes,
+					TTL:      cacheTTL,
+					Channel:  environment.LoadEnv("REDIS_CACHE_CHANNEL", storage.DefaultInvalidationChannel),
+				},
+				Compression:          storage.Compression(environment.LoadEnv("REDIS_COMPRESSION", storage.CompressionNone.String())),
+				CompressionThreshold: compressionThreshold,
+				Encryption: EncryptionConfig{
+					KeyFile:           environment.LoadEnv("REDIS_ENCRYPTION_KEY_FILE", ""),
+					ReencryptOnRead:   reencryptOnRead,
+					ReencryptInterval: reencryptInterval,
+				},
+				ChangeStream: ChangeStreamConfig{
+					Stream: environment.LoadEnv("REDIS_CHANGE_STREAM", ""),
+					MaxLen: changeStreamMaxLen,
+				},
+				MigrateStrings: migrateStrings,
+			},
+			Memory: MemoryConfig{
+				Shards:           memoryShards,
+				MaxKeys:          memoryMaxKeys,
+				MaxBytes:         memoryMaxBytes,
+				Eviction:         storage.EvictionPolicy(environment.LoadEnv("MEMORY_EVICTION_POLICY", storage.EvictionNone.String())),
+				ReaperInterval:   reaperInterval,
+				DataDir:          environment.LoadEnv("MEMORY_DATA_DIR", ""),
+				Fsync:            storage.FsyncPolicy(environment.LoadEnv("MEMORY_FSYNC", storage.FsyncEverySecond.String())),
+				SnapshotInterval: snapshotInterval,
+			},
+			Disk: DiskConfig{
+				DataDir:       environment.LoadEnv("DISK_DATA_DIR", "./data"),
+				MaxFileSize:   diskMaxFileSize,
+				MergeInterval: diskMergeInterval,
+				Fsync:         storage.FsyncPolicy(environment.LoadEnv("DISK_FSYNC", storage.FsyncEverySecond.String())),
+			},
+			Indexes: indexes,
+			History: history,
+			SoftDelete: SoftDeleteConfig{
+				Retention:     softDeleteRetention,
+				PurgeInterval: purgeInterval,
 			},
 		},
 		Server: ServerConfig{
-			Port: environment.LoadEnv("SERVER_PORT", "8080"),
+			Port:           environment.LoadEnv("SERVER_PORT", "8080"),
+			RequestTimeout: requestTimeout,
+		},
+		Watch: WatchConfig{
+			History: watchHistory,
+			Buffer:  watchBuffer,
+			Channel: environment.LoadEnv("WATCH_CHANNEL", watch.DefaultChannel),
 		},
 	}, nil
 }
@@ -20,6 +20,42 @@ func NotFound(w http.ResponseWriter, message string) {
 	JSON(w, http.StatusNotFound, NewErrorResponse(message))
 }
 
+func Conflict(w http.ResponseWriter, message string) {
+	JSON(w, http.StatusConflict, NewErrorResponse(message))
+}
+
+func Gone(w http.ResponseWriter, message string) {
+	JSON(w, http.StatusGone, NewErrorResponse(message))
+}
+
+func PreconditionFailed(w http.ResponseWriter, message string) {
+	JSON(w, http.StatusPreconditionFailed, NewErrorResponse(message))
+}
+
+func RequestEntityTooLarge(w http.ResponseWriter, message string) {
+	JSON(w, http.StatusRequestEntityTooLarge, NewErrorResponse(message))
+}
+
+func UnsupportedMediaType(w http.ResponseWriter, message string) {
+	JSON(w, http.StatusUnsupportedMediaType, NewErrorResponse(message))
+}
+
+func UnprocessableEntity(w http.ResponseWriter, message string) {
+	JSON(w, http.StatusUnprocessableEntity, NewErrorResponse(message))
+}
+
+func InsufficientStorage(w http.ResponseWriter, message string) {
+	JSON(w, http.StatusInsufficientStorage, NewErrorResponse(message))
+}
+
 func InternalServerError(w http.ResponseWriter, message string) {
 	JSON(w, http.StatusInternalServerError, NewErrorResponse(message))
 }
+
+func GatewayTimeout(w http.ResponseWriter, message string) {
+	JSON(w, http.StatusGatewayTimeout, NewErrorResponse(message))
+}
+
+func NotImplemented(w http.ResponseWriter, message string) {
+	JSON(w, http.StatusNotImplemented, NewErrorResponse(message))
+}
@@ -0,0 +1,55 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/felipeascari/kv-store/pkg/storage"
+)
+
+// ETag formats a key version as a strong entity tag.
+func ETag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// Precondition parses the If-Match and If-None-Match request headers.
+func Precondition(r *http.Request) storage.Precondition {
+	return storage.Precondition{
+		IfMatch:     parseVersionMatch(r.Header.Get("If-Match")),
+		IfNoneMatch: parseVersionMatch(r.Header.Get("If-None-Match")),
+	}
+}
+
+// parseVersionMatch parses a comma separated list of entity tags.
+// Weak tags are compared as strong ones and tags that are not versions, including
+// versions below 1, are ignored, so they never match.
+func parseVersionMatch(header string) *storage.VersionMatch {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return nil
+	}
+
+	if header == "*" {
+		return &storage.VersionMatch{Any: true}
+	}
+
+	var match storage.VersionMatch
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+
+		unquoted, err := strconv.Unquote(tag)
+		if err != nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(unquoted, 10, 64)
+		if err != nil || version <= 0 {
+			continue
+		}
+
+		match.Versions = append(match.Versions, version)
+	}
+
+	return &match
+}
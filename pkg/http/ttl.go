@@ -0,0 +1,34 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// MaxExpiresIn is the longest expires_in, in seconds, a time.Duration can hold.
+const MaxExpiresIn = math.MaxInt64 / int64(time.Second)
+
+var (
+	ErrExpiresIn = fmt.Errorf("expires_in must be between 1 and %d seconds", MaxExpiresIn)
+	ErrExpiresAt = errors.New("expires_at must be in the future and within the next 292 years")
+)
+
+// ExpiresIn converts an expires_in field, in seconds, into a TTL.
+func ExpiresIn(seconds int64) (time.Duration, error) {
+	if seconds <= 0 || seconds > MaxExpiresIn {
+		return 0, ErrExpiresIn
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// ExpiresAt converts an expires_at field into the TTL left at now. Time.Sub saturates
+// instead of overflowing, so a TTL that saturates is rejected as too far away.
+func ExpiresAt(at, now time.Time) (time.Duration, error) {
+	ttl := at.Sub(now)
+	if ttl <= 0 || ttl == math.MaxInt64 {
+		return 0, ErrExpiresAt
+	}
+	return ttl, nil
+}
@@ -0,0 +1,39 @@
+package jsonpatch
+
+// MergePatch returns the result of applying a JSON Merge Patch to doc, which is left
+// untouched. Members of patch set to null are removed, objects are merged recursively
+// and any other value replaces the target as a whole.
+func MergePatch(doc, patch any) (any, error) {
+	doc, err := normalize(doc)
+	if err != nil {
+		return nil, err
+	}
+	patch, err = normalize(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	return merge(doc, patch), nil
+}
+
+func merge(target, patch any) any {
+	members, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	object, ok := target.(map[string]any)
+	if !ok {
+		object = make(map[string]any, len(members))
+	}
+
+	for key, value := range members {
+		if value == nil {
+			delete(object, key)
+			continue
+		}
+		object[key] = merge(object[key], value)
+	}
+
+	return object
+}
@@ -0,0 +1,189 @@
+package jsonpatch_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/felipeascari/kv-store/pkg/jsonpatch"
+	"github.com/stretchr/testify/require"
+)
+
+func decode(t *testing.T, data string) any {
+	t.Helper()
+	var value any
+	require.NoError(t, json.Unmarshal([]byte(data), &value))
+	return value
+}
+
+func TestPatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+		err   error
+	}{
+		{
+			name:  "should add an object member",
+			doc:   `{"foo": "bar"}`,
+			patch: `[{"op": "add", "path": "/baz", "value": "qux"}]`,
+			want:  `{"foo": "bar", "baz": "qux"}`,
+		},
+		{
+			name:  "should insert into an array",
+			doc:   `{"foo": ["bar", "baz"]}`,
+			patch: `[{"op": "add", "path": "/foo/1", "value": "qux"}]`,
+			want:  `{"foo": ["bar", "qux", "baz"]}`,
+		},
+		{
+			name:  "should append to an array",
+			doc:   `{"foo": [1]}`,
+			patch: `[{"op": "add", "path": "/foo/-", "value": {"a": null}}]`,
+			want:  `{"foo": [1, {"a": null}]}`,
+		},
+		{
+			name:  "should remove an array element",
+			doc:   `{"foo": ["bar", "qux", "baz"]}`,
+			patch: `[{"op": "remove", "path": "/foo/1"}]`,
+			want:  `{"foo": ["bar", "baz"]}`,
+		},
+		{
+			name:  "should replace a value",
+			doc:   `{"baz": "qux", "foo": "bar"}`,
+			patch: `[{"op": "replace", "path": "/baz", "value": "boo"}]`,
+			want:  `{"baz": "boo", "foo": "bar"}`,
+		},
+		{
+			name:  "should move a value",
+			doc:   `{"foo": {"bar": "baz", "waldo": "fred"}, "qux": {"corge": "grault"}}`,
+			patch: `[{"op": "move", "from": "/foo/waldo", "path": "/qux/thud"}]`,
+			want:  `{"foo": {"bar": "baz"}, "qux": {"corge": "grault", "thud": "fred"}}`,
+		},
+		{
+			name:  "should move an array element",
+			doc:   `{"foo": ["all", "grass", "cows", "eat"]}`,
+			patch: `[{"op": "move", "from": "/foo/1", "path": "/foo/3"}]`,
+			want:  `{"foo": ["all", "cows", "eat", "grass"]}`,
+		},
+		{
+			name:  "should copy a value",
+			doc:   `{"a": {"b": 1}}`,
+			patch: `[{"op": "copy", "from": "/a", "path": "/c"}, {"op": "replace", "path": "/c/b", "value": 2}]`,
+			want:  `{"a": {"b": 1}, "c": {"b": 2}}`,
+		},
+		{
+			name:  "should unescape pointer tokens",
+			doc:   `{"a/b": 1, "m~n": 2}`,
+			patch: `[{"op": "replace", "path": "/a~1b", "value": 3}, {"op": "remove", "path": "/m~0n"}]`,
+			want:  `{"a/b": 3}`,
+		},
+		{
+			name:  "should replace the whole document",
+			doc:   `{"a": 1}`,
+			patch: `[{"op": "replace", "path": "", "value": [1, 2]}]`,
+			want:  `[1, 2]`,
+		},
+		{
+			name:  "should pass a matching test",
+			doc:   `{"baz": "qux", "foo": ["a", 2, "c"]}`,
+			patch: `[{"op": "test", "path": "/baz", "value": "qux"}, {"op": "test", "path": "/foo/1", "value": 2}]`,
+			want:  `{"baz": "qux", "foo": ["a", 2, "c"]}`,
+		},
+		{
+			name:  "should fail a mismatching test",
+			doc:   `{"baz": "qux"}`,
+			patch: `[{"op": "replace", "path": "/baz", "value": "x"}, {"op": "test", "path": "/baz", "value": "qux"}]`,
+			err:   jsonpatch.ErrTestFailed,
+		},
+		{
+			name:  "should fail to add under a missing parent",
+			doc:   `{"foo": "bar"}`,
+			patch: `[{"op": "add", "path": "/baz/bat", "value": "qux"}]`,
+			err:   jsonpatch.ErrPathNotFound,
+		},
+		{
+			name:  "should fail to remove a missing member",
+			doc:   `{"foo": "bar"}`,
+			patch: `[{"op": "remove", "path": "/baz"}]`,
+			err:   jsonpatch.ErrPathNotFound,
+		},
+		{
+			name:  "should fail on an out of range index",
+			doc:   `{"foo": [1]}`,
+			patch: `[{"op": "add", "path": "/foo/2", "value": 2}]`,
+			err:   jsonpatch.ErrPathNotFound,
+		},
+		{
+			name:  "should refuse to move a value into itself",
+			doc:   `{"a": {"b": 1}}`,
+			patch: `[{"op": "move", "from": "/a", "path": "/a/c"}]`,
+			err:   jsonpatch.ErrInvalidPatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patch, err := jsonpatch.Decode([]byte(tt.patch))
+			require.NoError(t, err)
+
+			doc := decode(t, tt.doc)
+			got, err := patch.Apply(doc)
+			if tt.err != nil {
+				require.ErrorIs(t, err, tt.err)
+				require.Equal(t, decode(t, tt.doc), doc)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, decode(t, tt.want), got)
+			require.Equal(t, decode(t, tt.doc), doc)
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+	}{
+		{name: "should reject a non-array document", patch: `{"op": "add"}`},
+		{name: "should reject an unknown op", patch: `[{"op": "merge", "path": "/a"}]`},
+		{name: "should reject a missing value", patch: `[{"op": "add", "path": "/a"}]`},
+		{name: "should reject a relative path", patch: `[{"op": "remove", "path": "a"}]`},
+		{name: "should reject a missing from", patch: `[{"op": "copy", "from": "a", "path": "/b"}]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := jsonpatch.Decode([]byte(tt.patch))
+			require.ErrorIs(t, err, jsonpatch.ErrInvalidPatch)
+		})
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+	}{
+		{name: "should replace a member", doc: `{"a": "b"}`, patch: `{"a": "c"}`, want: `{"a": "c"}`},
+		{name: "should add a member", doc: `{"a": "b"}`, patch: `{"b": "c"}`, want: `{"a": "b", "b": "c"}`},
+		{name: "should remove a member", doc: `{"a": "b", "b": "c"}`, patch: `{"a": null}`, want: `{"b": "c"}`},
+		{name: "should replace arrays", doc: `{"a": ["b"]}`, patch: `{"a": ["c"]}`, want: `{"a": ["c"]}`},
+		{name: "should replace non-objects", doc: `["a", "b"]`, patch: `{"a": "b"}`, want: `{"a": "b"}`},
+		{name: "should merge nested objects", doc: `{"e": null, "a": {"b": "c", "d": 1}}`, patch: `{"a": {"b": "d", "d": null}, "c": {"x": null}}`, want: `{"e": null, "a": {"b": "d"}, "c": {}}`},
+		{name: "should replace the document with a scalar", doc: `{"a": "foo"}`, patch: `"bar"`, want: `"bar"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := decode(t, tt.doc)
+			got, err := jsonpatch.MergePatch(doc, decode(t, tt.patch))
+			require.NoError(t, err)
+			require.Equal(t, decode(t, tt.want), got)
+			require.Equal(t, decode(t, tt.doc), doc)
+		})
+	}
+}
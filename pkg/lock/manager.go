@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"slices"
 	"time"
 )
 
@@ -14,6 +15,13 @@ type (
 		ValidateToken(ctx context.Context, key string, token int64) (bool, error)
 	}
 
+	// MultiLock is implemented by the locks that can acquire many keys at once.
+	MultiLock interface {
+		Lock
+		// AcquireAll acquires the locks of distinct keys all at once, or none of them.
+		AcquireAll(ctx context.Context, keys []string) (map[string]int64, error)
+	}
+
 	Manager struct {
 		lock           Lock
 		serverID       string
@@ -47,6 +55,11 @@ func (lm *Manager) WithConfig(cfg Config) *Manager {
 	return lm
 }
 
+// ServerID returns the ID of this instance, its hostname and process ID.
+func (lm *Manager) ServerID() string {
+	return lm.serverID
+}
+
 func (lm *Manager) ExecuteWithLock(ctx context.Context, key string, fn func(int64) error) error {
 	acquireCtx, cancel := context.WithTimeout(ctx, lm.acquireTimeout)
 	defer cancel()
@@ -64,3 +77,56 @@ func (lm *Manager) ExecuteWithLock(ctx context.Context, key string, fn func(int6
 
 	return fn(token)
 }
+
+// ExecuteWithLocks acquires the locks of all keys before running fn with the fencing token of each key.
+// Locks implementing MultiLock acquire every key at once. Other locks acquire keys in sorted order, so
+// concurrent callers locking overlapping key sets cannot deadlock, and the locks are checked to be still
+// held before fn runs, as the first ones may expire while the others are acquired. Every lock acquired
+// is released if one of them cannot be acquired.
+func (lm *Manager) ExecuteWithLocks(ctx context.Context, keys []string, fn func(map[string]int64) error) error {
+	sorted := slices.Clone(keys)
+	slices.Sort(sorted)
+	sorted = slices.Compact(sorted)
+
+	acquireCtx, cancel := context.WithTimeout(ctx, lm.acquireTimeout)
+	defer cancel()
+
+	tokens := make(map[string]int64, len(sorted))
+
+	defer func() {
+		releaseCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		for key, token := range tokens {
+			_ = lm.lock.Release(releaseCtx, key, token)
+		}
+	}()
+
+	if multi, ok := lm.lock.(MultiLock); ok {
+		acquired, err := multi.AcquireAll(acquireCtx, sorted)
+		if err != nil {
+			return err
+		}
+		tokens = acquired
+		return fn(tokens)
+	}
+
+	for _, key := range sorted {
+		token, err := lm.lock.Acquire(acquireCtx, key)
+		if err != nil {
+			return err
+		}
+		tokens[key] = token
+	}
+
+	for _, key := range sorted {
+		valid, err := lm.lock.ValidateToken(acquireCtx, key, tokens[key])
+		if err != nil {
+			return err
+		}
+		if !valid {
+			return fmt.Errorf("lock of %q expired before use: %w", key, ErrInvalidToken)
+		}
+	}
+
+	return fn(tokens)
+}
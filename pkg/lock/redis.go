@@ -10,13 +10,39 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
-const releaseLockScript = `
+const (
+	// Lock keys and fencing counters live under a reserved prefix, so they cannot collide with stored keys.
+	lockKeyPrefix = "kv-store:lock:"
+	tokenKey      = "kv-store:lock-token"
+
+	// acquireAllScript takes the lock keys followed by their fencing counters in KEYS and the
+	// TTL in milliseconds and the JSON acquisition time in ARGV. It sets every lock if none is
+	// held and returns their tokens, or {-1, index of a held lock} without setting any.
+	acquireAllScript = `
+	local n = #KEYS / 2
+	for i = 1, n do
+		if redis.call('exists', KEYS[i]) == 1 then
+			return {-1, i}
+		end
+	end
+	local tokens = {}
+	for i = 1, n do
+		local token = redis.call('incr', KEYS[n + i])
+		local entry = '{"token":' .. token .. ',"server_id":"","acquired_at":' .. ARGV[2] .. '}'
+		redis.call('set', KEYS[i], entry, 'px', ARGV[1])
+		table.insert(tokens, token)
+	end
+	return tokens
+`
+
+	releaseLockScript = `
 	if redis.call('get', KEYS[1]) == ARGV[1] then
 		return redis.call('del', KEYS[1])
 	else
 		return 0
 	end
 `
+)
 
 var (
 	ErrLockAcquisition = errors.New("lock acquisition failed")
@@ -29,6 +55,8 @@ type (
 		lockKeyPrefix string
 		tokenKey      string
 		ttl           time.Duration
+		// tokenScope picks the fencing counter of a key, see WithTokenScope.
+		tokenScope func(key string) string
 	}
 
 	Entry struct {
@@ -45,16 +73,25 @@ func NewRedisLock(client *redis.Client, ttl time.Duration) *RedisLock {
 
 	return &RedisLock{
 		client:        client,
-		lockKeyPrefix: "lock:",
-		tokenKey:      "lock:token_counter",
+		lockKeyPrefix: lockKeyPrefix,
+		tokenKey:      tokenKey,
 		ttl:           ttl,
 	}
 }
 
+// WithTokenScope draws the fencing tokens of every key from the counter of the scope
+// returned for it, such as its namespace, instead of a single counter. An empty scope
+// uses the default counter. Tokens only need to grow per key, so any scope that is a
+// function of the key keeps them safe.
+func (rl *RedisLock) WithTokenScope(scope func(key string) string) *RedisLock {
+	rl.tokenScope = scope
+	return rl
+}
+
 func (rl *RedisLock) Acquire(ctx context.Context, key string) (int64, error) {
 	lockKey := rl.lockKeyPrefix + key
 
-	token, err := rl.client.Incr(ctx, rl.tokenKey).Result()
+	token, err := rl.client.Incr(ctx, rl.counterKey(key)).Result()
 	if err != nil {
 		return 0, fmt.Errorf("failed to generate token: %w", err)
 	}
@@ -82,6 +119,41 @@ func (rl *RedisLock) Acquire(ctx context.Context, key string) (int64, error) {
 	return token, nil
 }
 
+// AcquireAll acquires the locks of distinct keys in a single script, all of them or none,
+// so they are held for the whole TTL from the same instant.
+func (rl *RedisLock) AcquireAll(ctx context.Context, keys []string) (map[string]int64, error) {
+	redisKeys := make([]string, 0, 2*len(keys))
+	for _, key := range keys {
+		redisKeys = append(redisKeys, rl.lockKeyPrefix+key)
+	}
+	for _, key := range keys {
+		redisKeys = append(redisKeys, rl.counterKey(key))
+	}
+
+	acquiredAt, err := json.Marshal(time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal lock entry: %w", err)
+	}
+
+	script := redis.NewScript(acquireAllScript)
+
+	reply, err := script.Run(ctx, rl.client, redisKeys, rl.ttl.Milliseconds(), acquiredAt).Int64Slice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire locks: %w", err)
+	}
+
+	// Tokens are positive, so -1 can only be the held lock marker.
+	if len(reply) > 0 && reply[0] == -1 {
+		return nil, fmt.Errorf("lock of %q already held: %w", keys[reply[1]-1], ErrLockAcquisition)
+	}
+
+	tokens := make(map[string]int64, len(keys))
+	for i, key := range keys {
+		tokens[key] = reply[i]
+	}
+	return tokens, nil
+}
+
 // Release releases a lock only if the provided token matches.
 //
 // Uses a Lua script to ensure atomicity: the Get + Compare + Del operations
@@ -140,3 +212,12 @@ func (rl *RedisLock) ValidateToken(ctx context.Context, key string, token int64)
 
 	return entry.Token == token, nil
 }
+
+func (rl *RedisLock) counterKey(key string) string {
+	if rl.tokenScope != nil {
+		if scope := rl.tokenScope(key); scope != "" {
+			return rl.tokenKey + ":" + scope
+		}
+	}
+	return rl.tokenKey
+}
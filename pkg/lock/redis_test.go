@@ -4,6 +4,7 @@ package lock_test
 
 import (
 	"context"
+	"strings"
 	"testing"
 	"time"
 
@@ -30,7 +31,7 @@ func TestRedisLock(t *testing.T) {
 		require.NoError(t, err)
 		require.Greater(t, token, int64(0))
 
-		lockKey := "lock:test-key"
+		lockKey := "kv-store:lock:test-key"
 		exists := client.Exists(ctx, lockKey).Val()
 		require.Equal(t, int64(1), exists)
 
@@ -41,6 +42,31 @@ func TestRedisLock(t *testing.T) {
 		require.Equal(t, int64(0), exists)
 	})
 
+	t.Run("should keep a fencing counter per token scope", func(t *testing.T) {
+		client.FlushDB(ctx)
+		redisLock := lock.NewRedisLock(client, 5*time.Second).WithTokenScope(func(key string) string {
+			tenant, _, _ := strings.Cut(key, "/")
+			return tenant
+		})
+
+		first, err := redisLock.Acquire(ctx, "a/key")
+		require.NoError(t, err)
+		require.NoError(t, redisLock.Release(ctx, "a/key", first))
+
+		second, err := redisLock.Acquire(ctx, "a/key")
+		require.NoError(t, err)
+		require.NoError(t, redisLock.Release(ctx, "a/key", second))
+		require.Greater(t, second, first)
+
+		other, err := redisLock.Acquire(ctx, "b/key")
+		require.NoError(t, err)
+		require.NoError(t, redisLock.Release(ctx, "b/key", other))
+		require.Equal(t, int64(1), other)
+
+		require.Equal(t, int64(1), client.Exists(ctx, "kv-store:lock-token:a").Val())
+		require.Equal(t, int64(1), client.Exists(ctx, "kv-store:lock-token:b").Val())
+	})
+
 	t.Run("should prevent double acquisition", func(t *testing.T) {
 		client.FlushDB(ctx)
 		redisLock := lock.NewRedisLock(client, 5*time.Second)
@@ -65,6 +91,46 @@ func TestRedisLock(t *testing.T) {
 		_ = redisLock.Release(ctx, "double-key", token3)
 	})
 
+	t.Run("should acquire many locks at once or none of them", func(t *testing.T) {
+		client.FlushDB(ctx)
+		redisLock := lock.NewRedisLock(client, 5*time.Second)
+
+		held, err := redisLock.Acquire(ctx, "multi-b")
+		require.NoError(t, err)
+
+		_, err = redisLock.AcquireAll(ctx, []string{"multi-a", "multi-b", "multi-c"})
+		require.ErrorIs(t, err, lock.ErrLockAcquisition)
+		require.Zero(t, client.Exists(ctx, "kv-store:lock:multi-a", "kv-store:lock:multi-c").Val())
+
+		require.NoError(t, redisLock.Release(ctx, "multi-b", held))
+
+		tokens, err := redisLock.AcquireAll(ctx, []string{"multi-a", "multi-b", "multi-c"})
+		require.NoError(t, err)
+		require.Len(t, tokens, 3)
+		for key, token := range tokens {
+			valid, err := redisLock.ValidateToken(ctx, key, token)
+			require.NoError(t, err)
+			require.True(t, valid)
+			require.Positive(t, client.PTTL(ctx, "kv-store:lock:"+key).Val())
+		}
+
+		manager := lock.NewManager(redisLock)
+		err = manager.ExecuteWithLocks(ctx, []string{"multi-c", "multi-d"}, func(map[string]int64) error { return nil })
+		require.ErrorIs(t, err, lock.ErrLockAcquisition)
+
+		for key, token := range tokens {
+			require.NoError(t, redisLock.Release(ctx, key, token))
+		}
+
+		err = manager.ExecuteWithLocks(ctx, []string{"multi-d", "multi-c", "multi-d"}, func(tokens map[string]int64) error {
+			require.Len(t, tokens, 2)
+			require.Equal(t, int64(1), client.Exists(ctx, "kv-store:lock:multi-c").Val())
+			return nil
+		})
+		require.NoError(t, err)
+		require.Zero(t, client.Exists(ctx, "kv-store:lock:multi-c", "kv-store:lock:multi-d").Val())
+	})
+
 	t.Run("should validate tokens correctly", func(t *testing.T) {
 		client.FlushDB(ctx)
 		redisLock := lock.NewRedisLock(client, 5*time.Second)
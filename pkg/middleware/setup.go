@@ -5,6 +5,8 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 )
 
+// Setup installs the middlewares of every route. RequestTimeout is left to the router, which
+// applies it to every route but the long-lived streams.
 func Setup(r *chi.Mux) {
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	pkghttp "github.com/felipeascari/kv-store/pkg/http"
+)
+
+// RequestTimeoutHeader lets callers set a deadline for their request, either as a
+// Go duration ("250ms", "2s") or as a number of seconds.
+const RequestTimeoutHeader = "X-Request-Timeout"
+
+// RequestTimeout bounds every request context so storage and lock calls are
+// cancelled once the deadline passes. The deadline comes from RequestTimeoutHeader
+// when present and is capped at maxTimeout, which is also the default. Routes serving
+// long-lived streams must not use it.
+func RequestTimeout(maxTimeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			timeout := maxTimeout
+
+			if raw := r.Header.Get(RequestTimeoutHeader); raw != "" {
+				requested, err := parseTimeout(raw)
+				if err != nil {
+					pkghttp.BadRequest(w, "invalid "+RequestTimeoutHeader+" header")
+					return
+				}
+				if maxTimeout <= 0 || requested < maxTimeout {
+					timeout = requested
+				}
+			}
+
+			if timeout <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func parseTimeout(raw string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(raw, 64); err == nil {
+		raw = strconv.FormatFloat(seconds, 'f', -1, 64) + "s"
+	}
+
+	timeout, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, err
+	}
+	if timeout <= 0 {
+		return 0, strconv.ErrRange
+	}
+
+	return timeout, nil
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"sync"
+	"time"
+)
+
+// background runs the periodic maintenance jobs of a store until it is closed.
+type background struct {
+	stop chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+}
+
+func newBackground() *background {
+	return &background{stop: make(chan struct{})}
+}
+
+// every runs fn at the given interval until close is called.
+func (b *background) every(interval time.Duration, fn func()) {
+	ticker := time.NewTicker(interval)
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-b.stop:
+				return
+			case <-ticker.C:
+				fn()
+			}
+		}
+	}()
+}
+
+// run starts fn in a goroutine. fn must return once stop is closed.
+func (b *background) run(fn func(stop <-chan struct{})) {
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		fn(b.stop)
+	}()
+}
+
+// close stops every job and waits for running ones to return. It reports
+// whether this call closed b, so cleanup that must run once can be chained to it.
+func (b *background) close() bool {
+	closed := false
+	b.once.Do(func() {
+		close(b.stop)
+		closed = true
+	})
+	b.wg.Wait()
+	return closed
+}
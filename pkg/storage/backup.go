@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// An export walks every key of a store as it is stored, namespaced keys and the store's own
+// bookkeeping keys, such as the namespace registry and tombstones, included, so importing it
+// restores the namespaces along with their keys. Collections and version history are not exported.
+const (
+	// ImportOverwrite writes every imported key, replacing the existing ones.
+	ImportOverwrite ImportMode = "overwrite"
+	// ImportSkipExisting only writes the imported keys that do not exist.
+	ImportSkipExisting ImportMode = "skip-existing"
+)
+
+var (
+	ErrInvalidImportMode = errors.New("invalid import mode")
+	ErrCollectionKey     = fmt.Errorf("keys starting with %q hold collections and cannot be imported", collectionKeyPrefix)
+)
+
+type (
+	ImportMode string
+
+	// Snapshotter is implemented by the stores that can copy every key at a single point in
+	// time. Export uses it to take consistent snapshots.
+	Snapshotter interface {
+		// SnapshotEntries returns every live key with its entry as of a single point in time.
+		SnapshotEntries(ctx context.Context) (map[string]Entry, error)
+	}
+
+	// directSource is implemented by the layers that only lock or cache the keys of the store
+	// under them. Export reads that store instead, so an export neither takes a lock per key
+	// nor fills a local cache with every key.
+	directSource interface {
+		directSource() Store
+	}
+)
+
+func (m ImportMode) String() string {
+	return string(m)
+}
+
+func (m ImportMode) IsValid() bool {
+	switch m {
+	case ImportOverwrite, ImportSkipExisting:
+		return true
+	default:
+		return false
+	}
+}
+
+// Export calls fn with every key of the store and its entry, stopping at the first error.
+// Stores implementing Snapshotter are exported as of a single point in time, in key order, see
+// ExportsSnapshot. Other stores are exported a page at a time, which is not a snapshot: every
+// entry is read atomically, but keys written during the export may be exported before or after
+// the write, and with Redis a key may be exported more than once.
+func Export(ctx context.Context, s Store, fn func(key string, entry Entry) error) error {
+	s = directStore(s)
+	if snapshotter, ok := s.(Snapshotter); ok {
+		entries, err := snapshotter.SnapshotEntries(ctx)
+		if err != nil {
+			return err
+		}
+
+		keys := make([]string, 0, len(entries))
+		for key := range entries {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+
+		for _, key := range keys {
+			if err := fn(key, entries[key]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var cursor string
+	for {
+		page, err := s.Scan(ctx, "", cursor, DefaultScanLimit)
+		if err != nil {
+			return err
+		}
+
+		if len(page.Keys) > 0 {
+			results, err := s.BatchRetrieve(ctx, page.Keys)
+			if err != nil {
+				return err
+			}
+
+			for _, result := range results {
+				switch {
+				case errors.Is(result.Err, ErrKeyNotFound):
+					// Deleted or expired since the page was scanned.
+					continue
+				case result.Err != nil:
+					return result.Err
+				}
+				if err := fn(result.Key, result.Entry); err != nil {
+					return err
+				}
+			}
+		}
+
+		if page.Cursor == "" {
+			return nil
+		}
+		cursor = page.Cursor
+	}
+}
+
+// ValidateImportKey returns ErrCollectionKey for the keys Redis stores collections under, which
+// an imported value would clash with.
+func ValidateImportKey(key string) error {
+	if strings.HasPrefix(key, collectionKeyPrefix) {
+		return ErrCollectionKey
+	}
+	return nil
+}
+
+// ExportsSnapshot reports whether Export exports s as of a single point in time.
+func ExportsSnapshot(s Store) bool {
+	_, ok := directStore(s).(Snapshotter)
+	return ok
+}
+
+// directStore returns the store under the layers of s that only lock or cache its keys.
+func directStore(s Store) Store {
+	for {
+		source, ok := s.(directSource)
+		if !ok {
+			return s
+		}
+		s = source.directSource()
+	}
+}
@@ -0,0 +1,143 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/felipeascari/kv-store/pkg/lock"
+	"github.com/felipeascari/kv-store/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+// refusingLock fails every acquisition, for the code paths that must not lock.
+type refusingLock struct{}
+
+func (refusingLock) Acquire(context.Context, string) (int64, error) {
+	return 0, errors.New("locked")
+}
+
+func (refusingLock) Release(context.Context, string, int64) error {
+	return nil
+}
+
+func (refusingLock) ValidateToken(context.Context, string, int64) (bool, error) {
+	return false, nil
+}
+
+func TestExport(t *testing.T) {
+	ctx := context.Background()
+
+	fill := func(t *testing.T, s storage.Store) {
+		t.Helper()
+		n := storage.NewNamespaced(s)
+		_, err := storage.NewNamespaces(s).Create(ctx, "acme")
+		require.NoError(t, err)
+
+		_, err = n.Save(ctx, "b", map[string]any{"n": 1.0}, 0)
+		require.NoError(t, err)
+		_, err = n.Save(ctx, "a", storage.Blob{ContentType: "text/plain", Data: []byte("hi")}, time.Hour)
+		require.NoError(t, err)
+		_, err = n.Save(storage.WithNamespace(ctx, "acme"), "a", "tenant", 0)
+		require.NoError(t, err)
+		_, err = n.Save(ctx, "gone", "soon", time.Millisecond)
+		require.NoError(t, err)
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	export := func(t *testing.T, s storage.Store) map[string]storage.Entry {
+		t.Helper()
+		exported := make(map[string]storage.Entry)
+		require.NoError(t, storage.Export(ctx, s, func(key string, entry storage.Entry) error {
+			require.NotContains(t, exported, key)
+			exported[key] = entry
+			return nil
+		}))
+		return exported
+	}
+
+	check := func(t *testing.T, exported map[string]storage.Entry) {
+		t.Helper()
+		require.Equal(t, map[string]any{"n": 1.0}, exported["b"].Value)
+		require.Equal(t, int64(1), exported["b"].Version)
+		require.Equal(t, storage.Blob{ContentType: "text/plain", Data: []byte("hi")}, exported["a"].Value)
+		require.False(t, exported["a"].ExpiresAt.IsZero())
+		require.NotContains(t, exported, "gone")
+
+		// Namespaced keys are exported with their namespace, along with the namespace registry
+		var tenant, registry bool
+		for key, entry := range exported {
+			namespace, local, ok := storage.LocalKey(key)
+			if ok && namespace == "acme" && local == "a" {
+				tenant = entry.Value == "tenant"
+			}
+			registry = registry || !ok
+		}
+		require.True(t, tenant)
+		require.True(t, registry)
+	}
+
+	t.Run("should export a memory snapshot in key order", func(t *testing.T) {
+		m := storage.NewMemory()
+		fill(t, m)
+
+		var keys []string
+		require.NoError(t, storage.Export(ctx, m, func(key string, _ storage.Entry) error {
+			keys = append(keys, key)
+			return nil
+		}))
+		require.IsNonDecreasing(t, keys)
+
+		check(t, export(t, m))
+	})
+
+	t.Run("should export stores without snapshots a page at a time", func(t *testing.T) {
+		d, err := storage.OpenDisk(storage.DiskOptions{Dir: t.TempDir(), Fsync: storage.FsyncNever})
+		require.NoError(t, err)
+		defer func() { _ = d.Close() }()
+
+		fill(t, d)
+		for i := range storage.DefaultScanLimit {
+			_, err := d.Save(ctx, fmt.Sprintf("page:%03d", i), i, 0)
+			require.NoError(t, err)
+		}
+
+		exported := export(t, d)
+		check(t, exported)
+		require.Len(t, exported, storage.DefaultScanLimit+4)
+	})
+
+	t.Run("should export the store under the locks without locking keys", func(t *testing.T) {
+		d, err := storage.OpenDisk(storage.DiskOptions{Dir: t.TempDir(), Fsync: storage.FsyncNever})
+		require.NoError(t, err)
+		defer func() { _ = d.Close() }()
+		fill(t, d)
+
+		locked := storage.NewLockedStore(d, lock.NewManager(refusingLock{}))
+		check(t, export(t, locked))
+		require.False(t, storage.ExportsSnapshot(locked))
+		require.True(t, storage.ExportsSnapshot(storage.NewLockedStore(storage.NewMemory(), lock.NewManager(refusingLock{}))))
+	})
+
+	t.Run("should stop at the first error", func(t *testing.T) {
+		m := storage.NewMemory()
+		fill(t, m)
+
+		stop := errors.New("stop")
+		calls := 0
+		err := storage.Export(ctx, m, func(string, storage.Entry) error {
+			calls++
+			return stop
+		})
+		require.ErrorIs(t, err, stop)
+		require.Equal(t, 1, calls)
+	})
+}
+
+func TestValidateImportKey(t *testing.T) {
+	require.NoError(t, storage.ValidateImportKey("kv-store:ns:acme:user:1"))
+	require.NoError(t, storage.ValidateImportKey("kv-store:namespace:acme"))
+	require.ErrorIs(t, storage.ValidateImportKey("kv-store:collection:profile"), storage.ErrCollectionKey)
+}
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+// Blobs are stored byte for byte. Stores that keep values as bytes write them as blobMarker,
+// the content type, blobMarker again and the data, which no JSON document can start with.
+const blobMarker = 0x00
+
+// ErrBlobValue is returned by operations that need a JSON value on a key holding a blob.
+var ErrBlobValue = errors.New("key holds a binary value")
+
+// Blob is a raw value stored along with its content type instead of being JSON encoded.
+type Blob struct {
+	ContentType string `json:"content_type"`
+	Data        []byte `json:"data"`
+}
+
+// encodeValue returns the stored bytes of a value: blobs as they are, anything else as JSON.
+func encodeValue(value any) ([]byte, error) {
+	blob, ok := value.(Blob)
+	if !ok {
+		return json.Marshal(value)
+	}
+
+	data := make([]byte, 0, len(blob.ContentType)+len(blob.Data)+2)
+	data = append(data, blobMarker)
+	data = append(data, blob.ContentType...)
+	data = append(data, blobMarker)
+	return append(data, blob.Data...), nil
+}
+
+// decodeValue is the inverse of encodeValue, and also reads values compressed by a store.
+func decodeValue(data []byte) (any, error) {
+	if len(data) > 0 && (data[0] == zstdMarker || data[0] == snappyMarker) {
+		var err error
+		if data, err = decompress(data); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(data) > 0 && data[0] == blobMarker {
+		contentType, body, ok := bytes.Cut(data[1:], []byte{blobMarker})
+		if !ok {
+			return nil, errors.New("invalid blob encoding")
+		}
+		return Blob{ContentType: string(contentType), Data: body}, nil
+	}
+
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
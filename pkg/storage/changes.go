@@ -0,0 +1,272 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Every write made through a LockedStore with a change stream is appended to a Redis Stream by
+// the script that makes the write, so the write and its entry are applied together or not at
+// all. Each entry has the fields:
+//
+//	key           the stored key, namespace included
+//	op            save or delete
+//	version       the version written, for saves
+//	value         the JSON encoded value, for saves of JSON values
+//	content_type  the content type of a blob value, or EncryptedContentType with encryption
+//	data          the bytes of a blob value, or the ciphertext of the value with encryption
+//	expires_at    the expiration of the key in RFC 3339, for saves of keys that expire
+//	token         the fencing token the write was made with
+//	server_id     the instance that made the write
+//	timestamp     when the write was made, in RFC 3339
+//
+// Entries of a key are appended in the order of its writes, and the entries of a transaction are
+// appended together. The writes of reserved keys, such as namespace registrations and the
+// tombstones of soft deletes, are not captured: a soft delete appears as the delete of its key
+// and an undelete as a save. Expirations are not captured either.
+const (
+	ChangeSave   ChangeOp = "save"
+	ChangeDelete ChangeOp = "delete"
+
+	// DefaultChangeStream is the stream changes are appended to when none is set.
+	DefaultChangeStream = "kv-store:changes"
+	// DefaultChangeStreamMaxLen is the number of entries the stream is trimmed to when none is set.
+	DefaultChangeStreamMaxLen = 1_000_000
+
+	changeFieldKey         = "key"
+	changeFieldOp          = "op"
+	changeFieldVersion     = "version"
+	changeFieldValue       = "value"
+	changeFieldContentType = "content_type"
+	changeFieldData        = "data"
+	changeFieldExpiresAt   = "expires_at"
+	changeFieldToken       = "token"
+	changeFieldServerID    = "server_id"
+	changeFieldTimestamp   = "timestamp"
+)
+
+type (
+	ChangeOp string
+
+	// ChangeStreamOptions configures a ChangeStream.
+	ChangeStreamOptions struct {
+		// Stream is the key of the Redis Stream. Defaults to DefaultChangeStream.
+		Stream string
+		// MaxLen is the number of entries the stream is trimmed to, approximately, as changes
+		// are appended. Defaults to DefaultChangeStreamMaxLen, a negative value keeps every entry.
+		MaxLen int64
+		// Keyring encrypts the values of the changes like Encrypted does, so the stream does
+		// not hold them in plaintext. Values are appended as they are when nil.
+		Keyring *Keyring
+	}
+
+	// changeCapture asks the store a LockedStore writes to for the changes of the write to be
+	// appended to the change stream along with the write itself, see withChangeCapture.
+	changeCapture struct {
+		stream    *ChangeStream
+		serverID  string
+		timestamp time.Time
+		// tokens holds the fencing token of every key written.
+		tokens map[string]int64
+	}
+
+	changeCaptureKey struct{}
+
+	// ChangeStream is the Redis Stream the changes made to the keys are appended to, where
+	// consumer groups can read them and replay them from any entry still kept. It lives in the
+	// Redis instance holding the keys.
+	ChangeStream struct {
+		stream  string
+		maxLen  int64
+		keyring *Keyring
+	}
+
+	// Change is a write captured in the change stream. Value, Version and ExpiresAt are only
+	// set for saves. ID is the ID of the stream entry, set by the stream.
+	Change struct {
+		ID        string
+		Key       string
+		Op        ChangeOp
+		Value     any
+		Version   int64
+		ExpiresAt time.Time
+		Token     int64
+		ServerID  string
+		Timestamp time.Time
+	}
+)
+
+func NewChangeStream(opts ChangeStreamOptions) *ChangeStream {
+	if opts.Stream == "" {
+		opts.Stream = DefaultChangeStream
+	}
+	if opts.MaxLen == 0 {
+		opts.MaxLen = DefaultChangeStreamMaxLen
+	}
+
+	return &ChangeStream{
+		stream:  opts.Stream,
+		maxLen:  max(opts.MaxLen, 0),
+		keyring: opts.Keyring,
+	}
+}
+
+// Stream returns the key of the Redis Stream.
+func (cs *ChangeStream) Stream() string {
+	return cs.stream
+}
+
+// Decode returns the change held by an entry of the stream, decrypting its value when the
+// change stream has a keyring.
+func (cs *ChangeStream) Decode(msg redis.XMessage) (Change, error) {
+	field := func(name string) string {
+		value, _ := msg.Values[name].(string)
+		return value
+	}
+
+	change := Change{
+		ID:       msg.ID,
+		Key:      field(changeFieldKey),
+		Op:       ChangeOp(field(changeFieldOp)),
+		ServerID: field(changeFieldServerID),
+	}
+	if change.Key == "" || (change.Op != ChangeSave && change.Op != ChangeDelete) {
+		return Change{}, fmt.Errorf("malformed change %s", msg.ID)
+	}
+
+	var err error
+	if change.Token, err = strconv.ParseInt(field(changeFieldToken), 10, 64); err != nil {
+		return Change{}, fmt.Errorf("malformed change %s: %w", msg.ID, err)
+	}
+	if change.Timestamp, err = time.Parse(time.RFC3339Nano, field(changeFieldTimestamp)); err != nil {
+		return Change{}, fmt.Errorf("malformed change %s: %w", msg.ID, err)
+	}
+	if change.Op == ChangeDelete {
+		return change, nil
+	}
+
+	if change.Version, err = strconv.ParseInt(field(changeFieldVersion), 10, 64); err != nil {
+		return Change{}, fmt.Errorf("malformed change %s: %w", msg.ID, err)
+	}
+	if expiresAt := field(changeFieldExpiresAt); expiresAt != "" {
+		if change.ExpiresAt, err = time.Parse(time.RFC3339Nano, expiresAt); err != nil {
+			return Change{}, fmt.Errorf("malformed change %s: %w", msg.ID, err)
+		}
+	}
+
+	if change.Value, err = cs.decodeValue(change.Key, field); err != nil {
+		return Change{}, fmt.Errorf("malformed change %s: %w", msg.ID, err)
+	}
+	return change, nil
+}
+
+// encodeValue returns the content type and the data of the value of a save, an empty content
+// type for JSON values. With a keyring, the value is encrypted and bound to its key as Encrypted
+// does, and values Encrypted already sealed are appended as they are stored.
+func (cs *ChangeStream) encodeValue(key string, value any) (string, []byte, error) {
+	if cs.keyring != nil {
+		if blob, ok := value.(Blob); ok && blob.ContentType == EncryptedContentType {
+			return blob.ContentType, blob.Data, nil
+		}
+		sealed, err := cs.keyring.SealValue(key, value)
+		if err != nil {
+			return "", nil, err
+		}
+		return sealed.ContentType, sealed.Data, nil
+	}
+
+	if blob, ok := value.(Blob); ok {
+		return blob.ContentType, blob.Data, nil
+	}
+
+	data, err := json.Marshal(value)
+	return "", data, err
+}
+
+func (cs *ChangeStream) decodeValue(key string, field func(string) string) (any, error) {
+	contentType := field(changeFieldContentType)
+	if contentType == "" {
+		var value any
+		err := json.Unmarshal([]byte(field(changeFieldValue)), &value)
+		return value, err
+	}
+
+	data := []byte(field(changeFieldData))
+	if contentType != EncryptedContentType || cs.keyring == nil {
+		return Blob{ContentType: contentType, Data: data}, nil
+	}
+
+	plaintext, _, err := cs.keyring.open(data, []byte(key))
+	if err != nil {
+		return nil, err
+	}
+	return decodeValue(plaintext)
+}
+
+// withChangeCapture returns ctx asking the store to append the changes of its writes to the
+// change stream of c, in the same script as the writes. Redis does, other stores ignore it.
+func withChangeCapture(ctx context.Context, c *changeCapture) context.Context {
+	return context.WithValue(ctx, changeCaptureKey{}, c)
+}
+
+// changeCaptureFrom returns the change capture of ctx, nil when writes are not captured.
+func changeCaptureFrom(ctx context.Context) *changeCapture {
+	c, _ := ctx.Value(changeCaptureKey{}).(*changeCapture)
+	return c
+}
+
+// args returns the arguments captureFunctions reads for all the writes of a script: the stream,
+// its maximum length, the server ID and the timestamp. The stream is empty when c is nil.
+func (c *changeCapture) args() []any {
+	if c == nil {
+		return []any{"", 0, "", ""}
+	}
+	return []any{c.stream.stream, c.stream.maxLen, c.serverID, c.timestamp.UTC().Format(time.RFC3339Nano)}
+}
+
+// changeArgs returns the arguments captureFunctions reads for a write to key: the fencing token,
+// then the content type and data of the value of a save. The token is empty for the writes that
+// are not captured, those of reserved keys included.
+func (c *changeCapture) changeArgs(key string, op ChangeOp, value any) ([]any, error) {
+	if c == nil {
+		return []any{"", "", ""}, nil
+	}
+	token, ok := c.tokens[key]
+	if _, _, local := LocalKey(key); !ok || !local {
+		return []any{"", "", ""}, nil
+	}
+	if op == ChangeDelete {
+		return []any{token, "", ""}, nil
+	}
+
+	contentType, data, err := c.stream.encodeValue(key, value)
+	if err != nil {
+		return nil, err
+	}
+	return []any{token, contentType, data}, nil
+}
+
+// seals reports whether the changes of key are captured with encrypted values.
+func (c *changeCapture) seals(key string) bool {
+	if c == nil || c.stream.keyring == nil {
+		return false
+	}
+	_, ok := c.tokens[key]
+	return ok
+}
+
+// captureArgs returns the arguments captureFunctions reads for a script making a single write
+// to key, taken from the change capture of ctx.
+func captureArgs(ctx context.Context, key string, op ChangeOp, value any) ([]any, error) {
+	c := changeCaptureFrom(ctx)
+	change, err := c.changeArgs(key, op, value)
+	if err != nil {
+		return nil, err
+	}
+	return append(c.args(), change...), nil
+}
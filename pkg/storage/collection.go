@@ -0,0 +1,656 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Collections live in a keyspace of their own, next to the JSON values: the key "cart" can
+// hold a value and a list at the same time, but only one collection. Like in Redis, a
+// collection is created by its first write, removed once empty and has no expiration.
+// Hash field values and list elements are any JSON value, set members are strings.
+const (
+	CollectionHash      CollectionType = "hash"
+	CollectionList      CollectionType = "list"
+	CollectionSet       CollectionType = "set"
+	CollectionSortedSet CollectionType = "zset"
+)
+
+var (
+	// ErrWrongType is returned when a key already holds a collection of another type.
+	ErrWrongType = errors.New("key holds a collection of another type")
+	// ErrFieldNotFound is returned when a hash has no such field.
+	ErrFieldNotFound = errors.New("field not found")
+	// ErrCollectionsUnsupported is returned by stores without collections.
+	ErrCollectionsUnsupported = errors.New("storage does not support collections")
+)
+
+type (
+	CollectionType string
+
+	ScoredMember struct {
+		Member string
+		Score  float64
+	}
+
+	// Collections is implemented by the stores that hold typed collections. Every operation
+	// is atomic. Ranges take inclusive start and stop indexes, negative ones counting from
+	// the end, so 0 and -1 select the whole collection. Reading a missing collection returns
+	// nothing, except HashGet and ListPop, which fail with ErrKeyNotFound.
+	Collections interface {
+		// HashSet sets the given fields and returns how many of them are new.
+		HashSet(ctx context.Context, key string, fields map[string]any) (int, error)
+		HashGet(ctx context.Context, key, field string) (any, error)
+		HashGetAll(ctx context.Context, key string) (map[string]any, error)
+		// ListPush inserts values at the head of the list, one after the other, and returns its length.
+		ListPush(ctx context.Context, key string, values []any) (int, error)
+		// ListPop removes and returns the element at the tail of the list.
+		ListPop(ctx context.Context, key string) (any, error)
+		ListRange(ctx context.Context, key string, start, stop int) ([]any, error)
+		// SetAdd adds members to the set and returns how many of them are new.
+		SetAdd(ctx context.Context, key string, members []string) (int, error)
+		// SetMembers returns the members of the set in lexical order.
+		SetMembers(ctx context.Context, key string) ([]string, error)
+		// SortedSetAdd adds members or updates their score and returns how many of them are new.
+		SortedSetAdd(ctx context.Context, key string, members []ScoredMember) (int, error)
+		// SortedSetRange returns members by rank, ordered by score and then by member.
+		SortedSetRange(ctx context.Context, key string, start, stop int) ([]ScoredMember, error)
+	}
+
+	// collectionDeleter is implemented by the stores whose collections can be deleted
+	// along with a namespace.
+	collectionDeleter interface {
+		DeleteCollections(ctx context.Context, prefix string) (int, error)
+	}
+
+	// forwardCollections implements Collections for the decorators that leave collections
+	// alone. Each operation is atomic in the store, so it needs neither locks nor caching.
+	forwardCollections struct {
+		store Store
+	}
+
+	memoryCollection struct {
+		kind CollectionType
+		// size approximates the memory held by the collection, like the size of a memoryItem.
+		size int64
+		hash map[string]any
+		// list holds the elements from head to tail.
+		list []any
+		set  map[string]struct{}
+		zset map[string]float64
+	}
+
+	// collectionRecord holds the elements a write adds to a collection in the log of a memory
+	// store. Snapshots log every collection as the write that creates it.
+	collectionRecord struct {
+		Kind   CollectionType `json:"kind"`
+		Fields map[string]any `json:"fields,omitempty"`
+		// Values are pushed at the head of the list one after the other.
+		Values  []any              `json:"values,omitempty"`
+		Members []string           `json:"members,omitempty"`
+		Scores  map[string]float64 `json:"scores,omitempty"`
+	}
+
+	// memoryCollections holds the collections of a memory store behind a single lock.
+	memoryCollections struct {
+		mu    sync.RWMutex
+		items map[string]*memoryCollection
+	}
+)
+
+func (f forwardCollections) HashSet(ctx context.Context, key string, fields map[string]any) (int, error) {
+	c, err := collectionsOf(f.store)
+	if err != nil {
+		return 0, err
+	}
+	return c.HashSet(ctx, key, fields)
+}
+
+func (f forwardCollections) HashGet(ctx context.Context, key, field string) (any, error) {
+	c, err := collectionsOf(f.store)
+	if err != nil {
+		return nil, err
+	}
+	return c.HashGet(ctx, key, field)
+}
+
+func (f forwardCollections) HashGetAll(ctx context.Context, key string) (map[string]any, error) {
+	c, err := collectionsOf(f.store)
+	if err != nil {
+		return nil, err
+	}
+	return c.HashGetAll(ctx, key)
+}
+
+func (f forwardCollections) ListPush(ctx context.Context, key string, values []any) (int, error) {
+	c, err := collectionsOf(f.store)
+	if err != nil {
+		return 0, err
+	}
+	return c.ListPush(ctx, key, values)
+}
+
+func (f forwardCollections) ListPop(ctx context.Context, key string) (any, error) {
+	c, err := collectionsOf(f.store)
+	if err != nil {
+		return nil, err
+	}
+	return c.ListPop(ctx, key)
+}
+
+func (f forwardCollections) ListRange(ctx context.Context, key string, start, stop int) ([]any, error) {
+	c, err := collectionsOf(f.store)
+	if err != nil {
+		return nil, err
+	}
+	return c.ListRange(ctx, key, start, stop)
+}
+
+func (f forwardCollections) SetAdd(ctx context.Context, key string, members []string) (int, error) {
+	c, err := collectionsOf(f.store)
+	if err != nil {
+		return 0, err
+	}
+	return c.SetAdd(ctx, key, members)
+}
+
+func (f forwardCollections) SetMembers(ctx context.Context, key string) ([]string, error) {
+	c, err := collectionsOf(f.store)
+	if err != nil {
+		return nil, err
+	}
+	return c.SetMembers(ctx, key)
+}
+
+func (f forwardCollections) SortedSetAdd(ctx context.Context, key string, members []ScoredMember) (int, error) {
+	c, err := collectionsOf(f.store)
+	if err != nil {
+		return 0, err
+	}
+	return c.SortedSetAdd(ctx, key, members)
+}
+
+func (f forwardCollections) SortedSetRange(ctx context.Context, key string, start, stop int) ([]ScoredMember, error) {
+	c, err := collectionsOf(f.store)
+	if err != nil {
+		return nil, err
+	}
+	return c.SortedSetRange(ctx, key, start, stop)
+}
+
+func (f forwardCollections) DeleteCollections(ctx context.Context, prefix string) (int, error) {
+	deleter, ok := f.store.(collectionDeleter)
+	if !ok {
+		return 0, nil
+	}
+	return deleter.DeleteCollections(ctx, prefix)
+}
+
+func collectionsOf(store Store) (Collections, error) {
+	c, ok := store.(Collections)
+	if !ok {
+		return nil, ErrCollectionsUnsupported
+	}
+	return c, nil
+}
+
+// rangeBounds converts inclusive start and stop indexes, which may count from the end, into
+// the bounds of a slice of n elements. It returns false when the range is empty.
+func rangeBounds(start, stop, n int) (int, int, bool) {
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	start = max(start, 0)
+	stop = min(stop, n-1)
+	if start > stop {
+		return 0, 0, false
+	}
+	return start, stop + 1, true
+}
+
+func newMemoryCollections() *memoryCollections {
+	return &memoryCollections{items: make(map[string]*memoryCollection)}
+}
+
+// get returns the collection of key, nil if it is missing. With create, a missing collection
+// is created, and only stored by put once it holds something.
+func (mc *memoryCollections) get(key string, kind CollectionType, create bool) (*memoryCollection, error) {
+	c, ok := mc.items[key]
+	if ok {
+		if c.kind != kind {
+			return nil, ErrWrongType
+		}
+		return c, nil
+	}
+	if !create {
+		return nil, nil
+	}
+
+	c = &memoryCollection{kind: kind}
+	switch kind {
+	case CollectionHash:
+		c.hash = make(map[string]any)
+	case CollectionSet:
+		c.set = make(map[string]struct{})
+	case CollectionSortedSet:
+		c.zset = make(map[string]float64)
+	}
+	return c, nil
+}
+
+// put stores the collection of key after a write, or removes it once empty.
+func (mc *memoryCollections) put(key string, c *memoryCollection) {
+	if c.len() == 0 {
+		delete(mc.items, key)
+		return
+	}
+	mc.items[key] = c
+}
+
+// apply replays a collection write read back from the log.
+func (mc *memoryCollections) apply(record walRecord) {
+	switch record.Op {
+	case walOpCollection:
+		if c, err := mc.get(record.Key, record.Collection.Kind, true); err == nil {
+			c.add(record.Key, record.Collection)
+			mc.put(record.Key, c)
+		}
+	case walOpListPop:
+		if c, _ := mc.get(record.Key, CollectionList, false); c != nil {
+			c.pop()
+			mc.put(record.Key, c)
+		}
+	case walOpDeleteCollections:
+		mc.deletePrefix(record.Key)
+	}
+}
+
+// deletePrefix deletes every collection whose key starts with prefix and returns how many
+// there were and their size.
+func (mc *memoryCollections) deletePrefix(prefix string) (int, int64) {
+	var (
+		deleted int
+		bytes   int64
+	)
+	for key, c := range mc.items {
+		if strings.HasPrefix(key, prefix) {
+			delete(mc.items, key)
+			deleted++
+			bytes += c.size
+		}
+	}
+	return deleted, bytes
+}
+
+// records returns every collection as the log record of the write that creates it.
+func (mc *memoryCollections) records() []walRecord {
+	records := make([]walRecord, 0, len(mc.items))
+	for key, c := range mc.items {
+		records = append(records, walRecord{Op: walOpCollection, Key: key, Collection: c.record()})
+	}
+	return records
+}
+
+// growth returns how many collections and bytes adding the elements of r to the collection of
+// key adds, counting the collection itself when it is created.
+func (c *memoryCollection) growth(key string, r *collectionRecord) (int64, int64) {
+	var keys, bytes int64
+	if c.len() == 0 {
+		keys, bytes = 1, entryOverhead+int64(len(key))
+	}
+
+	switch c.kind {
+	case CollectionHash:
+		for field, value := range r.Fields {
+			if previous, ok := c.hash[field]; ok {
+				bytes -= fieldSize(field, previous)
+			}
+			bytes += fieldSize(field, value)
+		}
+	case CollectionList:
+		for _, value := range r.Values {
+			bytes += elementSize(value)
+		}
+	case CollectionSet:
+		added := make(map[string]struct{}, len(r.Members))
+		for _, member := range r.Members {
+			if _, ok := c.set[member]; ok {
+				continue
+			}
+			if _, ok := added[member]; !ok {
+				added[member] = struct{}{}
+				bytes += memberSize(member)
+			}
+		}
+	case CollectionSortedSet:
+		for member := range r.Scores {
+			if _, ok := c.zset[member]; !ok {
+				bytes += memberSize(member) + 8
+			}
+		}
+	}
+	return keys, bytes
+}
+
+// add adds the elements of r and returns how many of them are new, every pushed value being new.
+func (c *memoryCollection) add(key string, r *collectionRecord) int {
+	_, bytes := c.growth(key, r)
+	c.size += bytes
+
+	added := 0
+	switch c.kind {
+	case CollectionHash:
+		for field, value := range r.Fields {
+			if _, ok := c.hash[field]; !ok {
+				added++
+			}
+			c.hash[field] = value
+		}
+	case CollectionList:
+		head := slices.Clone(r.Values)
+		slices.Reverse(head)
+		c.list = append(head, c.list...)
+		added = len(r.Values)
+	case CollectionSet:
+		for _, member := range r.Members {
+			if _, ok := c.set[member]; !ok {
+				c.set[member] = struct{}{}
+				added++
+			}
+		}
+	case CollectionSortedSet:
+		for member, score := range r.Scores {
+			if _, ok := c.zset[member]; !ok {
+				added++
+			}
+			c.zset[member] = score
+		}
+	}
+	return added
+}
+
+// pop removes and returns the element at the tail of the list.
+func (c *memoryCollection) pop() any {
+	last := len(c.list) - 1
+	value := c.list[last]
+	c.list[last] = nil
+	c.list = c.list[:last]
+	c.size -= elementSize(value)
+	return value
+}
+
+// record returns a copy of every element of the collection as the write that creates it.
+func (c *memoryCollection) record() *collectionRecord {
+	r := &collectionRecord{Kind: c.kind}
+	switch c.kind {
+	case CollectionHash:
+		r.Fields = maps.Clone(c.hash)
+	case CollectionList:
+		r.Values = slices.Clone(c.list)
+		slices.Reverse(r.Values)
+	case CollectionSet:
+		r.Members = slices.Collect(maps.Keys(c.set))
+	case CollectionSortedSet:
+		r.Scores = maps.Clone(c.zset)
+	}
+	return r
+}
+
+func (c *memoryCollection) len() int {
+	switch c.kind {
+	case CollectionHash:
+		return len(c.hash)
+	case CollectionList:
+		return len(c.list)
+	case CollectionSet:
+		return len(c.set)
+	default:
+		return len(c.zset)
+	}
+}
+
+func (m *Memory) HashSet(_ context.Context, key string, fields map[string]any) (int, error) {
+	mc := m.collections
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	c, err := mc.get(key, CollectionHash, true)
+	if err != nil {
+		return 0, err
+	}
+	return m.addToCollection(key, c, &collectionRecord{Kind: CollectionHash, Fields: fields})
+}
+
+func (m *Memory) HashGet(_ context.Context, key, field string) (any, error) {
+	mc := m.collections
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+
+	c, err := mc.get(key, CollectionHash, false)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return nil, ErrKeyNotFound
+	}
+
+	value, ok := c.hash[field]
+	if !ok {
+		return nil, ErrFieldNotFound
+	}
+	return value, nil
+}
+
+func (m *Memory) HashGetAll(_ context.Context, key string) (map[string]any, error) {
+	mc := m.collections
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+
+	c, err := mc.get(key, CollectionHash, false)
+	if err != nil || c == nil {
+		return map[string]any{}, err
+	}
+	return maps.Clone(c.hash), nil
+}
+
+func (m *Memory) ListPush(_ context.Context, key string, values []any) (int, error) {
+	mc := m.collections
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	c, err := mc.get(key, CollectionList, true)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := m.addToCollection(key, c, &collectionRecord{Kind: CollectionList, Values: values}); err != nil {
+		return 0, err
+	}
+	return len(c.list), nil
+}
+
+func (m *Memory) ListPop(_ context.Context, key string) (any, error) {
+	mc := m.collections
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	c, err := mc.get(key, CollectionList, false)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return nil, ErrKeyNotFound
+	}
+
+	if m.wal != nil {
+		if err := m.wal.append(walRecord{Op: walOpListPop, Key: key}); err != nil {
+			return nil, err
+		}
+	}
+
+	size := c.size
+	value := c.pop()
+	mc.put(key, c)
+	if c.len() == 0 {
+		m.release(1, size)
+	} else {
+		m.release(0, size-c.size)
+	}
+	return value, nil
+}
+
+func (m *Memory) ListRange(_ context.Context, key string, start, stop int) ([]any, error) {
+	mc := m.collections
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+
+	c, err := mc.get(key, CollectionList, false)
+	if err != nil || c == nil {
+		return []any{}, err
+	}
+
+	from, to, ok := rangeBounds(start, stop, len(c.list))
+	if !ok {
+		return []any{}, nil
+	}
+	return slices.Clone(c.list[from:to]), nil
+}
+
+func (m *Memory) SetAdd(_ context.Context, key string, members []string) (int, error) {
+	mc := m.collections
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	c, err := mc.get(key, CollectionSet, true)
+	if err != nil {
+		return 0, err
+	}
+	return m.addToCollection(key, c, &collectionRecord{Kind: CollectionSet, Members: members})
+}
+
+func (m *Memory) SetMembers(_ context.Context, key string) ([]string, error) {
+	mc := m.collections
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+
+	c, err := mc.get(key, CollectionSet, false)
+	if err != nil || c == nil {
+		return []string{}, err
+	}
+	return slices.Sorted(maps.Keys(c.set)), nil
+}
+
+func (m *Memory) SortedSetAdd(_ context.Context, key string, members []ScoredMember) (int, error) {
+	mc := m.collections
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	c, err := mc.get(key, CollectionSortedSet, true)
+	if err != nil {
+		return 0, err
+	}
+
+	scores := make(map[string]float64, len(members))
+	for _, member := range members {
+		scores[member.Member] = member.Score
+	}
+	return m.addToCollection(key, c, &collectionRecord{Kind: CollectionSortedSet, Scores: scores})
+}
+
+func (m *Memory) SortedSetRange(_ context.Context, key string, start, stop int) ([]ScoredMember, error) {
+	mc := m.collections
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+
+	c, err := mc.get(key, CollectionSortedSet, false)
+	if err != nil || c == nil {
+		return []ScoredMember{}, err
+	}
+
+	members := make([]ScoredMember, 0, len(c.zset))
+	for member, score := range c.zset {
+		members = append(members, ScoredMember{Member: member, Score: score})
+	}
+	slices.SortFunc(members, compareScoredMembers)
+
+	from, to, ok := rangeBounds(start, stop, len(members))
+	if !ok {
+		return []ScoredMember{}, nil
+	}
+	return members[from:to], nil
+}
+
+// DeleteCollections deletes every collection whose key starts with prefix.
+func (m *Memory) DeleteCollections(_ context.Context, prefix string) (int, error) {
+	mc := m.collections
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	if m.wal != nil {
+		if err := m.wal.append(walRecord{Op: walOpDeleteCollections, Key: prefix}); err != nil {
+			return 0, err
+		}
+	}
+
+	deleted, bytes := mc.deletePrefix(prefix)
+	m.release(int64(deleted), bytes)
+	return deleted, nil
+}
+
+// addToCollection adds the elements of r to the collection c of key, returned by get, once the
+// write fits within the limits and is logged. Values are evicted to make room, collections never
+// are. Callers must hold m.collections.mu for writing.
+func (m *Memory) addToCollection(key string, c *memoryCollection, r *collectionRecord) (int, error) {
+	if r.len() == 0 {
+		return 0, nil
+	}
+
+	keys, bytes := c.growth(key, r)
+	keep := func(string) bool { return false }
+	if err := m.reserve(nil, keep, keys, bytes, 1, c.size+bytes); err != nil {
+		return 0, err
+	}
+
+	if m.wal != nil {
+		if err := m.wal.append(walRecord{Op: walOpCollection, Key: key, Collection: r}); err != nil {
+			m.release(keys, bytes)
+			return 0, err
+		}
+	}
+
+	added := c.add(key, r)
+	m.collections.put(key, c)
+	return added, nil
+}
+
+func (r *collectionRecord) len() int {
+	return len(r.Fields) + len(r.Values) + len(r.Members) + len(r.Scores)
+}
+
+// fieldSize, elementSize and memberSize approximate the memory held by an element of a
+// collection, in the same way valueSize does for maps, slices and strings.
+func fieldSize(field string, value any) int64 {
+	return 16 + int64(len(field)) + 16 + valueSize(value)
+}
+
+func elementSize(value any) int64 {
+	return 16 + valueSize(value)
+}
+
+func memberSize(member string) int64 {
+	return 16 + int64(len(member))
+}
+
+func compareScoredMembers(a, b ScoredMember) int {
+	if c := cmp.Compare(a.Score, b.Score); c != 0 {
+		return c
+	}
+	return strings.Compare(a.Member, b.Member)
+}
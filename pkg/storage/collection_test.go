@@ -0,0 +1,211 @@
+package storage_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/felipeascari/kv-store/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryCollections(t *testing.T) {
+	testCollections(t, storage.NewMemory())
+}
+
+func TestMemoryCollectionLimits(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should count collections toward the limits", func(t *testing.T) {
+		m, err := storage.NewMemoryWithOptions(storage.MemoryOptions{MaxKeys: 2})
+		require.NoError(t, err)
+
+		_, err = m.Save(ctx, "value", "1", 0)
+		require.NoError(t, err)
+		_, err = m.SetAdd(ctx, "tags", []string{"a"})
+		require.NoError(t, err)
+		require.Equal(t, int64(2), m.Stats().Keys)
+
+		_, err = m.ListPush(ctx, "queue", []any{1})
+		require.ErrorIs(t, err, storage.ErrOutOfMemory)
+
+		_, err = m.SetAdd(ctx, "tags", []string{"b"})
+		require.NoError(t, err)
+
+		_, err = m.DeleteCollections(ctx, "tags")
+		require.NoError(t, err)
+		require.Equal(t, int64(1), m.Stats().Keys)
+	})
+
+	t.Run("should evict values to make room for collections", func(t *testing.T) {
+		m, err := storage.NewMemoryWithOptions(storage.MemoryOptions{MaxBytes: 1024, Eviction: storage.EvictionLRU})
+		require.NoError(t, err)
+
+		_, err = m.Save(ctx, "value", strings.Repeat("x", 512), 0)
+		require.NoError(t, err)
+		_, err = m.ListPush(ctx, "queue", []any{strings.Repeat("y", 512)})
+		require.NoError(t, err)
+
+		_, err = m.Retrieve(ctx, "value")
+		require.ErrorIs(t, err, storage.ErrKeyNotFound)
+
+		_, err = m.ListPop(ctx, "queue")
+		require.NoError(t, err)
+		require.Equal(t, storage.MemoryStats{MaxBytes: 1024, Eviction: storage.EvictionLRU, Evictions: 1}, m.Stats())
+	})
+}
+
+func TestNamespacedCollections(t *testing.T) {
+	ctx := context.Background()
+
+	m := storage.NewMemory()
+	namespaces := storage.NewNamespaces(m)
+	n := storage.NewNamespaced(m)
+
+	_, err := namespaces.Create(ctx, "billing")
+	require.NoError(t, err)
+	billing := storage.WithNamespace(ctx, "billing")
+
+	_, err = n.SetAdd(billing, "tags", []string{"a", "b"})
+	require.NoError(t, err)
+	_, err = n.SetAdd(ctx, "tags", []string{"c"})
+	require.NoError(t, err)
+
+	members, err := n.SetMembers(billing, "tags")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b"}, members)
+
+	_, err = n.ListPush(ctx, "kv-store:queue", []any{1})
+	require.ErrorIs(t, err, storage.ErrReservedKey)
+
+	deleted, err := namespaces.Delete(ctx, "billing")
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted)
+
+	members, err = n.SetMembers(billing, "tags")
+	require.NoError(t, err)
+	require.Empty(t, members)
+
+	members, err = n.SetMembers(ctx, "tags")
+	require.NoError(t, err)
+	require.Equal(t, []string{"c"}, members)
+}
+
+// testCollections checks the semantics every storage.Collections implementation shares.
+func testCollections(t *testing.T, c storage.Collections) {
+	ctx := context.Background()
+
+	t.Run("should set and get hash fields", func(t *testing.T) {
+		added, err := c.HashSet(ctx, "coll:user", map[string]any{"name": "Alice", "age": 30.0})
+		require.NoError(t, err)
+		require.Equal(t, 2, added)
+
+		added, err = c.HashSet(ctx, "coll:user", map[string]any{"age": 31.0, "team": map[string]any{"id": 1.0}})
+		require.NoError(t, err)
+		require.Equal(t, 1, added)
+
+		value, err := c.HashGet(ctx, "coll:user", "age")
+		require.NoError(t, err)
+		require.Equal(t, 31.0, value)
+
+		_, err = c.HashGet(ctx, "coll:user", "email")
+		require.ErrorIs(t, err, storage.ErrFieldNotFound)
+
+		_, err = c.HashGet(ctx, "coll:missing", "email")
+		require.ErrorIs(t, err, storage.ErrKeyNotFound)
+
+		fields, err := c.HashGetAll(ctx, "coll:user")
+		require.NoError(t, err)
+		require.Equal(t, map[string]any{"name": "Alice", "age": 31.0, "team": map[string]any{"id": 1.0}}, fields)
+	})
+
+	t.Run("should push, pop and range lists", func(t *testing.T) {
+		length, err := c.ListPush(ctx, "coll:queue", []any{"a", "b"})
+		require.NoError(t, err)
+		require.Equal(t, 2, length)
+
+		length, err = c.ListPush(ctx, "coll:queue", []any{"c"})
+		require.NoError(t, err)
+		require.Equal(t, 3, length)
+
+		values, err := c.ListRange(ctx, "coll:queue", 0, -1)
+		require.NoError(t, err)
+		require.Equal(t, []any{"c", "b", "a"}, values)
+
+		values, err = c.ListRange(ctx, "coll:queue", -2, 10)
+		require.NoError(t, err)
+		require.Equal(t, []any{"b", "a"}, values)
+
+		values, err = c.ListRange(ctx, "coll:queue", 5, 10)
+		require.NoError(t, err)
+		require.Empty(t, values)
+
+		for _, want := range []string{"a", "b", "c"} {
+			value, err := c.ListPop(ctx, "coll:queue")
+			require.NoError(t, err)
+			require.Equal(t, want, value)
+		}
+
+		_, err = c.ListPop(ctx, "coll:queue")
+		require.ErrorIs(t, err, storage.ErrKeyNotFound)
+	})
+
+	t.Run("should add set members", func(t *testing.T) {
+		added, err := c.SetAdd(ctx, "coll:tags", []string{"b", "a", "b"})
+		require.NoError(t, err)
+		require.Equal(t, 2, added)
+
+		added, err = c.SetAdd(ctx, "coll:tags", []string{"a", "c"})
+		require.NoError(t, err)
+		require.Equal(t, 1, added)
+
+		members, err := c.SetMembers(ctx, "coll:tags")
+		require.NoError(t, err)
+		require.Equal(t, []string{"a", "b", "c"}, members)
+
+		members, err = c.SetMembers(ctx, "coll:missing")
+		require.NoError(t, err)
+		require.Empty(t, members)
+	})
+
+	t.Run("should rank sorted set members", func(t *testing.T) {
+		added, err := c.SortedSetAdd(ctx, "coll:board", []storage.ScoredMember{
+			{Member: "alice", Score: 30},
+			{Member: "bob", Score: 10},
+			{Member: "carol", Score: 20},
+		})
+		require.NoError(t, err)
+		require.Equal(t, 3, added)
+
+		added, err = c.SortedSetAdd(ctx, "coll:board", []storage.ScoredMember{{Member: "bob", Score: 40}, {Member: "dave", Score: 20}})
+		require.NoError(t, err)
+		require.Equal(t, 1, added)
+
+		members, err := c.SortedSetRange(ctx, "coll:board", 0, -1)
+		require.NoError(t, err)
+		require.Equal(t, []storage.ScoredMember{
+			{Member: "carol", Score: 20},
+			{Member: "dave", Score: 20},
+			{Member: "alice", Score: 30},
+			{Member: "bob", Score: 40},
+		}, members)
+
+		members, err = c.SortedSetRange(ctx, "coll:board", -1, -1)
+		require.NoError(t, err)
+		require.Equal(t, []storage.ScoredMember{{Member: "bob", Score: 40}}, members)
+	})
+
+	t.Run("should reject operations of another type", func(t *testing.T) {
+		_, err := c.SetAdd(ctx, "coll:typed", []string{"a"})
+		require.NoError(t, err)
+
+		_, err = c.ListPush(ctx, "coll:typed", []any{"a"})
+		require.ErrorIs(t, err, storage.ErrWrongType)
+
+		_, err = c.HashGet(ctx, "coll:typed", "a")
+		require.ErrorIs(t, err, storage.ErrWrongType)
+
+		_, err = c.SortedSetRange(ctx, "coll:typed", 0, -1)
+		require.ErrorIs(t, err, storage.ErrWrongType)
+	})
+}
@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressed values start with a marker byte naming their encoding, which neither JSON
+// documents nor blobs start with, so values written with and without compression coexist
+// and can be read whatever the current configuration is.
+const (
+	CompressionNone   Compression = "none"
+	CompressionZstd   Compression = "zstd"
+	CompressionSnappy Compression = "snappy"
+
+	// DefaultCompressionThreshold is the size below which values are stored uncompressed.
+	DefaultCompressionThreshold = 1024
+	// minCompressionThreshold keeps numbers uncompressed, so Redis can still increment them.
+	minCompressionThreshold = 64
+
+	zstdMarker   = 0x01
+	snappyMarker = 0x02
+)
+
+var (
+	zstdEncoder = sync.OnceValue(func() *zstd.Encoder {
+		encoder, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		return encoder
+	})
+	zstdDecoder = sync.OnceValue(func() *zstd.Decoder {
+		decoder, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+		return decoder
+	})
+)
+
+type (
+	Compression string
+
+	// CompressionStats reports what a store compressed since it started.
+	CompressionStats struct {
+		Algorithm Compression
+		Threshold int
+		// Compressed counts the values stored compressed, and Skipped the values stored as
+		// they are because they were below the threshold or did not get any smaller.
+		Compressed uint64
+		Skipped    uint64
+		// BytesIn and BytesOut are the sizes of the compressed values before and after compression.
+		BytesIn  uint64
+		BytesOut uint64
+	}
+
+	// compressionReporter is implemented by the stores that compress values, and by the
+	// decorators in front of them.
+	compressionReporter interface {
+		CompressionStats() (CompressionStats, bool)
+	}
+
+	// encodedCompressor is implemented by the stores that compress values, for the decorators
+	// that must compress them before transforming them, such as Encrypted.
+	encodedCompressor interface {
+		// compressEncoded returns the stored form of an encoded value, compressed when enabled
+		// and worth it. decodeValue reads it back.
+		compressEncoded(data []byte) []byte
+	}
+
+	// compressor compresses the values of a store above a size threshold and accounts for it.
+	compressor struct {
+		algorithm  Compression
+		threshold  int
+		compressed atomic.Uint64
+		skipped    atomic.Uint64
+		bytesIn    atomic.Uint64
+		bytesOut   atomic.Uint64
+	}
+)
+
+func (c Compression) String() string {
+	return string(c)
+}
+
+func (c Compression) IsValid() bool {
+	switch c {
+	case CompressionNone, CompressionZstd, CompressionSnappy:
+		return true
+	default:
+		return false
+	}
+}
+
+// Ratio returns how many times smaller compression made the values it compressed.
+func (s CompressionStats) Ratio() float64 {
+	if s.BytesOut == 0 {
+		return 0
+	}
+	return float64(s.BytesIn) / float64(s.BytesOut)
+}
+
+// compressionStatsOf returns the compression stats of store, false when it does not compress.
+func compressionStatsOf(store Store) (CompressionStats, bool) {
+	reporter, ok := store.(compressionReporter)
+	if !ok {
+		return CompressionStats{}, false
+	}
+	return reporter.CompressionStats()
+}
+
+// newCompressor returns nil when compression is disabled.
+func newCompressor(algorithm Compression, threshold int) (*compressor, error) {
+	if algorithm == "" {
+		algorithm = CompressionNone
+	}
+	if !algorithm.IsValid() {
+		return nil, fmt.Errorf("invalid compression: %q", algorithm)
+	}
+	if algorithm == CompressionNone {
+		return nil, nil
+	}
+
+	if threshold <= 0 {
+		threshold = DefaultCompressionThreshold
+	}
+	return &compressor{
+		algorithm: algorithm,
+		threshold: max(threshold, minCompressionThreshold),
+	}, nil
+}
+
+// compress returns the stored form of an encoded value, compressed only when that saves space.
+func (c *compressor) compress(data []byte) []byte {
+	if len(data) < c.threshold {
+		c.skipped.Add(1)
+		return data
+	}
+
+	var out []byte
+	switch c.algorithm {
+	case CompressionZstd:
+		out = zstdEncoder().EncodeAll(data, []byte{zstdMarker})
+	case CompressionSnappy:
+		out = append([]byte{snappyMarker}, snappy.Encode(nil, data)...)
+	}
+
+	if len(out) >= len(data) {
+		c.skipped.Add(1)
+		return data
+	}
+
+	c.compressed.Add(1)
+	c.bytesIn.Add(uint64(len(data)))
+	c.bytesOut.Add(uint64(len(out)))
+	return out
+}
+
+func (c *compressor) stats() CompressionStats {
+	return CompressionStats{
+		Algorithm:  c.algorithm,
+		Threshold:  c.threshold,
+		Compressed: c.compressed.Load(),
+		Skipped:    c.skipped.Load(),
+		BytesIn:    c.bytesIn.Load(),
+		BytesOut:   c.bytesOut.Load(),
+	}
+}
+
+// decompress returns the encoded value held by compressed data, starting with its marker.
+func decompress(data []byte) ([]byte, error) {
+	switch data[0] {
+	case zstdMarker:
+		return zstdDecoder().DecodeAll(data[1:], nil)
+	case snappyMarker:
+		return snappy.Decode(nil, data[1:])
+	default:
+		return nil, errors.New("unknown compression marker")
+	}
+}
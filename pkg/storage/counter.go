@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"math"
+)
+
+// maxExactInteger is the largest integer a float64 delta holds exactly.
+const maxExactInteger = 1 << 53
+
+var ErrNotNumeric = errors.New("value is not a number")
+
+// increment adds delta to a numeric value held by a store, nil meaning the key does not exist yet.
+func increment(current any, delta float64) (float64, error) {
+	var value float64
+	switch v := current.(type) {
+	case nil:
+	case float64:
+		value = v
+	case int:
+		value = float64(v)
+	case int64:
+		value = float64(v)
+	default:
+		return 0, ErrNotNumeric
+	}
+
+	result := value + delta
+	if math.IsInf(result, 0) || math.IsNaN(result) {
+		return 0, ErrNotNumeric
+	}
+	return result, nil
+}
+
+// incrementWithUpdate increments with the Update of store, for stores that cannot compute
+// the new value themselves. Existing keys are updated and missing ones are created with a
+// compare-and-swap, retrying if another writer creates the key in between.
+func incrementWithUpdate(ctx context.Context, store Store, key string, delta float64) (Entry, error) {
+	for range maxUpdateAttempts {
+		entry, err := store.Update(ctx, key, func(current Entry) (any, error) {
+			return increment(current.Value, delta)
+		})
+		if !errors.Is(err, ErrKeyNotFound) {
+			return entry, err
+		}
+
+		value, err := increment(nil, delta)
+		if err != nil {
+			return Entry{}, err
+		}
+
+		version, err := store.CompareAndSwap(ctx, key, 0, value, 0)
+		if errors.Is(err, ErrVersionMismatch) {
+			continue
+		}
+		if err != nil {
+			return Entry{}, err
+		}
+		return Entry{Value: value, Version: version}, nil
+	}
+
+	return Entry{}, ErrVersionMismatch
+}
+
+// isInteger reports whether delta can be applied with integer arithmetic.
+func isInteger(delta float64) bool {
+	return delta == math.Trunc(delta) && math.Abs(delta) < maxExactInteger
+}
@@ -0,0 +1,664 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const defaultMaxFileSize = 64 << 20
+
+type (
+	// DiskOptions configures the disk storage engine.
+	DiskOptions struct {
+		// Dir holds the data and hint files. It is created if missing.
+		Dir string
+		// MaxFileSize is the size at which the active data file is sealed and a new one started.
+		MaxFileSize int64
+		// MergeInterval is how often sealed files are compacted. Zero disables background merges.
+		MergeInterval time.Duration
+		// Fsync controls when writes are flushed to stable storage.
+		Fsync FsyncPolicy
+	}
+
+	// Disk is a single node, log-structured storage engine. Every write is appended to
+	// the active data file and the in-memory key directory records where the latest
+	// version of each key lives, so a read costs a single disk access.
+	Disk struct {
+		mu          sync.RWMutex
+		dir         string
+		fsync       FsyncPolicy
+		maxFileSize int64
+		keydir      map[string]diskLocation
+		// floor is the highest version of a key that was deleted or expired. Keys created
+		// afterwards start above it, so a version never matches a key that came back.
+		floor      int64
+		files      map[uint32]*os.File
+		active     *os.File
+		activeID   uint32
+		activeSize int64
+		merging    sync.Mutex
+		background *background
+	}
+)
+
+// OpenDisk opens the engine in opts.Dir, rebuilding the key directory from hint and data files.
+func OpenDisk(opts DiskOptions) (*Disk, error) {
+	if !opts.Fsync.IsValid() {
+		return nil, fmt.Errorf("invalid fsync policy: %q", opts.Fsync)
+	}
+
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	ids, err := listDataFileIDs(opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Disk{
+		dir:         opts.Dir,
+		fsync:       opts.Fsync,
+		maxFileSize: opts.MaxFileSize,
+		keydir:      make(map[string]diskLocation),
+		files:       make(map[uint32]*os.File, len(ids)+1),
+		background:  newBackground(),
+	}
+	if d.maxFileSize <= 0 {
+		d.maxFileSize = defaultMaxFileSize
+	}
+
+	if d.floor, err = readFloor(opts.Dir); err != nil {
+		return nil, err
+	}
+
+	for i, id := range ids {
+		if err := loadDataFile(opts.Dir, id, i == len(ids)-1, d.keydir, &d.floor); err != nil {
+			d.closeFiles()
+			return nil, err
+		}
+
+		file, err := os.Open(dataPath(opts.Dir, id))
+		if err != nil {
+			d.closeFiles()
+			return nil, err
+		}
+		d.files[id] = file
+	}
+
+	var next uint32 = 1
+	if len(ids) > 0 {
+		next = ids[len(ids)-1] + 1
+	}
+
+	if err := d.openActive(next); err != nil {
+		d.closeFiles()
+		return nil, err
+	}
+
+	if opts.Fsync == FsyncEverySecond {
+		d.background.every(time.Second, func() {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			_ = d.active.Sync()
+		})
+	}
+
+	if opts.MergeInterval > 0 {
+		d.background.every(opts.MergeInterval, func() {
+			_ = d.Merge()
+		})
+	}
+
+	return d, nil
+}
+
+func (d *Disk) Save(_ context.Context, key string, value any, ttl time.Duration) (int64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.save(key, value, ttl)
+}
+
+func (d *Disk) Retrieve(_ context.Context, key string) (Entry, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.get(key)
+}
+
+func (d *Disk) Delete(_ context.Context, key string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, exists := d.location(key); !exists {
+		return ErrKeyNotFound
+	}
+
+	return d.remove(key)
+}
+
+func (d *Disk) CompareAndSwap(_ context.Context, key string, expectedVersion int64, value any, ttl time.Duration) (int64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	current, _ := d.location(key)
+	if current.version != expectedVersion {
+		return 0, ErrVersionMismatch
+	}
+
+	return d.save(key, value, ttl)
+}
+
+func (d *Disk) CompareAndDelete(_ context.Context, key string, expectedVersion int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	current, exists := d.location(key)
+	if !exists {
+		return ErrKeyNotFound
+	}
+	if current.version != expectedVersion {
+		return ErrVersionMismatch
+	}
+
+	return d.remove(key)
+}
+
+func (d *Disk) Increment(_ context.Context, key string, delta float64) (Entry, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	current, err := d.get(key)
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return Entry{}, err
+	}
+
+	value, err := increment(current.Value, delta)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	entry := Entry{
+		Value:     value,
+		Version:   d.next(key),
+		ExpiresAt: current.ExpiresAt,
+	}
+	if err := d.put(key, entry); err != nil {
+		return Entry{}, err
+	}
+
+	return entry, nil
+}
+
+func (d *Disk) Update(_ context.Context, key string, fn UpdateFunc) (Entry, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	current, err := d.get(key)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	value, err := fn(current)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	entry := Entry{
+		Value:     value,
+		Version:   current.Version + 1,
+		ExpiresAt: current.ExpiresAt,
+	}
+	if err := d.put(key, entry); err != nil {
+		return Entry{}, err
+	}
+
+	return entry, nil
+}
+
+// Scan walks the key directory, values are never read from disk.
+func (d *Disk) Scan(ctx context.Context, prefix, cursor string, limit int) (ScanResult, error) {
+	page, err := newPageSelector(prefix, cursor, limit)
+	if err != nil {
+		return ScanResult{}, err
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	now := time.Now()
+	scanned := 0
+	for key, loc := range d.keydir {
+		if scanned++; scanned%scanCancelCheckInterval == 0 && ctx.Err() != nil {
+			return ScanResult{}, ctx.Err()
+		}
+		if !loc.expired(now) {
+			page.offer(key)
+		}
+	}
+
+	return page.result(), nil
+}
+
+func (d *Disk) BatchSave(_ context.Context, items []BatchItem) ([]BatchResult, error) {
+	results := make([]BatchResult, len(items))
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i, item := range items {
+		results[i] = BatchResult{Key: item.Key}
+
+		version, err := d.save(item.Key, item.Value, item.TTL)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		results[i].Entry = Entry{
+			Value:     item.Value,
+			Version:   version,
+			ExpiresAt: d.keydir[item.Key].expiration(),
+		}
+	}
+
+	return results, nil
+}
+
+func (d *Disk) BatchRetrieve(_ context.Context, keys []string) ([]BatchResult, error) {
+	results := make([]BatchResult, len(keys))
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	for i, key := range keys {
+		results[i] = BatchResult{Key: key}
+		results[i].Entry, results[i].Err = d.get(key)
+	}
+
+	return results, nil
+}
+
+func (d *Disk) BatchDelete(_ context.Context, keys []string) ([]BatchResult, error) {
+	results := make([]BatchResult, len(keys))
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i, key := range keys {
+		results[i] = BatchResult{Key: key}
+		if _, exists := d.location(key); exists {
+			results[i].Err = d.remove(key)
+		} else {
+			results[i].Err = ErrKeyNotFound
+		}
+	}
+
+	return results, nil
+}
+
+// Transact writes the records of the transaction to the active file in a single write,
+// flagged so that recovery applies them all or none.
+func (d *Disk) Transact(_ context.Context, tx Transaction) ([]TxResult, error) {
+	if err := tx.Validate(); err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	writes, results, err := tx.plan(
+		func(key string) int64 {
+			loc, _ := d.location(key)
+			return loc.version
+		},
+		d.next,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]diskRecord, len(writes))
+	for i, write := range writes {
+		records[i] = diskRecord{
+			tombstone: write.deleted,
+			pending:   i < len(writes)-1,
+			version:   write.entry.Version,
+			key:       write.key,
+		}
+		if write.deleted {
+			continue
+		}
+		if records[i].value, err = encodeValue(write.entry.Value); err != nil {
+			return nil, err
+		}
+		if !write.entry.ExpiresAt.IsZero() {
+			records[i].expiresAt = write.entry.ExpiresAt.UnixNano()
+		}
+	}
+
+	locations, err := d.append(records...)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, record := range records {
+		if record.tombstone {
+			delete(d.keydir, record.key)
+			d.floor = max(d.floor, record.version)
+		} else {
+			d.keydir[record.key] = locations[i]
+		}
+	}
+
+	return results, nil
+}
+
+// Merge compacts every sealed data file into a single new one holding only the live
+// records, then deletes the compacted files. Writes keep going to a fresh active file
+// while the merge runs; the store is only locked to seal the active file and to swap
+// the key directory entries of the records that were copied.
+func (d *Disk) Merge() error {
+	d.merging.Lock()
+	defer d.merging.Unlock()
+
+	d.mu.Lock()
+	if len(d.files) == 1 && d.activeSize == 0 {
+		d.mu.Unlock()
+		return nil
+	}
+
+	mergeID := d.activeID + 1
+	if err := d.openActive(d.activeID + 2); err != nil {
+		d.mu.Unlock()
+		return err
+	}
+
+	sealed := make(map[uint32]*os.File, len(d.files)-1)
+	for id, file := range d.files {
+		if id != d.activeID {
+			sealed[id] = file
+		}
+	}
+
+	live := make(map[string]diskLocation, len(d.keydir))
+	expired := make(map[string]diskLocation)
+	floor := d.floor
+	now := time.Now()
+	for key, loc := range d.keydir {
+		if loc.expired(now) {
+			expired[key] = loc
+			floor = max(floor, loc.version)
+		} else {
+			live[key] = loc
+		}
+	}
+	d.mu.Unlock()
+
+	// The tombstones and expired records of the sealed files go away with them, the floor stays.
+	if err := writeFloor(d.dir, floor); err != nil {
+		return fmt.Errorf("failed to merge data files: %w", err)
+	}
+
+	locations, err := writeMergeFile(d.dir, mergeID, func(write func(diskRecord) error) error {
+		for _, loc := range live {
+			record, err := readDiskRecord(sealed[loc.fileID], loc)
+			if err != nil {
+				return err
+			}
+			// Merged records stand on their own, whatever transaction wrote them.
+			record.pending = false
+			if err := write(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to merge data files: %w", err)
+	}
+
+	merged, err := os.Open(dataPath(d.dir, mergeID))
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	for key, loc := range locations {
+		if current, exists := d.keydir[key]; exists && current == live[key] {
+			d.keydir[key] = loc
+		}
+	}
+	for key, loc := range expired {
+		if current, exists := d.keydir[key]; exists && current == loc {
+			delete(d.keydir, key)
+		}
+	}
+	d.floor = max(d.floor, floor)
+	d.files[mergeID] = merged
+	for id := range sealed {
+		delete(d.files, id)
+	}
+	d.mu.Unlock()
+
+	// Files are removed oldest first: should the process stop half way, a value is
+	// never left behind without the tombstones written after it.
+	ids, err := listDataFileIDs(d.dir)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if file, ok := sealed[id]; ok {
+			_ = file.Close()
+			_ = os.Remove(dataPath(d.dir, id))
+			_ = os.Remove(hintPath(d.dir, id))
+		}
+	}
+
+	return nil
+}
+
+// Len returns the number of keys in the key directory, including expired keys not merged away yet.
+func (d *Disk) Len() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return len(d.keydir)
+}
+
+// Close stops background merges, syncs the active file and closes every data file.
+func (d *Disk) Close() error {
+	if !d.background.close() {
+		return nil
+	}
+
+	d.merging.Lock()
+	defer d.merging.Unlock()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	err := d.active.Sync()
+	d.closeFiles()
+	return err
+}
+
+// location returns the key directory entry of a live key. Callers must hold d.mu.
+func (d *Disk) location(key string) (diskLocation, bool) {
+	loc, exists := d.keydir[key]
+	if !exists || loc.expired(time.Now()) {
+		return diskLocation{}, false
+	}
+	return loc, true
+}
+
+// get reads the live entry of key from disk. Callers must hold d.mu.
+func (d *Disk) get(key string) (Entry, error) {
+	loc, exists := d.location(key)
+	if !exists {
+		return Entry{}, ErrKeyNotFound
+	}
+
+	record, err := readDiskRecord(d.files[loc.fileID], loc)
+	if err != nil {
+		return Entry{}, fmt.Errorf("failed to read key %q: %w", key, err)
+	}
+
+	entry := Entry{
+		Version:   loc.version,
+		ExpiresAt: loc.expiration(),
+	}
+	if entry.Value, err = decodeValue(record.value); err != nil {
+		return Entry{}, err
+	}
+
+	return entry, nil
+}
+
+// save appends the value with the next version. Callers must hold d.mu for writing.
+func (d *Disk) save(key string, value any, ttl time.Duration) (int64, error) {
+	entry := Entry{
+		Value:     value,
+		Version:   d.next(key),
+		ExpiresAt: expiresAt(ttl),
+	}
+	if err := d.put(key, entry); err != nil {
+		return 0, err
+	}
+
+	return entry.Version, nil
+}
+
+// put appends the entry as the latest record of key. Callers must hold d.mu for writing.
+func (d *Disk) put(key string, entry Entry) error {
+	data, err := encodeValue(entry.Value)
+	if err != nil {
+		return err
+	}
+
+	record := diskRecord{
+		version: entry.Version,
+		key:     key,
+		value:   data,
+	}
+	if !entry.ExpiresAt.IsZero() {
+		record.expiresAt = entry.ExpiresAt.UnixNano()
+	}
+
+	locations, err := d.append(record)
+	if err != nil {
+		return err
+	}
+
+	d.keydir[key] = locations[0]
+	return nil
+}
+
+// remove appends a tombstone for key, retiring its version. Callers must hold d.mu for writing.
+func (d *Disk) remove(key string) error {
+	version := d.keydir[key].version
+	if _, err := d.append(diskRecord{tombstone: true, version: version, key: key}); err != nil {
+		return err
+	}
+
+	delete(d.keydir, key)
+	d.floor = max(d.floor, version)
+	return nil
+}
+
+// next returns the version the next write to key gets: one above its current version, or
+// above every retired version when the key is missing. Callers must hold d.mu.
+func (d *Disk) next(key string) int64 {
+	loc, exists := d.keydir[key]
+	switch {
+	case !exists:
+		return d.floor + 1
+	case loc.expired(time.Now()):
+		// The key expired but was not merged away yet, so its version is not part of the floor.
+		return max(loc.version, d.floor) + 1
+	default:
+		return loc.version + 1
+	}
+}
+
+// append writes records to the active file in a single write, sealing it and starting
+// a new one once it is full. Callers must hold d.mu for writing.
+func (d *Disk) append(records ...diskRecord) ([]diskLocation, error) {
+	var buf []byte
+	locations := make([]diskLocation, len(records))
+	for i, record := range records {
+		encoded := encodeDiskRecord(record)
+		locations[i] = diskLocation{
+			fileID:    d.activeID,
+			offset:    d.activeSize + int64(len(buf)),
+			size:      uint32(len(encoded)),
+			version:   record.version,
+			expiresAt: record.expiresAt,
+		}
+		buf = append(buf, encoded...)
+	}
+
+	if _, err := d.active.Write(buf); err != nil {
+		return nil, fmt.Errorf("failed to append record: %w", err)
+	}
+
+	if d.fsync == FsyncAlways {
+		if err := d.active.Sync(); err != nil {
+			return nil, err
+		}
+	}
+
+	d.activeSize += int64(len(buf))
+
+	if d.activeSize >= d.maxFileSize {
+		if err := d.openActive(d.activeID + 1); err != nil {
+			return nil, err
+		}
+	}
+
+	return locations, nil
+}
+
+// openActive seals the current active file, if any, and starts writing to the data file id.
+// Callers must hold d.mu for writing.
+func (d *Disk) openActive(id uint32) error {
+	path := dataPath(d.dir, id)
+
+	writer, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open data file: %w", err)
+	}
+
+	reader, err := os.Open(path)
+	if err != nil {
+		_ = writer.Close()
+		return fmt.Errorf("failed to open data file: %w", err)
+	}
+
+	if d.active != nil {
+		if err := d.active.Sync(); err != nil {
+			_ = writer.Close()
+			_ = reader.Close()
+			return err
+		}
+		_ = d.active.Close()
+	}
+
+	d.active = writer
+	d.activeID = id
+	d.activeSize = 0
+	d.files[id] = reader
+
+	return nil
+}
+
+func (d *Disk) closeFiles() {
+	if d.active != nil {
+		_ = d.active.Close()
+	}
+	for _, file := range d.files {
+		_ = file.Close()
+	}
+}
@@ -0,0 +1,442 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The disk engine is a log-structured hash table in the style of Bitcask.
+// Data lives in append-only files named <id>.data; only the file with the highest id
+// is written to. A record is laid out as:
+//
+//	crc       uint32  CRC32 (Castagnoli) of everything that follows
+//	flags     uint8   diskFlagTombstone for deletes, diskFlagPending on every record
+//	                  of a transaction but the last
+//	version   int64   the version written, or the version retired by a delete
+//	expiresAt int64   unix nanoseconds, 0 when the key never expires
+//	keyLen    uint32
+//	valueLen  uint32
+//	key, value
+//
+// The records of a transaction are only applied once its last record is read, so a
+// crash in the middle of writing one loses the whole transaction rather than part of it.
+//
+// Merging rewrites the live records of all sealed files into a single file and
+// writes a <id>.hint file next to it, holding the key directory entries of that
+// file so startup does not have to read the values back. Merges drop tombstones and
+// expired records, so the version floor they raised is written to the floor file first.
+const (
+	diskFlagTombstone = 1
+	diskFlagPending   = 2
+
+	diskHeaderSize = 29
+	hintHeaderSize = 36
+	dataSuffix     = ".data"
+	hintSuffix     = ".hint"
+	floorFile      = "floor"
+)
+
+var ErrCorruptData = errors.New("corrupt data file")
+
+type (
+	// diskLocation is the key directory entry of a key: where its latest record lives.
+	diskLocation struct {
+		fileID    uint32
+		offset    int64
+		size      uint32
+		version   int64
+		expiresAt int64
+	}
+
+	diskRecord struct {
+		tombstone bool
+		pending   bool
+		version   int64
+		expiresAt int64
+		key       string
+		value     []byte
+	}
+)
+
+func (l diskLocation) expired(now time.Time) bool {
+	return l.expiresAt != 0 && now.UnixNano() >= l.expiresAt
+}
+
+func (l diskLocation) expiration() time.Time {
+	if l.expiresAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, l.expiresAt)
+}
+
+func encodeDiskRecord(record diskRecord) []byte {
+	buf := make([]byte, diskHeaderSize, diskHeaderSize+len(record.key)+len(record.value))
+
+	if record.tombstone {
+		buf[4] |= diskFlagTombstone
+	}
+	if record.pending {
+		buf[4] |= diskFlagPending
+	}
+	binary.LittleEndian.PutUint64(buf[5:], uint64(record.version))
+	binary.LittleEndian.PutUint64(buf[13:], uint64(record.expiresAt))
+	binary.LittleEndian.PutUint32(buf[21:], uint32(len(record.key)))
+	binary.LittleEndian.PutUint32(buf[25:], uint32(len(record.value)))
+	buf = append(buf, record.key...)
+	buf = append(buf, record.value...)
+
+	binary.LittleEndian.PutUint32(buf, crc32.Checksum(buf[4:], crcTable))
+	return buf
+}
+
+// decodeDiskHeader returns the key and value lengths of a record header.
+func decodeDiskHeader(header []byte) (keyLen, valueLen uint32) {
+	return binary.LittleEndian.Uint32(header[21:]), binary.LittleEndian.Uint32(header[25:])
+}
+
+func decodeDiskRecord(buf []byte) (diskRecord, error) {
+	if len(buf) < diskHeaderSize || crc32.Checksum(buf[4:], crcTable) != binary.LittleEndian.Uint32(buf) {
+		return diskRecord{}, ErrCorruptData
+	}
+
+	keyLen, valueLen := decodeDiskHeader(buf)
+	if int64(len(buf)) != diskHeaderSize+int64(keyLen)+int64(valueLen) {
+		return diskRecord{}, ErrCorruptData
+	}
+
+	return diskRecord{
+		tombstone: buf[4]&diskFlagTombstone != 0,
+		pending:   buf[4]&diskFlagPending != 0,
+		version:   int64(binary.LittleEndian.Uint64(buf[5:])),
+		expiresAt: int64(binary.LittleEndian.Uint64(buf[13:])),
+		key:       string(buf[diskHeaderSize : diskHeaderSize+keyLen]),
+		value:     buf[diskHeaderSize+keyLen:],
+	}, nil
+}
+
+// readDiskRecord reads and verifies the record stored at loc.
+func readDiskRecord(file *os.File, loc diskLocation) (diskRecord, error) {
+	buf := make([]byte, loc.size)
+	if _, err := file.ReadAt(buf, loc.offset); err != nil {
+		return diskRecord{}, fmt.Errorf("failed to read record: %w", err)
+	}
+	return decodeDiskRecord(buf)
+}
+
+// scanDataFile calls fn with every intact record of a data file and its location.
+// It returns the offset right after the last intact record and whether the file
+// ends with a torn or corrupt record.
+func scanDataFile(path string, fileID uint32, fn func(diskRecord, diskLocation)) (int64, bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, false, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	header := make([]byte, diskHeaderSize)
+
+	var offset int64
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return offset, !errors.Is(err, io.EOF), nil
+		}
+
+		keyLen, valueLen := decodeDiskHeader(header)
+		size := int64(diskHeaderSize) + int64(keyLen) + int64(valueLen)
+		if size > walMaxRecord {
+			return offset, true, nil
+		}
+
+		buf := make([]byte, size)
+		copy(buf, header)
+		if _, err := io.ReadFull(reader, buf[diskHeaderSize:]); err != nil {
+			return offset, true, nil
+		}
+
+		record, err := decodeDiskRecord(buf)
+		if err != nil {
+			return offset, true, nil
+		}
+
+		fn(record, diskLocation{
+			fileID:    fileID,
+			offset:    offset,
+			size:      uint32(size),
+			version:   record.version,
+			expiresAt: record.expiresAt,
+		})
+		offset += size
+	}
+}
+
+func encodeHint(key string, loc diskLocation) []byte {
+	buf := make([]byte, hintHeaderSize, hintHeaderSize+len(key))
+
+	binary.LittleEndian.PutUint64(buf[4:], uint64(loc.offset))
+	binary.LittleEndian.PutUint32(buf[12:], loc.size)
+	binary.LittleEndian.PutUint64(buf[16:], uint64(loc.version))
+	binary.LittleEndian.PutUint64(buf[24:], uint64(loc.expiresAt))
+	binary.LittleEndian.PutUint32(buf[32:], uint32(len(key)))
+	buf = append(buf, key...)
+
+	binary.LittleEndian.PutUint32(buf, crc32.Checksum(buf[4:], crcTable))
+	return buf
+}
+
+// readHintFile loads the key directory entries of a merged data file.
+// Any damage makes the whole hint file unusable, the data file is scanned instead.
+func readHintFile(path string, fileID uint32) (map[string]diskLocation, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	locations := make(map[string]diskLocation)
+	for len(data) > 0 {
+		if len(data) < hintHeaderSize {
+			return nil, ErrCorruptData
+		}
+
+		size := hintHeaderSize + int(binary.LittleEndian.Uint32(data[32:]))
+		if len(data) < size || crc32.Checksum(data[4:size], crcTable) != binary.LittleEndian.Uint32(data) {
+			return nil, ErrCorruptData
+		}
+
+		locations[string(data[hintHeaderSize:size])] = diskLocation{
+			fileID:    fileID,
+			offset:    int64(binary.LittleEndian.Uint64(data[4:])),
+			size:      binary.LittleEndian.Uint32(data[12:]),
+			version:   int64(binary.LittleEndian.Uint64(data[16:])),
+			expiresAt: int64(binary.LittleEndian.Uint64(data[24:])),
+		}
+		data = data[size:]
+	}
+
+	return locations, nil
+}
+
+// loadDataFile applies the records of a data file to the key directory and the version floor, using its hint
+// file when there is one. A torn tail is only tolerated, and truncated, on the last file: the one that was
+// active when the process stopped.
+func loadDataFile(dir string, fileID uint32, last bool, keydir map[string]diskLocation, floor *int64) error {
+	if locations, err := readHintFile(hintPath(dir, fileID), fileID); err == nil {
+		for key, loc := range locations {
+			keydir[key] = loc
+		}
+		return nil
+	}
+
+	type located struct {
+		record diskRecord
+		loc    diskLocation
+	}
+	var pending []located
+
+	apply := func(record diskRecord, loc diskLocation) {
+		if record.tombstone {
+			delete(keydir, record.key)
+			*floor = max(*floor, record.version)
+			return
+		}
+		keydir[record.key] = loc
+	}
+
+	path := dataPath(dir, fileID)
+	offset, torn, err := scanDataFile(path, fileID, func(record diskRecord, loc diskLocation) {
+		if record.pending {
+			pending = append(pending, located{record, loc})
+			return
+		}
+		for _, p := range pending {
+			apply(p.record, p.loc)
+		}
+		pending = pending[:0]
+		apply(record, loc)
+	})
+	if err != nil {
+		return err
+	}
+
+	// A transaction cut short by a crash is dropped along with the torn record that may follow it.
+	if len(pending) > 0 {
+		offset = pending[0].loc.offset
+		torn = true
+	}
+
+	if torn {
+		if !last {
+			return fmt.Errorf("data file %d: %w", fileID, ErrCorruptData)
+		}
+		if err := os.Truncate(path, offset); err != nil {
+			return fmt.Errorf("failed to truncate torn record: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// writeMergeFile creates a data file and its hint file from the records passed to write by
+// produce, returning the new location of every written key. Files are written under temporary
+// names and renamed into place once synced, so a crash never leaves a partial merge behind.
+func writeMergeFile(dir string, fileID uint32, produce func(write func(diskRecord) error) error) (map[string]diskLocation, error) {
+	data, err := createTemp(dataPath(dir, fileID))
+	if err != nil {
+		return nil, err
+	}
+	defer data.discard()
+
+	hints, err := createTemp(hintPath(dir, fileID))
+	if err != nil {
+		return nil, err
+	}
+	defer hints.discard()
+
+	locations := make(map[string]diskLocation)
+	var offset int64
+
+	err = produce(func(record diskRecord) error {
+		encoded := encodeDiskRecord(record)
+		loc := diskLocation{
+			fileID:    fileID,
+			offset:    offset,
+			size:      uint32(len(encoded)),
+			version:   record.version,
+			expiresAt: record.expiresAt,
+		}
+
+		if _, err := data.writer.Write(encoded); err != nil {
+			return err
+		}
+		if _, err := hints.writer.Write(encodeHint(record.key, loc)); err != nil {
+			return err
+		}
+
+		locations[record.key] = loc
+		offset += int64(len(encoded))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := data.commit(); err != nil {
+		return nil, err
+	}
+	if err := hints.commit(); err != nil {
+		return nil, err
+	}
+
+	return locations, nil
+}
+
+// readFloor returns the version floor written by the last merge, zero when there was none.
+func readFloor(dir string) (int64, error) {
+	data, err := os.ReadFile(filepath.Join(dir, floorFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	floor, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("floor file: %w", ErrCorruptData)
+	}
+	return floor, nil
+}
+
+// writeFloor atomically replaces the floor file.
+func writeFloor(dir string, floor int64) error {
+	file, err := createTemp(filepath.Join(dir, floorFile))
+	if err != nil {
+		return err
+	}
+	defer file.discard()
+
+	if _, err := file.writer.WriteString(strconv.FormatInt(floor, 10) + "\n"); err != nil {
+		return err
+	}
+	return file.commit()
+}
+
+// tempFile is a buffered file written under a temporary name until it is committed.
+type tempFile struct {
+	path      string
+	file      *os.File
+	writer    *bufio.Writer
+	committed bool
+}
+
+func createTemp(path string) (*tempFile, error) {
+	file, err := os.Create(path + tmpSuffix)
+	if err != nil {
+		return nil, err
+	}
+	return &tempFile{path: path, file: file, writer: bufio.NewWriter(file)}, nil
+}
+
+// commit flushes and syncs the file, then atomically renames it to its final path.
+func (t *tempFile) commit() error {
+	if err := t.writer.Flush(); err != nil {
+		return err
+	}
+	if err := t.file.Sync(); err != nil {
+		return err
+	}
+	if err := t.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(t.file.Name(), t.path); err != nil {
+		return err
+	}
+
+	t.committed = true
+	return nil
+}
+
+// discard removes the temporary file unless it was committed.
+func (t *tempFile) discard() {
+	if t.committed {
+		return
+	}
+	_ = t.file.Close()
+	_ = os.Remove(t.file.Name())
+}
+
+// listDataFileIDs returns the ids of the data files in dir in ascending order.
+func listDataFileIDs(dir string) ([]uint32, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data directory: %w", err)
+	}
+
+	var ids []uint32
+	for _, file := range files {
+		if id, ok := parseSequence(file.Name(), "", dataSuffix); ok && id <= uint64(^uint32(0)) {
+			ids = append(ids, uint32(id))
+		}
+	}
+
+	slices.Sort(ids)
+	return ids, nil
+}
+
+func dataPath(dir string, fileID uint32) string {
+	return filepath.Join(dir, fmt.Sprintf("%010d%s", fileID, dataSuffix))
+}
+
+func hintPath(dir string, fileID uint32) string {
+	return filepath.Join(dir, fmt.Sprintf("%010d%s", fileID, hintSuffix))
+}
@@ -0,0 +1,331 @@
+package storage_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/felipeascari/kv-store/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDisk(t *testing.T) {
+	ctx := context.Background()
+
+	open := func(t *testing.T, dir string, maxFileSize int64) *storage.Disk {
+		t.Helper()
+		store, err := storage.OpenDisk(storage.DiskOptions{
+			Dir:         dir,
+			MaxFileSize: maxFileSize,
+			Fsync:       storage.FsyncNever,
+		})
+		require.NoError(t, err)
+		return store
+	}
+
+	t.Run("should save, retrieve and delete values", func(t *testing.T) {
+		store := open(t, t.TempDir(), 0)
+		defer func() { _ = store.Close() }()
+
+		version, err := store.Save(ctx, "user", map[string]any{"name": "Alice"}, 0)
+		require.NoError(t, err)
+		require.Equal(t, int64(1), version)
+
+		entry, err := store.Retrieve(ctx, "user")
+		require.NoError(t, err)
+		require.Equal(t, map[string]any{"name": "Alice"}, entry.Value)
+		require.Equal(t, int64(1), entry.Version)
+
+		_, err = store.CompareAndSwap(ctx, "user", 2, "stale", 0)
+		require.ErrorIs(t, err, storage.ErrVersionMismatch)
+
+		require.NoError(t, store.Delete(ctx, "user"))
+		_, err = store.Retrieve(ctx, "user")
+		require.ErrorIs(t, err, storage.ErrKeyNotFound)
+		require.ErrorIs(t, store.Delete(ctx, "user"), storage.ErrKeyNotFound)
+	})
+
+	t.Run("should expire keys with ttl", func(t *testing.T) {
+		store := open(t, t.TempDir(), 0)
+		defer func() { _ = store.Close() }()
+
+		_, err := store.Save(ctx, "session", "abc", 10*time.Millisecond)
+		require.NoError(t, err)
+		time.Sleep(20 * time.Millisecond)
+
+		_, err = store.Retrieve(ctx, "session")
+		require.ErrorIs(t, err, storage.ErrKeyNotFound)
+	})
+
+	t.Run("should recover keys after restart", func(t *testing.T) {
+		dir := t.TempDir()
+
+		store := open(t, dir, 256)
+		for i := range 20 {
+			_, err := store.Save(ctx, fmt.Sprintf("key:%02d", i), i, 0)
+			require.NoError(t, err)
+		}
+		_, err := store.Save(ctx, "key:00", "updated", 0)
+		require.NoError(t, err)
+		require.NoError(t, store.Delete(ctx, "key:01"))
+		require.NoError(t, store.Close())
+
+		store = open(t, dir, 256)
+		defer func() { _ = store.Close() }()
+
+		entry, err := store.Retrieve(ctx, "key:00")
+		require.NoError(t, err)
+		require.Equal(t, "updated", entry.Value)
+		require.Equal(t, int64(2), entry.Version)
+
+		_, err = store.Retrieve(ctx, "key:01")
+		require.ErrorIs(t, err, storage.ErrKeyNotFound)
+
+		page, err := store.Scan(ctx, "key:", "", 100)
+		require.NoError(t, err)
+		require.Len(t, page.Keys, 19)
+	})
+
+	t.Run("should store blobs byte for byte", func(t *testing.T) {
+		dir := t.TempDir()
+		blob := storage.Blob{ContentType: "image/png", Data: []byte{0x89, 'P', 'N', 'G', 0x00, 0xff}}
+
+		store := open(t, dir, 0)
+		_, err := store.Save(ctx, "image", blob, 0)
+		require.NoError(t, err)
+		_, err = store.Increment(ctx, "image", 1)
+		require.ErrorIs(t, err, storage.ErrNotNumeric)
+		require.NoError(t, store.Close())
+
+		store = open(t, dir, 0)
+		defer func() { _ = store.Close() }()
+
+		entry, err := store.Retrieve(ctx, "image")
+		require.NoError(t, err)
+		require.Equal(t, blob, entry.Value)
+	})
+
+	t.Run("should tolerate a torn final record", func(t *testing.T) {
+		dir := t.TempDir()
+
+		store := open(t, dir, 0)
+		_, err := store.Save(ctx, "a", "1", 0)
+		require.NoError(t, err)
+		require.NoError(t, store.Close())
+
+		files, err := filepath.Glob(filepath.Join(dir, "*.data"))
+		require.NoError(t, err)
+		require.Len(t, files, 1)
+
+		file, err := os.OpenFile(files[0], os.O_WRONLY|os.O_APPEND, 0o644)
+		require.NoError(t, err)
+		_, err = file.Write([]byte{0xde, 0xad, 0xbe, 0xef, 0x00})
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+
+		store = open(t, dir, 0)
+		defer func() { _ = store.Close() }()
+
+		entry, err := store.Retrieve(ctx, "a")
+		require.NoError(t, err)
+		require.Equal(t, "1", entry.Value)
+	})
+
+	t.Run("should merge sealed files into a hinted file", func(t *testing.T) {
+		dir := t.TempDir()
+
+		store := open(t, dir, 256)
+		for i := range 50 {
+			_, err := store.Save(ctx, fmt.Sprintf("key:%d", i%5), i, 0)
+			require.NoError(t, err)
+		}
+		require.NoError(t, store.Delete(ctx, "key:4"))
+
+		before, err := filepath.Glob(filepath.Join(dir, "*.data"))
+		require.NoError(t, err)
+		require.Greater(t, len(before), 2)
+
+		require.NoError(t, store.Merge())
+
+		after, err := filepath.Glob(filepath.Join(dir, "*.data"))
+		require.NoError(t, err)
+		require.Len(t, after, 2)
+
+		hints, err := filepath.Glob(filepath.Join(dir, "*.hint"))
+		require.NoError(t, err)
+		require.Len(t, hints, 1)
+
+		_, err = store.Save(ctx, "key:0", "after merge", 0)
+		require.NoError(t, err)
+		require.NoError(t, store.Close())
+
+		store = open(t, dir, 256)
+		defer func() { _ = store.Close() }()
+
+		require.Equal(t, 4, store.Len())
+
+		entry, err := store.Retrieve(ctx, "key:0")
+		require.NoError(t, err)
+		require.Equal(t, "after merge", entry.Value)
+
+		entry, err = store.Retrieve(ctx, "key:3")
+		require.NoError(t, err)
+		require.Equal(t, float64(48), entry.Value)
+		require.Equal(t, int64(10), entry.Version)
+
+		_, err = store.Retrieve(ctx, "key:4")
+		require.ErrorIs(t, err, storage.ErrKeyNotFound)
+	})
+
+	t.Run("should not reuse versions of deleted keys after restart and merge", func(t *testing.T) {
+		dir := t.TempDir()
+
+		store := open(t, dir, 128)
+		for range 3 {
+			_, err := store.Save(ctx, "gone", "value", 0)
+			require.NoError(t, err)
+		}
+		require.NoError(t, store.Delete(ctx, "gone"))
+		require.NoError(t, store.Close())
+
+		store = open(t, dir, 128)
+		version, err := store.Save(ctx, "gone", "again", 0)
+		require.NoError(t, err)
+		require.Equal(t, int64(4), version)
+		require.NoError(t, store.Delete(ctx, "gone"))
+
+		// Merging drops the tombstones, the floor file keeps their versions
+		require.NoError(t, store.Merge())
+		require.NoError(t, store.Close())
+
+		store = open(t, dir, 128)
+		defer func() { _ = store.Close() }()
+
+		version, err = store.Save(ctx, "gone", "once more", 0)
+		require.NoError(t, err)
+		require.Equal(t, int64(5), version)
+	})
+
+	t.Run("should apply transactions all or nothing", func(t *testing.T) {
+		dir := t.TempDir()
+
+		store := open(t, dir, 0)
+		_, err := store.Save(ctx, "a", "1", 0)
+		require.NoError(t, err)
+
+		_, err = store.Transact(ctx, storage.Transaction{
+			Checks: []storage.TxCheck{{Key: "a", Version: 2}},
+			Ops:    []storage.TxOp{{Type: storage.TxDelete, Key: "a"}},
+		})
+		require.ErrorIs(t, err, storage.ErrVersionMismatch)
+
+		results, err := store.Transact(ctx, storage.Transaction{
+			Checks: []storage.TxCheck{{Key: "a", Version: 1}},
+			Ops: []storage.TxOp{
+				{Type: storage.TxSet, Key: "b", Value: "2"},
+				{Type: storage.TxDelete, Key: "a"},
+			},
+		})
+		require.NoError(t, err)
+		require.Equal(t, int64(1), results[0].Version)
+		require.NoError(t, store.Close())
+
+		// Every open starts a new active file, so the next transaction is alone in its file.
+		store = open(t, dir, 0)
+		_, err = store.Transact(ctx, storage.Transaction{
+			Ops: []storage.TxOp{
+				{Type: storage.TxSet, Key: "c", Value: "3"},
+				{Type: storage.TxSet, Key: "d", Value: "4"},
+			},
+		})
+		require.NoError(t, err)
+		require.NoError(t, store.Close())
+
+		files, err := filepath.Glob(filepath.Join(dir, "*.data"))
+		require.NoError(t, err)
+		last := files[len(files)-1]
+
+		// Cut the transaction in its second record, as a crash mid-write would.
+		info, err := os.Stat(last)
+		require.NoError(t, err)
+		require.NoError(t, os.Truncate(last, info.Size()-4))
+
+		store = open(t, dir, 0)
+		defer func() { _ = store.Close() }()
+
+		_, err = store.Retrieve(ctx, "a")
+		require.ErrorIs(t, err, storage.ErrKeyNotFound)
+		_, err = store.Retrieve(ctx, "b")
+		require.NoError(t, err)
+		_, err = store.Retrieve(ctx, "c")
+		require.ErrorIs(t, err, storage.ErrKeyNotFound)
+		_, err = store.Retrieve(ctx, "d")
+		require.ErrorIs(t, err, storage.ErrKeyNotFound)
+	})
+
+	t.Run("should increment counters across restarts", func(t *testing.T) {
+		dir := t.TempDir()
+
+		store := open(t, dir, 0)
+		_, err := store.Increment(ctx, "hits", 2)
+		require.NoError(t, err)
+		_, err = store.Save(ctx, "name", "Alice", 0)
+		require.NoError(t, err)
+		_, err = store.Increment(ctx, "name", 1)
+		require.ErrorIs(t, err, storage.ErrNotNumeric)
+		require.NoError(t, store.Close())
+
+		store = open(t, dir, 0)
+		defer func() { _ = store.Close() }()
+
+		entry, err := store.Increment(ctx, "hits", 0.5)
+		require.NoError(t, err)
+		require.Equal(t, 2.5, entry.Value)
+		require.Equal(t, int64(2), entry.Version)
+	})
+
+	t.Run("should update existing keys", func(t *testing.T) {
+		store := open(t, t.TempDir(), 0)
+		defer func() { _ = store.Close() }()
+
+		_, err := store.Update(ctx, "user", func(storage.Entry) (any, error) { return "Bob", nil })
+		require.ErrorIs(t, err, storage.ErrKeyNotFound)
+
+		_, err = store.Save(ctx, "user", "Alice", time.Hour)
+		require.NoError(t, err)
+
+		entry, err := store.Update(ctx, "user", func(current storage.Entry) (any, error) {
+			return current.Value.(string) + " and Bob", nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, "Alice and Bob", entry.Value)
+		require.Equal(t, int64(2), entry.Version)
+		require.False(t, entry.ExpiresAt.IsZero())
+	})
+
+	t.Run("should reject corrupt sealed files", func(t *testing.T) {
+		dir := t.TempDir()
+
+		store := open(t, dir, 64)
+		for i := range 5 {
+			_, err := store.Save(ctx, fmt.Sprintf("key:%d", i), i, 0)
+			require.NoError(t, err)
+		}
+		require.NoError(t, store.Close())
+
+		files, err := filepath.Glob(filepath.Join(dir, "*.data"))
+		require.NoError(t, err)
+		require.Greater(t, len(files), 1)
+
+		data, err := os.ReadFile(files[0])
+		require.NoError(t, err)
+		data[len(data)-1] ^= 0xff
+		require.NoError(t, os.WriteFile(files[0], data, 0o644))
+
+		_, err = storage.OpenDisk(storage.DiskOptions{Dir: dir, Fsync: storage.FsyncNever})
+		require.ErrorIs(t, err, storage.ErrCorruptData)
+	})
+}
@@ -0,0 +1,345 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Encrypted values are stored as blobs of EncryptedContentType holding the ID of the key
+// they were encrypted with, a random nonce and the AES-256-GCM ciphertext of their encoding.
+// The ciphertext is bound to the stored key, so a value copied under another key does not
+// decrypt. Values stored in plaintext before encryption was enabled are read as they are
+// and encrypted like the values of a retired key.
+const EncryptedContentType = "application/vnd.kv-store.encrypted"
+
+var (
+	// ErrUnknownEncryptionKey is returned for values encrypted with a key missing from the keyring.
+	ErrUnknownEncryptionKey = errors.New("value is encrypted with an unknown key")
+	// ErrDecryption is returned for encrypted values that do not decrypt, because they are
+	// corrupted or were moved to another key.
+	ErrDecryption = errors.New("cannot decrypt value")
+	// ErrReencryptionUnsupported is returned by Reencrypt when the next store cannot rewrite
+	// values in place.
+	ErrReencryptionUnsupported = errors.New("storage does not support re-encryption")
+)
+
+type (
+	// EncryptionOptions configures an Encrypted store.
+	EncryptionOptions struct {
+		// ReencryptOnRead rewrites the values read in plaintext or with a key other than the
+		// primary one, encrypted with the primary key.
+		ReencryptOnRead bool
+	}
+
+	// Encrypted encrypts values before they reach the next store and decrypts them on the way
+	// back, so the backend only ever holds ciphertext. It is meant to sit right above the
+	// backend, under the LockedStore. Values are compressed before they are encrypted when the
+	// next store compresses them. Encrypted values cannot be indexed or incremented by the
+	// backend, so queries are not supported through it and increments read, decrypt and rewrite
+	// the value. Collections would be stored in plaintext, so Encrypted does not implement
+	// Collections and every collection operation through it fails with ErrCollectionsUnsupported.
+	Encrypted struct {
+		next            Store
+		keyring         *Keyring
+		reencryptOnRead bool
+		background      *background
+	}
+
+	// rewriter is implemented by the stores that can replace a value in place, keeping its
+	// version and expiration, as re-encryption does not change what clients read.
+	rewriter interface {
+		// Rewrite replaces the value of key if it is still at version. It fails with
+		// ErrKeyNotFound or ErrVersionMismatch when the key was deleted or written meanwhile.
+		Rewrite(ctx context.Context, key string, version int64, value any) error
+	}
+)
+
+func NewEncrypted(next Store, keyring *Keyring, opts EncryptionOptions) *Encrypted {
+	return &Encrypted{
+		next:            next,
+		keyring:         keyring,
+		reencryptOnRead: opts.ReencryptOnRead,
+		background:      newBackground(),
+	}
+}
+
+func (e *Encrypted) Save(ctx context.Context, key string, value any, ttl time.Duration) (int64, error) {
+	sealed, err := e.seal(key, value)
+	if err != nil {
+		return 0, err
+	}
+	return e.next.Save(ctx, key, sealed, ttl)
+}
+
+func (e *Encrypted) Retrieve(ctx context.Context, key string) (Entry, error) {
+	entry, err := e.next.Retrieve(ctx, key)
+	if err != nil {
+		return Entry{}, err
+	}
+	return e.decrypt(ctx, key, entry)
+}
+
+func (e *Encrypted) Delete(ctx context.Context, key string) error {
+	return e.next.Delete(ctx, key)
+}
+
+func (e *Encrypted) CompareAndSwap(ctx context.Context, key string, expectedVersion int64, value any, ttl time.Duration) (int64, error) {
+	sealed, err := e.seal(key, value)
+	if err != nil {
+		return 0, err
+	}
+	return e.next.CompareAndSwap(ctx, key, expectedVersion, sealed, ttl)
+}
+
+func (e *Encrypted) CompareAndDelete(ctx context.Context, key string, expectedVersion int64) error {
+	return e.next.CompareAndDelete(ctx, key, expectedVersion)
+}
+
+// Increment cannot run in Redis on encrypted values, see incrementWithUpdate.
+func (e *Encrypted) Increment(ctx context.Context, key string, delta float64) (Entry, error) {
+	return incrementWithUpdate(ctx, e, key, delta)
+}
+
+func (e *Encrypted) Update(ctx context.Context, key string, fn UpdateFunc) (Entry, error) {
+	// value is the plaintext of the last value fn computed, which is the one written.
+	var value any
+
+	entry, err := e.next.Update(ctx, key, func(current Entry) (any, error) {
+		plain, _, err := e.open(key, current.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		current.Value = plain
+		if value, err = fn(current); err != nil {
+			return nil, err
+		}
+		return e.seal(key, value)
+	})
+	if err != nil {
+		return Entry{}, err
+	}
+
+	entry.Value = value
+	return entry, nil
+}
+
+func (e *Encrypted) Scan(ctx context.Context, prefix, cursor string, limit int) (ScanResult, error) {
+	return e.next.Scan(ctx, prefix, cursor, limit)
+}
+
+func (e *Encrypted) BatchSave(ctx context.Context, items []BatchItem) ([]BatchResult, error) {
+	sealed := make([]BatchItem, len(items))
+	for i, item := range items {
+		value, err := e.seal(item.Key, item.Value)
+		if err != nil {
+			return nil, err
+		}
+		sealed[i] = BatchItem{Key: item.Key, Value: value, TTL: item.TTL}
+	}
+
+	results, err := e.next.BatchSave(ctx, sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range results {
+		if results[i].Err == nil {
+			results[i].Entry.Value = items[i].Value
+		}
+	}
+	return results, nil
+}
+
+func (e *Encrypted) BatchRetrieve(ctx context.Context, keys []string) ([]BatchResult, error) {
+	results, err := e.next.BatchRetrieve(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, result := range results {
+		if result.Err != nil {
+			continue
+		}
+		if results[i].Entry, err = e.decrypt(ctx, result.Key, result.Entry); err != nil {
+			results[i].Err = err
+		}
+	}
+	return results, nil
+}
+
+func (e *Encrypted) BatchDelete(ctx context.Context, keys []string) ([]BatchResult, error) {
+	return e.next.BatchDelete(ctx, keys)
+}
+
+func (e *Encrypted) Transact(ctx context.Context, tx Transaction) ([]TxResult, error) {
+	ops := make([]TxOp, len(tx.Ops))
+	for i, op := range tx.Ops {
+		if op.Type == TxSet {
+			sealed, err := e.seal(op.Key, op.Value)
+			if err != nil {
+				return nil, err
+			}
+			op.Value = sealed
+		}
+		ops[i] = op
+	}
+
+	return e.next.Transact(ctx, Transaction{Checks: tx.Checks, Ops: ops})
+}
+
+// History decrypts every version kept. Versions keep the key they were written with until
+// they fall out of the history, re-encryption only rewrites the current one.
+func (e *Encrypted) History(ctx context.Context, key string) ([]Revision, error) {
+	revisions, err := historyOf(ctx, e.next, key)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, revision := range revisions {
+		if revisions[i].Value, _, err = e.open(key, revision.Value); err != nil {
+			return nil, err
+		}
+	}
+	return revisions, nil
+}
+
+func (e *Encrypted) CompressionStats() (CompressionStats, bool) {
+	return compressionStatsOf(e.next)
+}
+
+// Reencrypt rewrites every value stored in plaintext or with a key other than the primary
+// one, keeping versions and expirations, and returns how many it rewrote. Values written
+// while it runs are already encrypted with the primary key and are left alone.
+func (e *Encrypted) Reencrypt(ctx context.Context) (int, error) {
+	rw, ok := e.next.(rewriter)
+	if !ok {
+		return 0, ErrReencryptionUnsupported
+	}
+
+	var (
+		rewritten int
+		cursor    string
+	)
+	for {
+		page, err := e.next.Scan(ctx, "", cursor, DefaultScanLimit)
+		if err != nil {
+			return rewritten, err
+		}
+
+		results, err := e.next.BatchRetrieve(ctx, page.Keys)
+		if err != nil {
+			return rewritten, err
+		}
+
+		for _, result := range results {
+			if result.Err != nil {
+				continue
+			}
+
+			plain, stale, err := e.open(result.Key, result.Entry.Value)
+			if err != nil || !stale {
+				continue
+			}
+
+			err = e.rewrite(ctx, rw, result.Key, result.Entry.Version, plain)
+			switch {
+			case err == nil:
+				rewritten++
+			case !errors.Is(err, ErrKeyNotFound) && !errors.Is(err, ErrVersionMismatch):
+				return rewritten, err
+			}
+		}
+
+		if page.Cursor == "" {
+			return rewritten, nil
+		}
+		cursor = page.Cursor
+	}
+}
+
+// StartReencryption runs Reencrypt at the given interval until the store is closed,
+// so the values nobody reads still move to the primary key after a rotation.
+func (e *Encrypted) StartReencryption(interval time.Duration) {
+	e.background.every(interval, func() {
+		_, _ = e.Reencrypt(context.Background())
+	})
+}
+
+// Keyring returns the keys values are encrypted with.
+func (e *Encrypted) Keyring() *Keyring {
+	return e.keyring
+}
+
+// Close stops the re-encryption job. The next store is left open.
+func (e *Encrypted) Close() error {
+	e.background.close()
+	return nil
+}
+
+// seal returns the encrypted form of a value stored under key, compressed first when the next
+// store compresses values, as ciphertext does not compress.
+func (e *Encrypted) seal(key string, value any) (Blob, error) {
+	plaintext, err := encodeValue(value)
+	if err != nil {
+		return Blob{}, err
+	}
+	if c, ok := e.next.(encodedCompressor); ok {
+		plaintext = c.compressEncoded(plaintext)
+	}
+
+	data, err := e.keyring.seal(plaintext, []byte(key))
+	if err != nil {
+		return Blob{}, fmt.Errorf("failed to encrypt value: %w", err)
+	}
+	return Blob{ContentType: EncryptedContentType, Data: data}, nil
+}
+
+// open returns the plaintext of a stored value and whether it should be re-encrypted,
+// because it is not encrypted or not with the primary key.
+func (e *Encrypted) open(key string, value any) (any, bool, error) {
+	blob, ok := value.(Blob)
+	if !ok || blob.ContentType != EncryptedContentType {
+		return value, true, nil
+	}
+
+	plaintext, id, err := e.keyring.open(blob.Data, []byte(key))
+	if err != nil {
+		return nil, false, err
+	}
+
+	plain, err := decodeValue(plaintext)
+	if err != nil {
+		return nil, false, err
+	}
+	return plain, id != e.keyring.primary, nil
+}
+
+// decrypt returns the entry holding the plaintext of a stored entry, re-encrypting the
+// stored value first when enabled and needed. Re-encryption is best effort: the value is
+// returned even if it fails, and is tried again on the next read.
+func (e *Encrypted) decrypt(ctx context.Context, key string, entry Entry) (Entry, error) {
+	plain, stale, err := e.open(key, entry.Value)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	if stale && e.reencryptOnRead {
+		if rw, ok := e.next.(rewriter); ok {
+			_ = e.rewrite(ctx, rw, key, entry.Version, plain)
+		}
+	}
+
+	entry.Value = plain
+	return entry, nil
+}
+
+func (e *Encrypted) rewrite(ctx context.Context, rw rewriter, key string, version int64, plain any) error {
+	sealed, err := e.seal(key, plain)
+	if err != nil {
+		return err
+	}
+	return rw.Rewrite(ctx, key, version, sealed)
+}
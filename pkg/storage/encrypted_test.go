@@ -0,0 +1,266 @@
+package storage_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/felipeascari/kv-store/pkg/lock"
+	"github.com/felipeascari/kv-store/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncrypted(t *testing.T) {
+	ctx := context.Background()
+
+	oldKey := bytes.Repeat([]byte{1}, storage.EncryptionKeySize)
+	newKey := bytes.Repeat([]byte{2}, storage.EncryptionKeySize)
+
+	keyring := func(t *testing.T, primary string, keys map[string][]byte) *storage.Keyring {
+		t.Helper()
+		k, err := storage.NewKeyring(primary, keys)
+		require.NoError(t, err)
+		return k
+	}
+	newStore := func(t *testing.T) *storage.Memory {
+		t.Helper()
+		m, err := storage.NewMemoryWithOptions(storage.MemoryOptions{})
+		require.NoError(t, err)
+		return m
+	}
+
+	t.Run("should only store ciphertext", func(t *testing.T) {
+		m := newStore(t)
+		e := storage.NewEncrypted(m, keyring(t, "old", map[string][]byte{"old": oldKey}), storage.EncryptionOptions{})
+
+		_, err := e.Save(ctx, "user:1", map[string]any{"email": "ada@example.com"}, time.Minute)
+		require.NoError(t, err)
+		_, err = e.Save(ctx, "avatar", storage.Blob{ContentType: "image/png", Data: []byte("PNG")}, 0)
+		require.NoError(t, err)
+
+		stored, err := m.Retrieve(ctx, "user:1")
+		require.NoError(t, err)
+		blob, ok := stored.Value.(storage.Blob)
+		require.True(t, ok)
+		require.Equal(t, storage.EncryptedContentType, blob.ContentType)
+		require.NotContains(t, string(blob.Data), "ada@example.com")
+
+		entry, err := e.Retrieve(ctx, "user:1")
+		require.NoError(t, err)
+		require.Equal(t, map[string]any{"email": "ada@example.com"}, entry.Value)
+		require.Equal(t, int64(1), entry.Version)
+		require.False(t, entry.ExpiresAt.IsZero())
+
+		entry, err = e.Retrieve(ctx, "avatar")
+		require.NoError(t, err)
+		require.Equal(t, storage.Blob{ContentType: "image/png", Data: []byte("PNG")}, entry.Value)
+	})
+
+	t.Run("should use a fresh nonce for every value", func(t *testing.T) {
+		m := newStore(t)
+		e := storage.NewEncrypted(m, keyring(t, "old", map[string][]byte{"old": oldKey}), storage.EncryptionOptions{})
+
+		_, err := e.Save(ctx, "a", "same", 0)
+		require.NoError(t, err)
+		_, err = e.Save(ctx, "b", "same", 0)
+		require.NoError(t, err)
+
+		a, err := m.Retrieve(ctx, "a")
+		require.NoError(t, err)
+		b, err := m.Retrieve(ctx, "b")
+		require.NoError(t, err)
+		require.NotEqual(t, a.Value, b.Value)
+	})
+
+	t.Run("should not decrypt values moved to another key", func(t *testing.T) {
+		m := newStore(t)
+		e := storage.NewEncrypted(m, keyring(t, "old", map[string][]byte{"old": oldKey}), storage.EncryptionOptions{})
+
+		_, err := e.Save(ctx, "a", "secret", 0)
+		require.NoError(t, err)
+		stored, err := m.Retrieve(ctx, "a")
+		require.NoError(t, err)
+		_, err = m.Save(ctx, "b", stored.Value, 0)
+		require.NoError(t, err)
+
+		_, err = e.Retrieve(ctx, "b")
+		require.ErrorIs(t, err, storage.ErrDecryption)
+	})
+
+	t.Run("should fail on values of unknown keys", func(t *testing.T) {
+		m := newStore(t)
+		old := storage.NewEncrypted(m, keyring(t, "old", map[string][]byte{"old": oldKey}), storage.EncryptionOptions{})
+		_, err := old.Save(ctx, "a", "secret", 0)
+		require.NoError(t, err)
+
+		e := storage.NewEncrypted(m, keyring(t, "new", map[string][]byte{"new": newKey}), storage.EncryptionOptions{})
+		_, err = e.Retrieve(ctx, "a")
+		require.ErrorIs(t, err, storage.ErrUnknownEncryptionKey)
+	})
+
+	t.Run("should re-encrypt values of retired keys on read", func(t *testing.T) {
+		m := newStore(t)
+		old := storage.NewEncrypted(m, keyring(t, "old", map[string][]byte{"old": oldKey}), storage.EncryptionOptions{})
+		_, err := old.Save(ctx, "a", "secret", time.Minute)
+		require.NoError(t, err)
+		_, err = m.Save(ctx, "legacy", "plaintext", 0)
+		require.NoError(t, err)
+
+		rotated := keyring(t, "new", map[string][]byte{"old": oldKey, "new": newKey})
+		e := storage.NewEncrypted(m, rotated, storage.EncryptionOptions{ReencryptOnRead: true})
+
+		entry, err := e.Retrieve(ctx, "a")
+		require.NoError(t, err)
+		require.Equal(t, "secret", entry.Value)
+		entry, err = e.Retrieve(ctx, "legacy")
+		require.NoError(t, err)
+		require.Equal(t, "plaintext", entry.Value)
+
+		// Both values now decrypt without the retired key, at the same version.
+		e = storage.NewEncrypted(m, keyring(t, "new", map[string][]byte{"new": newKey}), storage.EncryptionOptions{})
+		entry, err = e.Retrieve(ctx, "a")
+		require.NoError(t, err)
+		require.Equal(t, "secret", entry.Value)
+		require.Equal(t, int64(1), entry.Version)
+		require.False(t, entry.ExpiresAt.IsZero())
+
+		entry, err = e.Retrieve(ctx, "legacy")
+		require.NoError(t, err)
+		require.Equal(t, "plaintext", entry.Value)
+		require.Equal(t, int64(1), entry.Version)
+	})
+
+	t.Run("should re-encrypt every value in the background job", func(t *testing.T) {
+		m := newStore(t)
+		old := storage.NewEncrypted(m, keyring(t, "old", map[string][]byte{"old": oldKey}), storage.EncryptionOptions{})
+		for _, key := range []string{"a", "b", "c"} {
+			_, err := old.Save(ctx, key, key, 0)
+			require.NoError(t, err)
+		}
+		_, err := m.Save(ctx, "legacy", "plaintext", 0)
+		require.NoError(t, err)
+
+		e := storage.NewEncrypted(m, keyring(t, "new", map[string][]byte{"old": oldKey, "new": newKey}), storage.EncryptionOptions{})
+		_, err = e.Save(ctx, "d", "d", 0)
+		require.NoError(t, err)
+
+		rewritten, err := e.Reencrypt(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 4, rewritten)
+
+		rewritten, err = e.Reencrypt(ctx)
+		require.NoError(t, err)
+		require.Zero(t, rewritten)
+
+		e = storage.NewEncrypted(m, keyring(t, "new", map[string][]byte{"new": newKey}), storage.EncryptionOptions{})
+		results, err := e.BatchRetrieve(ctx, []string{"a", "b", "c", "d", "legacy"})
+		require.NoError(t, err)
+		for _, result := range results {
+			require.NoError(t, result.Err)
+			require.Equal(t, int64(1), result.Entry.Version)
+		}
+	})
+
+	t.Run("should increment and update encrypted values", func(t *testing.T) {
+		m := newStore(t)
+		e := storage.NewEncrypted(m, keyring(t, "old", map[string][]byte{"old": oldKey}), storage.EncryptionOptions{})
+
+		entry, err := e.Increment(ctx, "counter", 2)
+		require.NoError(t, err)
+		require.Equal(t, float64(2), entry.Value)
+		require.Equal(t, int64(1), entry.Version)
+
+		entry, err = e.Increment(ctx, "counter", 3)
+		require.NoError(t, err)
+		require.Equal(t, float64(5), entry.Value)
+		require.Equal(t, int64(2), entry.Version)
+
+		_, err = e.Save(ctx, "name", "ada", 0)
+		require.NoError(t, err)
+		_, err = e.Increment(ctx, "name", 1)
+		require.ErrorIs(t, err, storage.ErrNotNumeric)
+
+		entry, err = e.Update(ctx, "name", func(current storage.Entry) (any, error) {
+			return current.Value.(string) + " lovelace", nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, "ada lovelace", entry.Value)
+
+		entry, err = e.Retrieve(ctx, "name")
+		require.NoError(t, err)
+		require.Equal(t, "ada lovelace", entry.Value)
+	})
+
+	t.Run("should refuse collections rather than store them in plaintext", func(t *testing.T) {
+		m := newStore(t)
+		e := storage.NewEncrypted(m, keyring(t, "old", map[string][]byte{"old": oldKey}), storage.EncryptionOptions{})
+		locked := storage.NewLockedStore(e, lock.NewManager(refusingLock{}))
+
+		_, ok := any(e).(storage.Collections)
+		require.False(t, ok)
+		_, err := locked.HashSet(ctx, "profile", map[string]any{"email": "ada@example.com"})
+		require.ErrorIs(t, err, storage.ErrCollectionsUnsupported)
+
+		fields, err := m.HashGetAll(ctx, "profile")
+		require.NoError(t, err)
+		require.Empty(t, fields)
+	})
+
+	t.Run("should encrypt batches and transactions", func(t *testing.T) {
+		m := newStore(t)
+		e := storage.NewEncrypted(m, keyring(t, "old", map[string][]byte{"old": oldKey}), storage.EncryptionOptions{})
+
+		results, err := e.BatchSave(ctx, []storage.BatchItem{{Key: "a", Value: "one"}, {Key: "b", Value: "two"}})
+		require.NoError(t, err)
+		require.Equal(t, "one", results[0].Entry.Value)
+
+		_, err = e.Transact(ctx, storage.Transaction{
+			Checks: []storage.TxCheck{{Key: "a", Version: 1}},
+			Ops:    []storage.TxOp{{Type: storage.TxSet, Key: "c", Value: "three"}},
+		})
+		require.NoError(t, err)
+
+		for key, want := range map[string]string{"a": "one", "b": "two", "c": "three"} {
+			stored, err := m.Retrieve(ctx, key)
+			require.NoError(t, err)
+			require.IsType(t, storage.Blob{}, stored.Value)
+
+			entry, err := e.Retrieve(ctx, key)
+			require.NoError(t, err)
+			require.Equal(t, want, entry.Value)
+		}
+	})
+}
+
+func TestLoadKeyring(t *testing.T) {
+	writeKeyring := func(t *testing.T, content string) string {
+		t.Helper()
+		path := filepath.Join(t.TempDir(), "keyring.json")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		return path
+	}
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, storage.EncryptionKeySize))
+
+	t.Run("should load the keys and the primary key", func(t *testing.T) {
+		keyring, err := storage.LoadKeyring(writeKeyring(t, `{"primary": "2026-10", "keys": {"2026-10": "`+key+`"}}`))
+		require.NoError(t, err)
+		require.Equal(t, "2026-10", keyring.Primary())
+	})
+
+	t.Run("should reject invalid keyrings", func(t *testing.T) {
+		for _, content := range []string{
+			`not json`,
+			`{"keys": {"2026-10": "` + key + `"}}`,
+			`{"primary": "2026-11", "keys": {"2026-10": "` + key + `"}}`,
+			`{"primary": "2026-10", "keys": {"2026-10": "c2hvcnQ="}}`,
+			`{"primary": "2026-10", "keys": {"2026-10": "not base64"}}`,
+		} {
+			_, err := storage.LoadKeyring(writeKeyring(t, content))
+			require.Error(t, err, content)
+		}
+	})
+}
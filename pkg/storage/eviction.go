@@ -0,0 +1,219 @@
+package storage
+
+import (
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"time"
+)
+
+// Eviction in the memory backend is approximate, in the style of Redis: instead of keeping
+// every key in an ordered structure, a handful of keys is sampled from a shard and the best
+// candidate for the policy is evicted. Expired keys found while sampling are always evicted first.
+const (
+	EvictionNone     EvictionPolicy = "noeviction"
+	EvictionLRU      EvictionPolicy = "lru"
+	EvictionLFU      EvictionPolicy = "lfu"
+	EvictionRandom   EvictionPolicy = "random"
+	EvictionVolatile EvictionPolicy = "volatile"
+
+	// evictionSamples is how many candidates are compared for one eviction.
+	evictionSamples = 5
+	// evictionScanLimit bounds how many keys are looked at to find the samples,
+	// which matters for the volatile policy when few keys have a TTL.
+	evictionScanLimit = 64
+
+	// Outcomes of an eviction: nothing could be evicted, a key was evicted, or nothing could
+	// be evicted from the shards that were not locked by other writers.
+	evictNone evictResult = 0
+	evicted   evictResult = 1
+	evictBusy evictResult = 2
+
+	// maxEvictBusyRetries bounds how many times a write waits evictBusyWait for other writers
+	// to release the shards it needs to evict from before giving up with ErrOutOfMemory.
+	maxEvictBusyRetries = 20
+	evictBusyWait       = 50 * time.Microsecond
+
+	// entryOverhead approximates the bookkeeping cost of a key: map slot, item and entry headers.
+	entryOverhead = 96
+	// maxSizeDepth stops walking values nested deeper than this, which also guards against cycles.
+	maxSizeDepth = 32
+)
+
+var ErrOutOfMemory = errors.New("memory limit reached")
+
+type (
+	// EvictionPolicy decides which keys make room when the memory budget is exceeded.
+	EvictionPolicy string
+
+	evictResult int
+
+	// MemoryOptions configures the memory backend. Zero values mean no limit.
+	MemoryOptions struct {
+		// Shards is the number of partitions of the keyspace. Zero uses DefaultMemoryShards.
+		Shards int
+		// MaxKeys caps the number of keys held.
+		MaxKeys int64
+		// MaxBytes caps the approximate size of keys and values held.
+		MaxBytes int64
+		// Eviction picks the keys to evict once a limit is hit. Defaults to EvictionNone,
+		// which rejects writes with ErrOutOfMemory instead.
+		Eviction EvictionPolicy
+		// Indexes declares the secondary indexes maintained on every write.
+		Indexes []Index
+		// History is the number of versions kept per key, the current one included.
+		// Zero disables history. It is not persisted and starts over on restart.
+		History int
+	}
+
+	// MemoryStats describes the usage of the memory backend.
+	MemoryStats struct {
+		Keys      int64
+		Bytes     int64
+		MaxKeys   int64
+		MaxBytes  int64
+		Eviction  EvictionPolicy
+		Evictions uint64
+		// Expirations counts expired keys reclaimed by the reaper or while evicting.
+		Expirations uint64
+	}
+
+	// memoryItem is an entry plus the access statistics used by eviction. With history
+	// enabled it also holds when the entry was written and the versions before it.
+	memoryItem struct {
+		entry      Entry
+		size       int64
+		lastAccess atomic.Int64
+		hits       atomic.Uint32
+		writtenAt  time.Time
+		history    []Revision
+	}
+)
+
+func (p EvictionPolicy) String() string {
+	return string(p)
+}
+
+func (p EvictionPolicy) IsValid() bool {
+	switch p {
+	case EvictionNone, EvictionLRU, EvictionLFU, EvictionRandom, EvictionVolatile:
+		return true
+	default:
+		return false
+	}
+}
+
+func newMemoryItem(key string, entry Entry) *memoryItem {
+	item := &memoryItem{
+		entry: entry,
+		size:  entrySize(key, entry.Value),
+	}
+	item.touch()
+	return item
+}
+
+// touch records an access. It is safe to call under a read lock.
+func (i *memoryItem) touch() {
+	i.lastAccess.Store(time.Now().UnixNano())
+	if hits := i.hits.Load(); hits < 1<<31 {
+		i.hits.Add(1)
+	}
+}
+
+// better reports whether i should be evicted before other under policy.
+func (i *memoryItem) better(other *memoryItem, policy EvictionPolicy) bool {
+	switch policy {
+	case EvictionLRU:
+		return i.lastAccess.Load() < other.lastAccess.Load()
+	case EvictionLFU:
+		ih, oh := i.hits.Load(), other.hits.Load()
+		return ih < oh || ih == oh && i.lastAccess.Load() < other.lastAccess.Load()
+	case EvictionVolatile:
+		return i.entry.ExpiresAt.Before(other.entry.ExpiresAt)
+	default:
+		return false
+	}
+}
+
+// entrySize approximates the memory held by a key and its value.
+func entrySize(key string, value any) int64 {
+	return entryOverhead + int64(len(key)) + valueSize(value)
+}
+
+// valueSize approximates the memory held by a value decoded from JSON, or by any other
+// value by walking it with reflection.
+func valueSize(value any) int64 {
+	switch v := value.(type) {
+	case nil:
+		return 0
+	case string:
+		return 16 + int64(len(v))
+	case []byte:
+		return 24 + int64(len(v))
+	case Blob:
+		return 40 + int64(len(v.ContentType)) + int64(len(v.Data))
+	case bool:
+		return 1
+	case float64, int64, int:
+		return 8
+	case Entry:
+		return 40 + valueSize(v.Value)
+	case map[string]any:
+		size := int64(48)
+		for key, item := range v {
+			size += 16 + int64(len(key)) + 16 + valueSize(item)
+		}
+		return size
+	case []any:
+		size := int64(24)
+		for _, item := range v {
+			size += 16 + valueSize(item)
+		}
+		return size
+	case time.Time:
+		return 24
+	default:
+		return reflectSize(reflect.ValueOf(value), 0)
+	}
+}
+
+func reflectSize(v reflect.Value, depth int) int64 {
+	if depth > maxSizeDepth {
+		return 8
+	}
+	depth++
+
+	switch v.Kind() {
+	case reflect.String:
+		return 16 + int64(v.Len())
+	case reflect.Slice, reflect.Array:
+		size := int64(24)
+		for i := range v.Len() {
+			size += reflectSize(v.Index(i), depth)
+		}
+		return size
+	case reflect.Map:
+		size := int64(48)
+		iter := v.MapRange()
+		for iter.Next() {
+			size += reflectSize(iter.Key(), depth) + reflectSize(iter.Value(), depth)
+		}
+		return size
+	case reflect.Struct:
+		if v.Type() == reflect.TypeFor[time.Time]() {
+			return 24
+		}
+		var size int64
+		for i := range v.NumField() {
+			size += reflectSize(v.Field(i), depth)
+		}
+		return size
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return 8
+		}
+		return 8 + reflectSize(v.Elem(), depth)
+	default:
+		return int64(v.Type().Size())
+	}
+}
@@ -0,0 +1,200 @@
+package storage_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/felipeascari/kv-store/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryEviction(t *testing.T) {
+	ctx := context.Background()
+
+	open := func(t *testing.T, opts storage.MemoryOptions) *storage.Memory {
+		t.Helper()
+		// A single shard of a few keys makes sampling exact, so the victims are predictable.
+		opts.Shards = 1
+		m, err := storage.NewMemoryWithOptions(opts)
+		require.NoError(t, err)
+		return m
+	}
+
+	t.Run("should reject writes when full without eviction", func(t *testing.T) {
+		m := open(t, storage.MemoryOptions{MaxKeys: 2})
+
+		_, err := m.Save(ctx, "a", 1, 0)
+		require.NoError(t, err)
+		_, err = m.Save(ctx, "b", 2, 0)
+		require.NoError(t, err)
+
+		_, err = m.Save(ctx, "c", 3, 0)
+		require.ErrorIs(t, err, storage.ErrOutOfMemory)
+
+		version, err := m.Save(ctx, "a", 10, 0)
+		require.NoError(t, err)
+		require.Equal(t, int64(2), version)
+		require.Equal(t, 2, m.Len())
+	})
+
+	t.Run("should evict the least recently used key", func(t *testing.T) {
+		m := open(t, storage.MemoryOptions{MaxKeys: 3, Eviction: storage.EvictionLRU})
+
+		for _, key := range []string{"a", "b", "c"} {
+			_, err := m.Save(ctx, key, key, 0)
+			require.NoError(t, err)
+			time.Sleep(time.Millisecond)
+		}
+		_, err := m.Retrieve(ctx, "a")
+		require.NoError(t, err)
+
+		_, err = m.Save(ctx, "d", "d", 0)
+		require.NoError(t, err)
+
+		_, err = m.Retrieve(ctx, "b")
+		require.ErrorIs(t, err, storage.ErrKeyNotFound)
+		require.Equal(t, uint64(1), m.Stats().Evictions)
+	})
+
+	t.Run("should evict the least frequently used key", func(t *testing.T) {
+		m := open(t, storage.MemoryOptions{MaxKeys: 3, Eviction: storage.EvictionLFU})
+
+		for _, key := range []string{"a", "b", "c"} {
+			_, err := m.Save(ctx, key, key, 0)
+			require.NoError(t, err)
+		}
+		for range 3 {
+			_, _ = m.Retrieve(ctx, "a")
+			_, _ = m.Retrieve(ctx, "c")
+		}
+
+		_, err := m.Save(ctx, "d", "d", 0)
+		require.NoError(t, err)
+
+		_, err = m.Retrieve(ctx, "b")
+		require.ErrorIs(t, err, storage.ErrKeyNotFound)
+	})
+
+	t.Run("should only evict keys with ttl when volatile", func(t *testing.T) {
+		m := open(t, storage.MemoryOptions{MaxKeys: 2, Eviction: storage.EvictionVolatile})
+
+		_, err := m.Save(ctx, "persistent", 1, 0)
+		require.NoError(t, err)
+		_, err = m.Save(ctx, "session", 2, time.Hour)
+		require.NoError(t, err)
+
+		_, err = m.Save(ctx, "other", 3, 0)
+		require.NoError(t, err)
+
+		_, err = m.Retrieve(ctx, "session")
+		require.ErrorIs(t, err, storage.ErrKeyNotFound)
+
+		_, err = m.Save(ctx, "another", 4, 0)
+		require.ErrorIs(t, err, storage.ErrOutOfMemory)
+	})
+
+	t.Run("should never evict reserved keys", func(t *testing.T) {
+		m := open(t, storage.MemoryOptions{MaxKeys: 3, Eviction: storage.EvictionLRU})
+
+		_, err := storage.NewNamespaces(m).Create(ctx, "billing")
+		require.NoError(t, err)
+		for i := range 10 {
+			_, err := m.Save(ctx, fmt.Sprintf("key:%d", i), i, 0)
+			require.NoError(t, err)
+		}
+
+		_, err = storage.NewNamespaces(m).Get(ctx, "billing")
+		require.NoError(t, err)
+		require.Equal(t, 3, m.Len())
+	})
+
+	t.Run("should stay within max keys with random eviction", func(t *testing.T) {
+		m, err := storage.NewMemoryWithOptions(storage.MemoryOptions{MaxKeys: 100, Eviction: storage.EvictionRandom})
+		require.NoError(t, err)
+
+		for i := range 1000 {
+			_, err := m.Save(ctx, fmt.Sprintf("key:%d", i), i, 0)
+			require.NoError(t, err)
+		}
+
+		stats := m.Stats()
+		require.Equal(t, int64(100), stats.Keys)
+		require.Equal(t, uint64(900), stats.Evictions)
+	})
+
+	t.Run("should stay within max bytes", func(t *testing.T) {
+		m := open(t, storage.MemoryOptions{MaxBytes: 4096, Eviction: storage.EvictionLRU})
+
+		value := strings.Repeat("x", 512)
+		for i := range 50 {
+			_, err := m.Save(ctx, fmt.Sprintf("key:%d", i), value, 0)
+			require.NoError(t, err)
+		}
+
+		stats := m.Stats()
+		require.LessOrEqual(t, stats.Bytes, int64(4096))
+		require.Positive(t, stats.Evictions)
+
+		_, err := m.Save(ctx, "huge", strings.Repeat("x", 8192), 0)
+		require.ErrorIs(t, err, storage.ErrOutOfMemory)
+	})
+
+	t.Run("should not evict anything for writes that can never fit", func(t *testing.T) {
+		m := open(t, storage.MemoryOptions{MaxKeys: 3, MaxBytes: 4096, Eviction: storage.EvictionLRU})
+
+		for _, key := range []string{"a", "b", "c"} {
+			_, err := m.Save(ctx, key, key, 0)
+			require.NoError(t, err)
+		}
+
+		_, err := m.Save(ctx, "huge", strings.Repeat("x", 8192), 0)
+		require.ErrorIs(t, err, storage.ErrOutOfMemory)
+
+		_, err = m.Transact(ctx, storage.Transaction{Ops: []storage.TxOp{
+			{Type: storage.TxSet, Key: "w", Value: 1},
+			{Type: storage.TxSet, Key: "x", Value: 2},
+			{Type: storage.TxSet, Key: "y", Value: 3},
+			{Type: storage.TxSet, Key: "z", Value: 4},
+		}})
+		require.ErrorIs(t, err, storage.ErrOutOfMemory)
+
+		require.Equal(t, 3, m.Len())
+		require.Zero(t, m.Stats().Evictions)
+	})
+
+	t.Run("should reclaim expired keys before evicting live ones", func(t *testing.T) {
+		m := open(t, storage.MemoryOptions{MaxKeys: 2})
+
+		_, err := m.Save(ctx, "a", 1, 0)
+		require.NoError(t, err)
+		_, err = m.Save(ctx, "b", 2, 10*time.Millisecond)
+		require.NoError(t, err)
+		time.Sleep(20 * time.Millisecond)
+
+		_, err = m.Save(ctx, "c", 3, 0)
+		require.NoError(t, err)
+
+		stats := m.Stats()
+		require.Equal(t, uint64(0), stats.Evictions)
+		require.Equal(t, uint64(1), stats.Expirations)
+	})
+
+	t.Run("should account for deleted keys", func(t *testing.T) {
+		m := open(t, storage.MemoryOptions{})
+
+		_, err := m.Save(ctx, "a", "value", 0)
+		require.NoError(t, err)
+		require.Positive(t, m.Stats().Bytes)
+
+		require.NoError(t, m.Delete(ctx, "a"))
+		require.Equal(t, storage.MemoryStats{Eviction: storage.EvictionNone}, m.Stats())
+	})
+
+	t.Run("should reject unknown policies", func(t *testing.T) {
+		_, err := storage.NewMemoryWithOptions(storage.MemoryOptions{Eviction: "fifo"})
+		require.Error(t, err)
+	})
+}
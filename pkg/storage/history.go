@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Stores with history enabled keep the last versions of every key, the current one
+// included, along with when they were written. History lives and dies with its key:
+// it expires with it and is dropped when the key is deleted.
+const historyKeyPrefix = reservedKeyPrefix + "history:"
+
+var (
+	// ErrHistoryUnsupported is returned by stores that do not keep version history.
+	ErrHistoryUnsupported = errors.New("storage does not keep version history")
+	// ErrVersionNotFound is returned for versions of a key that are not in its history.
+	ErrVersionNotFound = errors.New("version not found")
+)
+
+type (
+	// Revision is a version of a key kept in its history. WrittenAt is zero for versions
+	// written before history was kept.
+	Revision struct {
+		Version   int64
+		Value     any
+		WrittenAt time.Time
+	}
+
+	// Historian is implemented by the stores that keep version history.
+	Historian interface {
+		// History returns the versions of key kept in its history, newest first, starting
+		// with the current one. It fails with ErrKeyNotFound when the key is missing.
+		History(ctx context.Context, key string) ([]Revision, error)
+	}
+)
+
+// RetrieveVersion returns the given version of key from its history.
+func RetrieveVersion(ctx context.Context, s Store, key string, version int64) (Revision, error) {
+	revisions, err := historyOf(ctx, s, key)
+	if err != nil {
+		return Revision{}, err
+	}
+
+	for _, revision := range revisions {
+		if revision.Version == version {
+			return revision, nil
+		}
+	}
+	return Revision{}, ErrVersionNotFound
+}
+
+// RetrieveAt returns the version key had at the given time, which must be covered by its history.
+func RetrieveAt(ctx context.Context, s Store, key string, at time.Time) (Revision, error) {
+	revisions, err := historyOf(ctx, s, key)
+	if err != nil {
+		return Revision{}, err
+	}
+
+	for _, revision := range revisions {
+		if !revision.WrittenAt.IsZero() && !revision.WrittenAt.After(at) {
+			return revision, nil
+		}
+	}
+	return Revision{}, ErrVersionNotFound
+}
+
+func historyOf(ctx context.Context, s Store, key string) ([]Revision, error) {
+	historian, ok := s.(Historian)
+	if !ok {
+		return nil, ErrHistoryUnsupported
+	}
+	return historian.History(ctx, key)
+}
+
+// keepHistory returns the history of an item replacing previous, oldest first and without
+// the current version, or nil when previous is missing or expired. An item rewritten at
+// the same version keeps the history of the one it replaces.
+func keepHistory(previous *memoryItem, version int64, limit int, now time.Time) []Revision {
+	if previous == nil || isExpired(previous.entry.ExpiresAt, now) {
+		return nil
+	}
+	if previous.entry.Version == version {
+		return previous.history
+	}
+
+	history := make([]Revision, 0, len(previous.history)+1)
+	history = append(history, previous.history...)
+	history = append(history, Revision{
+		Version:   previous.entry.Version,
+		Value:     previous.entry.Value,
+		WrittenAt: previous.writtenAt,
+	})
+	return history[max(len(history)-(limit-1), 0):]
+}
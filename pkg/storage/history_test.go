@@ -0,0 +1,163 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/felipeascari/kv-store/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryHistory(t *testing.T) {
+	ctx := context.Background()
+
+	newStore := func(t *testing.T, history int) *storage.Memory {
+		t.Helper()
+		m, err := storage.NewMemoryWithOptions(storage.MemoryOptions{History: history})
+		require.NoError(t, err)
+		return m
+	}
+
+	testHistory(t, newStore(t, 3))
+
+	t.Run("should not keep history unless enabled", func(t *testing.T) {
+		m := newStore(t, 0)
+		_, err := m.Save(ctx, "a", "one", 0)
+		require.NoError(t, err)
+
+		_, err = m.History(ctx, "a")
+		require.ErrorIs(t, err, storage.ErrHistoryUnsupported)
+	})
+
+	t.Run("should drop the history of expired keys", func(t *testing.T) {
+		m := newStore(t, 3)
+		_, err := m.Save(ctx, "a", "one", 20*time.Millisecond)
+		require.NoError(t, err)
+		_, err = m.Save(ctx, "a", "two", 20*time.Millisecond)
+		require.NoError(t, err)
+
+		time.Sleep(30 * time.Millisecond)
+		_, err = m.History(ctx, "a")
+		require.ErrorIs(t, err, storage.ErrKeyNotFound)
+
+		_, err = m.Save(ctx, "a", "three", 0)
+		require.NoError(t, err)
+		revisions, err := m.History(ctx, "a")
+		require.NoError(t, err)
+		require.Len(t, revisions, 1)
+	})
+
+	t.Run("should keep the history of rewritten versions", func(t *testing.T) {
+		m := newStore(t, 3)
+		_, err := m.Save(ctx, "a", "one", 0)
+		require.NoError(t, err)
+		_, err = m.Save(ctx, "a", "two", 0)
+		require.NoError(t, err)
+
+		require.NoError(t, m.Rewrite(ctx, "a", 2, "TWO"))
+
+		revisions, err := m.History(ctx, "a")
+		require.NoError(t, err)
+		require.Len(t, revisions, 2)
+		require.Equal(t, "TWO", revisions[0].Value)
+		require.Equal(t, "one", revisions[1].Value)
+	})
+
+	t.Run("should keep history per namespace", func(t *testing.T) {
+		m := newStore(t, 3)
+		n := storage.NewNamespaced(m)
+		billing := storage.WithNamespace(ctx, "billing")
+
+		_, err := n.Save(billing, "config", "one", 0)
+		require.NoError(t, err)
+		_, err = n.Save(billing, "config", "two", 0)
+		require.NoError(t, err)
+		_, err = n.Save(ctx, "config", "other", 0)
+		require.NoError(t, err)
+
+		revision, err := storage.RetrieveVersion(billing, n, "config", 1)
+		require.NoError(t, err)
+		require.Equal(t, "one", revision.Value)
+
+		_, err = storage.RetrieveVersion(ctx, n, "config", 2)
+		require.ErrorIs(t, err, storage.ErrVersionNotFound)
+	})
+}
+
+// testHistory checks the history of a store keeping the last 3 versions of every key.
+func testHistory(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+
+	historian, ok := s.(storage.Historian)
+	require.True(t, ok)
+
+	t.Run("should keep the last versions of a key", func(t *testing.T) {
+		before := time.Now().Add(-time.Second)
+		var last int64
+		for _, value := range []string{"one", "two", "three", "four"} {
+			var err error
+			last, err = s.Save(ctx, "history:config", value, 0)
+			require.NoError(t, err)
+		}
+
+		revisions, err := historian.History(ctx, "history:config")
+		require.NoError(t, err)
+		require.Len(t, revisions, 3)
+		for i, want := range []string{"four", "three", "two"} {
+			require.Equal(t, last-int64(i), revisions[i].Version)
+			require.Equal(t, want, revisions[i].Value)
+			require.True(t, revisions[i].WrittenAt.After(before))
+		}
+
+		revision, err := storage.RetrieveVersion(ctx, s, "history:config", last-2)
+		require.NoError(t, err)
+		require.Equal(t, "two", revision.Value)
+
+		_, err = storage.RetrieveVersion(ctx, s, "history:config", last-3)
+		require.ErrorIs(t, err, storage.ErrVersionNotFound)
+
+		revision, err = storage.RetrieveAt(ctx, s, "history:config", time.Now().Add(time.Second))
+		require.NoError(t, err)
+		require.Equal(t, last, revision.Version)
+
+		_, err = storage.RetrieveAt(ctx, s, "history:config", before)
+		require.ErrorIs(t, err, storage.ErrVersionNotFound)
+	})
+
+	t.Run("should record every kind of write", func(t *testing.T) {
+		_, err := s.Increment(ctx, "history:counter", 1)
+		require.NoError(t, err)
+		_, err = s.Update(ctx, "history:counter", func(current storage.Entry) (any, error) {
+			return current.Value.(float64) * 10, nil
+		})
+		require.NoError(t, err)
+		_, err = s.Transact(ctx, storage.Transaction{
+			Ops: []storage.TxOp{{Type: storage.TxSet, Key: "history:counter", Value: 42.0}},
+		})
+		require.NoError(t, err)
+
+		revisions, err := historian.History(ctx, "history:counter")
+		require.NoError(t, err)
+		require.Len(t, revisions, 3)
+		require.Equal(t, []any{42.0, 10.0, 1.0}, []any{revisions[0].Value, revisions[1].Value, revisions[2].Value})
+	})
+
+	t.Run("should drop the history of deleted keys", func(t *testing.T) {
+		_, err := s.Save(ctx, "history:deleted", "one", 0)
+		require.NoError(t, err)
+		_, err = s.Save(ctx, "history:deleted", "two", 0)
+		require.NoError(t, err)
+		require.NoError(t, s.Delete(ctx, "history:deleted"))
+
+		_, err = historian.History(ctx, "history:deleted")
+		require.ErrorIs(t, err, storage.ErrKeyNotFound)
+
+		_, err = s.Save(ctx, "history:deleted", "three", 0)
+		require.NoError(t, err)
+		revisions, err := historian.History(ctx, "history:deleted")
+		require.NoError(t, err)
+		require.Len(t, revisions, 1)
+		require.Equal(t, "three", revisions[0].Value)
+	})
+}
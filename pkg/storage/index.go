@@ -0,0 +1,267 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Secondary indexes map a field of the JSON objects stored under a key prefix to the keys
+// holding each value of that field. Prefixes are relative to namespaces, and each namespace
+// has its own posting lists. Every value is indexed by its text: strings as they
+// are and any other scalar by its JSON encoding, so where=age=30 matches {"age": 30}.
+// Arrays index each of their scalar elements, objects are not indexed.
+const (
+	// indexKeyPrefix namespaces the posting lists, which Redis stores next to the keys.
+	indexKeyPrefix = "kv-store:index:"
+	indexSeparator = "\x00"
+)
+
+var (
+	// ErrNoIndex is returned for queries none of whose conditions is covered by an index.
+	ErrNoIndex = errors.New("no index covers the query")
+	// ErrQueryUnsupported is returned by stores that cannot answer queries.
+	ErrQueryUnsupported = errors.New("storage does not support queries")
+)
+
+type (
+	// Index declares a secondary index on the field at Path, a dotted path such as
+	// "address.city", of the values of every key starting with Prefix.
+	Index struct {
+		Prefix string
+		Path   string
+	}
+
+	// Query selects the keys starting with Prefix whose values match every condition,
+	// in key order. At least one condition must be covered by an index whose prefix
+	// is a prefix of the query prefix.
+	Query struct {
+		Prefix string
+		Where  []Condition
+		Cursor string
+		Limit  int
+	}
+
+	// Condition matches values whose field at Path holds Value, compared as text.
+	Condition struct {
+		Path  string
+		Value string
+	}
+
+	QueryItem struct {
+		Key   string
+		Entry Entry
+	}
+
+	// QueryResult is a page of matches and the opaque cursor to fetch the next one.
+	QueryResult struct {
+		Items  []QueryItem
+		Cursor string
+	}
+
+	// Querier is implemented by the stores that maintain secondary indexes.
+	Querier interface {
+		Query(ctx context.Context, q Query) (QueryResult, error)
+	}
+
+	// memoryIndex holds the posting lists of a memory store. It has its own lock, always
+	// taken after the shard locks, so writes to different shards only contend briefly.
+	memoryIndex struct {
+		indexes  []Index
+		mu       sync.RWMutex
+		postings map[string]map[string]struct{}
+	}
+)
+
+// ParseIndexes parses a comma separated list of prefix=path declarations,
+// such as "user:=team,user:=address.city".
+func ParseIndexes(spec string) ([]Index, error) {
+	var indexes []Index
+	for declaration := range strings.SplitSeq(spec, ",") {
+		declaration = strings.TrimSpace(declaration)
+		if declaration == "" {
+			continue
+		}
+
+		i := strings.LastIndex(declaration, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid index %q: expected prefix=path", declaration)
+		}
+
+		index := Index{Prefix: declaration[:i], Path: declaration[i+1:]}
+		if err := index.Validate(); err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes, nil
+}
+
+func (ix Index) String() string {
+	return ix.Prefix + "=" + ix.Path
+}
+
+func (ix Index) Validate() error {
+	if slices.Contains(strings.Split(ix.Path, "."), "") {
+		return fmt.Errorf("invalid index %q: path must be a dotted list of fields", ix)
+	}
+	return nil
+}
+
+// postingKey names the list of the keys of namespace whose field holds token.
+func (ix Index) postingKey(namespace, token string) string {
+	return indexKeyPrefix + namespace + indexSeparator + ix.Prefix + indexSeparator + ix.Path + indexSeparator + token
+}
+
+// postings returns the posting lists key belongs to with the given value.
+func postings(indexes []Index, key string, value any) []string {
+	namespace, local := splitNamespace(key)
+
+	var keys []string
+	for _, index := range indexes {
+		if !strings.HasPrefix(local, index.Prefix) {
+			continue
+		}
+		for _, token := range fieldTokens(value, index.Path) {
+			keys = append(keys, index.postingKey(namespace, token))
+		}
+	}
+	return keys
+}
+
+// fieldTokens returns the indexed text of the field at path, none when it is missing or an object.
+func fieldTokens(value any, path string) []string {
+	for field := range strings.SplitSeq(path, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		if value, ok = object[field]; !ok {
+			return nil
+		}
+	}
+
+	if items, ok := value.([]any); ok {
+		tokens := make([]string, 0, len(items))
+		for _, item := range items {
+			if token, ok := scalarToken(item); ok && !slices.Contains(tokens, token) {
+				tokens = append(tokens, token)
+			}
+		}
+		return tokens
+	}
+
+	if token, ok := scalarToken(value); ok {
+		return []string{token}
+	}
+	return nil
+}
+
+func scalarToken(value any) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case map[string]any, []any:
+		return "", false
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return "", false
+		}
+		return string(data), true
+	}
+}
+
+// plan returns the posting list that drives the query: the one of the first condition
+// covered by an index.
+func (q Query) plan(indexes []Index) (string, error) {
+	namespace, prefix := splitNamespace(q.Prefix)
+	for _, cond := range q.Where {
+		for _, index := range indexes {
+			if index.Path == cond.Path && strings.HasPrefix(prefix, index.Prefix) {
+				return index.postingKey(namespace, cond.Value), nil
+			}
+		}
+	}
+	return "", ErrNoIndex
+}
+
+// matches reports whether the value of key satisfies the query. Stores check every
+// candidate found through a posting list, which may still list keys that expired.
+func (q Query) matches(key string, value any) bool {
+	if !strings.HasPrefix(key, q.Prefix) {
+		return false
+	}
+	for _, cond := range q.Where {
+		if !slices.Contains(fieldTokens(value, cond.Path), cond.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+func newMemoryIndex(indexes []Index) *memoryIndex {
+	return &memoryIndex{
+		indexes:  indexes,
+		postings: make(map[string]map[string]struct{}),
+	}
+}
+
+// replace moves key from the posting lists of its previous value to the ones of its new
+// value. A nil item stands for a missing key.
+func (ix *memoryIndex) replace(key string, previous, item *memoryItem) {
+	if len(ix.indexes) == 0 {
+		return
+	}
+
+	var before, after []string
+	if previous != nil {
+		before = postings(ix.indexes, key, previous.entry.Value)
+	}
+	if item != nil {
+		after = postings(ix.indexes, key, item.entry.Value)
+	}
+	if len(before) == 0 && len(after) == 0 {
+		return
+	}
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	for _, posting := range before {
+		if keys := ix.postings[posting]; keys != nil {
+			delete(keys, key)
+			if len(keys) == 0 {
+				delete(ix.postings, posting)
+			}
+		}
+	}
+	for _, posting := range after {
+		keys := ix.postings[posting]
+		if keys == nil {
+			keys = make(map[string]struct{})
+			ix.postings[posting] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+// candidates returns the keys of a posting list that start with prefix and sort after the
+// cursor position, in order.
+func (ix *memoryIndex) candidates(posting, prefix, after string) []string {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	var keys []string
+	for key := range ix.postings[posting] {
+		if strings.HasPrefix(key, prefix) && key > after {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
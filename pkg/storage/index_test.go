@@ -0,0 +1,157 @@
+package storage_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/felipeascari/kv-store/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseIndexes(t *testing.T) {
+	indexes, err := storage.ParseIndexes(" user:=team, user:=address.city,=kind ")
+	require.NoError(t, err)
+	require.Equal(t, []storage.Index{
+		{Prefix: "user:", Path: "team"},
+		{Prefix: "user:", Path: "address.city"},
+		{Prefix: "", Path: "kind"},
+	}, indexes)
+
+	indexes, err = storage.ParseIndexes("")
+	require.NoError(t, err)
+	require.Empty(t, indexes)
+
+	_, err = storage.ParseIndexes("user:team")
+	require.Error(t, err)
+
+	_, err = storage.ParseIndexes("user:=address..city")
+	require.Error(t, err)
+}
+
+func TestMemoryQuery(t *testing.T) {
+	ctx := context.Background()
+
+	newStore := func(t *testing.T) *storage.Memory {
+		t.Helper()
+		m, err := storage.NewMemoryWithOptions(storage.MemoryOptions{
+			Indexes: []storage.Index{
+				{Prefix: "user:", Path: "team"},
+				{Prefix: "user:", Path: "address.city"},
+				{Prefix: "user:", Path: "tags"},
+			},
+		})
+		require.NoError(t, err)
+		return m
+	}
+
+	keys := func(result storage.QueryResult) []string {
+		keys := make([]string, len(result.Items))
+		for i, item := range result.Items {
+			keys[i] = item.Key
+		}
+		return keys
+	}
+
+	t.Run("should find keys by indexed field", func(t *testing.T) {
+		m := newStore(t)
+		_, _ = m.Save(ctx, "user:1", map[string]any{"name": "Alice", "team": "infra", "address": map[string]any{"city": "Lisbon"}}, 0)
+		_, _ = m.Save(ctx, "user:2", map[string]any{"name": "Bob", "team": "web", "address": map[string]any{"city": "Lisbon"}}, 0)
+		_, _ = m.Save(ctx, "user:3", map[string]any{"name": "Carol", "team": "infra", "tags": []any{"oncall", 7.0}}, 0)
+		_, _ = m.Save(ctx, "group:1", map[string]any{"team": "infra"}, 0)
+
+		result, err := m.Query(ctx, storage.Query{Prefix: "user:", Where: []storage.Condition{{Path: "team", Value: "infra"}}})
+		require.NoError(t, err)
+		require.Equal(t, []string{"user:1", "user:3"}, keys(result))
+		require.Equal(t, "Carol", result.Items[1].Entry.Value.(map[string]any)["name"])
+		require.Empty(t, result.Cursor)
+
+		result, err = m.Query(ctx, storage.Query{Prefix: "user:", Where: []storage.Condition{{Path: "address.city", Value: "Lisbon"}, {Path: "team", Value: "web"}}})
+		require.NoError(t, err)
+		require.Equal(t, []string{"user:2"}, keys(result))
+
+		result, err = m.Query(ctx, storage.Query{Prefix: "user:", Where: []storage.Condition{{Path: "tags", Value: "7"}}})
+		require.NoError(t, err)
+		require.Equal(t, []string{"user:3"}, keys(result))
+	})
+
+	t.Run("should follow writes and deletes", func(t *testing.T) {
+		m := newStore(t)
+		infra := storage.Query{Prefix: "user:", Where: []storage.Condition{{Path: "team", Value: "infra"}}}
+
+		_, _ = m.Save(ctx, "user:1", map[string]any{"team": "infra"}, 0)
+		_, _ = m.Save(ctx, "user:2", map[string]any{"team": "infra"}, 0)
+		_, _ = m.Save(ctx, "user:3", map[string]any{"team": "infra"}, 0)
+
+		_, _ = m.Save(ctx, "user:1", map[string]any{"team": "web"}, 0)
+		require.NoError(t, m.Delete(ctx, "user:2"))
+		_, err := m.Update(ctx, "user:3", func(storage.Entry) (any, error) {
+			return map[string]any{"team": "infra", "lead": true}, nil
+		})
+		require.NoError(t, err)
+		_, err = m.Transact(ctx, storage.Transaction{Ops: []storage.TxOp{
+			{Type: storage.TxSet, Key: "user:4", Value: map[string]any{"team": "infra"}},
+		}})
+		require.NoError(t, err)
+
+		result, err := m.Query(ctx, infra)
+		require.NoError(t, err)
+		require.Equal(t, []string{"user:3", "user:4"}, keys(result))
+
+		result, err = m.Query(ctx, storage.Query{Prefix: "user:", Where: []storage.Condition{{Path: "team", Value: "web"}}})
+		require.NoError(t, err)
+		require.Equal(t, []string{"user:1"}, keys(result))
+	})
+
+	t.Run("should skip expired keys", func(t *testing.T) {
+		m := newStore(t)
+		_, _ = m.Save(ctx, "user:1", map[string]any{"team": "infra"}, time.Millisecond)
+		_, _ = m.Save(ctx, "user:2", map[string]any{"team": "infra"}, 0)
+		time.Sleep(5 * time.Millisecond)
+
+		result, err := m.Query(ctx, storage.Query{Prefix: "user:", Where: []storage.Condition{{Path: "team", Value: "infra"}}})
+		require.NoError(t, err)
+		require.Equal(t, []string{"user:2"}, keys(result))
+	})
+
+	t.Run("should page through matches", func(t *testing.T) {
+		m := newStore(t)
+		for i := range 25 {
+			_, _ = m.Save(ctx, fmt.Sprintf("user:%02d", i), map[string]any{"team": "infra"}, 0)
+		}
+
+		var (
+			all    []string
+			cursor string
+		)
+		for {
+			result, err := m.Query(ctx, storage.Query{
+				Prefix: "user:",
+				Where:  []storage.Condition{{Path: "team", Value: "infra"}},
+				Cursor: cursor,
+				Limit:  10,
+			})
+			require.NoError(t, err)
+			require.LessOrEqual(t, len(result.Items), 10)
+			all = append(all, keys(result)...)
+			if result.Cursor == "" {
+				break
+			}
+			cursor = result.Cursor
+		}
+		require.Len(t, all, 25)
+		require.Equal(t, "user:00", all[0])
+		require.Equal(t, "user:24", all[24])
+	})
+
+	t.Run("should require an index", func(t *testing.T) {
+		m := newStore(t)
+
+		_, err := m.Query(ctx, storage.Query{Prefix: "user:", Where: []storage.Condition{{Path: "name", Value: "Alice"}}})
+		require.ErrorIs(t, err, storage.ErrNoIndex)
+
+		_, err = m.Query(ctx, storage.Query{Prefix: "group:", Where: []storage.Condition{{Path: "team", Value: "infra"}}})
+		require.ErrorIs(t, err, storage.ErrNoIndex)
+	})
+}
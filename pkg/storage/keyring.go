@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+const (
+	// EncryptionKeySize is the size of the AES-256 keys of a keyring.
+	EncryptionKeySize = 32
+	// maxKeyIDLength keeps key IDs short enough to be stored in front of every value.
+	maxKeyIDLength = 255
+)
+
+type (
+	// Keyring holds the keys values are encrypted with, by ID. New values are encrypted with
+	// the primary key, and the other keys are kept to read the values written before a
+	// rotation until they are re-encrypted.
+	Keyring struct {
+		primary string
+		ciphers map[string]cipher.AEAD
+	}
+
+	// keyringFile is the JSON document a keyring is loaded from, with base64 encoded keys:
+	//
+	//	{"primary": "2026-10", "keys": {"2026-01": "...", "2026-10": "..."}}
+	keyringFile struct {
+		Primary string            `json:"primary"`
+		Keys    map[string]string `json:"keys"`
+	}
+)
+
+// NewKeyring returns a keyring of AES-256 keys, which must include the primary key.
+func NewKeyring(primary string, keys map[string][]byte) (*Keyring, error) {
+	if _, ok := keys[primary]; !ok {
+		return nil, fmt.Errorf("primary key %q is not in the keyring", primary)
+	}
+
+	ciphers := make(map[string]cipher.AEAD, len(keys))
+	for id, key := range keys {
+		if id == "" || len(id) > maxKeyIDLength {
+			return nil, fmt.Errorf("invalid key ID %q: must be 1 to %d bytes long", id, maxKeyIDLength)
+		}
+		if len(key) != EncryptionKeySize {
+			return nil, fmt.Errorf("invalid key %q: must be %d bytes long, got %d", id, EncryptionKeySize, len(key))
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		if ciphers[id], err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Keyring{primary: primary, ciphers: ciphers}, nil
+}
+
+// LoadKeyring reads a keyring from a JSON file listing the base64 encoded keys by ID
+// and naming the primary one.
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file keyringFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid keyring %s: %w", path, err)
+	}
+	if file.Primary == "" {
+		return nil, fmt.Errorf("invalid keyring %s: no primary key", path)
+	}
+
+	keys := make(map[string][]byte, len(file.Keys))
+	for id, encoded := range file.Keys {
+		if keys[id], err = base64.StdEncoding.DecodeString(encoded); err != nil {
+			return nil, fmt.Errorf("invalid keyring %s: key %q is not base64: %w", path, id, err)
+		}
+	}
+
+	keyring, err := NewKeyring(file.Primary, keys)
+	if err != nil {
+		return nil, fmt.Errorf("invalid keyring %s: %w", path, err)
+	}
+	return keyring, nil
+}
+
+// Primary returns the ID of the key new values are encrypted with.
+func (k *Keyring) Primary() string {
+	return k.primary
+}
+
+// SealValue encrypts a value bound to key as Encrypted stores it, for the values that leave
+// the store by other means, such as watch events.
+func (k *Keyring) SealValue(key string, value any) (Blob, error) {
+	plaintext, err := encodeValue(value)
+	if err != nil {
+		return Blob{}, err
+	}
+
+	data, err := k.seal(plaintext, []byte(key))
+	if err != nil {
+		return Blob{}, fmt.Errorf("failed to encrypt value: %w", err)
+	}
+	return Blob{ContentType: EncryptedContentType, Data: data}, nil
+}
+
+// OpenValue decrypts a blob sealed by SealValue for key.
+func (k *Keyring) OpenValue(key string, blob Blob) (any, error) {
+	plaintext, _, err := k.open(blob.Data, []byte(key))
+	if err != nil {
+		return nil, err
+	}
+	return decodeValue(plaintext)
+}
+
+// seal encrypts plaintext with the primary key under a fresh random nonce, bound to
+// additionalData. It returns the length of the key ID, the key ID, the nonce and the ciphertext.
+func (k *Keyring) seal(plaintext, additionalData []byte) ([]byte, error) {
+	aead := k.ciphers[k.primary]
+
+	out := make([]byte, 0, 1+len(k.primary)+aead.NonceSize()+len(plaintext)+aead.Overhead())
+	out = append(out, byte(len(k.primary)))
+	out = append(out, k.primary...)
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out = append(out, nonce...)
+
+	return aead.Seal(out, nonce, plaintext, additionalData), nil
+}
+
+// open decrypts the output of seal and returns the plaintext and the ID of the key it was
+// encrypted with.
+func (k *Keyring) open(sealed, additionalData []byte) ([]byte, string, error) {
+	if len(sealed) == 0 || len(sealed) < 1+int(sealed[0]) {
+		return nil, "", ErrDecryption
+	}
+	id, rest := string(sealed[1:1+sealed[0]]), sealed[1+sealed[0]:]
+
+	aead, ok := k.ciphers[id]
+	if !ok {
+		return nil, id, fmt.Errorf("%w: %q", ErrUnknownEncryptionKey, id)
+	}
+	if len(rest) < aead.NonceSize() {
+		return nil, id, ErrDecryption
+	}
+
+	plaintext, err := aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], additionalData)
+	if err != nil {
+		return nil, id, ErrDecryption
+	}
+	return plaintext, id, nil
+}
@@ -4,45 +4,70 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/felipeascari/kv-store/pkg/lock"
 )
 
 type LockedStore struct {
+	// Collection operations are forwarded without taking any lock.
+	forwardCollections
+
 	store              Store
 	lockManager        *lock.Manager
 	lastProcessedToken map[string]int64
 	mu                 sync.RWMutex
+	// changes is nil unless writes are captured, see WithChangeStream.
+	changes *ChangeStream
 }
 
 func NewLockedStore(store Store, lockMgr *lock.Manager) *LockedStore {
 	return &LockedStore{
+		forwardCollections: forwardCollections{store: store},
 		store:              store,
 		lockManager:        lockMgr,
 		lastProcessedToken: make(map[string]int64),
 	}
 }
 
-func (ls *LockedStore) Save(key string, value any) error {
-	return ls.lockManager.ExecuteWithLock(context.Background(), key, func(token int64) error {
+// WithChangeStream captures every write in the change stream. The store must be a Redis store,
+// possibly behind Encrypted: it appends the changes in the scripts that make the writes, while
+// other stores ignore the change stream.
+func (ls *LockedStore) WithChangeStream(changes *ChangeStream) *LockedStore {
+	ls.changes = changes
+	return ls
+}
+
+func (ls *LockedStore) Save(ctx context.Context, key string, value any, ttl time.Duration) (int64, error) {
+	var version int64
+
+	err := ls.lockManager.ExecuteWithLock(ctx, key, func(token int64) error {
 		if !ls.validateToken(key, token) {
 			return fmt.Errorf("token %d rejected: a newer token already processed key %q: %w", token, key, ErrInvalidToken)
 		}
 
-		if err := ls.store.Save(key, value); err != nil {
+		v, err := ls.store.Save(ls.capturing(ctx, map[string]int64{key: token}), key, value, ttl)
+		if err != nil {
 			return fmt.Errorf("failed to save with fencing token %d: %w", token, err)
 		}
 
+		version = v
 		ls.recordToken(key, token)
 		return nil
 	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return version, nil
 }
 
-func (ls *LockedStore) Retrieve(key string) (any, error) {
-	var result any
+func (ls *LockedStore) Retrieve(ctx context.Context, key string) (Entry, error) {
+	var result Entry
 
-	err := ls.lockManager.ExecuteWithLock(context.Background(), key, func(token int64) error {
-		value, err := ls.store.Retrieve(key)
+	err := ls.lockManager.ExecuteWithLock(ctx, key, func(token int64) error {
+		value, err := ls.store.Retrieve(ctx, key)
 		if err != nil {
 			return fmt.Errorf("failed to retrieve with fencing token %d: %w", token, err)
 		}
@@ -53,19 +78,19 @@ func (ls *LockedStore) Retrieve(key string) (any, error) {
 	})
 
 	if err != nil {
-		return nil, err
+		return Entry{}, err
 	}
 
 	return result, nil
 }
 
-func (ls *LockedStore) Delete(key string) error {
-	return ls.lockManager.ExecuteWithLock(context.Background(), key, func(token int64) error {
+func (ls *LockedStore) Delete(ctx context.Context, key string) error {
+	return ls.lockManager.ExecuteWithLock(ctx, key, func(token int64) error {
 		if !ls.validateToken(key, token) {
 			return fmt.Errorf("token %d rejected: a newer token already processed key %q: %w", token, key, ErrInvalidToken)
 		}
 
-		if err := ls.store.Delete(key); err != nil {
+		if err := ls.store.Delete(ls.capturing(ctx, map[string]int64{key: token}), key); err != nil {
 			return fmt.Errorf("failed to delete with fencing token %d: %w", token, err)
 		}
 
@@ -74,6 +99,259 @@ func (ls *LockedStore) Delete(key string) error {
 	})
 }
 
+func (ls *LockedStore) CompareAndSwap(ctx context.Context, key string, expectedVersion int64, value any, ttl time.Duration) (int64, error) {
+	var version int64
+
+	err := ls.lockManager.ExecuteWithLock(ctx, key, func(token int64) error {
+		if !ls.validateToken(key, token) {
+			return fmt.Errorf("token %d rejected: a newer token already processed key %q: %w", token, key, ErrInvalidToken)
+		}
+
+		v, err := ls.store.CompareAndSwap(ls.capturing(ctx, map[string]int64{key: token}), key, expectedVersion, value, ttl)
+		if err != nil {
+			return fmt.Errorf("failed to compare and swap with fencing token %d: %w", token, err)
+		}
+
+		version = v
+		ls.recordToken(key, token)
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
+
+func (ls *LockedStore) CompareAndDelete(ctx context.Context, key string, expectedVersion int64) error {
+	return ls.lockManager.ExecuteWithLock(ctx, key, func(token int64) error {
+		if !ls.validateToken(key, token) {
+			return fmt.Errorf("token %d rejected: a newer token already processed key %q: %w", token, key, ErrInvalidToken)
+		}
+
+		if err := ls.store.CompareAndDelete(ls.capturing(ctx, map[string]int64{key: token}), key, expectedVersion); err != nil {
+			return fmt.Errorf("failed to compare and delete with fencing token %d: %w", token, err)
+		}
+
+		ls.resetToken(key)
+		return nil
+	})
+}
+
+func (ls *LockedStore) Increment(ctx context.Context, key string, delta float64) (Entry, error) {
+	var entry Entry
+
+	err := ls.lockManager.ExecuteWithLock(ctx, key, func(token int64) error {
+		if !ls.validateToken(key, token) {
+			return fmt.Errorf("token %d rejected: a newer token already processed key %q: %w", token, key, ErrInvalidToken)
+		}
+
+		e, err := ls.store.Increment(ls.capturing(ctx, map[string]int64{key: token}), key, delta)
+		if err != nil {
+			return fmt.Errorf("failed to increment with fencing token %d: %w", token, err)
+		}
+
+		entry = e
+		ls.recordToken(key, token)
+		return nil
+	})
+
+	if err != nil {
+		return Entry{}, err
+	}
+
+	return entry, nil
+}
+
+func (ls *LockedStore) Update(ctx context.Context, key string, fn UpdateFunc) (Entry, error) {
+	var entry Entry
+
+	err := ls.lockManager.ExecuteWithLock(ctx, key, func(token int64) error {
+		if !ls.validateToken(key, token) {
+			return fmt.Errorf("token %d rejected: a newer token already processed key %q: %w", token, key, ErrInvalidToken)
+		}
+
+		e, err := ls.store.Update(ls.capturing(ctx, map[string]int64{key: token}), key, fn)
+		if err != nil {
+			return fmt.Errorf("failed to update with fencing token %d: %w", token, err)
+		}
+
+		entry = e
+		ls.recordToken(key, token)
+		return nil
+	})
+
+	if err != nil {
+		return Entry{}, err
+	}
+
+	return entry, nil
+}
+
+// Scan does not take any lock: it only reads key names and a page is not a consistent snapshot anyway.
+func (ls *LockedStore) Scan(ctx context.Context, prefix, cursor string, limit int) (ScanResult, error) {
+	return ls.store.Scan(ctx, prefix, cursor, limit)
+}
+
+// Query does not take any lock either, the store checks every match against the current value.
+func (ls *LockedStore) Query(ctx context.Context, q Query) (QueryResult, error) {
+	querier, ok := ls.store.(Querier)
+	if !ok {
+		return QueryResult{}, ErrQueryUnsupported
+	}
+	return querier.Query(ctx, q)
+}
+
+// History does not take any lock either, it only reads.
+func (ls *LockedStore) History(ctx context.Context, key string) ([]Revision, error) {
+	return historyOf(ctx, ls.store, key)
+}
+
+func (ls *LockedStore) CompressionStats() (CompressionStats, bool) {
+	return compressionStatsOf(ls.store)
+}
+
+// directSource lets Export and the namespace registry read keys without locking them.
+func (ls *LockedStore) directSource() Store {
+	return ls.store
+}
+
+func (ls *LockedStore) BatchSave(ctx context.Context, items []BatchItem) ([]BatchResult, error) {
+	keys := make([]string, len(items))
+	for i, item := range items {
+		keys[i] = item.Key
+	}
+
+	return ls.executeBatch(ctx, keys, true, func(ctx context.Context, indexes []int) ([]BatchResult, error) {
+		accepted := make([]BatchItem, len(indexes))
+		for i, index := range indexes {
+			accepted[i] = items[index]
+		}
+		return ls.store.BatchSave(ctx, accepted)
+	}, func(result BatchResult, token int64) {
+		ls.recordToken(result.Key, token)
+	})
+}
+
+func (ls *LockedStore) BatchRetrieve(ctx context.Context, keys []string) ([]BatchResult, error) {
+	return ls.executeBatch(ctx, keys, false, func(ctx context.Context, indexes []int) ([]BatchResult, error) {
+		return ls.store.BatchRetrieve(ctx, pick(keys, indexes))
+	}, func(result BatchResult, token int64) {
+		ls.recordToken(result.Key, token)
+	})
+}
+
+func (ls *LockedStore) BatchDelete(ctx context.Context, keys []string) ([]BatchResult, error) {
+	return ls.executeBatch(ctx, keys, true, func(ctx context.Context, indexes []int) ([]BatchResult, error) {
+		return ls.store.BatchDelete(ctx, pick(keys, indexes))
+	}, func(result BatchResult, _ int64) {
+		ls.resetToken(result.Key)
+	})
+}
+
+// Transact locks every key of the transaction in a single ExecuteWithLocks call, which
+// acquires them in sorted order. The whole transaction is rejected if the fencing token
+// of any written key is stale.
+func (ls *LockedStore) Transact(ctx context.Context, tx Transaction) ([]TxResult, error) {
+	if err := tx.Validate(); err != nil {
+		return nil, err
+	}
+
+	var results []TxResult
+
+	err := ls.lockManager.ExecuteWithLocks(ctx, tx.Keys(), func(tokens map[string]int64) error {
+		for _, op := range tx.Ops {
+			if token := tokens[op.Key]; !ls.validateToken(op.Key, token) {
+				return fmt.Errorf("token %d rejected: a newer token already processed key %q: %w", token, op.Key, ErrInvalidToken)
+			}
+		}
+
+		applied, err := ls.store.Transact(ls.capturing(ctx, tokens), tx)
+		if err != nil {
+			return fmt.Errorf("failed to execute transaction with fencing tokens: %w", err)
+		}
+
+		for _, op := range tx.Ops {
+			if op.Type == TxDelete {
+				ls.resetToken(op.Key)
+			} else {
+				ls.recordToken(op.Key, tokens[op.Key])
+			}
+		}
+
+		results = applied
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// executeBatch locks every key in a single ExecuteWithLocks call and runs apply on the
+// indexes of the items whose fencing token is accepted. Items with a rejected token fail
+// individually, and commit is called for every item that succeeded.
+func (ls *LockedStore) executeBatch(
+	ctx context.Context,
+	keys []string,
+	validate bool,
+	apply func(context.Context, []int) ([]BatchResult, error),
+	commit func(BatchResult, int64),
+) ([]BatchResult, error) {
+	results := make([]BatchResult, len(keys))
+
+	err := ls.lockManager.ExecuteWithLocks(ctx, keys, func(tokens map[string]int64) error {
+		indexes := make([]int, 0, len(keys))
+		for i, key := range keys {
+			token := tokens[key]
+			if validate && !ls.validateToken(key, token) {
+				results[i] = BatchResult{
+					Key: key,
+					Err: fmt.Errorf("token %d rejected: a newer token already processed key %q: %w", token, key, ErrInvalidToken),
+				}
+				continue
+			}
+			indexes = append(indexes, i)
+		}
+
+		applied, err := apply(ls.capturing(ctx, tokens), indexes)
+		if err != nil {
+			return fmt.Errorf("failed to execute batch with fencing tokens: %w", err)
+		}
+
+		for i, result := range applied {
+			results[indexes[i]] = result
+			if result.Err == nil {
+				commit(result, tokens[result.Key])
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// capturing returns ctx asking the store to append the writes made with tokens to the change
+// stream, if any.
+func (ls *LockedStore) capturing(ctx context.Context, tokens map[string]int64) context.Context {
+	if ls.changes == nil {
+		return ctx
+	}
+	return withChangeCapture(ctx, &changeCapture{
+		stream:    ls.changes,
+		serverID:  ls.lockManager.ServerID(),
+		timestamp: time.Now(),
+		tokens:    tokens,
+	})
+}
+
 func (ls *LockedStore) validateToken(key string, token int64) bool {
 	ls.mu.RLock()
 	defer ls.mu.RUnlock()
@@ -98,3 +376,11 @@ func (ls *LockedStore) resetToken(key string) {
 	defer ls.mu.Unlock()
 	delete(ls.lastProcessedToken, key)
 }
+
+func pick(keys []string, indexes []int) []string {
+	picked := make([]string, len(indexes))
+	for i, index := range indexes {
+		picked[i] = keys[index]
+	}
+	return picked
+}
@@ -1,45 +1,974 @@
 package storage
 
-import "sync"
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
 
-type Memory struct {
-	mu    sync.RWMutex
-	store map[string]any
-}
+const (
+	// DefaultMemoryShards is the number of partitions used when none is configured.
+	DefaultMemoryShards = 32
+
+	scanCancelCheckInterval = 1024
+)
 
+type (
+	// Memory partitions the keyspace into shards by key hash, each guarded by its own lock,
+	// so operations on different keys rarely contend with each other.
+	Memory struct {
+		shards []*memoryShard
+		limits MemoryOptions
+		// keys and bytes account for every stored key and collection, including expired keys not reaped yet.
+		keys        atomic.Int64
+		bytes       atomic.Int64
+		evictions   atomic.Uint64
+		expirations atomic.Uint64
+		// floor is the highest version of a key that was deleted, expired or evicted. Keys
+		// created afterwards start above it, so a version never matches a key that came back.
+		floor atomic.Int64
+		// onExpire is called with the keys the reaper removes, see NotifyExpired.
+		onExpire atomic.Pointer[func(key string)]
+		index    *memoryIndex
+		// collections are kept apart from the shards. They are logged like the keys and count
+		// toward the limits, each as one key, but are never evicted.
+		collections *memoryCollections
+		// wal is nil unless the store was opened with persistence.
+		wal        *wal
+		walDir     string
+		snapshot   sync.Mutex
+		background *background
+	}
+
+	memoryShard struct {
+		mu    sync.RWMutex
+		store map[string]*memoryItem
+	}
+)
+
+// NewMemory returns an unbounded memory store with the default number of shards.
 func NewMemory() *Memory {
-	return &Memory{
-		store: make(map[string]any),
+	m, _ := NewMemoryWithOptions(MemoryOptions{})
+	return m
+}
+
+// NewMemoryWithOptions returns a memory store with the given number of shards and limits.
+// A single shard behaves like one map behind one lock.
+func NewMemoryWithOptions(opts MemoryOptions) (*Memory, error) {
+	if opts.Shards <= 0 {
+		opts.Shards = DefaultMemoryShards
+	}
+	if opts.Eviction == "" {
+		opts.Eviction = EvictionNone
 	}
+	if !opts.Eviction.IsValid() {
+		return nil, fmt.Errorf("invalid eviction policy: %q", opts.Eviction)
+	}
+	for _, index := range opts.Indexes {
+		if err := index.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	m := &Memory{
+		shards:      make([]*memoryShard, opts.Shards),
+		limits:      opts,
+		index:       newMemoryIndex(opts.Indexes),
+		collections: newMemoryCollections(),
+		background:  newBackground(),
+	}
+	for i := range m.shards {
+		m.shards[i] = &memoryShard{store: make(map[string]*memoryItem)}
+	}
+
+	return m, nil
 }
 
-func (m *Memory) Save(key string, value any) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	m.store[key] = value
-	return nil
+// OpenMemory returns a memory store that survives restarts. Every write is appended to a log
+// in persistence.Dir before it is applied, the log is periodically compacted into a snapshot,
+// and on startup the snapshot and the log are replayed to rebuild the keyspace.
+func OpenMemory(opts MemoryOptions, persistence PersistenceOptions) (*Memory, error) {
+	if !persistence.Fsync.IsValid() {
+		return nil, fmt.Errorf("invalid fsync policy: %q", persistence.Fsync)
+	}
+
+	m, err := NewMemoryWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, collections, floor, seq, err := recoverMemory(persistence.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to recover memory storage: %w", err)
+	}
+
+	w, err := openWAL(persistence.Dir, persistence.Fsync, seq)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, entry := range entries {
+		item := newMemoryItem(key, entry)
+		m.shard(key).store[key] = item
+		m.index.replace(key, nil, item)
+		m.keys.Add(1)
+		m.bytes.Add(item.size)
+	}
+	for _, c := range collections.items {
+		m.keys.Add(1)
+		m.bytes.Add(c.size)
+	}
+	m.collections = collections
+	m.floor.Store(floor)
+	m.wal = w
+	m.walDir = persistence.Dir
+
+	if persistence.Fsync == FsyncEverySecond {
+		m.background.every(time.Second, func() {
+			_ = m.wal.sync()
+		})
+	}
+
+	if persistence.SnapshotInterval > 0 {
+		m.background.every(persistence.SnapshotInterval, func() {
+			_ = m.Snapshot()
+		})
+	}
+
+	return m, nil
+}
+
+func (m *Memory) Save(_ context.Context, key string, value any, ttl time.Duration) (int64, error) {
+	shard := m.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	return m.save(shard, key, value, ttl)
 }
 
-func (m *Memory) Retrieve(key string) (any, error) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+func (m *Memory) Retrieve(_ context.Context, key string) (Entry, error) {
+	shard := m.shard(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
 
-	value, exists := m.store[key]
+	item, exists := shard.get(key)
 	if !exists {
-		return nil, ErrKeyNotFound
+		return Entry{}, ErrKeyNotFound
 	}
 
-	return value, nil
+	item.touch()
+	return item.entry, nil
 }
 
-func (m *Memory) Delete(key string) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+func (m *Memory) Delete(_ context.Context, key string) error {
+	shard := m.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
 
-	if _, exists := m.store[key]; !exists {
+	if _, exists := shard.get(key); !exists {
 		return ErrKeyNotFound
 	}
 
-	delete(m.store, key)
+	return m.remove(shard, key)
+}
+
+func (m *Memory) CompareAndSwap(_ context.Context, key string, expectedVersion int64, value any, ttl time.Duration) (int64, error) {
+	shard := m.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if shard.version(key) != expectedVersion {
+		return 0, ErrVersionMismatch
+	}
+
+	return m.save(shard, key, value, ttl)
+}
+
+func (m *Memory) CompareAndDelete(_ context.Context, key string, expectedVersion int64) error {
+	shard := m.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	current, exists := shard.get(key)
+	if !exists {
+		return ErrKeyNotFound
+	}
+	if current.entry.Version != expectedVersion {
+		return ErrVersionMismatch
+	}
+
+	return m.remove(shard, key)
+}
+
+func (m *Memory) Increment(_ context.Context, key string, delta float64) (Entry, error) {
+	shard := m.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	var current Entry
+	if item, exists := shard.get(key); exists {
+		current = item.entry
+	}
+
+	value, err := increment(current.Value, delta)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	entry := Entry{
+		Value:     value,
+		Version:   m.next(shard, key),
+		ExpiresAt: current.ExpiresAt,
+	}
+	if err := m.put(shard, key, entry); err != nil {
+		return Entry{}, err
+	}
+
+	return entry, nil
+}
+
+func (m *Memory) Update(_ context.Context, key string, fn UpdateFunc) (Entry, error) {
+	shard := m.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	item, exists := shard.get(key)
+	if !exists {
+		return Entry{}, ErrKeyNotFound
+	}
+
+	value, err := fn(item.entry)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	entry := Entry{
+		Value:     value,
+		Version:   item.entry.Version + 1,
+		ExpiresAt: item.entry.ExpiresAt,
+	}
+	if err := m.put(shard, key, entry); err != nil {
+		return Entry{}, err
+	}
+
+	return entry, nil
+}
+
+// Rewrite replaces the value of key if it is still at version, keeping its version and expiration.
+func (m *Memory) Rewrite(_ context.Context, key string, version int64, value any) error {
+	shard := m.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	item, exists := shard.get(key)
+	if !exists {
+		return ErrKeyNotFound
+	}
+	if item.entry.Version != version {
+		return ErrVersionMismatch
+	}
+
+	return m.put(shard, key, Entry{
+		Value:     value,
+		Version:   version,
+		ExpiresAt: item.entry.ExpiresAt,
+	})
+}
+
+// History returns the versions of key kept in memory, newest first.
+func (m *Memory) History(_ context.Context, key string) ([]Revision, error) {
+	if m.limits.History <= 0 {
+		return nil, ErrHistoryUnsupported
+	}
+
+	shard := m.shard(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	item, exists := shard.get(key)
+	if !exists {
+		return nil, ErrKeyNotFound
+	}
+
+	revisions := make([]Revision, 0, len(item.history)+1)
+	revisions = append(revisions, Revision{
+		Version:   item.entry.Version,
+		Value:     item.entry.Value,
+		WrittenAt: item.writtenAt,
+	})
+	for _, revision := range slices.Backward(item.history) {
+		revisions = append(revisions, revision)
+	}
+	return revisions, nil
+}
+
+// Scan walks the shards one at a time under their read locks and returns keys in lexical order.
+// The cursor is the last returned key, so pages stay stable while keys are added or removed.
+func (m *Memory) Scan(ctx context.Context, prefix, cursor string, limit int) (ScanResult, error) {
+	page, err := newPageSelector(prefix, cursor, limit)
+	if err != nil {
+		return ScanResult{}, err
+	}
+
+	now := time.Now()
+	scanned := 0
+	for _, shard := range m.shards {
+		shard.mu.RLock()
+		for key, item := range shard.store {
+			// Walking a large keyspace can take a while, stop early once the caller is gone.
+			if scanned++; scanned%scanCancelCheckInterval == 0 && ctx.Err() != nil {
+				shard.mu.RUnlock()
+				return ScanResult{}, ctx.Err()
+			}
+			if !isExpired(item.entry.ExpiresAt, now) {
+				page.offer(key)
+			}
+		}
+		shard.mu.RUnlock()
+	}
+
+	return page.result(), nil
+}
+
+// BatchSave writes all items while holding the locks of every shard involved,
+// so the batch is applied as a whole with respect to other operations.
+func (m *Memory) BatchSave(_ context.Context, items []BatchItem) ([]BatchResult, error) {
+	results := make([]BatchResult, len(items))
+
+	keys := make([]string, len(items))
+	for i, item := range items {
+		keys[i] = item.Key
+	}
+
+	unlock := m.lockShards(keys, true)
+	defer unlock()
+
+	for i, item := range items {
+		shard := m.shard(item.Key)
+		results[i] = BatchResult{Key: item.Key}
+		if _, err := m.save(shard, item.Key, item.Value, item.TTL); err != nil {
+			results[i].Err = err
+			continue
+		}
+		results[i].Entry = shard.store[item.Key].entry
+	}
+
+	return results, nil
+}
+
+func (m *Memory) BatchRetrieve(_ context.Context, keys []string) ([]BatchResult, error) {
+	results := make([]BatchResult, len(keys))
+
+	unlock := m.lockShards(keys, false)
+	defer unlock()
+
+	for i, key := range keys {
+		results[i] = BatchResult{Key: key}
+		if item, exists := m.shard(key).get(key); exists {
+			item.touch()
+			results[i].Entry = item.entry
+		} else {
+			results[i].Err = ErrKeyNotFound
+		}
+	}
+
+	return results, nil
+}
+
+func (m *Memory) BatchDelete(_ context.Context, keys []string) ([]BatchResult, error) {
+	results := make([]BatchResult, len(keys))
+
+	unlock := m.lockShards(keys, true)
+	defer unlock()
+
+	for i, key := range keys {
+		shard := m.shard(key)
+		results[i] = BatchResult{Key: key}
+		if _, exists := shard.get(key); exists {
+			results[i].Err = m.remove(shard, key)
+		} else {
+			results[i].Err = ErrKeyNotFound
+		}
+	}
+
+	return results, nil
+}
+
+// Transact locks the shards of every key involved, so the checks and the writes form
+// a single critical section, and logs the writes as one record.
+func (m *Memory) Transact(_ context.Context, tx Transaction) ([]TxResult, error) {
+	if err := tx.Validate(); err != nil {
+		return nil, err
+	}
+
+	unlock := m.lockShards(tx.Keys(), true)
+	defer unlock()
+
+	writes, results, err := tx.plan(
+		func(key string) int64 { return m.shard(key).version(key) },
+		func(key string) int64 { return m.next(m.shard(key), key) },
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*memoryItem, len(writes))
+	previous := make([]*memoryItem, len(writes))
+	written := make(map[string]bool, len(writes))
+	var keys, bytes, keptKeys, keptBytes int64
+	for i, write := range writes {
+		written[write.key] = true
+		if item, exists := m.shard(write.key).store[write.key]; exists {
+			previous[i] = item
+			keys--
+			bytes -= item.size
+		}
+		if !write.deleted {
+			items[i] = m.newItem(write.key, write.entry, previous[i])
+			keys++
+			bytes += items[i].size
+			keptKeys++
+			keptBytes += items[i].size
+		}
+	}
+
+	keep := func(key string) bool { return written[key] }
+	if err := m.reserve(m.shard(writes[0].key), keep, keys, bytes, keptKeys, keptBytes); err != nil {
+		return nil, err
+	}
+
+	if m.wal != nil {
+		records := make([]walRecord, len(writes))
+		for i, write := range writes {
+			records[i] = deleteRecord(write.key, write.entry.Version)
+			if !write.deleted {
+				records[i] = setRecord(write.key, write.entry)
+			}
+		}
+		if err := m.wal.append(txRecord(records)); err != nil {
+			m.release(keys, bytes)
+			return nil, err
+		}
+	}
+
+	for i, write := range writes {
+		shard := m.shard(write.key)
+		if write.deleted {
+			delete(shard.store, write.key)
+			m.retire(write.entry.Version)
+		} else {
+			shard.store[write.key] = items[i]
+		}
+		m.index.replace(write.key, previous[i], items[i])
+	}
+
+	return results, nil
+}
+
+// Query looks the keys up in the posting list of an indexed condition, in key order, and
+// checks every other condition against the current values.
+func (m *Memory) Query(ctx context.Context, q Query) (QueryResult, error) {
+	posting, err := q.plan(m.index.indexes)
+	if err != nil {
+		return QueryResult{}, err
+	}
+
+	after, err := decodeCursor(q.Cursor)
+	if err != nil {
+		return QueryResult{}, err
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultScanLimit
+	}
+
+	var result QueryResult
+	for i, key := range m.index.candidates(posting, q.Prefix, after) {
+		if i%scanCancelCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return QueryResult{}, err
+			}
+		}
+		if len(result.Items) == limit {
+			result.Cursor = encodeCursor(result.Items[limit-1].Key)
+			break
+		}
+
+		shard := m.shard(key)
+		shard.mu.RLock()
+		item, exists := shard.get(key)
+		var entry Entry
+		if exists {
+			item.touch()
+			entry = item.entry
+		}
+		shard.mu.RUnlock()
+
+		if exists && q.matches(key, entry.Value) {
+			result.Items = append(result.Items, QueryItem{Key: key, Entry: entry})
+		}
+	}
+
+	return result, nil
+}
+
+// Len returns the number of keys and collections currently held, including expired keys
+// that have not been reaped yet.
+func (m *Memory) Len() int {
+	return int(m.keys.Load())
+}
+
+// Stats returns the current usage, limits and eviction counters.
+func (m *Memory) Stats() MemoryStats {
+	return MemoryStats{
+		Keys:        m.keys.Load(),
+		Bytes:       m.bytes.Load(),
+		MaxKeys:     m.limits.MaxKeys,
+		MaxBytes:    m.limits.MaxBytes,
+		Eviction:    m.limits.Eviction,
+		Evictions:   m.evictions.Load(),
+		Expirations: m.expirations.Load(),
+	}
+}
+
+// NotifyExpired calls fn with every key the reaper removes. Keys that expire are only
+// reported once reaped, so the reaper must be running.
+func (m *Memory) NotifyExpired(fn func(key string)) {
+	m.onExpire.Store(&fn)
+}
+
+// StartReaper periodically removes expired keys in the background until Close is called.
+// Expired keys are never returned by Retrieve, the reaper only reclaims their memory.
+func (m *Memory) StartReaper(interval time.Duration) {
+	m.background.every(interval, func() {
+		m.reap(time.Now())
+	})
+}
+
+// Snapshot compacts the log: it starts a new log segment, writes every live key to a
+// snapshot for that segment along with every collection and removes the files the snapshot
+// supersedes. The store is only locked while switching segments and copying the keyspace.
+func (m *Memory) Snapshot() error {
+	if m.wal == nil {
+		return nil
+	}
+
+	m.snapshot.Lock()
+	defer m.snapshot.Unlock()
+
+	// Every shard and the collections are locked so no write can land in the old segment after the copy.
+	for _, shard := range m.shards {
+		shard.mu.Lock()
+	}
+	m.collections.mu.RLock()
+	seq, err := m.wal.rotate()
+	floor := m.floor.Load()
+	collections := m.collections.records()
+	m.collections.mu.RUnlock()
+	entries := make(map[string]Entry, m.keys.Load())
+	for _, shard := range m.shards {
+		for key, item := range shard.store {
+			entries[key] = item.entry
+		}
+		shard.mu.Unlock()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return writeSnapshot(m.walDir, seq, entries, collections, floor)
+}
+
+// SnapshotEntries copies every live key while holding the read locks of every shard, so the
+// copy is a single point in time. Writes wait while the keyspace is copied. Collections are
+// not included.
+func (m *Memory) SnapshotEntries(_ context.Context) (map[string]Entry, error) {
+	for _, shard := range m.shards {
+		shard.mu.RLock()
+	}
+
+	now := time.Now()
+	entries := make(map[string]Entry, m.keys.Load())
+	for _, shard := range m.shards {
+		for key, item := range shard.store {
+			if !isExpired(item.entry.ExpiresAt, now) {
+				entries[key] = item.entry
+			}
+		}
+		shard.mu.RUnlock()
+	}
+
+	return entries, nil
+}
+
+// Close stops the background goroutines and, for a persistent store, syncs and closes the log.
+func (m *Memory) Close() error {
+	if !m.background.close() || m.wal == nil {
+		return nil
+	}
+	return m.wal.close()
+}
+
+// shard returns the shard owning key, chosen by its FNV-1a hash.
+func (m *Memory) shard(key string) *memoryShard {
+	if len(m.shards) == 1 {
+		return m.shards[0]
+	}
+
+	hash := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint64(key[i])
+		hash *= 1099511628211
+	}
+
+	return m.shards[hash%uint64(len(m.shards))]
+}
+
+// lockShards locks the shards owning keys in ascending order, so concurrent
+// multi-key operations cannot deadlock, and returns the function releasing them.
+func (m *Memory) lockShards(keys []string, write bool) func() {
+	seen := make(map[*memoryShard]struct{}, len(keys))
+	for _, key := range keys {
+		seen[m.shard(key)] = struct{}{}
+	}
+
+	shards := make([]*memoryShard, 0, len(seen))
+	for _, shard := range m.shards {
+		if _, ok := seen[shard]; ok {
+			shards = append(shards, shard)
+		}
+	}
+
+	for _, shard := range shards {
+		if write {
+			shard.mu.Lock()
+		} else {
+			shard.mu.RLock()
+		}
+	}
+
+	return func() {
+		for _, shard := range slices.Backward(shards) {
+			if write {
+				shard.mu.Unlock()
+			} else {
+				shard.mu.RUnlock()
+			}
+		}
+	}
+}
+
+// save writes the value with the next version, evicting other keys first if the write
+// would exceed the limits. Callers must hold shard.mu for writing.
+func (m *Memory) save(shard *memoryShard, key string, value any, ttl time.Duration) (int64, error) {
+	entry := Entry{
+		Value:     value,
+		Version:   m.next(shard, key),
+		ExpiresAt: expiresAt(ttl),
+	}
+
+	if err := m.put(shard, key, entry); err != nil {
+		return 0, err
+	}
+	return entry.Version, nil
+}
+
+// fill stores an entry read from another store as is, keeping its version, unless the cached
+// entry is already as recent or valid reports, under the shard lock, that it went stale.
+func (m *Memory) fill(key string, entry Entry, valid func() bool) error {
+	shard := m.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if !valid() || shard.version(key) >= entry.Version {
+		return nil
+	}
+	return m.put(shard, key, entry)
+}
+
+// put stores the entry, evicting other keys first if it would exceed the limits.
+// Callers must hold shard.mu for writing.
+func (m *Memory) put(shard *memoryShard, key string, entry Entry) error {
+	previous, exists := shard.store[key]
+	item := m.newItem(key, entry, previous)
+
+	keys, bytes := int64(1), item.size
+	if exists {
+		keys, bytes = 0, item.size-previous.size
+	}
+
+	keep := func(k string) bool { return k == key }
+	if err := m.reserve(shard, keep, keys, bytes, 1, item.size); err != nil {
+		return err
+	}
+
+	if m.wal != nil {
+		if err := m.wal.append(setRecord(key, entry)); err != nil {
+			m.release(keys, bytes)
+			return err
+		}
+	}
+
+	shard.store[key] = item
+	m.index.replace(key, previous, item)
 	return nil
 }
+
+// newItem returns the item storing entry, carrying over the history of the item it
+// replaces when history is enabled.
+func (m *Memory) newItem(key string, entry Entry, previous *memoryItem) *memoryItem {
+	item := newMemoryItem(key, entry)
+	if m.limits.History <= 0 {
+		return item
+	}
+
+	now := time.Now()
+	item.writtenAt = now
+	if previous != nil && previous.entry.Version == entry.Version && !isExpired(previous.entry.ExpiresAt, now) {
+		item.writtenAt = previous.writtenAt
+	}
+
+	item.history = keepHistory(previous, entry.Version, m.limits.History, now)
+	for _, revision := range item.history {
+		item.size += valueSize(revision.Value)
+	}
+	return item
+}
+
+// remove deletes the key and retires its version. Callers must hold shard.mu for writing.
+func (m *Memory) remove(shard *memoryShard, key string) error {
+	item := shard.store[key]
+	if m.wal != nil {
+		if err := m.wal.append(deleteRecord(key, item.entry.Version)); err != nil {
+			return err
+		}
+	}
+
+	m.release(1, item.size)
+	delete(shard.store, key)
+	m.index.replace(key, item, nil)
+	m.retire(item.entry.Version)
+	return nil
+}
+
+// next returns the version the next write to key gets: one above its current version, or
+// above every retired version when the key is missing. Callers must hold shard.mu.
+func (m *Memory) next(shard *memoryShard, key string) int64 {
+	item, exists := shard.store[key]
+	switch {
+	case !exists:
+		return m.floor.Load() + 1
+	case isExpired(item.entry.ExpiresAt, time.Now()):
+		// The key expired but was not reaped yet, so its version is not part of the floor.
+		return max(item.entry.Version, m.floor.Load()) + 1
+	default:
+		return item.entry.Version + 1
+	}
+}
+
+// retire raises the version floor to the version of a key that is gone.
+func (m *Memory) retire(version int64) {
+	for {
+		floor := m.floor.Load()
+		if version <= floor || m.floor.CompareAndSwap(floor, version) {
+			return
+		}
+	}
+}
+
+// reserve accounts for a write to shard, evicting keys other than the ones being written
+// until it fits within the limits. keptKeys and keptBytes are the usage of the keys written
+// alone, which no eviction can reduce, so a write that could never fit is rejected before
+// anything is evicted. Callers must hold shard.mu for writing. Collections, which are not
+// stored in a shard, reserve with a nil shard.
+func (m *Memory) reserve(shard *memoryShard, keep func(string) bool, keys, bytes, keptKeys, keptBytes int64) error {
+	if m.limits.MaxKeys > 0 && keptKeys > m.limits.MaxKeys || m.limits.MaxBytes > 0 && keptBytes > m.limits.MaxBytes {
+		return ErrOutOfMemory
+	}
+
+	m.keys.Add(keys)
+	m.bytes.Add(bytes)
+
+	if keys <= 0 && bytes <= 0 {
+		return nil
+	}
+
+	for busy := 0; m.overLimit(); {
+		switch m.evict(shard, keep) {
+		case evicted:
+			busy = 0
+		case evictBusy:
+			// Another writer holds the shards left to evict from, wait for it rather
+			// than failing a write that fits once it is done
+			if busy++; busy <= maxEvictBusyRetries {
+				time.Sleep(evictBusyWait)
+				continue
+			}
+			fallthrough
+		default:
+			m.release(keys, bytes)
+			return ErrOutOfMemory
+		}
+	}
+
+	return nil
+}
+
+func (m *Memory) release(keys, bytes int64) {
+	m.keys.Add(-keys)
+	m.bytes.Add(-bytes)
+}
+
+func (m *Memory) overLimit() bool {
+	return m.limits.MaxKeys > 0 && m.keys.Load() > m.limits.MaxKeys ||
+		m.limits.MaxBytes > 0 && m.bytes.Load() > m.limits.MaxBytes
+}
+
+// evict removes one key, never one being written. It looks in the shard already
+// locked by the caller first, then in the other shards that can be locked without waiting,
+// so two writers evicting from each other's shards cannot deadlock. It reports evictBusy
+// when nothing could be evicted but some shards were skipped because they were locked.
+func (m *Memory) evict(locked *memoryShard, keep func(string) bool) evictResult {
+	if locked != nil && m.evictFrom(locked, keep) {
+		return evicted
+	}
+
+	result := evictNone
+	start := rand.IntN(len(m.shards))
+	for i := range m.shards {
+		shard := m.shards[(start+i)%len(m.shards)]
+		if shard == locked {
+			continue
+		}
+		if !shard.mu.TryLock() {
+			result = evictBusy
+			continue
+		}
+		ok := m.evictFrom(shard, keep)
+		shard.mu.Unlock()
+		if ok {
+			return evicted
+		}
+	}
+
+	return result
+}
+
+// evictFrom samples the shard and removes the best candidate for the policy.
+// Callers must hold shard.mu for writing.
+func (m *Memory) evictFrom(shard *memoryShard, keep func(string) bool) bool {
+	now := time.Now()
+
+	var (
+		victim    string
+		candidate *memoryItem
+		sampled   int
+		scanned   int
+	)
+	for key, item := range shard.store {
+		if scanned++; scanned > evictionScanLimit {
+			break
+		}
+		// Reserved keys register namespaces and soft-deleted keys, so they are never evicted.
+		if keep(key) || strings.HasPrefix(key, reservedKeyPrefix) {
+			continue
+		}
+
+		if isExpired(item.entry.ExpiresAt, now) {
+			victim, candidate = key, item
+			break
+		}
+
+		if m.limits.Eviction == EvictionNone ||
+			m.limits.Eviction == EvictionVolatile && item.entry.ExpiresAt.IsZero() {
+			continue
+		}
+
+		if candidate == nil || item.better(candidate, m.limits.Eviction) {
+			victim, candidate = key, item
+		}
+		if sampled++; sampled == evictionSamples || m.limits.Eviction == EvictionRandom {
+			break
+		}
+	}
+
+	if candidate == nil {
+		return false
+	}
+
+	expired := isExpired(candidate.entry.ExpiresAt, now)
+	if err := m.remove(shard, victim); err != nil {
+		return false
+	}
+
+	if expired {
+		m.expirations.Add(1)
+	} else {
+		m.evictions.Add(1)
+	}
+	return true
+}
+
+// purge drops every key without logging the deletes, for stores used as a cache.
+func (m *Memory) purge() {
+	for _, shard := range m.shards {
+		shard.mu.Lock()
+		for key, item := range shard.store {
+			m.release(1, item.size)
+			delete(shard.store, key)
+			m.index.replace(key, item, nil)
+		}
+		shard.mu.Unlock()
+	}
+}
+
+func (m *Memory) reap(now time.Time) {
+	onExpire := m.onExpire.Load()
+
+	for _, shard := range m.shards {
+		var expired []string
+
+		shard.mu.Lock()
+		for key, item := range shard.store {
+			if isExpired(item.entry.ExpiresAt, now) {
+				m.release(1, item.size)
+				m.expirations.Add(1)
+				delete(shard.store, key)
+				m.index.replace(key, item, nil)
+				m.retire(item.entry.Version)
+				expired = append(expired, key)
+			}
+		}
+		shard.mu.Unlock()
+
+		if onExpire != nil {
+			for _, key := range expired {
+				(*onExpire)(key)
+			}
+		}
+	}
+}
+
+// get returns the live item for key. Callers must hold s.mu.
+func (s *memoryShard) get(key string) (*memoryItem, bool) {
+	item, exists := s.store[key]
+	if !exists || isExpired(item.entry.ExpiresAt, time.Now()) {
+		return nil, false
+	}
+	return item, true
+}
+
+// version returns the version of the live entry for key, zero when there is none.
+// Callers must hold s.mu.
+func (s *memoryShard) version(key string) int64 {
+	if item, exists := s.get(key); exists {
+		return item.entry.Version
+	}
+	return 0
+}
@@ -0,0 +1,59 @@
+package storage_test
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/felipeascari/kv-store/pkg/storage"
+)
+
+const benchmarkKeys = 10_000
+
+// BenchmarkMemoryParallel compares throughput of parallel mixed workloads across shard counts.
+// One shard is the single map behind a single lock the store used to be.
+func BenchmarkMemoryParallel(b *testing.B) {
+	ctx := context.Background()
+
+	keys := make([]string, benchmarkKeys)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key:%d", i)
+	}
+
+	workloads := []struct {
+		name   string
+		writes int // percent of operations that are writes
+	}{
+		{name: "read-heavy", writes: 10},
+		{name: "mixed", writes: 50},
+		{name: "write-heavy", writes: 90},
+	}
+
+	for _, workload := range workloads {
+		for _, shards := range []int{1, 8, storage.DefaultMemoryShards, 128} {
+			b.Run(fmt.Sprintf("%s/shards=%d", workload.name, shards), func(b *testing.B) {
+				store, err := storage.NewMemoryWithOptions(storage.MemoryOptions{Shards: shards})
+				if err != nil {
+					b.Fatal(err)
+				}
+				for _, key := range keys {
+					_, _ = store.Save(ctx, key, "value", 0)
+				}
+
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					rng := rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+					for pb.Next() {
+						key := keys[rng.IntN(len(keys))]
+						if rng.IntN(100) < workload.writes {
+							_, _ = store.Save(ctx, key, "value", 0)
+						} else {
+							_, _ = store.Retrieve(ctx, key)
+						}
+					}
+				})
+			})
+		}
+	}
+}
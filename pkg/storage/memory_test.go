@@ -1,13 +1,19 @@
 package storage_test
 
 import (
+	"context"
+	"fmt"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/felipeascari/kv-store/pkg/storage"
 	"github.com/stretchr/testify/require"
 )
 
 func TestMemorySave(t *testing.T) {
+	ctx := context.Background()
+
 	tests := []struct {
 		name     string
 		key      string
@@ -44,17 +50,19 @@ func TestMemorySave(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			store := storage.NewMemory()
 
-			err := store.Save(tt.key, tt.value)
+			_, err := store.Save(ctx, tt.key, tt.value, 0)
 			require.NoError(t, err)
 
-			value, err := store.Retrieve(tt.key)
+			entry, err := store.Retrieve(ctx, tt.key)
 			require.NoError(t, err)
-			require.Equal(t, tt.expected, value)
+			require.Equal(t, tt.expected, entry.Value)
 		})
 	}
 }
 
 func TestMemoryRetrieve(t *testing.T) {
+	ctx := context.Background()
+
 	tests := []struct {
 		name        string
 		setup       func(*storage.Memory)
@@ -65,7 +73,7 @@ func TestMemoryRetrieve(t *testing.T) {
 		{
 			name: "should retrieve existing key",
 			setup: func(m *storage.Memory) {
-				_ = m.Save("key1", "value1")
+				_, _ = m.Save(ctx, "key1", "value1", 0)
 			},
 			key:         "key1",
 			expectError: false,
@@ -82,9 +90,9 @@ func TestMemoryRetrieve(t *testing.T) {
 		{
 			name: "should retrieve after multiple saves",
 			setup: func(m *storage.Memory) {
-				_ = m.Save("a", "1")
-				_ = m.Save("b", "2")
-				_ = m.Save("c", "3")
+				_, _ = m.Save(ctx, "a", "1", 0)
+				_, _ = m.Save(ctx, "b", "2", 0)
+				_, _ = m.Save(ctx, "c", "3", 0)
 			},
 			key:         "b",
 			expectError: false,
@@ -97,7 +105,7 @@ func TestMemoryRetrieve(t *testing.T) {
 			store := storage.NewMemory()
 			tt.setup(store)
 
-			value, err := store.Retrieve(tt.key)
+			entry, err := store.Retrieve(ctx, tt.key)
 
 			if tt.expectError {
 				require.Error(t, err)
@@ -106,12 +114,14 @@ func TestMemoryRetrieve(t *testing.T) {
 			}
 
 			require.NoError(t, err)
-			require.Equal(t, tt.expectValue, value)
+			require.Equal(t, tt.expectValue, entry.Value)
 		})
 	}
 }
 
 func TestMemoryDelete(t *testing.T) {
+	ctx := context.Background()
+
 	tests := []struct {
 		name        string
 		setup       func(*storage.Memory)
@@ -122,7 +132,7 @@ func TestMemoryDelete(t *testing.T) {
 		{
 			name: "should delete existing key",
 			setup: func(m *storage.Memory) {
-				_ = m.Save("key1", "value1")
+				_, _ = m.Save(ctx, "key1", "value1", 0)
 			},
 			key:         "key1",
 			expectError: false,
@@ -140,9 +150,9 @@ func TestMemoryDelete(t *testing.T) {
 		{
 			name: "should delete one of multiple keys",
 			setup: func(m *storage.Memory) {
-				_ = m.Save("a", "1")
-				_ = m.Save("b", "2")
-				_ = m.Save("c", "3")
+				_, _ = m.Save(ctx, "a", "1", 0)
+				_, _ = m.Save(ctx, "b", "2", 0)
+				_, _ = m.Save(ctx, "c", "3", 0)
 			},
 			key:         "b",
 			expectError: false,
@@ -155,7 +165,7 @@ func TestMemoryDelete(t *testing.T) {
 			store := storage.NewMemory()
 			tt.setup(store)
 
-			err := store.Delete(tt.key)
+			err := store.Delete(ctx, tt.key)
 
 			if tt.expectError {
 				require.Error(t, err)
@@ -166,10 +176,613 @@ func TestMemoryDelete(t *testing.T) {
 			require.NoError(t, err)
 
 			if tt.verifyGone {
-				_, err := store.Retrieve(tt.key)
+				_, err := store.Retrieve(ctx, tt.key)
 				require.Error(t, err)
 				require.ErrorIs(t, err, storage.ErrKeyNotFound)
 			}
 		})
 	}
 }
+
+func TestMemoryTTL(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should return remaining ttl", func(t *testing.T) {
+		store := storage.NewMemory()
+
+		_, err := store.Save(ctx, "session", "abc", time.Minute)
+		require.NoError(t, err)
+
+		entry, err := store.Retrieve(ctx, "session")
+		require.NoError(t, err)
+		require.Equal(t, "abc", entry.Value)
+		require.InDelta(t, time.Minute, entry.TTL(), float64(time.Second))
+	})
+
+	t.Run("should not expire keys saved without ttl", func(t *testing.T) {
+		store := storage.NewMemory()
+
+		_, err := store.Save(ctx, "config", "value", 0)
+		require.NoError(t, err)
+
+		entry, err := store.Retrieve(ctx, "config")
+		require.NoError(t, err)
+		require.True(t, entry.ExpiresAt.IsZero())
+		require.Zero(t, entry.TTL())
+	})
+
+	t.Run("should hide expired keys", func(t *testing.T) {
+		store := storage.NewMemory()
+
+		_, err := store.Save(ctx, "session", "abc", 10*time.Millisecond)
+		require.NoError(t, err)
+		time.Sleep(20 * time.Millisecond)
+
+		_, err = store.Retrieve(ctx, "session")
+		require.ErrorIs(t, err, storage.ErrKeyNotFound)
+		require.ErrorIs(t, store.Delete(ctx, "session"), storage.ErrKeyNotFound)
+	})
+
+	t.Run("should clear ttl when overwritten without ttl", func(t *testing.T) {
+		store := storage.NewMemory()
+
+		_, err := store.Save(ctx, "session", "abc", 10*time.Millisecond)
+		require.NoError(t, err)
+		_, err = store.Save(ctx, "session", "def", 0)
+		require.NoError(t, err)
+		time.Sleep(20 * time.Millisecond)
+
+		entry, err := store.Retrieve(ctx, "session")
+		require.NoError(t, err)
+		require.Equal(t, "def", entry.Value)
+	})
+
+	t.Run("should reap expired keys in background", func(t *testing.T) {
+		store := storage.NewMemory()
+		store.StartReaper(5 * time.Millisecond)
+		defer func() { _ = store.Close() }()
+
+		_, err := store.Save(ctx, "short", "1", 10*time.Millisecond)
+		require.NoError(t, err)
+		_, err = store.Save(ctx, "long", "2", 0)
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool {
+			return store.Len() == 1
+		}, time.Second, 5*time.Millisecond)
+	})
+
+	t.Run("should report the keys it reaps", func(t *testing.T) {
+		store := storage.NewMemory()
+		expired := make(chan string, 1)
+		store.NotifyExpired(func(key string) { expired <- key })
+		store.StartReaper(5 * time.Millisecond)
+		defer func() { _ = store.Close() }()
+
+		_, err := store.Save(ctx, "short", "1", 10*time.Millisecond)
+		require.NoError(t, err)
+
+		select {
+		case key := <-expired:
+			require.Equal(t, "short", key)
+		case <-time.After(time.Second):
+			require.FailNow(t, "expiration not reported")
+		}
+	})
+}
+
+func TestMemoryVersions(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should increment version on every save", func(t *testing.T) {
+		store := storage.NewMemory()
+
+		version, err := store.Save(ctx, "key", "a", 0)
+		require.NoError(t, err)
+		require.Equal(t, int64(1), version)
+
+		version, err = store.Save(ctx, "key", "b", 0)
+		require.NoError(t, err)
+		require.Equal(t, int64(2), version)
+
+		entry, err := store.Retrieve(ctx, "key")
+		require.NoError(t, err)
+		require.Equal(t, int64(2), entry.Version)
+	})
+
+	t.Run("should never reuse a version after delete or expiry", func(t *testing.T) {
+		store := storage.NewMemory()
+
+		_, err := store.Save(ctx, "key", "a", 0)
+		require.NoError(t, err)
+		_, err = store.Save(ctx, "key", "b", 0)
+		require.NoError(t, err)
+		require.NoError(t, store.Delete(ctx, "key"))
+
+		version, err := store.Save(ctx, "key", "c", 0)
+		require.NoError(t, err)
+		require.Equal(t, int64(3), version)
+
+		// A stale ETag of the deleted key must not match the new one
+		_, err = store.CompareAndSwap(ctx, "key", 1, "d", 0)
+		require.ErrorIs(t, err, storage.ErrVersionMismatch)
+
+		_, err = store.Save(ctx, "short", "e", time.Millisecond)
+		require.NoError(t, err)
+		time.Sleep(5 * time.Millisecond)
+
+		version, err = store.Save(ctx, "short", "f", 0)
+		require.NoError(t, err)
+		require.Equal(t, int64(4), version)
+	})
+
+	t.Run("should not create missing keys when expecting version 0", func(t *testing.T) {
+		store := storage.NewMemory()
+
+		ifMatch := storage.Precondition{IfMatch: &storage.VersionMatch{Versions: []int64{0}}}
+		_, err := storage.ResolveVersion(ctx, store, "missing", ifMatch)
+		require.ErrorIs(t, err, storage.ErrVersionMismatch)
+	})
+
+	t.Run("should only delete with If-None-Match: * when nothing would be deleted", func(t *testing.T) {
+		store := storage.NewMemory()
+		_, err := store.Save(ctx, "key", "a", 0)
+		require.NoError(t, err)
+
+		ifNoneMatch := storage.Precondition{IfNoneMatch: &storage.VersionMatch{Any: true}}
+		_, err = storage.ResolveDeleteVersion(ctx, store, "key", ifNoneMatch)
+		require.ErrorIs(t, err, storage.ErrVersionMismatch)
+		_, err = storage.ResolveDeleteVersion(ctx, store, "missing", ifNoneMatch)
+		require.ErrorIs(t, err, storage.ErrKeyNotFound)
+
+		version, err := storage.ResolveDeleteVersion(ctx, store, "key", storage.Precondition{IfMatch: &storage.VersionMatch{Any: true}})
+		require.NoError(t, err)
+		require.Equal(t, int64(1), version)
+	})
+}
+
+func TestMemoryCompareAndSwap(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name            string
+		setup           func(*storage.Memory)
+		expectedVersion int64
+		expectError     error
+		expectVersion   int64
+	}{
+		{
+			name:            "should create key when expecting version 0",
+			setup:           func(_ *storage.Memory) {},
+			expectedVersion: 0,
+			expectVersion:   1,
+		},
+		{
+			name: "should reject create when key exists",
+			setup: func(m *storage.Memory) {
+				_, _ = m.Save(ctx, "key", "old", 0)
+			},
+			expectedVersion: 0,
+			expectError:     storage.ErrVersionMismatch,
+		},
+		{
+			name: "should swap when version matches",
+			setup: func(m *storage.Memory) {
+				_, _ = m.Save(ctx, "key", "old", 0)
+				_, _ = m.Save(ctx, "key", "old", 0)
+			},
+			expectedVersion: 2,
+			expectVersion:   3,
+		},
+		{
+			name: "should reject stale version",
+			setup: func(m *storage.Memory) {
+				_, _ = m.Save(ctx, "key", "old", 0)
+				_, _ = m.Save(ctx, "key", "old", 0)
+			},
+			expectedVersion: 1,
+			expectError:     storage.ErrVersionMismatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := storage.NewMemory()
+			tt.setup(store)
+
+			version, err := store.CompareAndSwap(ctx, "key", tt.expectedVersion, "new", 0)
+
+			if tt.expectError != nil {
+				require.ErrorIs(t, err, tt.expectError)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.expectVersion, version)
+
+			entry, err := store.Retrieve(ctx, "key")
+			require.NoError(t, err)
+			require.Equal(t, "new", entry.Value)
+		})
+	}
+}
+
+func TestMemoryCompareAndDelete(t *testing.T) {
+	ctx := context.Background()
+
+	store := storage.NewMemory()
+
+	require.ErrorIs(t, store.CompareAndDelete(ctx, "key", 1), storage.ErrKeyNotFound)
+
+	_, err := store.Save(ctx, "key", "value", 0)
+	require.NoError(t, err)
+
+	require.ErrorIs(t, store.CompareAndDelete(ctx, "key", 2), storage.ErrVersionMismatch)
+	require.NoError(t, store.CompareAndDelete(ctx, "key", 1))
+
+	_, err = store.Retrieve(ctx, "key")
+	require.ErrorIs(t, err, storage.ErrKeyNotFound)
+}
+
+func TestMemoryScan(t *testing.T) {
+	ctx := context.Background()
+
+	store := storage.NewMemory()
+	for _, key := range []string{"user:3", "user:1", "order:1", "user:2", "user:4", "user:5"} {
+		_, err := store.Save(ctx, key, "value", 0)
+		require.NoError(t, err)
+	}
+
+	t.Run("should page through keys in order", func(t *testing.T) {
+		page, err := store.Scan(ctx, "user:", "", 2)
+		require.NoError(t, err)
+		require.Equal(t, []string{"user:1", "user:2"}, page.Keys)
+		require.NotEmpty(t, page.Cursor)
+
+		page, err = store.Scan(ctx, "user:", page.Cursor, 2)
+		require.NoError(t, err)
+		require.Equal(t, []string{"user:3", "user:4"}, page.Keys)
+
+		page, err = store.Scan(ctx, "user:", page.Cursor, 2)
+		require.NoError(t, err)
+		require.Equal(t, []string{"user:5"}, page.Keys)
+		require.Empty(t, page.Cursor)
+	})
+
+	t.Run("should return all keys without prefix", func(t *testing.T) {
+		page, err := store.Scan(ctx, "", "", 10)
+		require.NoError(t, err)
+		require.Len(t, page.Keys, 6)
+		require.Equal(t, "order:1", page.Keys[0])
+		require.Empty(t, page.Cursor)
+	})
+
+	t.Run("should skip expired keys", func(t *testing.T) {
+		_, err := store.Save(ctx, "user:0", "value", time.Nanosecond)
+		require.NoError(t, err)
+		time.Sleep(time.Millisecond)
+
+		page, err := store.Scan(ctx, "user:0", "", 10)
+		require.NoError(t, err)
+		require.Empty(t, page.Keys)
+	})
+
+	t.Run("should reject invalid cursor", func(t *testing.T) {
+		_, err := store.Scan(ctx, "", "not a cursor!", 10)
+		require.ErrorIs(t, err, storage.ErrInvalidCursor)
+	})
+
+	t.Run("should stop when context is cancelled", func(t *testing.T) {
+		items := make([]storage.BatchItem, 5000)
+		for i := range items {
+			items[i] = storage.BatchItem{Key: fmt.Sprintf("bulk:%d", i), Value: i}
+		}
+		_, err := store.BatchSave(ctx, items)
+		require.NoError(t, err)
+
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+
+		_, err = store.Scan(cancelled, "bulk:", "", 10)
+		require.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func TestMemoryBatch(t *testing.T) {
+	ctx := context.Background()
+
+	store := storage.NewMemory()
+
+	saved, err := store.BatchSave(ctx, []storage.BatchItem{
+		{Key: "a", Value: "1"},
+		{Key: "b", Value: "2", TTL: time.Minute},
+		{Key: "a", Value: "3"},
+	})
+	require.NoError(t, err)
+	require.Len(t, saved, 3)
+	require.Equal(t, int64(1), saved[0].Entry.Version)
+	require.False(t, saved[1].Entry.ExpiresAt.IsZero())
+	require.Equal(t, int64(2), saved[2].Entry.Version)
+
+	retrieved, err := store.BatchRetrieve(ctx, []string{"a", "missing", "b"})
+	require.NoError(t, err)
+	require.Equal(t, "3", retrieved[0].Entry.Value)
+	require.ErrorIs(t, retrieved[1].Err, storage.ErrKeyNotFound)
+	require.Equal(t, "2", retrieved[2].Entry.Value)
+
+	deleted, err := store.BatchDelete(ctx, []string{"a", "missing"})
+	require.NoError(t, err)
+	require.NoError(t, deleted[0].Err)
+	require.ErrorIs(t, deleted[1].Err, storage.ErrKeyNotFound)
+
+	_, err = store.Retrieve(ctx, "a")
+	require.ErrorIs(t, err, storage.ErrKeyNotFound)
+}
+
+func TestMemoryShards(t *testing.T) {
+	ctx := context.Background()
+
+	for _, shards := range []int{1, 4, storage.DefaultMemoryShards} {
+		t.Run(fmt.Sprintf("shards=%d", shards), func(t *testing.T) {
+			m, err := storage.NewMemoryWithOptions(storage.MemoryOptions{Shards: shards})
+			require.NoError(t, err)
+
+			const (
+				writers = 8
+				keys    = 50
+				rounds  = 20
+			)
+
+			var wg sync.WaitGroup
+			for range writers {
+				wg.Go(func() {
+					for range rounds {
+						for i := range keys {
+							_, _ = m.Save(ctx, fmt.Sprintf("key:%02d", i), i, 0)
+						}
+					}
+				})
+			}
+			wg.Wait()
+
+			require.Equal(t, keys, m.Len())
+
+			entry, err := m.Retrieve(ctx, "key:07")
+			require.NoError(t, err)
+			require.Equal(t, int64(writers*rounds), entry.Version)
+
+			page, err := m.Scan(ctx, "key:", "", 10)
+			require.NoError(t, err)
+			require.Equal(t, []string{"key:00", "key:01", "key:02", "key:03", "key:04", "key:05", "key:06", "key:07", "key:08", "key:09"}, page.Keys)
+
+			results, err := m.BatchDelete(ctx, []string{"key:00", "key:49", "missing"})
+			require.NoError(t, err)
+			require.NoError(t, results[0].Err)
+			require.NoError(t, results[1].Err)
+			require.ErrorIs(t, results[2].Err, storage.ErrKeyNotFound)
+			require.Equal(t, keys-2, m.Len())
+		})
+	}
+}
+
+func TestMemoryTransact(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should apply every operation when checks hold", func(t *testing.T) {
+		m := storage.NewMemory()
+		_, _ = m.Save(ctx, "account:a", 100, 0)
+		_, _ = m.Save(ctx, "account:b", 0, 0)
+		_, _ = m.Save(ctx, "pending", "x", 0)
+
+		results, err := m.Transact(ctx, storage.Transaction{
+			Checks: []storage.TxCheck{{Key: "account:a", Version: 1}, {Key: "account:b", Version: 1}, {Key: "log", Version: 0}},
+			Ops: []storage.TxOp{
+				{Type: storage.TxSet, Key: "account:a", Value: 70},
+				{Type: storage.TxSet, Key: "account:b", Value: 30},
+				{Type: storage.TxSet, Key: "log", Value: "moved 30", TTL: time.Hour},
+				{Type: storage.TxDelete, Key: "pending"},
+			},
+		})
+		require.NoError(t, err)
+		require.Len(t, results, 4)
+		require.Equal(t, storage.TxResult{Key: "account:a", Version: 2}, results[0])
+		require.Equal(t, int64(1), results[2].Version)
+		require.False(t, results[2].ExpiresAt.IsZero())
+		require.Equal(t, storage.TxResult{Key: "pending"}, results[3])
+
+		entry, err := m.Retrieve(ctx, "account:b")
+		require.NoError(t, err)
+		require.Equal(t, 30, entry.Value)
+
+		_, err = m.Retrieve(ctx, "pending")
+		require.ErrorIs(t, err, storage.ErrKeyNotFound)
+	})
+
+	t.Run("should apply operations on the same key in order", func(t *testing.T) {
+		m := storage.NewMemory()
+
+		results, err := m.Transact(ctx, storage.Transaction{
+			Ops: []storage.TxOp{
+				{Type: storage.TxSet, Key: "a", Value: 1},
+				{Type: storage.TxDelete, Key: "a"},
+				{Type: storage.TxSet, Key: "a", Value: 2},
+				{Type: storage.TxSet, Key: "a", Value: 3},
+			},
+		})
+		require.NoError(t, err)
+		require.Equal(t, []int64{1, 0, 2, 3}, []int64{results[0].Version, results[1].Version, results[2].Version, results[3].Version})
+
+		entry, err := m.Retrieve(ctx, "a")
+		require.NoError(t, err)
+		require.Equal(t, 3, entry.Value)
+		require.Equal(t, int64(3), entry.Version)
+	})
+
+	t.Run("should write nothing when a check fails", func(t *testing.T) {
+		m := storage.NewMemory()
+		_, _ = m.Save(ctx, "a", "old", 0)
+		_, _ = m.Save(ctx, "b", "old", 0)
+
+		_, err := m.Transact(ctx, storage.Transaction{
+			Checks: []storage.TxCheck{{Key: "a", Version: 1}, {Key: "b", Version: 7}},
+			Ops: []storage.TxOp{
+				{Type: storage.TxSet, Key: "a", Value: "new"},
+				{Type: storage.TxSet, Key: "b", Value: "new"},
+			},
+		})
+		require.ErrorIs(t, err, storage.ErrVersionMismatch)
+
+		var check *storage.TxCheckError
+		require.ErrorAs(t, err, &check)
+		require.Equal(t, storage.TxCheckError{Key: "b", Expected: 7, Actual: 1}, *check)
+
+		entry, err := m.Retrieve(ctx, "a")
+		require.NoError(t, err)
+		require.Equal(t, "old", entry.Value)
+	})
+
+	t.Run("should write nothing when the memory limit is reached", func(t *testing.T) {
+		m, err := storage.NewMemoryWithOptions(storage.MemoryOptions{MaxKeys: 2})
+		require.NoError(t, err)
+		_, _ = m.Save(ctx, "a", 1, 0)
+
+		_, err = m.Transact(ctx, storage.Transaction{
+			Ops: []storage.TxOp{
+				{Type: storage.TxSet, Key: "a", Value: 2},
+				{Type: storage.TxSet, Key: "b", Value: 2},
+				{Type: storage.TxSet, Key: "c", Value: 2},
+			},
+		})
+		require.ErrorIs(t, err, storage.ErrOutOfMemory)
+		require.Equal(t, 1, m.Len())
+
+		entry, err := m.Retrieve(ctx, "a")
+		require.NoError(t, err)
+		require.Equal(t, 1, entry.Value)
+	})
+
+	t.Run("should reject invalid transactions", func(t *testing.T) {
+		m := storage.NewMemory()
+
+		_, err := m.Transact(ctx, storage.Transaction{})
+		require.ErrorIs(t, err, storage.ErrEmptyTransaction)
+
+		_, err = m.Transact(ctx, storage.Transaction{Ops: []storage.TxOp{{Type: "incr", Key: "a"}}})
+		require.ErrorIs(t, err, storage.ErrUnknownTxOp)
+	})
+}
+
+func TestMemoryIncrement(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should start missing keys at zero", func(t *testing.T) {
+		m := storage.NewMemory()
+
+		entry, err := m.Increment(ctx, "hits", 1)
+		require.NoError(t, err)
+		require.Equal(t, float64(1), entry.Value)
+		require.Equal(t, int64(1), entry.Version)
+
+		entry, err = m.Increment(ctx, "hits", -3)
+		require.NoError(t, err)
+		require.Equal(t, float64(-2), entry.Value)
+		require.Equal(t, int64(2), entry.Version)
+	})
+
+	t.Run("should add fractional deltas", func(t *testing.T) {
+		m := storage.NewMemory()
+		_, _ = m.Save(ctx, "balance", 10, 0)
+
+		entry, err := m.Increment(ctx, "balance", 0.5)
+		require.NoError(t, err)
+		require.Equal(t, 10.5, entry.Value)
+	})
+
+	t.Run("should keep the expiration", func(t *testing.T) {
+		m := storage.NewMemory()
+		_, _ = m.Save(ctx, "hits", 1, time.Hour)
+
+		before, err := m.Retrieve(ctx, "hits")
+		require.NoError(t, err)
+
+		entry, err := m.Increment(ctx, "hits", 1)
+		require.NoError(t, err)
+		require.Equal(t, before.ExpiresAt, entry.ExpiresAt)
+	})
+
+	t.Run("should reject non-numeric values", func(t *testing.T) {
+		m := storage.NewMemory()
+		_, _ = m.Save(ctx, "name", "Alice", 0)
+
+		_, err := m.Increment(ctx, "name", 1)
+		require.ErrorIs(t, err, storage.ErrNotNumeric)
+
+		entry, err := m.Retrieve(ctx, "name")
+		require.NoError(t, err)
+		require.Equal(t, int64(1), entry.Version)
+	})
+
+	t.Run("should not lose concurrent increments", func(t *testing.T) {
+		m := storage.NewMemory()
+
+		var wg sync.WaitGroup
+		for range 50 {
+			wg.Go(func() {
+				_, _ = m.Increment(ctx, "hits", 1)
+			})
+		}
+		wg.Wait()
+
+		entry, err := m.Retrieve(ctx, "hits")
+		require.NoError(t, err)
+		require.Equal(t, float64(50), entry.Value)
+	})
+}
+
+func TestMemoryUpdate(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should replace the value and keep the expiration", func(t *testing.T) {
+		m := storage.NewMemory()
+		_, _ = m.Save(ctx, "user", map[string]any{"name": "Alice"}, time.Hour)
+
+		before, err := m.Retrieve(ctx, "user")
+		require.NoError(t, err)
+
+		entry, err := m.Update(ctx, "user", func(current storage.Entry) (any, error) {
+			require.Equal(t, int64(1), current.Version)
+			return map[string]any{"name": "Bob"}, nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, int64(2), entry.Version)
+		require.Equal(t, before.ExpiresAt, entry.ExpiresAt)
+
+		entry, err = m.Retrieve(ctx, "user")
+		require.NoError(t, err)
+		require.Equal(t, map[string]any{"name": "Bob"}, entry.Value)
+	})
+
+	t.Run("should fail on missing keys", func(t *testing.T) {
+		m := storage.NewMemory()
+
+		_, err := m.Update(ctx, "missing", func(storage.Entry) (any, error) {
+			t.Fatal("update function called for a missing key")
+			return nil, nil
+		})
+		require.ErrorIs(t, err, storage.ErrKeyNotFound)
+	})
+
+	t.Run("should write nothing when the function fails", func(t *testing.T) {
+		m := storage.NewMemory()
+		_, _ = m.Save(ctx, "user", "Alice", 0)
+
+		_, err := m.Update(ctx, "user", func(storage.Entry) (any, error) {
+			return nil, storage.ErrVersionMismatch
+		})
+		require.ErrorIs(t, err, storage.ErrVersionMismatch)
+
+		entry, err := m.Retrieve(ctx, "user")
+		require.NoError(t, err)
+		require.Equal(t, int64(1), entry.Version)
+	})
+}
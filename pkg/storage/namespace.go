@@ -0,0 +1,693 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Namespaces partition the keyspace: the key "config" of the namespace "billing" is stored as
+// "kv-store:ns:billing:config", so its locks, fencing counter and cache entries are its own.
+// Keys of the default namespace are stored as they are, except that the "kv-store:" prefix
+// is reserved for namespaces and the store's own bookkeeping.
+const (
+	reservedKeyPrefix  = "kv-store:"
+	namespaceKeyPrefix = reservedKeyPrefix + "ns:"
+	// namespaceRecordPrefix holds one key per namespace, registering it.
+	namespaceRecordPrefix = reservedKeyPrefix + "namespace:"
+
+	namespaceDeleteBatch = 1000
+	// maxNamespacePurgePasses bounds the passes deleting the contents of a namespace that
+	// requests keep writing to.
+	maxNamespacePurgePasses = 10
+	// namespaceCacheTTL bounds how long a namespace deleted by another server keeps being
+	// found by a registry that looked it up before.
+	namespaceCacheTTL = time.Second
+)
+
+var (
+	ErrInvalidNamespace  = errors.New("namespace must be 1 to 63 lowercase letters, digits, '-' or '_'")
+	ErrNamespaceNotFound = errors.New("namespace not found")
+	ErrNamespaceExists   = errors.New("namespace already exists")
+	ErrReservedKey       = fmt.Errorf("keys starting with %q are reserved", reservedKeyPrefix)
+
+	namespacePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+)
+
+type (
+	namespaceContextKey struct{}
+
+	// Namespaced scopes every call to the namespace carried by its context, see WithNamespace,
+	// and to the default namespace otherwise. Keys are translated on the way in and out, so
+	// the stores below only ever see full keys.
+	Namespaced struct {
+		store Store
+	}
+
+	// NamespaceInfo describes a registered namespace.
+	NamespaceInfo struct {
+		Name      string
+		CreatedAt time.Time
+	}
+
+	// Namespaces is the registry of namespaces, kept as reserved keys of a store. Every request
+	// to a namespace looks it up, so lookups read the records without locking them and the
+	// namespaces found are cached for namespaceCacheTTL, or until they are deleted here.
+	Namespaces struct {
+		store Store
+		mu    sync.Mutex
+		known map[string]cachedNamespace
+	}
+
+	cachedNamespace struct {
+		info      NamespaceInfo
+		expiresAt time.Time
+	}
+)
+
+// WithNamespace returns a context scoping Namespaced stores to namespace.
+func WithNamespace(ctx context.Context, namespace string) context.Context {
+	return context.WithValue(ctx, namespaceContextKey{}, namespace)
+}
+
+// NamespaceFromContext returns the namespace of ctx, empty for the default namespace.
+func NamespaceFromContext(ctx context.Context) string {
+	namespace, _ := ctx.Value(namespaceContextKey{}).(string)
+	return namespace
+}
+
+func ValidateNamespace(namespace string) error {
+	if !namespacePattern.MatchString(namespace) {
+		return ErrInvalidNamespace
+	}
+	return nil
+}
+
+// KeyNamespace returns the namespace a stored key belongs to, empty for the default namespace.
+func KeyNamespace(key string) string {
+	namespace, _ := splitNamespace(key)
+	return namespace
+}
+
+// LocalKey splits a stored key into its namespace and the key within the namespace, and
+// reports false for the store's own bookkeeping keys.
+func LocalKey(key string) (string, string, bool) {
+	namespace, local := splitNamespace(key)
+	if namespace == "" && strings.HasPrefix(local, reservedKeyPrefix) {
+		return "", "", false
+	}
+	return namespace, local, true
+}
+
+// splitNamespace splits a stored key into its namespace and the key within the namespace.
+func splitNamespace(key string) (string, string) {
+	rest, ok := strings.CutPrefix(key, namespaceKeyPrefix)
+	if !ok {
+		return "", key
+	}
+	namespace, local, ok := strings.Cut(rest, ":")
+	if !ok {
+		return "", key
+	}
+	return namespace, local
+}
+
+func namespacePrefix(namespace string) string {
+	return namespaceKeyPrefix + namespace + ":"
+}
+
+func NewNamespaced(store Store) *Namespaced {
+	return &Namespaced{store: store}
+}
+
+func (n *Namespaced) Save(ctx context.Context, key string, value any, ttl time.Duration) (int64, error) {
+	key, err := n.key(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	return n.store.Save(ctx, key, value, ttl)
+}
+
+func (n *Namespaced) Retrieve(ctx context.Context, key string) (Entry, error) {
+	key, err := n.key(ctx, key)
+	if err != nil {
+		return Entry{}, err
+	}
+	return n.store.Retrieve(ctx, key)
+}
+
+func (n *Namespaced) Delete(ctx context.Context, key string) error {
+	key, err := n.key(ctx, key)
+	if err != nil {
+		return err
+	}
+	return n.store.Delete(ctx, key)
+}
+
+func (n *Namespaced) CompareAndSwap(ctx context.Context, key string, expectedVersion int64, value any, ttl time.Duration) (int64, error) {
+	key, err := n.key(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	return n.store.CompareAndSwap(ctx, key, expectedVersion, value, ttl)
+}
+
+func (n *Namespaced) CompareAndDelete(ctx context.Context, key string, expectedVersion int64) error {
+	key, err := n.key(ctx, key)
+	if err != nil {
+		return err
+	}
+	return n.store.CompareAndDelete(ctx, key, expectedVersion)
+}
+
+func (n *Namespaced) Increment(ctx context.Context, key string, delta float64) (Entry, error) {
+	key, err := n.key(ctx, key)
+	if err != nil {
+		return Entry{}, err
+	}
+	return n.store.Increment(ctx, key, delta)
+}
+
+func (n *Namespaced) Update(ctx context.Context, key string, fn UpdateFunc) (Entry, error) {
+	key, err := n.key(ctx, key)
+	if err != nil {
+		return Entry{}, err
+	}
+	return n.store.Update(ctx, key, fn)
+}
+
+// Scan lists the keys of the namespace. In the default namespace reserved keys are left out,
+// so a page may hold fewer keys than the limit while more remain.
+func (n *Namespaced) Scan(ctx context.Context, prefix, cursor string, limit int) (ScanResult, error) {
+	namespace := NamespaceFromContext(ctx)
+
+	result, err := n.store.Scan(ctx, n.prefix(namespace, prefix), cursor, limit)
+	if err != nil {
+		return ScanResult{}, err
+	}
+
+	keys := result.Keys[:0]
+	for _, key := range result.Keys {
+		if local, ok := n.local(namespace, key); ok {
+			keys = append(keys, local)
+		}
+	}
+	result.Keys = keys
+
+	return result, nil
+}
+
+func (n *Namespaced) BatchSave(ctx context.Context, items []BatchItem) ([]BatchResult, error) {
+	keys := make([]string, len(items))
+	for i, item := range items {
+		keys[i] = item.Key
+	}
+
+	return n.batch(ctx, keys, func(indexes []int, stored []string) ([]BatchResult, error) {
+		scoped := make([]BatchItem, len(indexes))
+		for j, i := range indexes {
+			scoped[j] = items[i]
+			scoped[j].Key = stored[j]
+		}
+		return n.store.BatchSave(ctx, scoped)
+	})
+}
+
+func (n *Namespaced) BatchRetrieve(ctx context.Context, keys []string) ([]BatchResult, error) {
+	return n.batch(ctx, keys, func(_ []int, stored []string) ([]BatchResult, error) {
+		return n.store.BatchRetrieve(ctx, stored)
+	})
+}
+
+func (n *Namespaced) BatchDelete(ctx context.Context, keys []string) ([]BatchResult, error) {
+	return n.batch(ctx, keys, func(_ []int, stored []string) ([]BatchResult, error) {
+		return n.store.BatchDelete(ctx, stored)
+	})
+}
+
+func (n *Namespaced) Transact(ctx context.Context, tx Transaction) ([]TxResult, error) {
+	scoped := Transaction{
+		Checks: make([]TxCheck, len(tx.Checks)),
+		Ops:    make([]TxOp, len(tx.Ops)),
+	}
+	for i, check := range tx.Checks {
+		key, err := n.key(ctx, check.Key)
+		if err != nil {
+			return nil, err
+		}
+		scoped.Checks[i] = TxCheck{Key: key, Version: check.Version}
+	}
+	for i, op := range tx.Ops {
+		key, err := n.key(ctx, op.Key)
+		if err != nil {
+			return nil, err
+		}
+		op.Key = key
+		scoped.Ops[i] = op
+	}
+
+	results, err := n.store.Transact(ctx, scoped)
+
+	var check *TxCheckError
+	if errors.As(err, &check) {
+		_, check.Key = splitNamespace(check.Key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range results {
+		results[i].Key = tx.Ops[i].Key
+	}
+	return results, nil
+}
+
+// Query runs the query within the namespace. Indexes are declared per namespace-relative
+// prefix, so every namespace gets its own copy of each index.
+func (n *Namespaced) Query(ctx context.Context, q Query) (QueryResult, error) {
+	querier, ok := n.store.(Querier)
+	if !ok {
+		return QueryResult{}, ErrQueryUnsupported
+	}
+
+	namespace := NamespaceFromContext(ctx)
+	q.Prefix = n.prefix(namespace, q.Prefix)
+
+	result, err := querier.Query(ctx, q)
+	if err != nil {
+		return QueryResult{}, err
+	}
+
+	items := result.Items[:0]
+	for _, item := range result.Items {
+		if local, ok := n.local(namespace, item.Key); ok {
+			item.Key = local
+			items = append(items, item)
+		}
+	}
+	result.Items = items
+
+	return result, nil
+}
+
+func (n *Namespaced) History(ctx context.Context, key string) ([]Revision, error) {
+	key, err := n.key(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return historyOf(ctx, n.store, key)
+}
+
+func (n *Namespaced) Undelete(ctx context.Context, key string) (Entry, error) {
+	undeleter, ok := n.store.(Undeleter)
+	if !ok {
+		return Entry{}, ErrSoftDeleteUnsupported
+	}
+
+	key, err := n.key(ctx, key)
+	if err != nil {
+		return Entry{}, err
+	}
+	return undeleter.Undelete(ctx, key)
+}
+
+func (n *Namespaced) HashSet(ctx context.Context, key string, fields map[string]any) (int, error) {
+	c, err := collectionsOf(n.store)
+	if err != nil {
+		return 0, err
+	}
+	if key, err = n.key(ctx, key); err != nil {
+		return 0, err
+	}
+	return c.HashSet(ctx, key, fields)
+}
+
+func (n *Namespaced) HashGet(ctx context.Context, key, field string) (any, error) {
+	c, err := collectionsOf(n.store)
+	if err != nil {
+		return nil, err
+	}
+	if key, err = n.key(ctx, key); err != nil {
+		return nil, err
+	}
+	return c.HashGet(ctx, key, field)
+}
+
+func (n *Namespaced) HashGetAll(ctx context.Context, key string) (map[string]any, error) {
+	c, err := collectionsOf(n.store)
+	if err != nil {
+		return nil, err
+	}
+	if key, err = n.key(ctx, key); err != nil {
+		return nil, err
+	}
+	return c.HashGetAll(ctx, key)
+}
+
+func (n *Namespaced) ListPush(ctx context.Context, key string, values []any) (int, error) {
+	c, err := collectionsOf(n.store)
+	if err != nil {
+		return 0, err
+	}
+	if key, err = n.key(ctx, key); err != nil {
+		return 0, err
+	}
+	return c.ListPush(ctx, key, values)
+}
+
+func (n *Namespaced) ListPop(ctx context.Context, key string) (any, error) {
+	c, err := collectionsOf(n.store)
+	if err != nil {
+		return nil, err
+	}
+	if key, err = n.key(ctx, key); err != nil {
+		return nil, err
+	}
+	return c.ListPop(ctx, key)
+}
+
+func (n *Namespaced) ListRange(ctx context.Context, key string, start, stop int) ([]any, error) {
+	c, err := collectionsOf(n.store)
+	if err != nil {
+		return nil, err
+	}
+	if key, err = n.key(ctx, key); err != nil {
+		return nil, err
+	}
+	return c.ListRange(ctx, key, start, stop)
+}
+
+func (n *Namespaced) SetAdd(ctx context.Context, key string, members []string) (int, error) {
+	c, err := collectionsOf(n.store)
+	if err != nil {
+		return 0, err
+	}
+	if key, err = n.key(ctx, key); err != nil {
+		return 0, err
+	}
+	return c.SetAdd(ctx, key, members)
+}
+
+func (n *Namespaced) SetMembers(ctx context.Context, key string) ([]string, error) {
+	c, err := collectionsOf(n.store)
+	if err != nil {
+		return nil, err
+	}
+	if key, err = n.key(ctx, key); err != nil {
+		return nil, err
+	}
+	return c.SetMembers(ctx, key)
+}
+
+func (n *Namespaced) SortedSetAdd(ctx context.Context, key string, members []ScoredMember) (int, error) {
+	c, err := collectionsOf(n.store)
+	if err != nil {
+		return 0, err
+	}
+	if key, err = n.key(ctx, key); err != nil {
+		return 0, err
+	}
+	return c.SortedSetAdd(ctx, key, members)
+}
+
+func (n *Namespaced) SortedSetRange(ctx context.Context, key string, start, stop int) ([]ScoredMember, error) {
+	c, err := collectionsOf(n.store)
+	if err != nil {
+		return nil, err
+	}
+	if key, err = n.key(ctx, key); err != nil {
+		return nil, err
+	}
+	return c.SortedSetRange(ctx, key, start, stop)
+}
+
+// key returns the stored key of key in the namespace of ctx.
+func (n *Namespaced) key(ctx context.Context, key string) (string, error) {
+	namespace := NamespaceFromContext(ctx)
+	if namespace == "" {
+		if strings.HasPrefix(key, reservedKeyPrefix) {
+			return "", ErrReservedKey
+		}
+		return key, nil
+	}
+	return namespacePrefix(namespace) + key, nil
+}
+
+func (n *Namespaced) prefix(namespace, prefix string) string {
+	if namespace == "" {
+		return prefix
+	}
+	return namespacePrefix(namespace) + prefix
+}
+
+// local returns the key within namespace of a stored key, false if the key is not part of it.
+func (n *Namespaced) local(namespace, key string) (string, bool) {
+	if namespace == "" {
+		return key, !strings.HasPrefix(key, reservedKeyPrefix)
+	}
+	return strings.CutPrefix(key, namespacePrefix(namespace))
+}
+
+// batch runs fn on the stored keys of the valid keys, given with their positions in keys,
+// and merges its results with an ErrReservedKey result for every other key.
+func (n *Namespaced) batch(ctx context.Context, keys []string, fn func(indexes []int, stored []string) ([]BatchResult, error)) ([]BatchResult, error) {
+	results := make([]BatchResult, len(keys))
+
+	var (
+		indexes []int
+		stored  []string
+	)
+	for i, key := range keys {
+		scoped, err := n.key(ctx, key)
+		if err != nil {
+			results[i] = BatchResult{Key: key, Err: err}
+			continue
+		}
+		indexes = append(indexes, i)
+		stored = append(stored, scoped)
+	}
+
+	if len(stored) == 0 {
+		return results, nil
+	}
+
+	batch, err := fn(indexes, stored)
+	if err != nil {
+		return nil, err
+	}
+
+	for j, result := range batch {
+		result.Key = keys[indexes[j]]
+		results[indexes[j]] = result
+	}
+	return results, nil
+}
+
+func NewNamespaces(store Store) *Namespaces {
+	return &Namespaces{store: store, known: make(map[string]cachedNamespace)}
+}
+
+func (n *Namespaces) Create(ctx context.Context, name string) (NamespaceInfo, error) {
+	if err := ValidateNamespace(name); err != nil {
+		return NamespaceInfo{}, err
+	}
+
+	info := NamespaceInfo{Name: name, CreatedAt: time.Now().UTC()}
+	record := map[string]any{"created_at": info.CreatedAt.Format(time.RFC3339Nano)}
+
+	if _, err := n.store.CompareAndSwap(ctx, namespaceRecordPrefix+name, 0, record, 0); err != nil {
+		if errors.Is(err, ErrVersionMismatch) {
+			return NamespaceInfo{}, ErrNamespaceExists
+		}
+		return NamespaceInfo{}, err
+	}
+
+	n.remember(info)
+	return info, nil
+}
+
+func (n *Namespaces) Get(ctx context.Context, name string) (NamespaceInfo, error) {
+	if err := ValidateNamespace(name); err != nil {
+		return NamespaceInfo{}, ErrNamespaceNotFound
+	}
+
+	n.mu.Lock()
+	cached, ok := n.known[name]
+	n.mu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.info, nil
+	}
+
+	entry, err := directStore(n.store).Retrieve(ctx, namespaceRecordPrefix+name)
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			n.forget(name)
+			return NamespaceInfo{}, ErrNamespaceNotFound
+		}
+		return NamespaceInfo{}, err
+	}
+
+	info := namespaceInfo(name, entry)
+	n.remember(info)
+	return info, nil
+}
+
+// List returns every namespace in name order.
+func (n *Namespaces) List(ctx context.Context) ([]NamespaceInfo, error) {
+	var (
+		namespaces []NamespaceInfo
+		cursor     string
+	)
+	for {
+		page, err := n.store.Scan(ctx, namespaceRecordPrefix, cursor, DefaultScanLimit)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(page.Keys) > 0 {
+			results, err := n.store.BatchRetrieve(ctx, page.Keys)
+			if err != nil {
+				return nil, err
+			}
+			for _, result := range results {
+				if result.Err != nil {
+					continue
+				}
+				name := strings.TrimPrefix(result.Key, namespaceRecordPrefix)
+				namespaces = append(namespaces, namespaceInfo(name, result.Entry))
+			}
+		}
+
+		if page.Cursor == "" {
+			break
+		}
+		cursor = page.Cursor
+	}
+
+	// Redis scans in no particular order.
+	slices.SortFunc(namespaces, func(a, b NamespaceInfo) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return namespaces, nil
+}
+
+// Delete deletes the keys of the namespace, the tombstones of its deleted keys and its
+// collections, then unregisters it so it stops accepting requests. Requests already scoped
+// to the namespace may still write to it, so its contents are deleted in passes until one
+// finds nothing left, both before and after unregistering. It returns the number of keys deleted.
+func (n *Namespaces) Delete(ctx context.Context, name string) (int, error) {
+	if _, err := n.Get(ctx, name); err != nil {
+		return 0, err
+	}
+
+	deleted, err := n.purge(ctx, name)
+	if err != nil {
+		return deleted, err
+	}
+
+	err = n.store.Delete(ctx, namespaceRecordPrefix+name)
+	n.forget(name)
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return deleted, ErrNamespaceNotFound
+		}
+		return deleted, err
+	}
+
+	late, err := n.purge(ctx, name)
+	return deleted + late, err
+}
+
+// purge deletes the contents of the namespace in passes, until a pass finds nothing left or
+// maxNamespacePurgePasses were made while writers kept adding keys. It returns the number of
+// keys and collections deleted.
+func (n *Namespaces) purge(ctx context.Context, name string) (int, error) {
+	prefix := namespacePrefix(name)
+
+	var deleted int
+	for range maxNamespacePurgePasses {
+		keys, err := n.deletePrefix(ctx, prefix)
+		deleted += keys
+		if err != nil {
+			return deleted, err
+		}
+
+		tombstones, err := n.deletePrefix(ctx, tombstoneKey(prefix))
+		if err != nil {
+			return deleted, err
+		}
+
+		var collections int
+		if deleter, ok := n.store.(collectionDeleter); ok {
+			collections, err = deleter.DeleteCollections(ctx, prefix)
+			deleted += collections
+			if err != nil {
+				return deleted, err
+			}
+		}
+
+		if keys+tombstones+collections == 0 {
+			break
+		}
+	}
+
+	return deleted, nil
+}
+
+// deletePrefix deletes every key starting with prefix and returns how many it deleted.
+func (n *Namespaces) deletePrefix(ctx context.Context, prefix string) (int, error) {
+	var (
+		deleted int
+		cursor  string
+	)
+	for {
+		page, err := n.store.Scan(ctx, prefix, cursor, namespaceDeleteBatch)
+		if err != nil {
+			return deleted, err
+		}
+
+		if len(page.Keys) > 0 {
+			results, err := n.store.BatchDelete(ctx, page.Keys)
+			if err != nil {
+				return deleted, err
+			}
+			for _, result := range results {
+				if result.Err == nil {
+					deleted++
+				}
+			}
+		}
+
+		if page.Cursor == "" {
+			return deleted, nil
+		}
+		cursor = page.Cursor
+	}
+}
+
+func (n *Namespaces) remember(info NamespaceInfo) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.known[info.Name] = cachedNamespace{info: info, expiresAt: time.Now().Add(namespaceCacheTTL)}
+}
+
+func (n *Namespaces) forget(name string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.known, name)
+}
+
+func namespaceInfo(name string, entry Entry) NamespaceInfo {
+	info := NamespaceInfo{Name: name}
+	if record, ok := entry.Value.(map[string]any); ok {
+		if createdAt, ok := record["created_at"].(string); ok {
+			info.CreatedAt, _ = time.Parse(time.RFC3339Nano, createdAt)
+		}
+	}
+	return info
+}
@@ -0,0 +1,243 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/felipeascari/kv-store/pkg/lock"
+	"github.com/felipeascari/kv-store/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+// lateWriter writes a key to a namespace the first time keys are deleted, like a request
+// that was scoped to the namespace before it was deleted.
+type lateWriter struct {
+	storage.Store
+	once sync.Once
+}
+
+func TestNamespaced(t *testing.T) {
+	newStore := func(t *testing.T) (*storage.Memory, *storage.Namespaced) {
+		t.Helper()
+		m, err := storage.NewMemoryWithOptions(storage.MemoryOptions{
+			Indexes: []storage.Index{{Prefix: "user:", Path: "team"}},
+		})
+		require.NoError(t, err)
+		return m, storage.NewNamespaced(m)
+	}
+
+	billing := storage.WithNamespace(context.Background(), "billing")
+	search := storage.WithNamespace(context.Background(), "search")
+	root := context.Background()
+
+	t.Run("should isolate keys between namespaces", func(t *testing.T) {
+		m, n := newStore(t)
+
+		_, err := n.Save(billing, "config", "billing", 0)
+		require.NoError(t, err)
+		_, err = n.Save(root, "config", "default", 0)
+		require.NoError(t, err)
+
+		entry, err := n.Retrieve(billing, "config")
+		require.NoError(t, err)
+		require.Equal(t, "billing", entry.Value)
+
+		entry, err = n.Retrieve(root, "config")
+		require.NoError(t, err)
+		require.Equal(t, "default", entry.Value)
+
+		_, err = n.Retrieve(search, "config")
+		require.ErrorIs(t, err, storage.ErrKeyNotFound)
+
+		entry, err = m.Retrieve(root, "kv-store:ns:billing:config")
+		require.NoError(t, err)
+		require.Equal(t, "billing", entry.Value)
+
+		require.NoError(t, n.Delete(billing, "config"))
+		_, err = n.Retrieve(root, "config")
+		require.NoError(t, err)
+	})
+
+	t.Run("should reject reserved keys", func(t *testing.T) {
+		_, n := newStore(t)
+
+		_, err := n.Save(root, "kv-store:ns:billing:config", "x", 0)
+		require.ErrorIs(t, err, storage.ErrReservedKey)
+
+		_, err = n.Retrieve(root, "kv-store:index")
+		require.ErrorIs(t, err, storage.ErrReservedKey)
+
+		_, err = n.Save(billing, "kv-store:index", "x", 0)
+		require.NoError(t, err)
+
+		results, err := n.BatchSave(root, []storage.BatchItem{{Key: "a", Value: 1}, {Key: "kv-store:b", Value: 2}})
+		require.NoError(t, err)
+		require.NoError(t, results[0].Err)
+		require.ErrorIs(t, results[1].Err, storage.ErrReservedKey)
+		require.Equal(t, "kv-store:b", results[1].Key)
+	})
+
+	t.Run("should scan within the namespace", func(t *testing.T) {
+		m, n := newStore(t)
+
+		_, _ = n.Save(root, "a", 1, 0)
+		_, _ = n.Save(billing, "a", 2, 0)
+		_, _ = n.Save(billing, "b", 3, 0)
+		_, err := storage.NewNamespaces(m).Create(root, "search")
+		require.NoError(t, err)
+
+		page, err := n.Scan(billing, "", "", 10)
+		require.NoError(t, err)
+		require.Equal(t, []string{"a", "b"}, page.Keys)
+
+		page, err = n.Scan(root, "", "", 10)
+		require.NoError(t, err)
+		require.Equal(t, []string{"a"}, page.Keys)
+	})
+
+	t.Run("should report keys of the namespace from batches and transactions", func(t *testing.T) {
+		_, n := newStore(t)
+
+		_, _ = n.Save(billing, "a", 1, 0)
+
+		results, err := n.BatchRetrieve(billing, []string{"a", "missing"})
+		require.NoError(t, err)
+		require.Equal(t, "a", results[0].Key)
+		require.Equal(t, 1, results[0].Entry.Value)
+		require.Equal(t, "missing", results[1].Key)
+		require.ErrorIs(t, results[1].Err, storage.ErrKeyNotFound)
+
+		txResults, err := n.Transact(billing, storage.Transaction{
+			Checks: []storage.TxCheck{{Key: "a", Version: 1}},
+			Ops:    []storage.TxOp{{Type: storage.TxSet, Key: "b", Value: 2}},
+		})
+		require.NoError(t, err)
+		require.Equal(t, "b", txResults[0].Key)
+
+		_, err = n.Transact(billing, storage.Transaction{
+			Checks: []storage.TxCheck{{Key: "a", Version: 7}},
+			Ops:    []storage.TxOp{{Type: storage.TxDelete, Key: "a"}},
+		})
+		var checkErr *storage.TxCheckError
+		require.True(t, errors.As(err, &checkErr))
+		require.Equal(t, "a", checkErr.Key)
+	})
+
+	t.Run("should query within the namespace", func(t *testing.T) {
+		_, n := newStore(t)
+
+		_, _ = n.Save(billing, "user:1", map[string]any{"team": "infra"}, 0)
+		_, _ = n.Save(search, "user:1", map[string]any{"team": "infra"}, 0)
+		_, _ = n.Save(search, "user:2", map[string]any{"team": "infra"}, 0)
+
+		result, err := n.Query(billing, storage.Query{Prefix: "user:", Where: []storage.Condition{{Path: "team", Value: "infra"}}})
+		require.NoError(t, err)
+		require.Len(t, result.Items, 1)
+		require.Equal(t, "user:1", result.Items[0].Key)
+
+		result, err = n.Query(search, storage.Query{Prefix: "user:", Where: []storage.Condition{{Path: "team", Value: "infra"}}})
+		require.NoError(t, err)
+		require.Len(t, result.Items, 2)
+	})
+}
+
+func TestNamespaces(t *testing.T) {
+	ctx := context.Background()
+
+	m, err := storage.NewMemoryWithOptions(storage.MemoryOptions{})
+	require.NoError(t, err)
+	namespaces := storage.NewNamespaces(m)
+	n := storage.NewNamespaced(m)
+
+	_, err = namespaces.Create(ctx, "Billing")
+	require.ErrorIs(t, err, storage.ErrInvalidNamespace)
+
+	created, err := namespaces.Create(ctx, "billing")
+	require.NoError(t, err)
+	require.Equal(t, "billing", created.Name)
+
+	_, err = namespaces.Create(ctx, "billing")
+	require.ErrorIs(t, err, storage.ErrNamespaceExists)
+
+	_, err = namespaces.Create(ctx, "auth")
+	require.NoError(t, err)
+
+	info, err := namespaces.Get(ctx, "billing")
+	require.NoError(t, err)
+	require.True(t, created.CreatedAt.Equal(info.CreatedAt))
+
+	list, err := namespaces.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	require.Equal(t, "auth", list[0].Name)
+	require.Equal(t, "billing", list[1].Name)
+
+	billing := storage.WithNamespace(ctx, "billing")
+	_, _ = n.Save(billing, "a", 1, 0)
+	_, _ = n.Save(billing, "b", 2, 0)
+	_, _ = n.Save(ctx, "a", 3, 0)
+
+	deleted, err := namespaces.Delete(ctx, "billing")
+	require.NoError(t, err)
+	require.Equal(t, 2, deleted)
+
+	_, err = namespaces.Get(ctx, "billing")
+	require.ErrorIs(t, err, storage.ErrNamespaceNotFound)
+	_, err = n.Retrieve(billing, "a")
+	require.ErrorIs(t, err, storage.ErrKeyNotFound)
+	_, err = n.Retrieve(ctx, "a")
+	require.NoError(t, err)
+
+	_, err = namespaces.Delete(ctx, "billing")
+	require.ErrorIs(t, err, storage.ErrNamespaceNotFound)
+
+	t.Run("should delete keys written while the namespace is deleted", func(t *testing.T) {
+		namespaces := storage.NewNamespaces(&lateWriter{Store: m})
+
+		_, err := namespaces.Create(ctx, "billing")
+		require.NoError(t, err)
+		_, err = n.Save(billing, "a", 1, 0)
+		require.NoError(t, err)
+
+		deleted, err := namespaces.Delete(ctx, "billing")
+		require.NoError(t, err)
+		require.Equal(t, 2, deleted)
+
+		_, err = n.Retrieve(billing, "late")
+		require.ErrorIs(t, err, storage.ErrKeyNotFound)
+	})
+	t.Run("should look namespaces up concurrently without locking them", func(t *testing.T) {
+		_, err := namespaces.Create(ctx, "search")
+		require.NoError(t, err)
+		locked := storage.NewNamespaces(storage.NewLockedStore(m, lock.NewManager(refusingLock{})))
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 20)
+		for range 20 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := locked.Get(ctx, "search")
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			require.NoError(t, err)
+		}
+		_, err = locked.Get(ctx, "missing")
+		require.ErrorIs(t, err, storage.ErrNamespaceNotFound)
+	})
+}
+
+func (w *lateWriter) BatchDelete(ctx context.Context, keys []string) ([]storage.BatchResult, error) {
+	results, err := w.Store.BatchDelete(ctx, keys)
+	w.once.Do(func() {
+		_, _ = storage.NewNamespaced(w.Store).Save(storage.WithNamespace(ctx, "billing"), "late", 1, 0)
+	})
+	return results, err
+}
@@ -4,72 +4,1083 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
-type Redis struct {
-	client *redis.Client
-	ctx    context.Context
-}
+// Each key is stored as a hash holding the JSON encoded value and its version.
+// Writes run as Lua scripts so the version bump, the value and the expiration
+// are applied atomically and compare-and-swap cannot interleave with other writers.
+//
+// With history enabled, the scripts also push every version of a key to a capped list
+// under historyKeyPrefix, as "version:unix millis:stored value" elements stamped with the
+// Redis clock. The list gets the expiration of its key and is deleted along with it.
+//
+// Versions never go back: a key created, or created again after it was deleted or expired,
+// starts past a version floor kept under versionFloorKey, which deletes raise to the version
+// they remove. Redis expires keys without running a script, so writes that leave a key with
+// an expiration raise the floor to its version right away. Keys evicted by maxmemory do not
+// raise it, so a Redis instance that evicts keys may reuse their versions.
+//
+// With secondary indexes, every posting list is a sorted set of keys and the hash of a key
+// also lists, in its index field, the posting lists the key belongs to. The scripts move
+// the key between posting lists along with its value. They touch posting lists not passed
+// in KEYS, which is fine on a single Redis instance but not supported by Redis Cluster.
+//
+// Behind a LockedStore with a change stream, the scripts also append every write they make to
+// the stream, which is not passed in KEYS either.
+const (
+	fieldValue   = "value"
+	fieldVersion = "version"
+	fieldIndex   = "index"
+
+	// versionFloorKey holds the version floor, the highest version retired by a delete or
+	// an expiration.
+	versionFloorKey = reservedKeyPrefix + "version-floor"
+
+	// legacyLockTokenKey is the fencing token counter of the locks of releases that stored
+	// keys as plain strings, which MigrateStrings leaves alone.
+	legacyLockTokenKey = "lock:token_counter"
+
+	// maxUpdateAttempts bounds how many times Update retries when the key keeps changing.
+	maxUpdateAttempts = 10
+
+	// indexFunctions is prepended to the scripts that write keys. reindex takes the JSON array
+	// of the posting lists the key belongs to after the write, or an empty string when no
+	// index is declared.
+	indexFunctions = `
+		local function unindex(key)
+			local previous = redis.call('HGET', key, 'index')
+			if previous then
+				for _, posting in ipairs(cjson.decode(previous)) do
+					redis.call('ZREM', posting, key)
+				end
+			end
+		end
+
+		local function reindex(key, postings)
+			if postings == '' then
+				return
+			end
+			unindex(key)
+			local list = cjson.decode(postings)
+			for _, posting in ipairs(list) do
+				redis.call('ZADD', posting, 0, key)
+			end
+			if #list > 0 then
+				redis.call('HSET', key, 'index', postings)
+			else
+				redis.call('HDEL', key, 'index')
+			end
+		end
+	`
+
+	// historyFunctions is prepended to the scripts that write keys. record pushes the current
+	// version of a key to its history, keeping the last limit versions, or replaces the newest
+	// element when the version was rewritten in place. forget deletes the history of a key.
+	historyFunctions = `
+		local function record(key, limit)
+			limit = tonumber(limit)
+			if limit <= 0 then
+				return
+			end
+			local history = '` + historyKeyPrefix + `' .. key
+			local version = redis.call('HGET', key, 'version')
+			local value = redis.call('HGET', key, 'value')
+			local head = redis.call('LINDEX', history, 0)
+			local written
+			if head then
+				local v, t = string.match(head, '^(%d+):(%d+):')
+				if v == version then
+					written = t
+				end
+			end
+			if written then
+				redis.call('LSET', history, 0, version .. ':' .. written .. ':' .. value)
+			else
+				local now = redis.call('TIME')
+				written = now[1] .. string.format('%03d', math.floor(tonumber(now[2]) / 1000))
+				redis.call('LPUSH', history, version .. ':' .. written .. ':' .. value)
+				redis.call('LTRIM', history, 0, limit - 1)
+			end
+			local ttl = redis.call('PTTL', key)
+			if ttl > 0 then
+				redis.call('PEXPIRE', history, ttl)
+			else
+				redis.call('PERSIST', history)
+			end
+		end
+
+		local function forget(key)
+			redis.call('DEL', '` + historyKeyPrefix + `' .. key)
+		end
+	`
+
+	// versionFunctions is prepended to the scripts that write keys. bump increments the version
+	// of a key, or starts it past the floor when the key is new. retire raises the floor to a
+	// version, settle retires the version of a key that will expire and remove deletes a key
+	// along with its index entries and history, retiring its version.
+	versionFunctions = `
+		local function retire(version)
+			local floor = tonumber(redis.call('GET', '` + versionFloorKey + `') or '0')
+			if tonumber(version) > floor then
+				redis.call('SET', '` + versionFloorKey + `', string.format('%d', version))
+			end
+		end
+
+		local function bump(key)
+			if redis.call('HEXISTS', key, 'version') == 1 then
+				return redis.call('HINCRBY', key, 'version', 1)
+			end
+			local version = tonumber(redis.call('GET', '` + versionFloorKey + `') or '0') + 1
+			redis.call('HSET', key, 'version', string.format('%d', version))
+			return version
+		end
+
+		local function settle(key, version)
+			if redis.call('PTTL', key) > 0 then
+				retire(version)
+			end
+		end
+
+		local function remove(key)
+			local version = redis.call('HGET', key, 'version')
+			if version then
+				retire(version)
+			end
+			unindex(key)
+			forget(key)
+			return redis.call('DEL', key)
+		end
+	`
+
+	// captureFunctions is prepended to the scripts that write keys. capture appends a write of
+	// key to the change stream, see ChangeStream, reading the stream, its maximum length, the
+	// server ID and the timestamp from ARGV[base] on, and the fencing token, content type and
+	// data of the write from ARGV[at] on. Nothing is appended when the stream or the token is
+	// empty. value replaces the data of a save when only the script knows the value.
+	captureFunctions = `
+		local function rfc3339(ms)
+			local days = math.floor(ms / 86400000)
+			local rest = ms - days * 86400000
+			local z = days + 719468
+			local era = math.floor(z / 146097)
+			local doe = z - era * 146097
+			local yoe = math.floor((doe - math.floor(doe / 1460) + math.floor(doe / 36524) - math.floor(doe / 146096)) / 365)
+			local doy = doe - (365 * yoe + math.floor(yoe / 4) - math.floor(yoe / 100))
+			local mp = math.floor((5 * doy + 2) / 153)
+			local day = doy - math.floor((153 * mp + 2) / 5) + 1
+			local month = mp < 10 and mp + 3 or mp - 9
+			local year = yoe + era * 400
+			if month <= 2 then
+				year = year + 1
+			end
+			return string.format('%04d-%02d-%02dT%02d:%02d:%02d.%03dZ', year, month, day,
+				math.floor(rest / 3600000), math.floor(rest / 60000) % 60, math.floor(rest / 1000) % 60, rest % 1000)
+		end
+
+		local function capture(base, at, key, op, version, value)
+			local stream, token = ARGV[base], ARGV[at]
+			if stream == '' or token == '' then
+				return
+			end
+			local args = {'XADD', stream}
+			if tonumber(ARGV[base + 1]) > 0 then
+				table.insert(args, 'MAXLEN')
+				table.insert(args, '~')
+				table.insert(args, ARGV[base + 1])
+			end
+			table.insert(args, '*')
+			local function add(name, field)
+				table.insert(args, name)
+				table.insert(args, field)
+			end
+			add('key', key)
+			add('op', op)
+			if op == 'save' then
+				add('version', string.format('%d', version))
+				if ARGV[at + 1] == '' then
+					add('value', value or ARGV[at + 2])
+				else
+					add('content_type', ARGV[at + 1])
+					add('data', ARGV[at + 2])
+				end
+				local ttl = redis.call('PTTL', key)
+				if ttl > 0 then
+					local now = redis.call('TIME')
+					add('expires_at', rfc3339(tonumber(now[1]) * 1000 + math.floor(tonumber(now[2]) / 1000) + ttl))
+				end
+			end
+			add('token', token)
+			add('server_id', ARGV[base + 2])
+			add('timestamp', ARGV[base + 3])
+			redis.call(unpack(args))
+		end
+	`
+
+	saveScript = indexFunctions + historyFunctions + versionFunctions + captureFunctions + `
+		local version = bump(KEYS[1])
+		redis.call('HSET', KEYS[1], 'value', ARGV[1])
+		reindex(KEYS[1], ARGV[3])
+		if tonumber(ARGV[2]) > 0 then
+			redis.call('PEXPIRE', KEYS[1], ARGV[2])
+		else
+			redis.call('PERSIST', KEYS[1])
+		end
+		settle(KEYS[1], version)
+		record(KEYS[1], ARGV[4])
+		capture(5, 9, KEYS[1], 'save', version)
+		return version
+	`
+
+	compareAndSwapScript = indexFunctions + historyFunctions + versionFunctions + captureFunctions + `
+		local current = tonumber(redis.call('HGET', KEYS[1], 'version') or '0')
+		if current ~= tonumber(ARGV[3]) then
+			return -1
+		end
+		local version = bump(KEYS[1])
+		redis.call('HSET', KEYS[1], 'value', ARGV[1])
+		reindex(KEYS[1], ARGV[4])
+		if tonumber(ARGV[2]) > 0 then
+			redis.call('PEXPIRE', KEYS[1], ARGV[2])
+		else
+			redis.call('PERSIST', KEYS[1])
+		end
+		settle(KEYS[1], version)
+		record(KEYS[1], ARGV[5])
+		capture(6, 10, KEYS[1], 'save', version)
+		return version
+	`
+
+	deleteScript = indexFunctions + historyFunctions + versionFunctions + captureFunctions + `
+		local removed = remove(KEYS[1])
+		if removed == 1 then
+			capture(1, 5, KEYS[1], 'delete')
+		end
+		return removed
+	`
+
+	compareAndDeleteScript = indexFunctions + historyFunctions + versionFunctions + captureFunctions + `
+		local current = tonumber(redis.call('HGET', KEYS[1], 'version') or '0')
+		if current == 0 then
+			return 0
+		end
+		if current ~= tonumber(ARGV[1]) then
+			return -1
+		end
+		local removed = remove(KEYS[1])
+		capture(2, 6, KEYS[1], 'delete')
+		return removed
+	`
+
+	// incrementScript adds ARGV[1] to the value with HINCRBY when ARGV[2] is 'int' and the
+	// value is an integer, so large counters stay exact, and with HINCRBYFLOAT otherwise.
+	// The expiration is left untouched. It returns the new value, version and PTTL.
+	incrementScript = indexFunctions + historyFunctions + versionFunctions + captureFunctions + `
+		local current = redis.call('HGET', KEYS[1], 'value') or '0'
+		if tonumber(current) == nil then
+			return redis.error_reply('NOTNUMERIC')
+		end
+		if ARGV[2] == 'int' and string.match(current, '^-?%d+$') then
+			redis.call('HINCRBY', KEYS[1], 'value', ARGV[1])
+		else
+			redis.call('HINCRBYFLOAT', KEYS[1], 'value', ARGV[1])
+		end
+		local version = bump(KEYS[1])
+		settle(KEYS[1], version)
+		record(KEYS[1], ARGV[3])
+		local value = redis.call('HGET', KEYS[1], 'value')
+		capture(4, 8, KEYS[1], 'save', version, value)
+		return {value, version, redis.call('PTTL', KEYS[1])}
+	`
+
+	// updateScript replaces the value if the key is still at version ARGV[2], keeping
+	// its expiration. It returns the new version, or -1 if the key changed meanwhile.
+	updateScript = indexFunctions + historyFunctions + versionFunctions + captureFunctions + `
+		local current = tonumber(redis.call('HGET', KEYS[1], 'version') or '0')
+		if current == 0 or current ~= tonumber(ARGV[2]) then
+			return -1
+		end
+		redis.call('HSET', KEYS[1], 'value', ARGV[1])
+		reindex(KEYS[1], ARGV[3])
+		local version = bump(KEYS[1])
+		settle(KEYS[1], version)
+		record(KEYS[1], ARGV[4])
+		capture(5, 9, KEYS[1], 'save', version)
+		return version
+	`
+
+	// rewriteScript replaces the value if the key is still at version ARGV[2], keeping its
+	// version and expiration. It returns 0 if the key is missing and -1 if it changed meanwhile.
+	rewriteScript = indexFunctions + historyFunctions + versionFunctions + `
+		local current = tonumber(redis.call('HGET', KEYS[1], 'version') or '0')
+		if current == 0 then
+			return 0
+		end
+		if current ~= tonumber(ARGV[2]) then
+			return -1
+		end
+		redis.call('HSET', KEYS[1], 'value', ARGV[1])
+		reindex(KEYS[1], ARGV[3])
+		record(KEYS[1], ARGV[4])
+		return current
+	`
+
+	// transactScript takes the distinct keys of a transaction in KEYS and, in ARGV, the history
+	// limit, the change stream arguments shared by the operations and the number of checks,
+	// followed by a (key index, version) pair per check and a (type, key index, value, ttl,
+	// postings, token, content type, data) tuple per operation. It returns the version of every
+	// operation, or {-1, check index, current version} when a check fails and nothing was written.
+	transactScript = indexFunctions + historyFunctions + versionFunctions + captureFunctions + `
+		local checks = tonumber(ARGV[6])
+		local i = 7
+		for c = 1, checks do
+			local current = tonumber(redis.call('HGET', KEYS[tonumber(ARGV[i])], 'version') or '0')
+			if current ~= tonumber(ARGV[i + 1]) then
+				return {-1, c, current}
+			end
+			i = i + 2
+		end
+		local versions = {}
+		while i <= #ARGV do
+			local key = KEYS[tonumber(ARGV[i + 1])]
+			if ARGV[i] == 'set' then
+				local version = bump(key)
+				redis.call('HSET', key, 'value', ARGV[i + 2])
+				reindex(key, ARGV[i + 4])
+				if tonumber(ARGV[i + 3]) > 0 then
+					redis.call('PEXPIRE', key, ARGV[i + 3])
+				else
+					redis.call('PERSIST', key)
+				end
+				settle(key, version)
+				record(key, ARGV[1])
+				capture(2, i + 5, key, 'save', version)
+				table.insert(versions, version)
+			else
+				if remove(key) == 1 then
+					capture(2, i + 5, key, 'delete')
+				end
+				table.insert(versions, 0)
+			end
+			i = i + 8
+		end
+		return versions
+	`
+
+	// migrateScript turns KEYS[1] from a string still holding ARGV[1] into a hash at the first
+	// version past the floor, keeping its expiration. It returns 0 if the key changed meanwhile.
+	migrateScript = indexFunctions + historyFunctions + versionFunctions + `
+		if redis.call('TYPE', KEYS[1]).ok ~= 'string' or redis.call('GET', KEYS[1]) ~= ARGV[1] then
+			return 0
+		end
+		local ttl = redis.call('PTTL', KEYS[1])
+		redis.call('DEL', KEYS[1])
+		local version = bump(KEYS[1])
+		redis.call('HSET', KEYS[1], 'value', ARGV[1])
+		reindex(KEYS[1], ARGV[2])
+		if ttl > 0 then
+			redis.call('PEXPIRE', KEYS[1], ttl)
+		end
+		settle(KEYS[1], version)
+		record(KEYS[1], ARGV[3])
+		return 1
+	`
+
+	// pruneScript removes from the posting list KEYS[1] the keys in ARGV that no longer
+	// belong to it, because they expired or were rewritten after expiring.
+	pruneScript = `
+		local removed = 0
+		for _, key in ipairs(ARGV) do
+			local listed = false
+			local postings = redis.call('HGET', key, 'index')
+			if postings then
+				for _, posting in ipairs(cjson.decode(postings)) do
+					if posting == KEYS[1] then
+						listed = true
+						break
+					end
+				end
+			end
+			if not listed then
+				removed = removed + redis.call('ZREM', KEYS[1], key)
+			end
+		end
+		return removed
+	`
+)
+
+var (
+	saveCmd             = redis.NewScript(saveScript)
+	compareAndSwapCmd   = redis.NewScript(compareAndSwapScript)
+	deleteCmd           = redis.NewScript(deleteScript)
+	compareAndDeleteCmd = redis.NewScript(compareAndDeleteScript)
+	incrementCmd        = redis.NewScript(incrementScript)
+	updateCmd           = redis.NewScript(updateScript)
+	rewriteCmd          = redis.NewScript(rewriteScript)
+	transactCmd         = redis.NewScript(transactScript)
+	migrateCmd          = redis.NewScript(migrateScript)
+	pruneCmd            = redis.NewScript(pruneScript)
+)
+
+type (
+	// RedisOptions configures the Redis backend.
+	RedisOptions struct {
+		Addr     string
+		Password string
+		DB       int
+		// Indexes declares the secondary indexes maintained on every write.
+		Indexes []Index
+		// History is the number of versions kept per key, the current one included.
+		// Zero disables history.
+		History int
+		// Compression compresses values of at least CompressionThreshold bytes, which
+		// defaults to DefaultCompressionThreshold. Values are stored as they are by default.
+		Compression          Compression
+		CompressionThreshold int
+	}
+
+	Redis struct {
+		client  *redis.Client
+		indexes []Index
+		history int
+		// compressor is nil unless compression is enabled.
+		compressor *compressor
+	}
+)
 
 func NewRedis(addr, password string, db int) (*Redis, error) {
+	return NewRedisWithOptions(RedisOptions{Addr: addr, Password: password, DB: db})
+}
+
+func NewRedisWithOptions(opts RedisOptions) (*Redis, error) {
+	for _, index := range opts.Indexes {
+		if err := index.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	compressor, err := newCompressor(opts.Compression, opts.CompressionThreshold)
+	if err != nil {
+		return nil, err
+	}
+
 	client := redis.NewClient(&redis.Options{
-		Addr:     addr,
-		Password: password,
-		DB:       db,
+		Addr:     opts.Addr,
+		Password: opts.Password,
+		DB:       opts.DB,
 	})
 
-	ctx := context.Background()
-
-	if err := client.Ping(ctx).Err(); err != nil {
+	if err := client.Ping(context.Background()).Err(); err != nil {
 		_ = client.Close()
 		return nil, err
 	}
 
 	return &Redis{
-		client: client,
-		ctx:    ctx,
+		client:     client,
+		indexes:    opts.Indexes,
+		history:    max(opts.History, 0),
+		compressor: compressor,
 	}, nil
 }
 
-func (r *Redis) Save(key string, value any) error {
-	data, err := json.Marshal(value)
+// Save stores the value with a native Redis expiration when ttl is positive.
+func (r *Redis) Save(ctx context.Context, key string, value any, ttl time.Duration) (int64, error) {
+	data, err := r.encode(value)
+	if err != nil {
+		return 0, err
+	}
+	capture, err := captureArgs(ctx, key, ChangeSave, value)
+	if err != nil {
+		return 0, err
+	}
+
+	args := append([]any{data, max(ttl, 0).Milliseconds(), r.postings(key, value), r.history}, capture...)
+	return saveCmd.Run(ctx, r.client, []string{key}, args...).Int64()
+}
+
+func (r *Redis) Retrieve(ctx context.Context, key string) (Entry, error) {
+	var (
+		fields *redis.SliceCmd
+		ttl    *redis.DurationCmd
+	)
+
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		fields = pipe.HMGet(ctx, key, fieldValue, fieldVersion)
+		ttl = pipe.PTTL(ctx, key)
+		return nil
+	})
+	if err != nil {
+		return Entry{}, err
+	}
+
+	return decodeEntry(fields.Val(), ttl.Val())
+}
+
+func (r *Redis) Delete(ctx context.Context, key string) error {
+	capture, err := captureArgs(ctx, key, ChangeDelete, nil)
+	if err != nil {
+		return err
+	}
+
+	result, err := deleteCmd.Run(ctx, r.client, []string{key}, capture...).Int64()
+	if err != nil {
+		return err
+	}
+
+	if result == 0 {
+		return ErrKeyNotFound
+	}
+
+	return nil
+}
+
+func (r *Redis) CompareAndSwap(ctx context.Context, key string, expectedVersion int64, value any, ttl time.Duration) (int64, error) {
+	data, err := r.encode(value)
+	if err != nil {
+		return 0, err
+	}
+
+	capture, err := captureArgs(ctx, key, ChangeSave, value)
+	if err != nil {
+		return 0, err
+	}
+
+	args := append([]any{data, max(ttl, 0).Milliseconds(), expectedVersion, r.postings(key, value), r.history}, capture...)
+	version, err := compareAndSwapCmd.Run(ctx, r.client, []string{key}, args...).Int64()
+	if err != nil {
+		return 0, err
+	}
+
+	if version < 0 {
+		return 0, ErrVersionMismatch
+	}
+
+	return version, nil
+}
+
+func (r *Redis) CompareAndDelete(ctx context.Context, key string, expectedVersion int64) error {
+	capture, err := captureArgs(ctx, key, ChangeDelete, nil)
 	if err != nil {
 		return err
 	}
-	return r.client.Set(r.ctx, key, data, 0).Err()
+
+	result, err := compareAndDeleteCmd.Run(ctx, r.client, []string{key}, append([]any{expectedVersion}, capture...)...).Int64()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case result == 0:
+		return ErrKeyNotFound
+	case result < 0:
+		return ErrVersionMismatch
+	default:
+		return nil
+	}
 }
 
-func (r *Redis) Retrieve(key string) (any, error) {
-	data, err := r.client.Get(r.ctx, key).Bytes()
+// Increment adds delta in a script, which cannot encrypt the new value for the change stream:
+// when the change stream encrypts values, it increments with Update instead.
+func (r *Redis) Increment(ctx context.Context, key string, delta float64) (Entry, error) {
+	if changeCaptureFrom(ctx).seals(key) {
+		return incrementWithUpdate(ctx, r, key, delta)
+	}
+
+	mode := "float"
+	if isInteger(delta) {
+		mode = "int"
+	}
+
+	// The script appends the value it computes in place of the data argument.
+	capture, err := captureArgs(ctx, key, ChangeSave, nil)
 	if err != nil {
-		if errors.Is(err, redis.Nil) {
-			return nil, ErrKeyNotFound
+		return Entry{}, err
+	}
+
+	args := append([]any{strconv.FormatFloat(delta, 'f', -1, 64), mode, r.history}, capture...)
+	reply, err := incrementCmd.Run(ctx, r.client, []string{key}, args...).Slice()
+	if err != nil {
+		// Depending on the server version the reply may be prefixed with ERR.
+		if isReplyError(err) && strings.Contains(err.Error(), "NOTNUMERIC") {
+			return Entry{}, ErrNotNumeric
 		}
-		return nil, err
+		return Entry{}, err
 	}
 
-	var value any
-	if err := json.Unmarshal(data, &value); err != nil {
-		return nil, err
+	data, _ := reply[0].(string)
+	version, _ := reply[1].(int64)
+	ttl, _ := reply[2].(int64)
+
+	entry := Entry{Version: version}
+	if err := json.Unmarshal([]byte(data), &entry.Value); err != nil {
+		return Entry{}, err
+	}
+	if ttl > 0 {
+		entry.ExpiresAt = time.Now().Add(time.Duration(ttl) * time.Millisecond)
 	}
 
-	return value, nil
+	return entry, nil
 }
 
-func (r *Redis) Delete(key string) error {
-	result, err := r.client.Del(r.ctx, key).Result()
+// Update reads the key, computes the new value and writes it back only if the key has not
+// changed in between, retrying otherwise. Behind a LockedStore no other writer can
+// interleave, so the first attempt succeeds.
+func (r *Redis) Update(ctx context.Context, key string, fn UpdateFunc) (Entry, error) {
+	for range maxUpdateAttempts {
+		current, err := r.Retrieve(ctx, key)
+		if err != nil {
+			return Entry{}, err
+		}
+
+		value, err := fn(current)
+		if err != nil {
+			return Entry{}, err
+		}
+
+		data, err := r.encode(value)
+		if err != nil {
+			return Entry{}, err
+		}
+
+		capture, err := captureArgs(ctx, key, ChangeSave, value)
+		if err != nil {
+			return Entry{}, err
+		}
+
+		args := append([]any{data, current.Version, r.postings(key, value), r.history}, capture...)
+		version, err := updateCmd.Run(ctx, r.client, []string{key}, args...).Int64()
+		if err != nil {
+			return Entry{}, err
+		}
+		if version > 0 {
+			return Entry{Value: value, Version: version, ExpiresAt: current.ExpiresAt}, nil
+		}
+	}
+
+	return Entry{}, ErrVersionMismatch
+}
+
+// Rewrite replaces the value of key if it is still at version, keeping its version and expiration.
+func (r *Redis) Rewrite(ctx context.Context, key string, version int64, value any) error {
+	data, err := r.encode(value)
 	if err != nil {
 		return err
 	}
 
-	if result == 0 {
+	result, err := rewriteCmd.Run(ctx, r.client, []string{key}, data, version, r.postings(key, value), r.history).Int64()
+	switch {
+	case err != nil:
+		return err
+	case result == 0:
 		return ErrKeyNotFound
+	case result < 0:
+		return ErrVersionMismatch
+	default:
+		return nil
 	}
+}
 
-	return nil
+// History reads the key and its history in one round trip. The current version is listed
+// first even if it was written before history was enabled.
+func (r *Redis) History(ctx context.Context, key string) ([]Revision, error) {
+	if r.history <= 0 {
+		return nil, ErrHistoryUnsupported
+	}
+
+	var (
+		fields  *redis.SliceCmd
+		ttl     *redis.DurationCmd
+		history *redis.StringSliceCmd
+	)
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		fields = pipe.HMGet(ctx, key, fieldValue, fieldVersion)
+		ttl = pipe.PTTL(ctx, key)
+		history = pipe.LRange(ctx, historyKeyPrefix+key, 0, int64(r.history-1))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := decodeEntry(fields.Val(), ttl.Val())
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := make([]Revision, 0, len(history.Val())+1)
+	for _, element := range history.Val() {
+		revision, err := decodeRevision(element)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+
+	if len(revisions) == 0 || revisions[0].Version != current.Version {
+		revisions = append([]Revision{{Version: current.Version, Value: current.Value}}, revisions...)
+	}
+	return revisions[:min(len(revisions), r.history)], nil
+}
+
+// Scan pages through the keyspace with SCAN MATCH, skipping keys that are not
+// values written by this store (such as locks and collections). Redis does not
+// guarantee page sizes, so limit is a hint and a page may contain slightly more keys.
+func (r *Redis) Scan(ctx context.Context, prefix, cursor string, limit int) (ScanResult, error) {
+	position, err := decodeCursor(cursor)
+	if err != nil {
+		return ScanResult{}, err
+	}
+
+	var next uint64
+	if position != "" {
+		if next, err = strconv.ParseUint(position, 10, 64); err != nil {
+			return ScanResult{}, ErrInvalidCursor
+		}
+	}
+
+	if limit <= 0 {
+		limit = DefaultScanLimit
+	}
+
+	match := escapeGlob(prefix) + "*"
+	keys := make([]string, 0, limit)
+
+	for {
+		var batch []string
+
+		batch, next, err = r.client.ScanType(ctx, next, match, int64(limit-len(keys)), "hash").Result()
+		if err != nil {
+			return ScanResult{}, err
+		}
+
+		for _, key := range batch {
+			// Hash collections are hashes too.
+			if !strings.HasPrefix(key, collectionKeyPrefix) {
+				keys = append(keys, key)
+			}
+		}
+		if next == 0 || len(keys) >= limit {
+			break
+		}
+	}
+
+	result := ScanResult{Keys: keys}
+	if next != 0 {
+		result.Cursor = encodeCursor(strconv.FormatUint(next, 10))
+	}
+
+	return result, nil
+}
+
+// BatchSave pipelines the save script for every item, so the whole batch costs a single round trip.
+func (r *Redis) BatchSave(ctx context.Context, items []BatchItem) ([]BatchResult, error) {
+	results := make([]BatchResult, len(items))
+	cmds := make([]*redis.Cmd, len(items))
+
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, item := range items {
+			results[i] = BatchResult{Key: item.Key}
+
+			data, err := r.encode(item.Value)
+			if err != nil {
+				results[i].Err = err
+				continue
+			}
+			capture, err := captureArgs(ctx, item.Key, ChangeSave, item.Value)
+			if err != nil {
+				results[i].Err = err
+				continue
+			}
+
+			args := append([]any{data, max(item.TTL, 0).Milliseconds(), r.postings(item.Key, item.Value), r.history}, capture...)
+			cmds[i] = saveCmd.Eval(ctx, pipe, []string{item.Key}, args...)
+		}
+		return nil
+	})
+	if err != nil && !isReplyError(err) {
+		return nil, err
+	}
+
+	for i, cmd := range cmds {
+		if cmd == nil {
+			continue
+		}
+
+		version, err := cmd.Int64()
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		results[i].Entry = Entry{
+			Value:     items[i].Value,
+			Version:   version,
+			ExpiresAt: expiresAt(items[i].TTL),
+		}
+	}
+
+	return results, nil
+}
+
+func (r *Redis) BatchRetrieve(ctx context.Context, keys []string) ([]BatchResult, error) {
+	results := make([]BatchResult, len(keys))
+	fields := make([]*redis.SliceCmd, len(keys))
+	ttls := make([]*redis.DurationCmd, len(keys))
+
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			fields[i] = pipe.HMGet(ctx, key, fieldValue, fieldVersion)
+			ttls[i] = pipe.PTTL(ctx, key)
+		}
+		return nil
+	})
+	if err != nil && !isReplyError(err) {
+		return nil, err
+	}
+
+	for i, key := range keys {
+		results[i] = BatchResult{Key: key}
+
+		if err := fields[i].Err(); err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		results[i].Entry, results[i].Err = decodeEntry(fields[i].Val(), ttls[i].Val())
+	}
+
+	return results, nil
+}
+
+func (r *Redis) BatchDelete(ctx context.Context, keys []string) ([]BatchResult, error) {
+	results := make([]BatchResult, len(keys))
+	cmds := make([]*redis.Cmd, len(keys))
+
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			capture, err := captureArgs(ctx, key, ChangeDelete, nil)
+			if err != nil {
+				return err
+			}
+			cmds[i] = deleteCmd.Eval(ctx, pipe, []string{key}, capture...)
+		}
+		return nil
+	})
+	if err != nil && !isReplyError(err) {
+		return nil, err
+	}
+
+	for i, key := range keys {
+		results[i] = BatchResult{Key: key}
+
+		deleted, err := cmds[i].Int64()
+		switch {
+		case err != nil:
+			results[i].Err = err
+		case deleted == 0:
+			results[i].Err = ErrKeyNotFound
+		}
+	}
+
+	return results, nil
+}
+
+// Transact runs the whole transaction as one Lua script, which Redis executes atomically.
+func (r *Redis) Transact(ctx context.Context, tx Transaction) ([]TxResult, error) {
+	if err := tx.Validate(); err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	index := make(map[string]int)
+	slot := func(key string) int {
+		if i, ok := index[key]; ok {
+			return i
+		}
+		keys = append(keys, key)
+		index[key] = len(keys)
+		return len(keys)
+	}
+
+	c := changeCaptureFrom(ctx)
+	args := append(append([]any{r.history}, c.args()...), len(tx.Checks))
+	for _, check := range tx.Checks {
+		args = append(args, slot(check.Key), check.Version)
+	}
+	for _, op := range tx.Ops {
+		var (
+			data     []byte
+			postings string
+			change   = ChangeDelete
+		)
+		if op.Type == TxSet {
+			var err error
+			if data, err = r.encode(op.Value); err != nil {
+				return nil, err
+			}
+			postings = r.postings(op.Key, op.Value)
+			change = ChangeSave
+		}
+		capture, err := c.changeArgs(op.Key, change, op.Value)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, string(op.Type), slot(op.Key), data, max(op.TTL, 0).Milliseconds(), postings)
+		args = append(args, capture...)
+	}
+
+	reply, err := transactCmd.Run(ctx, r.client, keys, args...).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+
+	// Versions are never negative, so -1 can only be the failed check marker.
+	if reply[0] == -1 {
+		check := tx.Checks[reply[1]-1]
+		return nil, &TxCheckError{Key: check.Key, Expected: check.Version, Actual: reply[2]}
+	}
+
+	results := make([]TxResult, len(tx.Ops))
+	for i, op := range tx.Ops {
+		results[i] = TxResult{Key: op.Key, Version: reply[i]}
+		if op.Type == TxSet {
+			results[i].ExpiresAt = expiresAt(op.TTL)
+		}
+	}
+
+	return results, nil
+}
+
+// Query pages through the sorted set of an indexed condition, in key order, and checks every
+// other condition against the current values. Keys that expired are pruned from the set.
+func (r *Redis) Query(ctx context.Context, q Query) (QueryResult, error) {
+	posting, err := q.plan(r.indexes)
+	if err != nil {
+		return QueryResult{}, err
+	}
+
+	after, err := decodeCursor(q.Cursor)
+	if err != nil {
+		return QueryResult{}, err
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultScanLimit
+	}
+
+	start := "-"
+	switch {
+	case after >= q.Prefix:
+		if after != "" {
+			start = "(" + after
+		}
+	default:
+		start = "[" + q.Prefix
+	}
+
+	var result QueryResult
+	for {
+		keys, err := r.client.ZRangeByLex(ctx, posting, &redis.ZRangeBy{Min: start, Max: "+", Count: int64(limit)}).Result()
+		if err != nil {
+			return QueryResult{}, err
+		}
+
+		var candidates []string
+		for _, key := range keys {
+			if strings.HasPrefix(key, q.Prefix) {
+				candidates = append(candidates, key)
+			}
+		}
+
+		entries, err := r.BatchRetrieve(ctx, candidates)
+		if err != nil {
+			return QueryResult{}, err
+		}
+
+		var stale []any
+		for _, entry := range entries {
+			if len(result.Items) == limit {
+				result.Cursor = encodeCursor(result.Items[limit-1].Key)
+				break
+			}
+			switch {
+			case errors.Is(entry.Err, ErrKeyNotFound):
+				stale = append(stale, entry.Key)
+			case entry.Err != nil:
+				return QueryResult{}, entry.Err
+			case !slices.Contains(postings(r.indexes, entry.Key, entry.Entry.Value), posting):
+				stale = append(stale, entry.Key)
+			case q.matches(entry.Key, entry.Entry.Value):
+				result.Items = append(result.Items, QueryItem{Key: entry.Key, Entry: entry.Entry})
+			}
+		}
+
+		// Pruning is best effort, the keys are skipped either way.
+		if len(stale) > 0 {
+			_ = pruneCmd.Run(ctx, r.client, []string{posting}, stale...).Err()
+		}
+
+		// Keys are sorted, so the first one past the prefix ends the query.
+		if result.Cursor != "" || len(keys) < limit || len(candidates) < len(keys) {
+			return result, nil
+		}
+		start = "(" + keys[len(keys)-1]
+	}
+}
+
+// MigrateStrings converts the keys stored as plain JSON strings by releases without key
+// versions into the hashes this store reads, so they stop failing with WRONGTYPE. Keys get
+// their first version and keep their expiration. Reserved keys and the fencing token counter
+// of the old locks are left alone. It is safe to run again and returns the keys converted.
+func (r *Redis) MigrateStrings(ctx context.Context) (int, error) {
+	var (
+		migrated int
+		cursor   uint64
+	)
+	for {
+		keys, next, err := r.client.ScanType(ctx, cursor, "*", DefaultScanLimit, "string").Result()
+		if err != nil {
+			return migrated, err
+		}
+
+		for _, key := range keys {
+			if strings.HasPrefix(key, reservedKeyPrefix) || key == legacyLockTokenKey {
+				continue
+			}
+
+			data, err := r.client.Get(ctx, key).Result()
+			switch {
+			case errors.Is(err, redis.Nil) || isReplyError(err):
+				// Deleted or rewritten since the scan
+				continue
+			case err != nil:
+				return migrated, err
+			}
+
+			value, err := decodeValue([]byte(data))
+			if err != nil {
+				return migrated, fmt.Errorf("migrate key %q: %w", key, err)
+			}
+
+			n, err := migrateCmd.Run(ctx, r.client, []string{key}, data, r.postings(key, value), r.history).Int64()
+			if err != nil {
+				return migrated, fmt.Errorf("migrate key %q: %w", key, err)
+			}
+			migrated += int(n)
+		}
+
+		if next == 0 {
+			return migrated, nil
+		}
+		cursor = next
+	}
 }
 
 func (r *Redis) Close() error {
@@ -81,7 +1092,125 @@ func (r *Redis) Client() *redis.Client {
 }
 
 func (r *Redis) Ping(timeout time.Duration) error {
-	ctx, cancel := context.WithTimeout(r.ctx, timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 	return r.client.Ping(ctx).Err()
 }
+
+// postings returns the JSON array of the posting lists key belongs to with value,
+// or an empty string when no index is declared.
+// CompressionStats reports the compression of the values written by this process.
+func (r *Redis) CompressionStats() (CompressionStats, bool) {
+	if r.compressor == nil {
+		return CompressionStats{}, false
+	}
+	return r.compressor.stats(), true
+}
+
+// encode returns the stored form of a value, compressed when enabled and worth it. Encrypted
+// values were compressed before they were sealed and are stored as they are.
+func (r *Redis) encode(value any) ([]byte, error) {
+	data, err := encodeValue(value)
+	if err != nil {
+		return nil, err
+	}
+	if blob, ok := value.(Blob); ok && blob.ContentType == EncryptedContentType {
+		return data, nil
+	}
+	return r.compressEncoded(data), nil
+}
+
+func (r *Redis) compressEncoded(data []byte) []byte {
+	if r.compressor == nil {
+		return data
+	}
+	return r.compressor.compress(data)
+}
+
+func (r *Redis) postings(key string, value any) string {
+	if len(r.indexes) == 0 {
+		return ""
+	}
+
+	data, err := json.Marshal(append([]string{}, postings(r.indexes, key, value)...))
+	if err != nil {
+		return "[]"
+	}
+	return string(data)
+}
+
+// decodeEntry builds an entry from the HMGET value/version reply and the PTTL reply of a key.
+func decodeEntry(fields []any, ttl time.Duration) (Entry, error) {
+	data, ok := fields[0].(string)
+	if !ok {
+		return Entry{}, ErrKeyNotFound
+	}
+
+	var (
+		entry Entry
+		err   error
+	)
+	if entry.Value, err = decodeValue([]byte(data)); err != nil {
+		return Entry{}, err
+	}
+
+	if version, ok := fields[1].(string); ok {
+		if entry.Version, err = strconv.ParseInt(version, 10, 64); err != nil {
+			return Entry{}, err
+		}
+	}
+
+	// PTTL replies with a negative duration when the key has no expiration.
+	if ttl > 0 {
+		entry.ExpiresAt = time.Now().Add(ttl)
+	}
+
+	return entry, nil
+}
+
+// decodeRevision parses a "version:unix millis:stored value" element of a history list.
+func decodeRevision(element string) (Revision, error) {
+	version, rest, ok := strings.Cut(element, ":")
+	millis, data, ok2 := strings.Cut(rest, ":")
+	if !ok || !ok2 {
+		return Revision{}, errors.New("invalid history element")
+	}
+
+	var (
+		revision Revision
+		err      error
+	)
+	if revision.Version, err = strconv.ParseInt(version, 10, 64); err != nil {
+		return Revision{}, err
+	}
+	ms, err := strconv.ParseInt(millis, 10, 64)
+	if err != nil {
+		return Revision{}, err
+	}
+	revision.WrittenAt = time.UnixMilli(ms)
+
+	if revision.Value, err = decodeValue([]byte(data)); err != nil {
+		return Revision{}, err
+	}
+	return revision, nil
+}
+
+// isReplyError reports whether a pipeline failed because Redis rejected individual
+// commands, as opposed to the whole round trip failing, so errors can be reported per item.
+func isReplyError(err error) bool {
+	var replyErr redis.Error
+	return errors.As(err, &replyErr)
+}
+
+// escapeGlob escapes the special characters of a Redis MATCH pattern.
+func escapeGlob(pattern string) string {
+	var b strings.Builder
+	for _, c := range pattern {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,225 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Collections are stored as native Redis hashes, lists, sets and sorted sets under
+// collectionKeyPrefix, so they never collide with the hashes holding the JSON values.
+// Hash field values and list elements are JSON encoded.
+const collectionKeyPrefix = reservedKeyPrefix + "collection:"
+
+func (r *Redis) HashSet(ctx context.Context, key string, fields map[string]any) (int, error) {
+	if len(fields) == 0 {
+		return 0, nil
+	}
+
+	args := make([]any, 0, 2*len(fields))
+	for field, value := range fields {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return 0, err
+		}
+		args = append(args, field, data)
+	}
+
+	added, err := r.client.HSet(ctx, collectionKey(key), args...).Result()
+	return int(added), collectionError(err)
+}
+
+// HashGet reads the field and whether the hash exists in one MULTI, to tell a missing
+// hash from a missing field.
+func (r *Redis) HashGet(ctx context.Context, key, field string) (any, error) {
+	var (
+		value  *redis.StringCmd
+		exists *redis.IntCmd
+	)
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		value = pipe.HGet(ctx, collectionKey(key), field)
+		exists = pipe.Exists(ctx, collectionKey(key))
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, collectionError(err)
+	}
+
+	data, err := value.Result()
+	if errors.Is(err, redis.Nil) {
+		if exists.Val() == 0 {
+			return nil, ErrKeyNotFound
+		}
+		return nil, ErrFieldNotFound
+	}
+	if err != nil {
+		return nil, collectionError(err)
+	}
+
+	return decodeElement(data)
+}
+
+func (r *Redis) HashGetAll(ctx context.Context, key string) (map[string]any, error) {
+	fields, err := r.client.HGetAll(ctx, collectionKey(key)).Result()
+	if err != nil {
+		return nil, collectionError(err)
+	}
+
+	result := make(map[string]any, len(fields))
+	for field, data := range fields {
+		if result[field], err = decodeElement(data); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (r *Redis) ListPush(ctx context.Context, key string, values []any) (int, error) {
+	if len(values) == 0 {
+		length, err := r.client.LLen(ctx, collectionKey(key)).Result()
+		return int(length), collectionError(err)
+	}
+
+	args := make([]any, len(values))
+	for i, value := range values {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return 0, err
+		}
+		args[i] = data
+	}
+
+	length, err := r.client.LPush(ctx, collectionKey(key), args...).Result()
+	return int(length), collectionError(err)
+}
+
+func (r *Redis) ListPop(ctx context.Context, key string) (any, error) {
+	data, err := r.client.RPop(ctx, collectionKey(key)).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, collectionError(err)
+	}
+	return decodeElement(data)
+}
+
+func (r *Redis) ListRange(ctx context.Context, key string, start, stop int) ([]any, error) {
+	elements, err := r.client.LRange(ctx, collectionKey(key), int64(start), int64(stop)).Result()
+	if err != nil {
+		return nil, collectionError(err)
+	}
+
+	values := make([]any, len(elements))
+	for i, data := range elements {
+		if values[i], err = decodeElement(data); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func (r *Redis) SetAdd(ctx context.Context, key string, members []string) (int, error) {
+	if len(members) == 0 {
+		return 0, nil
+	}
+
+	args := make([]any, len(members))
+	for i, member := range members {
+		args[i] = member
+	}
+
+	added, err := r.client.SAdd(ctx, collectionKey(key), args...).Result()
+	return int(added), collectionError(err)
+}
+
+// SetMembers sorts the members, which Redis returns in no particular order.
+func (r *Redis) SetMembers(ctx context.Context, key string) ([]string, error) {
+	members, err := r.client.SMembers(ctx, collectionKey(key)).Result()
+	if err != nil {
+		return nil, collectionError(err)
+	}
+	slices.Sort(members)
+	return members, nil
+}
+
+func (r *Redis) SortedSetAdd(ctx context.Context, key string, members []ScoredMember) (int, error) {
+	if len(members) == 0 {
+		return 0, nil
+	}
+
+	args := make([]redis.Z, len(members))
+	for i, member := range members {
+		args[i] = redis.Z{Score: member.Score, Member: member.Member}
+	}
+
+	added, err := r.client.ZAdd(ctx, collectionKey(key), args...).Result()
+	return int(added), collectionError(err)
+}
+
+func (r *Redis) SortedSetRange(ctx context.Context, key string, start, stop int) ([]ScoredMember, error) {
+	scored, err := r.client.ZRangeWithScores(ctx, collectionKey(key), int64(start), int64(stop)).Result()
+	if err != nil {
+		return nil, collectionError(err)
+	}
+
+	members := make([]ScoredMember, len(scored))
+	for i, z := range scored {
+		member, _ := z.Member.(string)
+		members[i] = ScoredMember{Member: member, Score: z.Score}
+	}
+	return members, nil
+}
+
+// DeleteCollections deletes every collection whose key starts with prefix.
+func (r *Redis) DeleteCollections(ctx context.Context, prefix string) (int, error) {
+	match := escapeGlob(collectionKey(prefix)) + "*"
+
+	var (
+		deleted int
+		cursor  uint64
+	)
+	for {
+		keys, next, err := r.client.Scan(ctx, cursor, match, namespaceDeleteBatch).Result()
+		if err != nil {
+			return deleted, err
+		}
+
+		if len(keys) > 0 {
+			n, err := r.client.Unlink(ctx, keys...).Result()
+			if err != nil {
+				return deleted, err
+			}
+			deleted += int(n)
+		}
+
+		if next == 0 {
+			return deleted, nil
+		}
+		cursor = next
+	}
+}
+
+func collectionKey(key string) string {
+	return collectionKeyPrefix + key
+}
+
+// collectionError maps the WRONGTYPE reply Redis gives for a key holding another type.
+func collectionError(err error) error {
+	if err != nil && strings.Contains(err.Error(), "WRONGTYPE") {
+		return ErrWrongType
+	}
+	return err
+}
+
+func decodeElement(data string) (any, error) {
+	var value any
+	if err := json.Unmarshal([]byte(data), &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
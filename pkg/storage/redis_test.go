@@ -3,10 +3,17 @@
 package storage_test
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/felipeascari/kv-store/pkg/lock"
 	"github.com/felipeascari/kv-store/pkg/storage"
+	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/require"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
@@ -50,32 +57,622 @@ func TestRedis(t *testing.T) {
 
 		for _, tt := range tests {
 			t.Run(tt.name, func(t *testing.T) {
-				require.NoError(t, store.Save(tt.key, tt.value))
+				_, err := store.Save(ctx, tt.key, tt.value, 0)
+				require.NoError(t, err)
 
-				value, err := store.Retrieve(tt.key)
+				entry, err := store.Retrieve(ctx, tt.key)
 				require.NoError(t, err)
-				require.Equal(t, tt.want, value)
+				require.Equal(t, tt.want, entry.Value)
 			})
 		}
 	})
 
 	t.Run("should return error when retrieving non-existent key", func(t *testing.T) {
-		_, err := store.Retrieve("nonexistent")
+		_, err := store.Retrieve(ctx, "nonexistent")
 		require.ErrorIs(t, err, storage.ErrKeyNotFound)
 	})
 
 	t.Run("should delete existing key", func(t *testing.T) {
-		require.NoError(t, store.Save("temp", "value"))
-		require.NoError(t, store.Delete("temp"))
+		_, err := store.Save(ctx, "temp", "value", 0)
+		require.NoError(t, err)
+		require.NoError(t, store.Delete(ctx, "temp"))
 
-		_, err := store.Retrieve("temp")
+		_, err = store.Retrieve(ctx, "temp")
 		require.ErrorIs(t, err, storage.ErrKeyNotFound)
 	})
 
 	t.Run("should return error when deleting non-existent key", func(t *testing.T) {
-		err := store.Delete("nonexistent")
+		err := store.Delete(ctx, "nonexistent")
 		require.ErrorIs(t, err, storage.ErrKeyNotFound)
 	})
+
+	t.Run("should expire keys with ttl", func(t *testing.T) {
+		expired, err := store.Save(ctx, "session", "abc", time.Second)
+		require.NoError(t, err)
+
+		entry, err := store.Retrieve(ctx, "session")
+		require.NoError(t, err)
+		require.Equal(t, "abc", entry.Value)
+		require.Positive(t, entry.TTL())
+
+		require.Eventually(t, func() bool {
+			_, err := store.Retrieve(ctx, "session")
+			return errors.Is(err, storage.ErrKeyNotFound)
+		}, 3*time.Second, 100*time.Millisecond)
+
+		// Redis dropped the key on its own, its version must not come back
+		version, err := store.Save(ctx, "session", "def", 0)
+		require.NoError(t, err)
+		require.Greater(t, version, expired)
+	})
+
+	t.Run("should compare and swap atomically", func(t *testing.T) {
+		// New keys start past the versions retired by the earlier tests
+		first, err := store.CompareAndSwap(ctx, "cas", 0, "a", 0)
+		require.NoError(t, err)
+		require.Positive(t, first)
+
+		_, err = store.CompareAndSwap(ctx, "cas", 0, "b", 0)
+		require.ErrorIs(t, err, storage.ErrVersionMismatch)
+
+		version, err := store.CompareAndSwap(ctx, "cas", first, "b", 0)
+		require.NoError(t, err)
+		require.Equal(t, first+1, version)
+
+		entry, err := store.Retrieve(ctx, "cas")
+		require.NoError(t, err)
+		require.Equal(t, "b", entry.Value)
+		require.Equal(t, version, entry.Version)
+
+		require.ErrorIs(t, store.CompareAndDelete(ctx, "cas", first), storage.ErrVersionMismatch)
+		require.NoError(t, store.CompareAndDelete(ctx, "cas", version))
+		require.ErrorIs(t, store.CompareAndDelete(ctx, "cas", version), storage.ErrKeyNotFound)
+
+		// A stale version of the deleted key never matches the key written again
+		recreated, err := store.CompareAndSwap(ctx, "cas", 0, "c", 0)
+		require.NoError(t, err)
+		require.Greater(t, recreated, version)
+	})
+
+	t.Run("should migrate keys stored as plain strings", func(t *testing.T) {
+		require.NoError(t, store.Client().Set(ctx, "legacy:user", `{"name":"Alice"}`, 0).Err())
+		require.NoError(t, store.Client().Set(ctx, "legacy:session", `"abc"`, time.Hour).Err())
+		require.NoError(t, store.Client().Set(ctx, "lock:token_counter", "7", 0).Err())
+
+		_, err := store.Retrieve(ctx, "legacy:user")
+		require.Error(t, err)
+
+		migrated, err := store.MigrateStrings(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 2, migrated)
+
+		entry, err := store.Retrieve(ctx, "legacy:user")
+		require.NoError(t, err)
+		require.Equal(t, map[string]any{"name": "Alice"}, entry.Value)
+		require.Positive(t, entry.Version)
+		require.True(t, entry.ExpiresAt.IsZero())
+
+		entry, err = store.Retrieve(ctx, "legacy:session")
+		require.NoError(t, err)
+		require.Equal(t, "abc", entry.Value)
+		require.Positive(t, entry.TTL())
+
+		counter, err := store.Client().Get(ctx, "lock:token_counter").Result()
+		require.NoError(t, err)
+		require.Equal(t, "7", counter)
+
+		migrated, err = store.MigrateStrings(ctx)
+		require.NoError(t, err)
+		require.Zero(t, migrated)
+	})
+
+	t.Run("should scan keys by prefix", func(t *testing.T) {
+		for i := range 25 {
+			_, err := store.Save(ctx, fmt.Sprintf("scan:%02d", i), i, 0)
+			require.NoError(t, err)
+		}
+		require.NoError(t, store.Client().Set(ctx, "scan:lock", "held", 0).Err())
+
+		var (
+			keys   []string
+			cursor string
+		)
+		for {
+			page, err := store.Scan(ctx, "scan:", cursor, 10)
+			require.NoError(t, err)
+
+			keys = append(keys, page.Keys...)
+			if page.Cursor == "" {
+				break
+			}
+			cursor = page.Cursor
+		}
+
+		require.Len(t, keys, 25)
+		require.NotContains(t, keys, "scan:lock")
+	})
+
+	t.Run("should execute batches in a single round trip", func(t *testing.T) {
+		saved, err := store.BatchSave(ctx, []storage.BatchItem{
+			{Key: "batch:a", Value: "1"},
+			{Key: "batch:b", Value: map[string]any{"n": 2}, TTL: time.Minute},
+		})
+		require.NoError(t, err)
+		require.Len(t, saved, 2)
+		require.NoError(t, saved[0].Err)
+		require.Positive(t, saved[0].Entry.Version)
+
+		retrieved, err := store.BatchRetrieve(ctx, []string{"batch:a", "batch:missing", "batch:b"})
+		require.NoError(t, err)
+		require.Equal(t, "1", retrieved[0].Entry.Value)
+		require.ErrorIs(t, retrieved[1].Err, storage.ErrKeyNotFound)
+		require.Equal(t, map[string]any{"n": float64(2)}, retrieved[2].Entry.Value)
+		require.Positive(t, retrieved[2].Entry.TTL())
+
+		deleted, err := store.BatchDelete(ctx, []string{"batch:a", "batch:missing"})
+		require.NoError(t, err)
+		require.NoError(t, deleted[0].Err)
+		require.ErrorIs(t, deleted[1].Err, storage.ErrKeyNotFound)
+	})
+
+	t.Run("should apply transactions atomically", func(t *testing.T) {
+		first, err := store.Save(ctx, "tx:a", 100, 0)
+		require.NoError(t, err)
+
+		_, err = store.Transact(ctx, storage.Transaction{
+			Checks: []storage.TxCheck{{Key: "tx:a", Version: first}, {Key: "tx:b", Version: 1}},
+			Ops: []storage.TxOp{
+				{Type: storage.TxSet, Key: "tx:a", Value: 70},
+				{Type: storage.TxSet, Key: "tx:b", Value: 30},
+			},
+		})
+		var check *storage.TxCheckError
+		require.ErrorAs(t, err, &check)
+		require.Equal(t, storage.TxCheckError{Key: "tx:b", Expected: 1, Actual: 0}, *check)
+
+		results, err := store.Transact(ctx, storage.Transaction{
+			Checks: []storage.TxCheck{{Key: "tx:a", Version: first}, {Key: "tx:b", Version: 0}},
+			Ops: []storage.TxOp{
+				{Type: storage.TxSet, Key: "tx:a", Value: 70},
+				{Type: storage.TxSet, Key: "tx:b", Value: 30, TTL: time.Minute},
+				{Type: storage.TxDelete, Key: "tx:a"},
+				{Type: storage.TxSet, Key: "tx:a", Value: 70},
+			},
+		})
+		require.NoError(t, err)
+		require.Equal(t, first+1, results[0].Version)
+		require.Positive(t, results[1].Version)
+		require.Zero(t, results[2].Version)
+		// The key written again starts past the version it was deleted at
+		require.Greater(t, results[3].Version, results[0].Version)
+
+		entry, err := store.Retrieve(ctx, "tx:b")
+		require.NoError(t, err)
+		require.Equal(t, float64(30), entry.Value)
+		require.Positive(t, entry.TTL())
+	})
+
+	t.Run("should increment counters atomically", func(t *testing.T) {
+		entry, err := store.Increment(ctx, "counter:hits", 1)
+		require.NoError(t, err)
+		require.Equal(t, float64(1), entry.Value)
+		first := entry.Version
+
+		entry, err = store.Increment(ctx, "counter:hits", -4)
+		require.NoError(t, err)
+		require.Equal(t, float64(-3), entry.Value)
+
+		entry, err = store.Increment(ctx, "counter:hits", 0.25)
+		require.NoError(t, err)
+		require.Equal(t, -2.75, entry.Value)
+		require.Equal(t, first+2, entry.Version)
+
+		_, err = store.Save(ctx, "counter:ttl", 10, time.Hour)
+		require.NoError(t, err)
+		entry, err = store.Increment(ctx, "counter:ttl", 5)
+		require.NoError(t, err)
+		require.Equal(t, float64(15), entry.Value)
+		require.False(t, entry.ExpiresAt.IsZero())
+
+		_, err = store.Save(ctx, "counter:name", "Alice", 0)
+		require.NoError(t, err)
+		_, err = store.Increment(ctx, "counter:name", 1)
+		require.ErrorIs(t, err, storage.ErrNotNumeric)
+	})
+
+	t.Run("should update existing keys", func(t *testing.T) {
+		_, err := store.Update(ctx, "update:missing", func(storage.Entry) (any, error) { return 1, nil })
+		require.ErrorIs(t, err, storage.ErrKeyNotFound)
+
+		first, err := store.Save(ctx, "update:user", map[string]any{"name": "Alice"}, time.Hour)
+		require.NoError(t, err)
+
+		attempts := 0
+		entry, err := store.Update(ctx, "update:user", func(current storage.Entry) (any, error) {
+			attempts++
+			if attempts == 1 {
+				// A concurrent writer sneaks in, so the first attempt must be retried.
+				_, err := store.Save(ctx, "update:user", map[string]any{"name": "Carol"}, time.Hour)
+				require.NoError(t, err)
+			}
+			return map[string]any{"name": current.Value.(map[string]any)["name"].(string) + "!"}, nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, 2, attempts)
+		require.Equal(t, first+2, entry.Version)
+		require.Equal(t, map[string]any{"name": "Carol!"}, entry.Value)
+		require.False(t, entry.ExpiresAt.IsZero())
+	})
+
+	t.Run("should query secondary indexes", func(t *testing.T) {
+		indexed, err := storage.NewRedisWithOptions(storage.RedisOptions{
+			Addr:    store.Client().Options().Addr,
+			Indexes: []storage.Index{{Prefix: "member:", Path: "team"}},
+		})
+		require.NoError(t, err)
+		defer func() { _ = indexed.Close() }()
+
+		infra := storage.Query{Prefix: "member:", Where: []storage.Condition{{Path: "team", Value: "infra"}}}
+		keys := func(t *testing.T, q storage.Query) []string {
+			t.Helper()
+			result, err := indexed.Query(ctx, q)
+			require.NoError(t, err)
+			keys := make([]string, len(result.Items))
+			for i, item := range result.Items {
+				keys[i] = item.Key
+			}
+			return keys
+		}
+
+		for i := range 5 {
+			_, err := indexed.Save(ctx, fmt.Sprintf("member:%d", i), map[string]any{"team": "infra"}, 0)
+			require.NoError(t, err)
+		}
+		_, err = indexed.Save(ctx, "member:1", map[string]any{"team": "web"}, 0)
+		require.NoError(t, err)
+		require.NoError(t, indexed.Delete(ctx, "member:2"))
+		_, err = indexed.BatchDelete(ctx, []string{"member:3"})
+		require.NoError(t, err)
+		_, err = indexed.Transact(ctx, storage.Transaction{Ops: []storage.TxOp{
+			{Type: storage.TxSet, Key: "member:5", Value: map[string]any{"team": "infra"}},
+			{Type: storage.TxDelete, Key: "member:4"},
+		}})
+		require.NoError(t, err)
+		_, err = indexed.Save(ctx, "member:6", map[string]any{"team": "infra"}, 50*time.Millisecond)
+		require.NoError(t, err)
+
+		require.Equal(t, []string{"member:0", "member:5", "member:6"}, keys(t, infra))
+		require.Equal(t, []string{"member:1"}, keys(t, storage.Query{Prefix: "member:", Where: []storage.Condition{{Path: "team", Value: "web"}}}))
+
+		time.Sleep(200 * time.Millisecond)
+		require.Equal(t, []string{"member:0", "member:5"}, keys(t, infra))
+
+		infra.Limit = 1
+		result, err := indexed.Query(ctx, infra)
+		require.NoError(t, err)
+		require.Len(t, result.Items, 1)
+		require.NotEmpty(t, result.Cursor)
+
+		infra.Cursor = result.Cursor
+		result, err = indexed.Query(ctx, infra)
+		require.NoError(t, err)
+		require.Len(t, result.Items, 1)
+		require.Equal(t, "member:5", result.Items[0].Key)
+
+		// Index entries are not keys of their own.
+		scan, err := indexed.Scan(ctx, "kv-store:", "", 100)
+		require.NoError(t, err)
+		require.Empty(t, scan.Keys)
+	})
+
+	t.Run("should store blobs byte for byte", func(t *testing.T) {
+		blob := storage.Blob{ContentType: "image/png", Data: []byte{0x89, 'P', 'N', 'G', 0x00, 0xff}}
+
+		_, err := store.Save(ctx, "blob:image", blob, 0)
+		require.NoError(t, err)
+
+		entry, err := store.Retrieve(ctx, "blob:image")
+		require.NoError(t, err)
+		require.Equal(t, blob, entry.Value)
+
+		results, err := store.BatchRetrieve(ctx, []string{"blob:image"})
+		require.NoError(t, err)
+		require.Equal(t, blob, results[0].Entry.Value)
+
+		_, err = store.Increment(ctx, "blob:image", 1)
+		require.ErrorIs(t, err, storage.ErrNotNumeric)
+	})
+
+	t.Run("should compress large values transparently", func(t *testing.T) {
+		open := func(compression storage.Compression) *storage.Redis {
+			t.Helper()
+			r, err := storage.NewRedisWithOptions(storage.RedisOptions{
+				Addr:                 store.Client().Options().Addr,
+				Compression:          compression,
+				CompressionThreshold: 128,
+			})
+			require.NoError(t, err)
+			return r
+		}
+
+		zstdStore := open(storage.CompressionZstd)
+		defer func() { _ = zstdStore.Close() }()
+		snappyStore := open(storage.CompressionSnappy)
+		defer func() { _ = snappyStore.Close() }()
+
+		doc := map[string]any{"text": strings.Repeat("compressible ", 100)}
+		want := map[string]any{"text": doc["text"]}
+
+		_, err := zstdStore.Save(ctx, "compressed:zstd", doc, 0)
+		require.NoError(t, err)
+		_, err = snappyStore.Save(ctx, "compressed:snappy", doc, 0)
+		require.NoError(t, err)
+		_, err = zstdStore.Save(ctx, "compressed:small", "small", 0)
+		require.NoError(t, err)
+		_, err = zstdStore.Increment(ctx, "compressed:counter", 2)
+		require.NoError(t, err)
+
+		raw, err := store.Client().HGet(ctx, "compressed:zstd", "value").Bytes()
+		require.NoError(t, err)
+		require.Equal(t, byte(0x01), raw[0])
+		require.Less(t, len(raw), 200)
+
+		raw, err = store.Client().HGet(ctx, "compressed:small", "value").Bytes()
+		require.NoError(t, err)
+		require.Equal(t, `"small"`, string(raw))
+
+		for _, key := range []string{"compressed:zstd", "compressed:snappy"} {
+			for _, reader := range []*storage.Redis{store, zstdStore, snappyStore} {
+				entry, err := reader.Retrieve(ctx, key)
+				require.NoError(t, err)
+				require.Equal(t, want, entry.Value)
+			}
+		}
+
+		entry, err := zstdStore.Increment(ctx, "compressed:counter", 3)
+		require.NoError(t, err)
+		require.Equal(t, float64(5), entry.Value)
+
+		stats, ok := zstdStore.CompressionStats()
+		require.True(t, ok)
+		require.Equal(t, uint64(1), stats.Compressed)
+		require.Equal(t, uint64(1), stats.Skipped)
+		require.Greater(t, stats.Ratio(), 5.0)
+
+		_, ok = store.CompressionStats()
+		require.False(t, ok)
+
+		_, err = storage.NewRedisWithOptions(storage.RedisOptions{Compression: "lz4"})
+		require.Error(t, err)
+	})
+
+	t.Run("should encrypt values and re-encrypt them in place", func(t *testing.T) {
+		oldKey := bytes.Repeat([]byte{1}, storage.EncryptionKeySize)
+		newKey := bytes.Repeat([]byte{2}, storage.EncryptionKeySize)
+
+		old, err := storage.NewKeyring("old", map[string][]byte{"old": oldKey})
+		require.NoError(t, err)
+		version, err := storage.NewEncrypted(store, old, storage.EncryptionOptions{}).Save(ctx, "encrypted:user", "ada@example.com", time.Minute)
+		require.NoError(t, err)
+
+		raw, err := store.Client().HGet(ctx, "encrypted:user", "value").Bytes()
+		require.NoError(t, err)
+		require.NotContains(t, string(raw), "ada@example.com")
+
+		rotated, err := storage.NewKeyring("new", map[string][]byte{"old": oldKey, "new": newKey})
+		require.NoError(t, err)
+		rewritten, err := storage.NewEncrypted(store, rotated, storage.EncryptionOptions{}).Reencrypt(ctx)
+		require.NoError(t, err)
+		require.Positive(t, rewritten)
+
+		current, err := storage.NewKeyring("new", map[string][]byte{"new": newKey})
+		require.NoError(t, err)
+		entry, err := storage.NewEncrypted(store, current, storage.EncryptionOptions{}).Retrieve(ctx, "encrypted:user")
+		require.NoError(t, err)
+		require.Equal(t, "ada@example.com", entry.Value)
+		require.Equal(t, version, entry.Version)
+		require.False(t, entry.ExpiresAt.IsZero())
+
+		require.ErrorIs(t, store.Rewrite(ctx, "encrypted:user", version+1, "x"), storage.ErrVersionMismatch)
+		require.ErrorIs(t, store.Rewrite(ctx, "encrypted:missing", 1, "x"), storage.ErrKeyNotFound)
+	})
+
+	t.Run("should not store collections in plaintext when values are encrypted", func(t *testing.T) {
+		keyring, err := storage.NewKeyring("k", map[string][]byte{"k": bytes.Repeat([]byte{1}, storage.EncryptionKeySize)})
+		require.NoError(t, err)
+		lockMgr := lock.NewManager(lock.NewRedisLock(store.Client(), 5*time.Second))
+		locked := storage.NewLockedStore(storage.NewEncrypted(store, keyring, storage.EncryptionOptions{}), lockMgr)
+
+		_, err = locked.HashSet(ctx, "encrypted:profile", map[string]any{"email": "ada@example.com"})
+		require.ErrorIs(t, err, storage.ErrCollectionsUnsupported)
+
+		_, err = store.Client().HGet(ctx, "kv-store:collection:encrypted:profile", "email").Result()
+		require.ErrorIs(t, err, redis.Nil)
+	})
+
+	t.Run("should compress values before encrypting them", func(t *testing.T) {
+		zstdStore, err := storage.NewRedisWithOptions(storage.RedisOptions{
+			Addr:                 store.Client().Options().Addr,
+			Compression:          storage.CompressionZstd,
+			CompressionThreshold: 128,
+		})
+		require.NoError(t, err)
+		defer func() { _ = zstdStore.Close() }()
+
+		keyring, err := storage.NewKeyring("k", map[string][]byte{"k": bytes.Repeat([]byte{1}, storage.EncryptionKeySize)})
+		require.NoError(t, err)
+		e := storage.NewEncrypted(zstdStore, keyring, storage.EncryptionOptions{})
+
+		doc := map[string]any{"text": strings.Repeat("compressible ", 100)}
+		_, err = e.Save(ctx, "encrypted:compressed", doc, 0)
+		require.NoError(t, err)
+
+		raw, err := store.Client().HGet(ctx, "encrypted:compressed", "value").Bytes()
+		require.NoError(t, err)
+		require.Less(t, len(raw), 300)
+
+		entry, err := e.Retrieve(ctx, "encrypted:compressed")
+		require.NoError(t, err)
+		require.Equal(t, doc, entry.Value)
+
+		stats, ok := e.CompressionStats()
+		require.True(t, ok)
+		require.Equal(t, uint64(1), stats.Compressed)
+	})
+
+	t.Run("should keep version history", func(t *testing.T) {
+		historyStore, err := storage.NewRedisWithOptions(storage.RedisOptions{
+			Addr:    store.Client().Options().Addr,
+			History: 3,
+		})
+		require.NoError(t, err)
+		defer func() { _ = historyStore.Close() }()
+
+		testHistory(t, historyStore)
+
+		_, err = store.History(ctx, "history:config")
+		require.ErrorIs(t, err, storage.ErrHistoryUnsupported)
+	})
+
+	t.Run("should soft delete keys", func(t *testing.T) {
+		testSoftDelete(t, store)
+	})
+
+	t.Run("should store native collections", func(t *testing.T) {
+		testCollections(t, store)
+	})
+
+	t.Run("should not export collections", func(t *testing.T) {
+		_, err := store.HashSet(ctx, "export:profile", map[string]any{"value": "ada"})
+		require.NoError(t, err)
+
+		require.NoError(t, storage.Export(ctx, store, func(key string, _ storage.Entry) error {
+			require.NotContains(t, key, "export:profile")
+			return nil
+		}))
+	})
+
+	t.Run("should capture every write in a change stream", func(t *testing.T) {
+		changes := storage.NewChangeStream(storage.ChangeStreamOptions{Stream: "test:changes", MaxLen: 100})
+		lockMgr := lock.NewManager(lock.NewRedisLock(store.Client(), 5*time.Second))
+		locked := storage.NewLockedStore(store, lockMgr).WithChangeStream(changes)
+
+		_, err := locked.Save(ctx, "cdc:a", map[string]any{"n": 1.0}, time.Minute)
+		require.NoError(t, err)
+		_, err = locked.BatchSave(ctx, []storage.BatchItem{
+			{Key: "cdc:b", Value: storage.Blob{ContentType: "text/plain", Data: []byte("hi")}},
+		})
+		require.NoError(t, err)
+		_, err = locked.Transact(ctx, storage.Transaction{Ops: []storage.TxOp{
+			{Type: storage.TxSet, Key: "cdc:c", Value: "x"},
+			{Type: storage.TxDelete, Key: "cdc:a"},
+		}})
+		require.NoError(t, err)
+		require.NoError(t, locked.Delete(ctx, "cdc:b"))
+		require.ErrorIs(t, locked.Delete(ctx, "cdc:missing"), storage.ErrKeyNotFound)
+		_, err = locked.Save(ctx, "kv-store:tombstone:cdc:b", "tombstone", 0)
+		require.NoError(t, err)
+		_, err = locked.Increment(ctx, "cdc:n", 2)
+		require.NoError(t, err)
+
+		require.NoError(t, store.Client().XGroupCreate(ctx, changes.Stream(), "indexer", "0").Err())
+		streams, err := store.Client().XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    "indexer",
+			Consumer: "indexer-1",
+			Streams:  []string{changes.Stream(), ">"},
+			Count:    10,
+		}).Result()
+		require.NoError(t, err)
+		require.Len(t, streams, 1)
+
+		var captured []storage.Change
+		for _, msg := range streams[0].Messages {
+			change, err := changes.Decode(msg)
+			require.NoError(t, err)
+			require.Positive(t, change.Token)
+			require.Equal(t, lockMgr.ServerID(), change.ServerID)
+			require.False(t, change.Timestamp.IsZero())
+			captured = append(captured, change)
+		}
+		require.Len(t, captured, 6)
+
+		require.Equal(t, "cdc:a", captured[0].Key)
+		require.Equal(t, storage.ChangeSave, captured[0].Op)
+		require.Equal(t, map[string]any{"n": 1.0}, captured[0].Value)
+		require.Positive(t, captured[0].Version)
+		require.WithinDuration(t, time.Now().Add(time.Minute), captured[0].ExpiresAt, 5*time.Second)
+		require.Equal(t, storage.Blob{ContentType: "text/plain", Data: []byte("hi")}, captured[1].Value)
+		require.Equal(t, "x", captured[2].Value)
+		require.Equal(t, storage.ChangeDelete, captured[3].Op)
+		require.Equal(t, "cdc:a", captured[3].Key)
+		require.Nil(t, captured[3].Value)
+		require.Equal(t, "cdc:b", captured[4].Key)
+		require.Equal(t, "cdc:n", captured[5].Key)
+		require.Equal(t, 2.0, captured[5].Value)
+
+		replay, err := store.Client().XRange(ctx, changes.Stream(), captured[3].ID, "+").Result()
+		require.NoError(t, err)
+		require.Len(t, replay, 3)
+
+		keyring, err := storage.NewKeyring("k", map[string][]byte{"k": bytes.Repeat([]byte{3}, storage.EncryptionKeySize)})
+		require.NoError(t, err)
+		sealed := storage.NewChangeStream(storage.ChangeStreamOptions{Stream: "test:changes:sealed", Keyring: keyring})
+		_, err = storage.NewLockedStore(store, lockMgr).WithChangeStream(sealed).Save(ctx, "cdc:secret", "ada@example.com", 0)
+		require.NoError(t, err)
+		_, err = storage.NewLockedStore(store, lockMgr).WithChangeStream(sealed).Increment(ctx, "cdc:secret-n", 4)
+		require.NoError(t, err)
+
+		encrypted := storage.NewEncrypted(store, keyring, storage.EncryptionOptions{})
+		defer func() { _ = encrypted.Close() }()
+		_, err = storage.NewLockedStore(encrypted, lockMgr).WithChangeStream(sealed).Save(ctx, "cdc:encrypted", "grace@example.com", 0)
+		require.NoError(t, err)
+
+		entries, err := store.Client().XRange(ctx, sealed.Stream(), "-", "+").Result()
+		require.NoError(t, err)
+		require.Len(t, entries, 3)
+		require.NotContains(t, fmt.Sprint(entries[0].Values), "ada@example.com")
+		require.NotContains(t, fmt.Sprint(entries[2].Values), "grace@example.com")
+		for i, value := range []any{"ada@example.com", 4.0, "grace@example.com"} {
+			require.Contains(t, entries[i].Values, "data")
+			require.NotContains(t, entries[i].Values, "value")
+			change, err := sealed.Decode(entries[i])
+			require.NoError(t, err)
+			require.Equal(t, value, change.Value)
+		}
+	})
+
+	t.Run("should invalidate local caches across replicas", func(t *testing.T) {
+		first, err := storage.NewTiered(store, store.Client(), storage.TieredOptions{MaxKeys: 100, Channel: "test:invalidations"})
+		require.NoError(t, err)
+		defer func() { _ = first.Close() }()
+
+		second, err := storage.NewTiered(store, store.Client(), storage.TieredOptions{MaxKeys: 100, Channel: "test:invalidations"})
+		require.NoError(t, err)
+		defer func() { _ = second.Close() }()
+
+		_, err = first.Save(ctx, "tiered:a", "v1", 0)
+		require.NoError(t, err)
+
+		entry, err := second.Retrieve(ctx, "tiered:a")
+		require.NoError(t, err)
+		require.Equal(t, "v1", entry.Value)
+		require.Equal(t, int64(1), second.Stats().Keys)
+
+		version, err := first.Save(ctx, "tiered:a", "v2", 0)
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool {
+			entry, err := second.Retrieve(ctx, "tiered:a")
+			return err == nil && entry.Value == "v2" && entry.Version == version
+		}, time.Second, 10*time.Millisecond)
+
+		require.NoError(t, first.Delete(ctx, "tiered:a"))
+
+		require.Eventually(t, func() bool {
+			_, err := second.Retrieve(ctx, "tiered:a")
+			return errors.Is(err, storage.ErrKeyNotFound)
+		}, time.Second, 10*time.Millisecond)
+	})
 }
 
 func setupRedis(t *testing.T, ctx context.Context) (*storage.Redis, func()) {
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"container/heap"
+	"encoding/base64"
+	"slices"
+	"strings"
+)
+
+// keyHeap is a max-heap of keys used to select the smallest keys of a scan page
+// without sorting the whole keyspace.
+type keyHeap []string
+
+func (h keyHeap) Len() int           { return len(h) }
+func (h keyHeap) Less(i, j int) bool { return h[i] > h[j] }
+func (h keyHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *keyHeap) Push(x any)        { *h = append(*h, x.(string)) }
+
+func (h *keyHeap) Pop() any {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// pageSelector collects the first limit keys, in lexical order, that start with
+// prefix and sort strictly after the cursor position.
+type pageSelector struct {
+	prefix string
+	after  string
+	limit  int
+	keys   keyHeap
+	more   bool
+}
+
+func newPageSelector(prefix, cursor string, limit int) (*pageSelector, error) {
+	after, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 {
+		limit = DefaultScanLimit
+	}
+
+	return &pageSelector{
+		prefix: prefix,
+		after:  after,
+		limit:  limit,
+		keys:   make(keyHeap, 0, limit),
+	}, nil
+}
+
+func (p *pageSelector) offer(key string) {
+	if !strings.HasPrefix(key, p.prefix) || (p.after != "" && key <= p.after) {
+		return
+	}
+
+	if len(p.keys) < p.limit {
+		heap.Push(&p.keys, key)
+		return
+	}
+
+	p.more = true
+	if key < p.keys[0] {
+		p.keys[0] = key
+		heap.Fix(&p.keys, 0)
+	}
+}
+
+func (p *pageSelector) result() ScanResult {
+	keys := []string(p.keys)
+	slices.Sort(keys)
+
+	result := ScanResult{Keys: keys}
+	if p.more && len(keys) > 0 {
+		result.Cursor = encodeCursor(keys[len(keys)-1])
+	}
+
+	return result
+}
+
+func encodeCursor(position string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(position))
+}
+
+func decodeCursor(cursor string) (string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", ErrInvalidCursor
+	}
+	return string(data), nil
+}
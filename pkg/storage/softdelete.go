@@ -0,0 +1,337 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// A soft deleted key is moved, in one transaction, to a tombstone: the reserved key
+// tombstoneKeyPrefix followed by the stored key, whose value records when the key was
+// deleted along with its value and expiration. Tombstones are kept for the retention period,
+// during which the key can be restored, and are then purged. Writing the key again does not
+// remove its tombstone, but restoring it fails as long as it exists.
+const tombstoneKeyPrefix = reservedKeyPrefix + "tombstone:"
+
+var (
+	// ErrKeyDeleted is returned when reading a key deleted within the retention period. It
+	// wraps ErrKeyNotFound, so callers that do not care about tombstones see a missing key.
+	ErrKeyDeleted = fmt.Errorf("key was deleted: %w", ErrKeyNotFound)
+	// ErrKeyExists is returned when restoring a deleted key that was written again since.
+	ErrKeyExists = errors.New("key already exists")
+	// ErrSoftDeleteUnsupported is returned by stores that delete keys permanently.
+	ErrSoftDeleteUnsupported = errors.New("storage does not keep deleted keys")
+)
+
+type (
+	// SoftDeleteOptions configures a SoftDeleting store.
+	SoftDeleteOptions struct {
+		// Retention is how long deleted keys can be restored.
+		Retention time.Duration
+	}
+
+	// SoftDeleting turns the deletes of Delete, CompareAndDelete and BatchDelete into tombstones
+	// that can be restored with Undelete until the retention period is over. Deletes made by
+	// transactions are permanent. It sits under Namespaced, so it sees full keys, and above
+	// the LockedStore, which locks the key and its tombstone together.
+	SoftDeleting struct {
+		// Collections are deleted by their own operations, which are forwarded as they are.
+		forwardCollections
+
+		next       Store
+		retention  time.Duration
+		background *background
+	}
+
+	// Undeleter is implemented by the stores that keep deleted keys for a while.
+	Undeleter interface {
+		// Undelete restores a deleted key with the value and expiration it had, as a new
+		// version, and returns it. It fails with ErrKeyNotFound when the key has no tombstone
+		// and with ErrKeyExists when the key was written again since it was deleted.
+		Undelete(ctx context.Context, key string) (Entry, error)
+	}
+
+	// tombstone is the decoded value of a tombstone key.
+	tombstone struct {
+		DeletedAt time.Time
+		ExpiresAt time.Time
+		Value     any
+	}
+)
+
+func NewSoftDeleting(next Store, opts SoftDeleteOptions) *SoftDeleting {
+	return &SoftDeleting{
+		forwardCollections: forwardCollections{store: next},
+		next:               next,
+		retention:          opts.Retention,
+		background:         newBackground(),
+	}
+}
+
+func (s *SoftDeleting) Save(ctx context.Context, key string, value any, ttl time.Duration) (int64, error) {
+	return s.next.Save(ctx, key, value, ttl)
+}
+
+// Retrieve reports a missing key with a live tombstone as ErrKeyDeleted.
+func (s *SoftDeleting) Retrieve(ctx context.Context, key string) (Entry, error) {
+	entry, err := s.next.Retrieve(ctx, key)
+	if !errors.Is(err, ErrKeyNotFound) {
+		return entry, err
+	}
+
+	if _, _, tombErr := s.tombstone(ctx, key); tombErr == nil {
+		return Entry{}, ErrKeyDeleted
+	}
+	return Entry{}, err
+}
+
+// Delete buries the current version of the key, retrying if it changes in between.
+func (s *SoftDeleting) Delete(ctx context.Context, key string) error {
+	for range maxUpdateAttempts {
+		current, err := s.next.Retrieve(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		err = s.bury(ctx, key, current)
+		if !errors.Is(err, ErrVersionMismatch) {
+			return err
+		}
+	}
+	return ErrVersionMismatch
+}
+
+func (s *SoftDeleting) CompareAndSwap(ctx context.Context, key string, expectedVersion int64, value any, ttl time.Duration) (int64, error) {
+	return s.next.CompareAndSwap(ctx, key, expectedVersion, value, ttl)
+}
+
+func (s *SoftDeleting) CompareAndDelete(ctx context.Context, key string, expectedVersion int64) error {
+	current, err := s.next.Retrieve(ctx, key)
+	if err != nil {
+		return err
+	}
+	if current.Version != expectedVersion {
+		return ErrVersionMismatch
+	}
+	return s.bury(ctx, key, current)
+}
+
+func (s *SoftDeleting) Increment(ctx context.Context, key string, delta float64) (Entry, error) {
+	return s.next.Increment(ctx, key, delta)
+}
+
+func (s *SoftDeleting) Update(ctx context.Context, key string, fn UpdateFunc) (Entry, error) {
+	return s.next.Update(ctx, key, fn)
+}
+
+func (s *SoftDeleting) Scan(ctx context.Context, prefix, cursor string, limit int) (ScanResult, error) {
+	return s.next.Scan(ctx, prefix, cursor, limit)
+}
+
+func (s *SoftDeleting) BatchSave(ctx context.Context, items []BatchItem) ([]BatchResult, error) {
+	return s.next.BatchSave(ctx, items)
+}
+
+func (s *SoftDeleting) BatchRetrieve(ctx context.Context, keys []string) ([]BatchResult, error) {
+	return s.next.BatchRetrieve(ctx, keys)
+}
+
+// BatchDelete buries the keys one at a time, as every key needs a transaction of its own.
+func (s *SoftDeleting) BatchDelete(ctx context.Context, keys []string) ([]BatchResult, error) {
+	results := make([]BatchResult, len(keys))
+	for i, key := range keys {
+		results[i] = BatchResult{Key: key, Err: s.Delete(ctx, key)}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+func (s *SoftDeleting) Transact(ctx context.Context, tx Transaction) ([]TxResult, error) {
+	return s.next.Transact(ctx, tx)
+}
+
+func (s *SoftDeleting) Query(ctx context.Context, q Query) (QueryResult, error) {
+	querier, ok := s.next.(Querier)
+	if !ok {
+		return QueryResult{}, ErrQueryUnsupported
+	}
+	return querier.Query(ctx, q)
+}
+
+func (s *SoftDeleting) History(ctx context.Context, key string) ([]Revision, error) {
+	return historyOf(ctx, s.next, key)
+}
+
+// Undelete writes the value back and removes the tombstone in one transaction. A key that
+// would have expired by now is not restored.
+func (s *SoftDeleting) Undelete(ctx context.Context, key string) (Entry, error) {
+	tomb, version, err := s.tombstone(ctx, key)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	var ttl time.Duration
+	if !tomb.ExpiresAt.IsZero() {
+		if ttl = time.Until(tomb.ExpiresAt); ttl <= 0 {
+			return Entry{}, ErrKeyNotFound
+		}
+	}
+
+	results, err := s.next.Transact(ctx, Transaction{
+		Checks: []TxCheck{
+			{Key: key, Version: 0},
+			{Key: tombstoneKey(key), Version: version},
+		},
+		Ops: []TxOp{
+			{Type: TxSet, Key: key, Value: tomb.Value, TTL: ttl},
+			{Type: TxDelete, Key: tombstoneKey(key)},
+		},
+	})
+	if err != nil {
+		var checkErr *TxCheckError
+		if errors.As(err, &checkErr) && checkErr.Key == key {
+			return Entry{}, ErrKeyExists
+		}
+		if errors.As(err, &checkErr) {
+			return Entry{}, ErrKeyNotFound
+		}
+		return Entry{}, err
+	}
+
+	return Entry{Value: tomb.Value, Version: results[0].Version, ExpiresAt: results[0].ExpiresAt}, nil
+}
+
+// Purge permanently removes the tombstones older than the retention period and returns
+// how many it removed.
+func (s *SoftDeleting) Purge(ctx context.Context) (int, error) {
+	var (
+		purged int
+		cursor string
+	)
+	for {
+		page, err := s.next.Scan(ctx, tombstoneKeyPrefix, cursor, DefaultScanLimit)
+		if err != nil {
+			return purged, err
+		}
+
+		if len(page.Keys) > 0 {
+			results, err := s.next.BatchRetrieve(ctx, page.Keys)
+			if err != nil {
+				return purged, err
+			}
+
+			for _, result := range results {
+				if result.Err != nil || !s.expired(decodeTombstone(result.Entry.Value)) {
+					continue
+				}
+
+				err := s.next.CompareAndDelete(ctx, result.Key, result.Entry.Version)
+				switch {
+				case err == nil:
+					purged++
+				case !errors.Is(err, ErrKeyNotFound) && !errors.Is(err, ErrVersionMismatch):
+					return purged, err
+				}
+			}
+		}
+
+		if page.Cursor == "" {
+			return purged, nil
+		}
+		cursor = page.Cursor
+	}
+}
+
+// StartPurger runs Purge at the given interval until the store is closed. Expired tombstones
+// are ignored until they are purged, so the interval only bounds how long they take space.
+func (s *SoftDeleting) StartPurger(interval time.Duration) {
+	s.background.every(interval, func() {
+		_, _ = s.Purge(context.Background())
+	})
+}
+
+// Close stops the purger. The next store is left open.
+func (s *SoftDeleting) Close() error {
+	s.background.close()
+	return nil
+}
+
+// bury replaces the key by its tombstone if the key is still at the version of current.
+func (s *SoftDeleting) bury(ctx context.Context, key string, current Entry) error {
+	_, err := s.next.Transact(ctx, Transaction{
+		Checks: []TxCheck{{Key: key, Version: current.Version}},
+		Ops: []TxOp{
+			{Type: TxSet, Key: tombstoneKey(key), Value: encodeTombstone(time.Now(), current)},
+			{Type: TxDelete, Key: key},
+		},
+	})
+	return err
+}
+
+// tombstone returns the live tombstone of key and its version, ErrKeyNotFound if there is none.
+func (s *SoftDeleting) tombstone(ctx context.Context, key string) (tombstone, int64, error) {
+	entry, err := s.next.Retrieve(ctx, tombstoneKey(key))
+	if err != nil {
+		return tombstone{}, 0, err
+	}
+
+	tomb := decodeTombstone(entry.Value)
+	if s.expired(tomb) {
+		return tombstone{}, 0, ErrKeyNotFound
+	}
+	return tomb, entry.Version, nil
+}
+
+// expired reports whether a tombstone outlived the retention period. Tombstones that cannot
+// be decoded have a zero DeletedAt and are treated as expired.
+func (s *SoftDeleting) expired(tomb tombstone) bool {
+	return !time.Now().Before(tomb.DeletedAt.Add(s.retention))
+}
+
+func tombstoneKey(key string) string {
+	return tombstoneKeyPrefix + key
+}
+
+// encodeTombstone returns the value of a tombstone as a JSON object, so every store keeps it
+// as it is. Blobs are stored with their data in base64.
+func encodeTombstone(deletedAt time.Time, entry Entry) map[string]any {
+	record := map[string]any{"deleted_at": deletedAt.UTC().Format(time.RFC3339Nano)}
+	if !entry.ExpiresAt.IsZero() {
+		record["expires_at"] = entry.ExpiresAt.UTC().Format(time.RFC3339Nano)
+	}
+
+	if blob, ok := entry.Value.(Blob); ok {
+		record["blob"] = map[string]any{
+			"content_type": blob.ContentType,
+			"data":         base64.StdEncoding.EncodeToString(blob.Data),
+		}
+	} else {
+		record["value"] = entry.Value
+	}
+	return record
+}
+
+func decodeTombstone(value any) tombstone {
+	record, _ := value.(map[string]any)
+
+	var tomb tombstone
+	if deletedAt, ok := record["deleted_at"].(string); ok {
+		tomb.DeletedAt, _ = time.Parse(time.RFC3339Nano, deletedAt)
+	}
+	if expiresAt, ok := record["expires_at"].(string); ok {
+		tomb.ExpiresAt, _ = time.Parse(time.RFC3339Nano, expiresAt)
+	}
+
+	tomb.Value = record["value"]
+	if blob, ok := record["blob"].(map[string]any); ok {
+		contentType, _ := blob["content_type"].(string)
+		encoded, _ := blob["data"].(string)
+		data, _ := base64.StdEncoding.DecodeString(encoded)
+		tomb.Value = Blob{ContentType: contentType, Data: data}
+	}
+	return tomb
+}
@@ -0,0 +1,168 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/felipeascari/kv-store/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemorySoftDelete(t *testing.T) {
+	ctx := context.Background()
+
+	newStore := func(t *testing.T) *storage.Memory {
+		t.Helper()
+		m, err := storage.NewMemoryWithOptions(storage.MemoryOptions{})
+		require.NoError(t, err)
+		return m
+	}
+
+	testSoftDelete(t, newStore(t))
+
+	t.Run("should keep tombstones out of the key listing", func(t *testing.T) {
+		m := newStore(t)
+		n := storage.NewNamespaced(storage.NewSoftDeleting(m, storage.SoftDeleteOptions{Retention: time.Minute}))
+
+		_, err := n.Save(ctx, "a", "one", 0)
+		require.NoError(t, err)
+		_, err = n.Save(ctx, "b", "two", 0)
+		require.NoError(t, err)
+		require.NoError(t, n.Delete(ctx, "a"))
+
+		page, err := n.Scan(ctx, "", "", 0)
+		require.NoError(t, err)
+		require.Equal(t, []string{"b"}, page.Keys)
+	})
+
+	t.Run("should restore keys per namespace", func(t *testing.T) {
+		m := newStore(t)
+		s := storage.NewSoftDeleting(m, storage.SoftDeleteOptions{Retention: time.Minute})
+		n := storage.NewNamespaced(s)
+		billing := storage.WithNamespace(ctx, "billing")
+
+		_, err := storage.NewNamespaces(m).Create(ctx, "billing")
+		require.NoError(t, err)
+		_, err = n.Save(billing, "config", "one", 0)
+		require.NoError(t, err)
+		require.NoError(t, n.Delete(billing, "config"))
+
+		_, err = n.Undelete(ctx, "config")
+		require.ErrorIs(t, err, storage.ErrKeyNotFound)
+
+		entry, err := n.Undelete(billing, "config")
+		require.NoError(t, err)
+		require.Equal(t, "one", entry.Value)
+
+		require.NoError(t, n.Delete(billing, "config"))
+		_, err = storage.NewNamespaces(m).Delete(ctx, "billing")
+		require.NoError(t, err)
+
+		page, err := m.Scan(ctx, "", "", 0)
+		require.NoError(t, err)
+		require.Empty(t, page.Keys)
+	})
+
+	t.Run("should fail to restore without soft deletes", func(t *testing.T) {
+		n := storage.NewNamespaced(newStore(t))
+		_, err := n.Undelete(ctx, "a")
+		require.ErrorIs(t, err, storage.ErrSoftDeleteUnsupported)
+	})
+}
+
+// testSoftDelete checks soft deletes on top of a store.
+func testSoftDelete(t *testing.T, next storage.Store) {
+	ctx := context.Background()
+
+	t.Run("should restore deleted keys", func(t *testing.T) {
+		s := storage.NewSoftDeleting(next, storage.SoftDeleteOptions{Retention: time.Minute})
+
+		_, err := s.Save(ctx, "softdelete:config", map[string]any{"retries": 3.0}, time.Hour)
+		require.NoError(t, err)
+		require.NoError(t, s.Delete(ctx, "softdelete:config"))
+
+		_, err = s.Retrieve(ctx, "softdelete:config")
+		require.ErrorIs(t, err, storage.ErrKeyDeleted)
+		require.ErrorIs(t, err, storage.ErrKeyNotFound)
+
+		_, err = s.Retrieve(ctx, "softdelete:missing")
+		require.ErrorIs(t, err, storage.ErrKeyNotFound)
+		require.NotErrorIs(t, err, storage.ErrKeyDeleted)
+
+		entry, err := s.Undelete(ctx, "softdelete:config")
+		require.NoError(t, err)
+		require.Equal(t, map[string]any{"retries": 3.0}, entry.Value)
+		require.WithinDuration(t, time.Now().Add(time.Hour), entry.ExpiresAt, time.Minute)
+
+		entry, err = s.Retrieve(ctx, "softdelete:config")
+		require.NoError(t, err)
+		require.Equal(t, map[string]any{"retries": 3.0}, entry.Value)
+
+		_, err = s.Undelete(ctx, "softdelete:config")
+		require.ErrorIs(t, err, storage.ErrKeyNotFound)
+	})
+
+	t.Run("should restore blobs and conditional deletes", func(t *testing.T) {
+		s := storage.NewSoftDeleting(next, storage.SoftDeleteOptions{Retention: time.Minute})
+
+		blob := storage.Blob{ContentType: "image/png", Data: []byte{0x89, 'P', 'N', 'G', 0}}
+		version, err := s.Save(ctx, "softdelete:avatar", blob, 0)
+		require.NoError(t, err)
+
+		require.ErrorIs(t, s.CompareAndDelete(ctx, "softdelete:avatar", version+1), storage.ErrVersionMismatch)
+		require.NoError(t, s.CompareAndDelete(ctx, "softdelete:avatar", version))
+
+		entry, err := s.Undelete(ctx, "softdelete:avatar")
+		require.NoError(t, err)
+		require.Equal(t, blob, entry.Value)
+		require.True(t, entry.ExpiresAt.IsZero())
+	})
+
+	t.Run("should not restore keys written again", func(t *testing.T) {
+		s := storage.NewSoftDeleting(next, storage.SoftDeleteOptions{Retention: time.Minute})
+
+		_, err := s.Save(ctx, "softdelete:rewritten", "one", 0)
+		require.NoError(t, err)
+		results, err := s.BatchDelete(ctx, []string{"softdelete:rewritten", "softdelete:missing"})
+		require.NoError(t, err)
+		require.NoError(t, results[0].Err)
+		require.ErrorIs(t, results[1].Err, storage.ErrKeyNotFound)
+
+		_, err = s.Save(ctx, "softdelete:rewritten", "two", 0)
+		require.NoError(t, err)
+
+		_, err = s.Undelete(ctx, "softdelete:rewritten")
+		require.ErrorIs(t, err, storage.ErrKeyExists)
+	})
+
+	t.Run("should purge expired tombstones", func(t *testing.T) {
+		s := storage.NewSoftDeleting(next, storage.SoftDeleteOptions{Retention: 50 * time.Millisecond})
+
+		for _, key := range []string{"softdelete:purged:a", "softdelete:purged:b"} {
+			_, err := s.Save(ctx, key, key, 0)
+			require.NoError(t, err)
+			require.NoError(t, s.Delete(ctx, key))
+		}
+
+		purged, err := s.Purge(ctx)
+		require.NoError(t, err)
+		require.Zero(t, purged)
+
+		time.Sleep(60 * time.Millisecond)
+
+		_, err = s.Retrieve(ctx, "softdelete:purged:a")
+		require.ErrorIs(t, err, storage.ErrKeyNotFound)
+		require.NotErrorIs(t, err, storage.ErrKeyDeleted)
+		_, err = s.Undelete(ctx, "softdelete:purged:a")
+		require.ErrorIs(t, err, storage.ErrKeyNotFound)
+
+		purged, err = s.Purge(ctx)
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, purged, 2)
+
+		purged, err = s.Purge(ctx)
+		require.NoError(t, err)
+		require.Zero(t, purged)
+	})
+}
@@ -1,7 +1,99 @@
 package storage
 
+import (
+	"context"
+	"errors"
+	"time"
+)
+
 type Store interface {
-	Save(key string, value any) error
-	Retrieve(key string) (any, error)
-	Delete(key string) error
+	// Save unconditionally writes the value and returns its new version.
+	Save(ctx context.Context, key string, value any, ttl time.Duration) (int64, error)
+	Retrieve(ctx context.Context, key string) (Entry, error)
+	Delete(ctx context.Context, key string) error
+	// CompareAndSwap writes the value only if the key is currently at expectedVersion,
+	// where 0 means the key must not exist. It returns the new version or ErrVersionMismatch.
+	CompareAndSwap(ctx context.Context, key string, expectedVersion int64, value any, ttl time.Duration) (int64, error)
+	// CompareAndDelete deletes the key only if it is currently at expectedVersion.
+	CompareAndDelete(ctx context.Context, key string, expectedVersion int64) error
+	// Increment atomically adds delta to the numeric value of the key and returns the updated
+	// entry, keeping its expiration. A missing key starts at zero. It fails with ErrNotNumeric
+	// when the current value is not a number.
+	Increment(ctx context.Context, key string, delta float64) (Entry, error)
+	// Update atomically replaces the value of an existing key with the one fn computes from
+	// its current entry, keeping the expiration, and returns the updated entry. It fails with
+	// ErrKeyNotFound when the key is missing and returns the errors of fn unchanged.
+	Update(ctx context.Context, key string, fn UpdateFunc) (Entry, error)
+	// Scan returns a page of keys starting with prefix. An empty cursor starts a new scan
+	// and an empty ScanResult.Cursor means there are no more keys.
+	Scan(ctx context.Context, prefix, cursor string, limit int) (ScanResult, error)
+	// BatchSave, BatchRetrieve and BatchDelete apply an operation to many keys in one round trip.
+	// Results are returned in input order with per-item errors; the returned error is only set
+	// when the batch as a whole could not be executed.
+	BatchSave(ctx context.Context, items []BatchItem) ([]BatchResult, error)
+	BatchRetrieve(ctx context.Context, keys []string) ([]BatchResult, error)
+	BatchDelete(ctx context.Context, keys []string) ([]BatchResult, error)
+	// Transact applies every operation of tx atomically if all of its checks hold, and
+	// returns a *TxCheckError otherwise. Results are returned in operation order.
+	Transact(ctx context.Context, tx Transaction) ([]TxResult, error)
+}
+
+// ExpiryNotifier is implemented by the stores that report the keys they expire.
+type ExpiryNotifier interface {
+	// NotifyExpired calls fn with the stored key of every key removed because it expired.
+	// fn is called from the store's background jobs and must not block.
+	NotifyExpired(fn func(key string))
+}
+
+// ResolveVersion returns the version a conditional write must expect for the
+// precondition to hold, or ErrVersionMismatch if it does not hold right now.
+// Passing the result to CompareAndSwap or CompareAndDelete makes the check atomic.
+func ResolveVersion(ctx context.Context, s Store, key string, p Precondition) (int64, error) {
+	if p.IfMatch == nil && p.IfNoneMatch != nil && p.IfNoneMatch.Any {
+		return 0, nil
+	}
+
+	// Version 0 would make CompareAndSwap create a missing key, which If-Match never allows
+	if p.IfNoneMatch == nil && p.IfMatch != nil && !p.IfMatch.Any && len(p.IfMatch.Versions) == 1 && p.IfMatch.Versions[0] > 0 {
+		return p.IfMatch.Versions[0], nil
+	}
+
+	var version int64
+
+	current, err := s.Retrieve(ctx, key)
+	switch {
+	case err == nil:
+		version = current.Version
+	case !errors.Is(err, ErrKeyNotFound):
+		return 0, err
+	}
+
+	if !p.Allows(version) {
+		return 0, ErrVersionMismatch
+	}
+
+	return version, nil
+}
+
+// ResolveDeleteVersion is ResolveVersion for a conditional delete, which also fails with
+// ErrKeyNotFound when there is no key to delete. If-None-Match: * only holds for a missing
+// key, so it fails with ErrVersionMismatch when the key exists.
+func ResolveDeleteVersion(ctx context.Context, s Store, key string, p Precondition) (int64, error) {
+	if p.IfMatch == nil && p.IfNoneMatch != nil && p.IfNoneMatch.Any {
+		if _, err := s.Retrieve(ctx, key); err != nil {
+			return 0, err
+		}
+		return 0, ErrVersionMismatch
+	}
+
+	version, err := ResolveVersion(ctx, s, key, p)
+	if err != nil {
+		return 0, err
+	}
+
+	if version == 0 {
+		return 0, ErrKeyNotFound
+	}
+
+	return version, nil
 }
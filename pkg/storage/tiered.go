@@ -0,0 +1,430 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// DefaultInvalidationChannel is the pub/sub channel replicas announce their writes on.
+	DefaultInvalidationChannel = "kv-store:invalidations"
+
+	defaultCacheTTL = time.Minute
+	// resubscribeDelay throttles the subscriber while Redis is unreachable.
+	resubscribeDelay = time.Second
+)
+
+type (
+	// TieredOptions configures the local cache of a Tiered store.
+	TieredOptions struct {
+		// MaxKeys and MaxBytes bound the local cache, which evicts least recently used keys.
+		MaxKeys  int64
+		MaxBytes int64
+		// TTL bounds how long a key is served from the cache, and so how stale it can get
+		// if an invalidation message is lost. Defaults to one minute.
+		TTL time.Duration
+		// Channel is the pub/sub channel used for invalidations. Defaults to DefaultInvalidationChannel.
+		Channel string
+	}
+
+	// Tiered serves reads from a bounded in-process cache in front of another store,
+	// usually a LockedStore over Redis. Writes go to the next store first and then to the
+	// cache, and every replica sharing the Redis instance is told through pub/sub to drop
+	// the keys it has cached, so replicas only serve stale reads while a message is in flight.
+	Tiered struct {
+		// Collections are not cached, their operations go straight to the next store.
+		forwardCollections
+
+		next    Store
+		cache   *Memory
+		client  *redis.Client
+		pubsub  *redis.PubSub
+		channel string
+		ttl     time.Duration
+		// origin tells this replica's own invalidations apart from the others'.
+		origin string
+		// epoch changes on every invalidation. A read only fills the cache if no
+		// invalidation happened since it started, so it cannot cache a value
+		// that was overwritten while it was in flight.
+		epoch      atomic.Uint64
+		background *background
+	}
+
+	invalidation struct {
+		Origin string   `json:"origin"`
+		Keys   []string `json:"keys,omitempty"`
+	}
+)
+
+// NewTiered wraps next with a local cache and subscribes to the invalidation channel.
+// It returns once the subscription is active, so no write from another replica is missed.
+func NewTiered(next Store, client *redis.Client, opts TieredOptions) (*Tiered, error) {
+	if opts.TTL <= 0 {
+		opts.TTL = defaultCacheTTL
+	}
+	if opts.Channel == "" {
+		opts.Channel = DefaultInvalidationChannel
+	}
+
+	cache, err := NewMemoryWithOptions(MemoryOptions{
+		MaxKeys:  opts.MaxKeys,
+		MaxBytes: opts.MaxBytes,
+		Eviction: EvictionLRU,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	origin := make([]byte, 8)
+	_, _ = rand.Read(origin)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pubsub := client.Subscribe(ctx, opts.Channel)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		_ = pubsub.Close()
+		return nil, err
+	}
+
+	t := &Tiered{
+		forwardCollections: forwardCollections{store: next},
+		next:               next,
+		cache:              cache,
+		client:             client,
+		pubsub:             pubsub,
+		channel:            opts.Channel,
+		ttl:                opts.TTL,
+		origin:             hex.EncodeToString(origin),
+		background:         newBackground(),
+	}
+	t.background.run(t.subscribe)
+	t.background.every(opts.TTL, func() {
+		t.cache.reap(time.Now())
+	})
+
+	return t, nil
+}
+
+func (t *Tiered) Save(ctx context.Context, key string, value any, ttl time.Duration) (int64, error) {
+	epoch := t.epoch.Load()
+
+	version, err := t.next.Save(ctx, key, value, ttl)
+	if err != nil {
+		return 0, err
+	}
+
+	t.written(ctx, epoch, key, Entry{Value: value, Version: version, ExpiresAt: expiresAt(ttl)})
+	return version, nil
+}
+
+func (t *Tiered) Retrieve(ctx context.Context, key string) (Entry, error) {
+	if entry, ok := t.cached(key); ok {
+		return entry, nil
+	}
+
+	epoch := t.epoch.Load()
+
+	entry, err := t.next.Retrieve(ctx, key)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	t.fill(epoch, key, entry)
+	return entry, nil
+}
+
+func (t *Tiered) Delete(ctx context.Context, key string) error {
+	err := t.next.Delete(ctx, key)
+	if err == nil || errors.Is(err, ErrKeyNotFound) {
+		t.deleted(ctx, key)
+	}
+	return err
+}
+
+func (t *Tiered) CompareAndSwap(ctx context.Context, key string, expectedVersion int64, value any, ttl time.Duration) (int64, error) {
+	epoch := t.epoch.Load()
+
+	version, err := t.next.CompareAndSwap(ctx, key, expectedVersion, value, ttl)
+	if err != nil {
+		t.mismatched(key, err)
+		return 0, err
+	}
+
+	t.written(ctx, epoch, key, Entry{Value: value, Version: version, ExpiresAt: expiresAt(ttl)})
+	return version, nil
+}
+
+func (t *Tiered) CompareAndDelete(ctx context.Context, key string, expectedVersion int64) error {
+	err := t.next.CompareAndDelete(ctx, key, expectedVersion)
+	if err == nil || errors.Is(err, ErrKeyNotFound) {
+		t.deleted(ctx, key)
+	}
+	t.mismatched(key, err)
+	return err
+}
+
+func (t *Tiered) Increment(ctx context.Context, key string, delta float64) (Entry, error) {
+	epoch := t.epoch.Load()
+
+	entry, err := t.next.Increment(ctx, key, delta)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	t.written(ctx, epoch, key, entry)
+	return entry, nil
+}
+
+func (t *Tiered) Update(ctx context.Context, key string, fn UpdateFunc) (Entry, error) {
+	epoch := t.epoch.Load()
+
+	entry, err := t.next.Update(ctx, key, fn)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	t.written(ctx, epoch, key, entry)
+	return entry, nil
+}
+
+// Scan always goes to the next store, the cache only holds a subset of the keys.
+func (t *Tiered) Scan(ctx context.Context, prefix, cursor string, limit int) (ScanResult, error) {
+	return t.next.Scan(ctx, prefix, cursor, limit)
+}
+
+// Query always goes to the next store, which holds the indexes.
+func (t *Tiered) Query(ctx context.Context, q Query) (QueryResult, error) {
+	querier, ok := t.next.(Querier)
+	if !ok {
+		return QueryResult{}, ErrQueryUnsupported
+	}
+	return querier.Query(ctx, q)
+}
+
+// History is not cached, it is read from the next store.
+func (t *Tiered) History(ctx context.Context, key string) ([]Revision, error) {
+	return historyOf(ctx, t.next, key)
+}
+
+func (t *Tiered) BatchSave(ctx context.Context, items []BatchItem) ([]BatchResult, error) {
+	epoch := t.epoch.Load()
+
+	results, err := t.next.BatchSave(ctx, items)
+	if err != nil {
+		return nil, err
+	}
+
+	var written []string
+	for _, result := range results {
+		if result.Err != nil {
+			continue
+		}
+		t.fill(epoch, result.Key, result.Entry)
+		written = append(written, result.Key)
+	}
+	t.publish(ctx, written)
+
+	return results, nil
+}
+
+// BatchRetrieve serves the keys it can from the cache and fetches the others in one call.
+func (t *Tiered) BatchRetrieve(ctx context.Context, keys []string) ([]BatchResult, error) {
+	results := make([]BatchResult, len(keys))
+
+	var (
+		missing []string
+		indexes []int
+	)
+	for i, key := range keys {
+		if entry, ok := t.cached(key); ok {
+			results[i] = BatchResult{Key: key, Entry: entry}
+			continue
+		}
+		missing = append(missing, key)
+		indexes = append(indexes, i)
+	}
+
+	if len(missing) == 0 {
+		return results, nil
+	}
+
+	epoch := t.epoch.Load()
+
+	fetched, err := t.next.BatchRetrieve(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+
+	for j, result := range fetched {
+		results[indexes[j]] = result
+		if result.Err == nil {
+			t.fill(epoch, result.Key, result.Entry)
+		}
+	}
+
+	return results, nil
+}
+
+func (t *Tiered) BatchDelete(ctx context.Context, keys []string) ([]BatchResult, error) {
+	results, err := t.next.BatchDelete(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	t.invalidate(keys)
+	t.publish(ctx, keys)
+
+	return results, nil
+}
+
+// Transact drops every written key from the caches instead of filling them, the next
+// read fetches the committed values.
+func (t *Tiered) Transact(ctx context.Context, tx Transaction) ([]TxResult, error) {
+	results, err := t.next.Transact(ctx, tx)
+
+	var check *TxCheckError
+	if errors.As(err, &check) {
+		t.invalidate([]string{check.Key})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, len(tx.Ops))
+	for i, op := range tx.Ops {
+		keys[i] = op.Key
+	}
+	t.invalidate(keys)
+	t.publish(ctx, keys)
+
+	return results, nil
+}
+
+// Stats reports the usage of the local cache.
+func (t *Tiered) Stats() MemoryStats {
+	return t.cache.Stats()
+}
+
+// CompressionStats reports the compression done by the next store.
+func (t *Tiered) CompressionStats() (CompressionStats, bool) {
+	return compressionStatsOf(t.next)
+}
+
+// directSource lets Export read the keys without filling the cache with every one of them.
+func (t *Tiered) directSource() Store {
+	return t.next
+}
+
+// Close stops listening for invalidations. The next store is left open.
+func (t *Tiered) Close() error {
+	err := t.pubsub.Close()
+	t.background.close()
+	return err
+}
+
+// cached returns the entry of key held by the cache, if any.
+func (t *Tiered) cached(key string) (Entry, bool) {
+	item, err := t.cache.Retrieve(context.Background(), key)
+	if err != nil {
+		return Entry{}, false
+	}
+	return item.Value.(Entry), true
+}
+
+// fill caches an entry read or written at epoch. The entry is stored as the value of a
+// cache item expiring after the cache TTL, so the entry keeps its own expiration.
+func (t *Tiered) fill(epoch uint64, key string, entry Entry) {
+	expires := time.Now().Add(t.ttl)
+	if !entry.ExpiresAt.IsZero() && entry.ExpiresAt.Before(expires) {
+		expires = entry.ExpiresAt
+	}
+
+	// A full cache is not an error for the caller, the entry is simply not cached.
+	_ = t.cache.fill(key, Entry{Value: entry, Version: entry.Version, ExpiresAt: expires}, func() bool {
+		return t.epoch.Load() == epoch
+	})
+}
+
+func (t *Tiered) written(ctx context.Context, epoch uint64, key string, entry Entry) {
+	t.fill(epoch, key, entry)
+	t.publish(ctx, []string{key})
+}
+
+func (t *Tiered) deleted(ctx context.Context, key string) {
+	t.invalidate([]string{key})
+	t.publish(ctx, []string{key})
+}
+
+// mismatched drops key from the cache when a conditional write failed, since the
+// condition was most likely resolved against a cached version that is now stale.
+func (t *Tiered) mismatched(key string, err error) {
+	if errors.Is(err, ErrVersionMismatch) {
+		t.invalidate([]string{key})
+	}
+}
+
+// invalidate drops keys from the cache and fails the reads in flight.
+func (t *Tiered) invalidate(keys []string) {
+	t.epoch.Add(1)
+	for _, key := range keys {
+		_ = t.cache.Delete(context.Background(), key)
+	}
+}
+
+// publish tells the other replicas to drop keys. It is best effort: the write already
+// succeeded, and a replica missing the message serves the old value for at most the cache TTL.
+func (t *Tiered) publish(ctx context.Context, keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(invalidation{Origin: t.origin, Keys: keys})
+	if err != nil {
+		return
+	}
+	_ = t.client.Publish(context.WithoutCancel(ctx), t.channel, payload).Err()
+}
+
+// subscribe applies the invalidations published by other replicas until stop is closed.
+// Messages published while the connection is down are lost, so the whole cache is
+// dropped whenever the subscription is re-established.
+func (t *Tiered) subscribe(stop <-chan struct{}) {
+	ctx := context.Background()
+
+	for {
+		msg, err := t.pubsub.Receive(ctx)
+		if err != nil {
+			select {
+			case <-stop:
+				return
+			case <-time.After(resubscribeDelay):
+			}
+			t.flush()
+			continue
+		}
+
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			t.flush()
+		case *redis.Message:
+			var inv invalidation
+			if json.Unmarshal([]byte(msg.Payload), &inv) != nil || inv.Origin == t.origin {
+				continue
+			}
+			t.invalidate(inv.Keys)
+		}
+	}
+}
+
+func (t *Tiered) flush() {
+	t.epoch.Add(1)
+	t.cache.purge()
+}
@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	TxSet    TxOpType = "set"
+	TxDelete TxOpType = "delete"
+)
+
+var (
+	ErrEmptyTransaction = errors.New("transaction has no operations")
+	ErrUnknownTxOp      = errors.New("unknown transaction operation")
+)
+
+type (
+	TxOpType string
+
+	// TxCheck requires Key to be at Version when the transaction runs, 0 meaning the key must not exist.
+	TxCheck struct {
+		Key     string
+		Version int64
+	}
+
+	// TxOp is a write applied by a transaction. Operations run in order, so a key
+	// written twice ends up with the last value and a version bumped twice.
+	TxOp struct {
+		Type  TxOpType
+		Key   string
+		Value any
+		TTL   time.Duration
+	}
+
+	// Transaction applies all of its operations, or none of them if any check fails.
+	Transaction struct {
+		Checks []TxCheck
+		Ops    []TxOp
+	}
+
+	// TxResult is the outcome of one operation: the new version of the key, 0 once deleted.
+	TxResult struct {
+		Key       string
+		Version   int64
+		ExpiresAt time.Time
+	}
+
+	// TxCheckError reports the check that failed. It wraps ErrVersionMismatch.
+	TxCheckError struct {
+		Key      string
+		Expected int64
+		Actual   int64
+	}
+
+	// txWrite is the final state of a key written by a transaction.
+	txWrite struct {
+		key     string
+		entry   Entry
+		deleted bool
+	}
+)
+
+func (e *TxCheckError) Error() string {
+	return fmt.Sprintf("check failed for key %q: expected version %d, found %d", e.Key, e.Expected, e.Actual)
+}
+
+func (e *TxCheckError) Unwrap() error {
+	return ErrVersionMismatch
+}
+
+// Validate reports whether the transaction is well formed, before any store is touched.
+func (tx Transaction) Validate() error {
+	if len(tx.Ops) == 0 {
+		return ErrEmptyTransaction
+	}
+	for i, op := range tx.Ops {
+		if op.Type != TxSet && op.Type != TxDelete {
+			return fmt.Errorf("ops[%d]: %w: %q", i, ErrUnknownTxOp, op.Type)
+		}
+	}
+	return nil
+}
+
+// Keys returns every key the transaction checks or writes, in no particular order and with duplicates.
+func (tx Transaction) Keys() []string {
+	keys := make([]string, 0, len(tx.Checks)+len(tx.Ops))
+	for _, check := range tx.Checks {
+		keys = append(keys, check.Key)
+	}
+	for _, op := range tx.Ops {
+		keys = append(keys, op.Key)
+	}
+	return keys
+}
+
+// plan runs the checks against the current versions and works out the final state of every
+// written key, in the order the keys were first written, along with the result of each operation.
+// next returns the version a key gets when it is written next. A deleted write keeps the version
+// it had, for stores to retire it. Stores call plan while holding the locks of all the keys, then
+// apply the writes in one go.
+func (tx Transaction) plan(version, next func(string) int64) ([]txWrite, []TxResult, error) {
+	for _, check := range tx.Checks {
+		if actual := version(check.Key); actual != check.Version {
+			return nil, nil, &TxCheckError{Key: check.Key, Expected: check.Version, Actual: actual}
+		}
+	}
+
+	var (
+		writes  []txWrite
+		results = make([]TxResult, len(tx.Ops))
+		index   = make(map[string]int)
+	)
+	for i, op := range tx.Ops {
+		j, seen := index[op.Key]
+		if !seen {
+			j = len(writes)
+			index[op.Key] = j
+			writes = append(writes, txWrite{key: op.Key, entry: Entry{Version: next(op.Key) - 1}})
+		}
+
+		write := &writes[j]
+		switch op.Type {
+		case TxSet:
+			write.entry = Entry{
+				Value:     op.Value,
+				Version:   write.entry.Version + 1,
+				ExpiresAt: expiresAt(op.TTL),
+			}
+			write.deleted = false
+			results[i] = TxResult{Key: op.Key, Version: write.entry.Version, ExpiresAt: write.entry.ExpiresAt}
+		case TxDelete:
+			write.entry = Entry{Version: write.entry.Version}
+			write.deleted = true
+			results[i] = TxResult{Key: op.Key}
+		}
+	}
+
+	return writes, results, nil
+}
@@ -1,20 +1,79 @@
 package storage
 
-import "errors"
+import (
+	"errors"
+	"slices"
+	"time"
+)
 
 const (
 	TypeMemory Type = "memory"
 	TypeRedis  Type = "redis"
+	TypeDisk   Type = "disk"
+
+	// DefaultScanLimit is the page size used when Scan is called without a positive limit.
+	DefaultScanLimit = 100
 )
 
 var (
 	ErrKeyNotFound  = errors.New("key not found")
 	ErrInvalidToken = errors.New("invalid fencing token")
+	// ErrVersionMismatch is returned when a conditional write does not match the current version.
+	ErrVersionMismatch = errors.New("version mismatch")
+	ErrInvalidCursor   = errors.New("invalid cursor")
 )
 
 type (
 	Type string
 
+	// Entry is a stored value together with its metadata.
+	// Version is incremented on every write to the key. A new key starts past the versions of
+	// the keys deleted or expired before it, so a version is never reused for the same key.
+	// A zero ExpiresAt means the key never expires.
+	Entry struct {
+		Value     any
+		Version   int64
+		ExpiresAt time.Time
+	}
+
+	// VersionMatch is a parsed If-Match or If-None-Match condition.
+	VersionMatch struct {
+		Any      bool
+		Versions []int64
+	}
+
+	// Precondition guards a write against the current version of a key.
+	// A nil field means the corresponding condition is absent.
+	Precondition struct {
+		IfMatch     *VersionMatch
+		IfNoneMatch *VersionMatch
+	}
+
+	// UpdateFunc computes the new value of a key from its current entry. It may be called
+	// more than once if the key changes concurrently, so it must not have side effects, and
+	// it must not modify the current value in place.
+	UpdateFunc func(current Entry) (any, error)
+
+	// ScanResult is a page of keys and the opaque cursor to fetch the next one.
+	ScanResult struct {
+		Keys   []string
+		Cursor string
+	}
+
+	BatchItem struct {
+		Key   string
+		Value any
+		TTL   time.Duration
+	}
+
+	// BatchResult is the outcome of one item of a batch. For saves Entry holds the
+	// written value and its new version, for retrievals the stored entry.
+	BatchResult struct {
+		Key   string
+		Entry Entry
+		Err   error
+	}
+
 	LockInfo struct {
 		Token     int64
 		Key       string
@@ -29,9 +88,52 @@ func (t Type) String() string {
 
 func (t Type) IsValid() bool {
 	switch t {
-	case TypeMemory, TypeRedis:
+	case TypeMemory, TypeRedis, TypeDisk:
 		return true
 	default:
 		return false
 	}
 }
+
+// Matches reports whether the given version satisfies the condition.
+// Version 0 represents a missing key, which never matches.
+func (m VersionMatch) Matches(version int64) bool {
+	if version == 0 {
+		return false
+	}
+	return m.Any || slices.Contains(m.Versions, version)
+}
+
+func (p Precondition) IsZero() bool {
+	return p.IfMatch == nil && p.IfNoneMatch == nil
+}
+
+// Allows reports whether a write may proceed when the key is at the given version (0 if missing).
+func (p Precondition) Allows(version int64) bool {
+	if p.IfMatch != nil && !p.IfMatch.Matches(version) {
+		return false
+	}
+	if p.IfNoneMatch != nil && p.IfNoneMatch.Matches(version) {
+		return false
+	}
+	return true
+}
+
+// TTL returns the remaining time to live, or zero when the entry has no expiration.
+func (e Entry) TTL() time.Duration {
+	if e.ExpiresAt.IsZero() {
+		return 0
+	}
+	return max(time.Until(e.ExpiresAt), 0)
+}
+
+func expiresAt(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+func isExpired(expiresAt time.Time, now time.Time) bool {
+	return !expiresAt.IsZero() && !now.Before(expiresAt)
+}
@@ -0,0 +1,476 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The memory backend persists itself as a set of files in a data directory:
+//
+//	snapshot-<seq>.dat  every live key at the moment segment <seq> was started
+//	wal-<seq>.log       every Save/Delete and collection write applied after that moment, in order
+//
+// Both files share the same record framing: a 4 byte little endian payload length,
+// a 4 byte CRC32 (Castagnoli) of the payload, then the JSON encoded walRecord.
+// Recovery loads the newest snapshot and replays the segments that follow it.
+//
+// Deletes log the version they retire and snapshots start with the version floor, so the
+// floor survives restarts along with the keys.
+const (
+	FsyncAlways      FsyncPolicy = "always"
+	FsyncEverySecond FsyncPolicy = "everysec"
+	FsyncNever       FsyncPolicy = "no"
+
+	walOpSet    = "set"
+	walOpDelete = "delete"
+	walOpTx     = "tx"
+	walOpFloor  = "floor"
+
+	walOpCollection        = "collection"
+	walOpListPop           = "lpop"
+	walOpDeleteCollections = "delcollections"
+
+	walHeaderSize  = 8
+	walMaxRecord   = 512 << 20
+	walPrefix      = "wal-"
+	walSuffix      = ".log"
+	snapshotPrefix = "snapshot-"
+	snapshotSuffix = ".dat"
+	tmpSuffix      = ".tmp"
+)
+
+var (
+	ErrCorruptLog = errors.New("corrupt write-ahead log")
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+type (
+	FsyncPolicy string
+
+	// PersistenceOptions enables durability for the memory backend.
+	PersistenceOptions struct {
+		// Dir holds the snapshots and log segments. It is created if missing.
+		Dir string
+		// Fsync controls when log writes are flushed to stable storage.
+		Fsync FsyncPolicy
+		// SnapshotInterval is how often the log is compacted into a snapshot. Zero disables it.
+		SnapshotInterval time.Duration
+	}
+
+	walRecord struct {
+		Op        string `json:"op"`
+		Key       string `json:"key"`
+		Value     any    `json:"value,omitempty"`
+		Blob      *Blob  `json:"blob,omitempty"`
+		Version   int64  `json:"version,omitempty"`
+		ExpiresAt int64  `json:"expires_at,omitempty"`
+		// Ops holds the writes of a transaction, replayed together or not at all.
+		Ops []walRecord `json:"ops,omitempty"`
+		// Collection holds the elements added by a collection write.
+		Collection *collectionRecord `json:"collection,omitempty"`
+	}
+
+	// wal is the append-only log of the memory backend.
+	wal struct {
+		mu    sync.Mutex
+		dir   string
+		fsync FsyncPolicy
+		file  *os.File
+		seq   uint64
+	}
+)
+
+func (p FsyncPolicy) String() string {
+	return string(p)
+}
+
+func (p FsyncPolicy) IsValid() bool {
+	switch p {
+	case FsyncAlways, FsyncEverySecond, FsyncNever:
+		return true
+	default:
+		return false
+	}
+}
+
+func openWAL(dir string, fsync FsyncPolicy, seq uint64) (*wal, error) {
+	file, err := os.OpenFile(segmentPath(dir, seq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log segment: %w", err)
+	}
+
+	return &wal{
+		dir:   dir,
+		fsync: fsync,
+		file:  file,
+		seq:   seq,
+	}, nil
+}
+
+func (w *wal) append(records ...walRecord) error {
+	var buf []byte
+	for _, record := range records {
+		var err error
+		if buf, err = appendFrame(buf, record); err != nil {
+			return err
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, err := w.file.Write(buf); err != nil {
+		return fmt.Errorf("failed to append to log: %w", err)
+	}
+
+	if w.fsync == FsyncAlways {
+		return w.file.Sync()
+	}
+
+	return nil
+}
+
+func (w *wal) sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Sync()
+}
+
+// rotate starts a new log segment and returns its sequence number.
+// The previous segment is synced and closed.
+func (w *wal) rotate() (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	next, err := os.OpenFile(segmentPath(w.dir, w.seq+1), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open log segment: %w", err)
+	}
+
+	if err := w.file.Sync(); err != nil {
+		_ = next.Close()
+		return 0, err
+	}
+	_ = w.file.Close()
+
+	w.file = next
+	w.seq++
+
+	return w.seq, nil
+}
+
+func (w *wal) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.file.Sync(); err != nil {
+		_ = w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+func setRecord(key string, entry Entry) walRecord {
+	record := walRecord{
+		Op:      walOpSet,
+		Key:     key,
+		Value:   entry.Value,
+		Version: entry.Version,
+	}
+	// Blobs get a field of their own, so they are not read back as JSON objects.
+	if blob, ok := entry.Value.(Blob); ok {
+		record.Value, record.Blob = nil, &blob
+	}
+	if !entry.ExpiresAt.IsZero() {
+		record.ExpiresAt = entry.ExpiresAt.UnixNano()
+	}
+	return record
+}
+
+func deleteRecord(key string, version int64) walRecord {
+	return walRecord{Op: walOpDelete, Key: key, Version: version}
+}
+
+func floorRecord(floor int64) walRecord {
+	return walRecord{Op: walOpFloor, Version: floor}
+}
+
+func txRecord(records []walRecord) walRecord {
+	return walRecord{Op: walOpTx, Ops: records}
+}
+
+func appendFrame(buf []byte, record walRecord) ([]byte, error) {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode log record for key %q: %w", record.Key, err)
+	}
+
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(payload)))
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.Checksum(payload, crcTable))
+	return append(buf, payload...), nil
+}
+
+// readFrames calls fn for every intact record of the file and returns the offset
+// right after the last one. A short or corrupt record stops the read without error,
+// leaving the caller to decide whether a torn tail is acceptable.
+func readFrames(path string, fn func(walRecord)) (int64, bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, false, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	header := make([]byte, walHeaderSize)
+
+	var offset int64
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return offset, !errors.Is(err, io.EOF), nil
+		}
+
+		size := binary.LittleEndian.Uint32(header)
+		if size > walMaxRecord {
+			return offset, true, nil
+		}
+
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			return offset, true, nil
+		}
+
+		if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(header[4:]) {
+			return offset, true, nil
+		}
+
+		var record walRecord
+		if err := json.Unmarshal(payload, &record); err != nil {
+			return offset, true, nil
+		}
+
+		fn(record)
+		offset += walHeaderSize + int64(size)
+	}
+}
+
+// recoverMemory rebuilds the keyspace, the collections and the version floor from the newest snapshot and the log
+// segments written after it. A torn record at the end of the last segment, left by a crash in the
+// middle of a write, is truncated. It also returns the sequence number of the segment new writes
+// must go to.
+func recoverMemory(dir string) (map[string]Entry, *memoryCollections, int64, uint64, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, nil, 0, 0, fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	snapshots, segments, err := listDataFiles(dir)
+	if err != nil {
+		return nil, nil, 0, 0, err
+	}
+
+	entries := make(map[string]Entry)
+	collections := newMemoryCollections()
+	var floor int64
+	apply := func(record walRecord) {
+		applyRecord(entries, collections, &floor, record)
+	}
+
+	var base uint64
+	if len(snapshots) > 0 {
+		base = snapshots[len(snapshots)-1]
+		if _, torn, err := readFrames(snapshotPath(dir, base), apply); err != nil || torn {
+			return nil, nil, 0, 0, fmt.Errorf("snapshot %d: %w", base, errors.Join(ErrCorruptLog, err))
+		}
+	}
+
+	segments = slices.DeleteFunc(segments, func(seq uint64) bool { return seq < base })
+	for i, seq := range segments {
+		path := segmentPath(dir, seq)
+
+		offset, torn, err := readFrames(path, apply)
+		if err != nil {
+			return nil, nil, 0, 0, err
+		}
+
+		if torn {
+			if i != len(segments)-1 {
+				return nil, nil, 0, 0, fmt.Errorf("segment %d: %w", seq, ErrCorruptLog)
+			}
+			if err := os.Truncate(path, offset); err != nil {
+				return nil, nil, 0, 0, fmt.Errorf("failed to truncate torn record: %w", err)
+			}
+		}
+	}
+
+	next := max(base, 1)
+	if len(segments) > 0 {
+		next = segments[len(segments)-1]
+	}
+
+	now := time.Now()
+	for key, entry := range entries {
+		if isExpired(entry.ExpiresAt, now) {
+			floor = max(floor, entry.Version)
+			delete(entries, key)
+		}
+	}
+
+	return entries, collections, floor, next, nil
+}
+
+func applyRecord(entries map[string]Entry, collections *memoryCollections, floor *int64, record walRecord) {
+	switch record.Op {
+	case walOpSet:
+		entry := Entry{
+			Value:   record.Value,
+			Version: record.Version,
+		}
+		if record.Blob != nil {
+			entry.Value = *record.Blob
+		}
+		if record.ExpiresAt != 0 {
+			entry.ExpiresAt = time.Unix(0, record.ExpiresAt)
+		}
+		entries[record.Key] = entry
+	case walOpDelete:
+		// Logs written before deletes carried their version are covered by the deleted entry.
+		*floor = max(*floor, record.Version, entries[record.Key].Version)
+		delete(entries, record.Key)
+	case walOpFloor:
+		*floor = max(*floor, record.Version)
+	case walOpTx:
+		for _, op := range record.Ops {
+			applyRecord(entries, collections, floor, op)
+		}
+	case walOpCollection, walOpListPop, walOpDeleteCollections:
+		collections.apply(record)
+	}
+}
+
+// writeSnapshot atomically writes the version floor, every entry and the records creating every
+// collection as the snapshot for segment seq, then removes the snapshots and segments it supersedes.
+func writeSnapshot(dir string, seq uint64, entries map[string]Entry, collections []walRecord, floor int64) error {
+	path := snapshotPath(dir, seq)
+	tmp := path + tmpSuffix
+
+	file, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot: %w", err)
+	}
+
+	writer := bufio.NewWriter(file)
+	var buf []byte
+	write := func(record walRecord) error {
+		var err error
+		if buf, err = appendFrame(buf[:0], record); err != nil {
+			return err
+		}
+		_, err = writer.Write(buf)
+		return err
+	}
+
+	err = write(floorRecord(floor))
+	for key, entry := range entries {
+		if err != nil {
+			break
+		}
+		err = write(setRecord(key, entry))
+	}
+	for _, record := range collections {
+		if err != nil {
+			break
+		}
+		err = write(record)
+	}
+
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+
+	return removeDataFilesBefore(dir, seq)
+}
+
+func removeDataFilesBefore(dir string, seq uint64) error {
+	snapshots, segments, err := listDataFiles(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, old := range snapshots {
+		if old < seq {
+			_ = os.Remove(snapshotPath(dir, old))
+		}
+	}
+	for _, old := range segments {
+		if old < seq {
+			_ = os.Remove(segmentPath(dir, old))
+		}
+	}
+
+	return nil
+}
+
+// listDataFiles returns the sequence numbers of the snapshots and log segments in dir, in ascending order.
+func listDataFiles(dir string) (snapshots, segments []uint64, err error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read data directory: %w", err)
+	}
+
+	for _, file := range files {
+		name := file.Name()
+		if seq, ok := parseSequence(name, snapshotPrefix, snapshotSuffix); ok {
+			snapshots = append(snapshots, seq)
+		}
+		if seq, ok := parseSequence(name, walPrefix, walSuffix); ok {
+			segments = append(segments, seq)
+		}
+	}
+
+	slices.Sort(snapshots)
+	slices.Sort(segments)
+
+	return snapshots, segments, nil
+}
+
+func parseSequence(name, prefix, suffix string) (uint64, bool) {
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+		return 0, false
+	}
+
+	seq, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, prefix), suffix), 10, 64)
+	return seq, err == nil
+}
+
+func segmentPath(dir string, seq uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%s%020d%s", walPrefix, seq, walSuffix))
+}
+
+func snapshotPath(dir string, seq uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%s%020d%s", snapshotPrefix, seq, snapshotSuffix))
+}
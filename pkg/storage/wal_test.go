@@ -0,0 +1,260 @@
+package storage_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/felipeascari/kv-store/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryPersistence(t *testing.T) {
+	ctx := context.Background()
+
+	open := func(t *testing.T, dir string) *storage.Memory {
+		t.Helper()
+		store, err := storage.OpenMemory(storage.MemoryOptions{}, storage.PersistenceOptions{
+			Dir:   dir,
+			Fsync: storage.FsyncAlways,
+		})
+		require.NoError(t, err)
+		return store
+	}
+
+	t.Run("should recover writes after restart", func(t *testing.T) {
+		dir := t.TempDir()
+
+		store := open(t, dir)
+		_, err := store.Save(ctx, "a", "1", 0)
+		require.NoError(t, err)
+		_, err = store.Save(ctx, "a", "2", 0)
+		require.NoError(t, err)
+		_, err = store.Save(ctx, "b", map[string]any{"name": "Alice"}, time.Hour)
+		require.NoError(t, err)
+		_, err = store.Save(ctx, "c", "3", 0)
+		require.NoError(t, err)
+		require.NoError(t, store.Delete(ctx, "c"))
+		require.NoError(t, store.Close())
+
+		store = open(t, dir)
+		defer func() { _ = store.Close() }()
+
+		entry, err := store.Retrieve(ctx, "a")
+		require.NoError(t, err)
+		require.Equal(t, "2", entry.Value)
+		require.Equal(t, int64(2), entry.Version)
+
+		entry, err = store.Retrieve(ctx, "b")
+		require.NoError(t, err)
+		require.Equal(t, map[string]any{"name": "Alice"}, entry.Value)
+		require.InDelta(t, time.Hour, entry.TTL(), float64(time.Minute))
+
+		_, err = store.Retrieve(ctx, "c")
+		require.ErrorIs(t, err, storage.ErrKeyNotFound)
+	})
+
+	t.Run("should recover transactions", func(t *testing.T) {
+		dir := t.TempDir()
+
+		store := open(t, dir)
+		_, err := store.Save(ctx, "a", "1", 0)
+		require.NoError(t, err)
+		_, err = store.Transact(ctx, storage.Transaction{
+			Ops: []storage.TxOp{
+				{Type: storage.TxSet, Key: "b", Value: "2"},
+				{Type: storage.TxDelete, Key: "a"},
+			},
+		})
+		require.NoError(t, err)
+		require.NoError(t, store.Close())
+
+		store = open(t, dir)
+		defer func() { _ = store.Close() }()
+
+		_, err = store.Retrieve(ctx, "a")
+		require.ErrorIs(t, err, storage.ErrKeyNotFound)
+
+		entry, err := store.Retrieve(ctx, "b")
+		require.NoError(t, err)
+		require.Equal(t, "2", entry.Value)
+	})
+
+	t.Run("should recover blobs", func(t *testing.T) {
+		dir := t.TempDir()
+		blob := storage.Blob{ContentType: "image/png", Data: []byte{0x89, 'P', 'N', 'G', 0x00, 0xff}}
+
+		store := open(t, dir)
+		_, err := store.Save(ctx, "image", blob, 0)
+		require.NoError(t, err)
+		require.NoError(t, store.Snapshot())
+		_, err = store.Save(ctx, "empty", storage.Blob{ContentType: "text/plain"}, 0)
+		require.NoError(t, err)
+		require.NoError(t, store.Close())
+
+		store = open(t, dir)
+		defer func() { _ = store.Close() }()
+
+		entry, err := store.Retrieve(ctx, "image")
+		require.NoError(t, err)
+		require.Equal(t, blob, entry.Value)
+
+		entry, err = store.Retrieve(ctx, "empty")
+		require.NoError(t, err)
+		require.Equal(t, "text/plain", entry.Value.(storage.Blob).ContentType)
+		require.Empty(t, entry.Value.(storage.Blob).Data)
+	})
+
+	t.Run("should tolerate a torn final record", func(t *testing.T) {
+		dir := t.TempDir()
+
+		store := open(t, dir)
+		_, err := store.Save(ctx, "a", "1", 0)
+		require.NoError(t, err)
+		require.NoError(t, store.Close())
+
+		segments, err := filepath.Glob(filepath.Join(dir, "wal-*.log"))
+		require.NoError(t, err)
+		require.Len(t, segments, 1)
+
+		file, err := os.OpenFile(segments[0], os.O_WRONLY|os.O_APPEND, 0o644)
+		require.NoError(t, err)
+		_, err = file.Write([]byte{0x20, 0x00, 0x00, 0x00, 0x01, 0x02})
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+
+		store = open(t, dir)
+		entry, err := store.Retrieve(ctx, "a")
+		require.NoError(t, err)
+		require.Equal(t, "1", entry.Value)
+
+		_, err = store.Save(ctx, "b", "2", 0)
+		require.NoError(t, err)
+		require.NoError(t, store.Close())
+
+		store = open(t, dir)
+		defer func() { _ = store.Close() }()
+
+		entry, err = store.Retrieve(ctx, "b")
+		require.NoError(t, err)
+		require.Equal(t, "2", entry.Value)
+	})
+
+	t.Run("should compact the log into a snapshot", func(t *testing.T) {
+		dir := t.TempDir()
+
+		store := open(t, dir)
+		for range 10 {
+			_, err := store.Save(ctx, "counter", "value", 0)
+			require.NoError(t, err)
+		}
+		require.NoError(t, store.Snapshot())
+
+		_, err := store.Save(ctx, "after", "snapshot", 0)
+		require.NoError(t, err)
+		require.NoError(t, store.Close())
+
+		segments, err := filepath.Glob(filepath.Join(dir, "wal-*.log"))
+		require.NoError(t, err)
+		require.Len(t, segments, 1)
+
+		snapshots, err := filepath.Glob(filepath.Join(dir, "snapshot-*.dat"))
+		require.NoError(t, err)
+		require.Len(t, snapshots, 1)
+
+		store = open(t, dir)
+		defer func() { _ = store.Close() }()
+
+		entry, err := store.Retrieve(ctx, "counter")
+		require.NoError(t, err)
+		require.Equal(t, int64(10), entry.Version)
+
+		entry, err = store.Retrieve(ctx, "after")
+		require.NoError(t, err)
+		require.Equal(t, "snapshot", entry.Value)
+	})
+
+	t.Run("should not reuse versions of deleted keys after restart", func(t *testing.T) {
+		dir := t.TempDir()
+
+		store := open(t, dir)
+		for range 3 {
+			_, err := store.Save(ctx, "gone", "value", 0)
+			require.NoError(t, err)
+		}
+		require.NoError(t, store.Delete(ctx, "gone"))
+		require.NoError(t, store.Close())
+
+		store = open(t, dir)
+		require.NoError(t, store.Snapshot())
+		require.NoError(t, store.Close())
+
+		store = open(t, dir)
+		defer func() { _ = store.Close() }()
+
+		version, err := store.Save(ctx, "gone", "again", 0)
+		require.NoError(t, err)
+		require.Equal(t, int64(4), version)
+	})
+
+	t.Run("should recover collections after restart and snapshot", func(t *testing.T) {
+		dir := t.TempDir()
+
+		store := open(t, dir)
+		_, err := store.HashSet(ctx, "user", map[string]any{"name": "Alice", "age": float64(30)})
+		require.NoError(t, err)
+		_, err = store.ListPush(ctx, "queue", []any{"a", "b", "c"})
+		require.NoError(t, err)
+		_, err = store.ListPop(ctx, "queue")
+		require.NoError(t, err)
+		_, err = store.SetAdd(ctx, "tags", []string{"x", "y"})
+		require.NoError(t, err)
+		_, err = store.SortedSetAdd(ctx, "ranking", []storage.ScoredMember{{Member: "bob", Score: 2}})
+		require.NoError(t, err)
+		_, err = store.SetAdd(ctx, "gone:tags", []string{"z"})
+		require.NoError(t, err)
+		_, err = store.DeleteCollections(ctx, "gone:")
+		require.NoError(t, err)
+		require.NoError(t, store.Close())
+
+		check := func(store *storage.Memory) {
+			fields, err := store.HashGetAll(ctx, "user")
+			require.NoError(t, err)
+			require.Equal(t, map[string]any{"name": "Alice", "age": float64(30)}, fields)
+
+			values, err := store.ListRange(ctx, "queue", 0, -1)
+			require.NoError(t, err)
+			require.Equal(t, []any{"c", "b"}, values)
+
+			members, err := store.SetMembers(ctx, "tags")
+			require.NoError(t, err)
+			require.Equal(t, []string{"x", "y"}, members)
+
+			ranking, err := store.SortedSetRange(ctx, "ranking", 0, -1)
+			require.NoError(t, err)
+			require.Equal(t, []storage.ScoredMember{{Member: "bob", Score: 2}}, ranking)
+
+			members, err = store.SetMembers(ctx, "gone:tags")
+			require.NoError(t, err)
+			require.Empty(t, members)
+
+			require.Equal(t, int64(4), store.Stats().Keys)
+		}
+
+		store = open(t, dir)
+		check(store)
+		require.NoError(t, store.Snapshot())
+		require.NoError(t, store.Close())
+
+		store = open(t, dir)
+		defer func() { _ = store.Close() }()
+		check(store)
+	})
+
+	t.Run("should reject unknown fsync policy", func(t *testing.T) {
+		_, err := storage.OpenMemory(storage.MemoryOptions{}, storage.PersistenceOptions{Dir: t.TempDir(), Fsync: "sometimes"})
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,276 @@
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/felipeascari/kv-store/pkg/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// DefaultChannel is the pub/sub channel instances share their changes on.
+	DefaultChannel = "kv-store:watch"
+
+	// expiredChannel is where Redis announces expired keys when notify-keyspace-events
+	// includes "Ex", followed by the database number.
+	expiredChannel = "__keyevent@%d__:expired"
+
+	// revisionKey is the counter revisions are taken from.
+	revisionKey = "kv-store:watch:revision"
+	// expiredKeyPrefix holds one short-lived key per reported expiration, so the
+	// expiration of a key seen by every instance is published once.
+	expiredKeyPrefix = "kv-store:watch:expired:"
+	expiredDedupTTL  = 5 * time.Second
+
+	// resubscribeDelay throttles the subscriber while Redis is unreachable.
+	resubscribeDelay = time.Second
+)
+
+// publishScript takes the next revision and publishes the change with it in one step, so
+// changes reach the subscribers in revision order. The change is sent as its revision, a
+// colon and its JSON encoding. When a dedup key is given, the change is only published by
+// the first instance reporting it.
+var publishScript = redis.NewScript(`
+if KEYS[2] and not redis.call('SET', KEYS[2], 1, 'NX', 'PX', ARGV[3]) then
+	return 0
+end
+
+local revision = redis.call('INCR', KEYS[1])
+redis.call('PUBLISH', ARGV[1], revision .. ':' .. ARGV[2])
+return revision
+`)
+
+type (
+	// redisBroker shares the changes of every instance through Redis pub/sub.
+	redisBroker struct {
+		bus     *Bus
+		client  *redis.Client
+		pubsub  *redis.PubSub
+		channel string
+		expired string
+		// keyring encrypts the values sent, nil to send them in plaintext.
+		keyring *storage.Keyring
+		stop    chan struct{}
+		wg      sync.WaitGroup
+	}
+
+	// wireEvent is the JSON encoding of an Event. Blob values are sent as their content
+	// type and bytes, as they are not JSON.
+	wireEvent struct {
+		Type        EventType       `json:"type"`
+		Namespace   string          `json:"namespace,omitempty"`
+		Key         string          `json:"key"`
+		Value       json.RawMessage `json:"value,omitempty"`
+		ContentType string          `json:"content_type,omitempty"`
+		Data        []byte          `json:"data,omitempty"`
+		Version     int64           `json:"version,omitempty"`
+		ExpiresAt   time.Time       `json:"expires_at,omitzero"`
+		Timestamp   time.Time       `json:"timestamp"`
+	}
+)
+
+// NewRedisBus returns a bus sharing changes and revisions with the other instances using
+// the same Redis channel, see Options.Channel. It also publishes the expirations Redis
+// announces, which requires notify-keyspace-events to include "Ex". It returns once
+// subscribed, so no change published afterwards is missed. Changes published before the
+// bus started cannot be resumed from this instance.
+func NewRedisBus(client *redis.Client, opts Options) (*Bus, error) {
+	if opts.Channel == "" {
+		opts.Channel = DefaultChannel
+	}
+	expired := fmt.Sprintf(expiredChannel, client.Options().DB)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pubsub := client.Subscribe(ctx, opts.Channel, expired)
+	for range 2 {
+		if _, err := pubsub.Receive(ctx); err != nil {
+			_ = pubsub.Close()
+			return nil, err
+		}
+	}
+
+	// Changes published from now on are queued on the subscription, so the counter read
+	// afterwards is at most the revision of the first one.
+	revision, err := client.Get(ctx, revisionKey).Int64()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		_ = pubsub.Close()
+		return nil, err
+	}
+
+	b := newBus(opts)
+	b.reset(revision)
+
+	broker := &redisBroker{
+		bus:     b,
+		client:  client,
+		pubsub:  pubsub,
+		channel: opts.Channel,
+		expired: expired,
+		keyring: opts.Keyring,
+		stop:    make(chan struct{}),
+	}
+	b.publish = broker.publish
+	b.close = broker.close
+
+	broker.wg.Add(1)
+	go func() {
+		defer broker.wg.Done()
+		broker.subscribe()
+	}()
+
+	return b, nil
+}
+
+// publish sends a change to every instance. Expirations are reported by every instance
+// watching Redis, so they are deduplicated by key.
+func (r *redisBroker) publish(ctx context.Context, e Event) error {
+	payload, err := r.encodeEvent(e)
+	if err != nil {
+		return err
+	}
+
+	keys := []string{revisionKey}
+	if e.Type == EventExpire {
+		keys = append(keys, expiredKeyPrefix+e.Namespace+":"+e.Key)
+	}
+	return publishScript.Run(ctx, r.client, keys, r.channel, payload, expiredDedupTTL.Milliseconds()).Err()
+}
+
+// subscribe delivers the changes of every instance to the bus, and publishes the
+// expirations announced by Redis, until the broker is closed. Changes published while
+// the connection is down are lost, the bus notices the gap in revisions with the next change.
+func (r *redisBroker) subscribe() {
+	ctx := context.Background()
+
+	for {
+		msg, err := r.pubsub.Receive(ctx)
+		if err != nil {
+			select {
+			case <-r.stop:
+				return
+			case <-time.After(resubscribeDelay):
+			}
+			continue
+		}
+
+		message, ok := msg.(*redis.Message)
+		if !ok {
+			continue
+		}
+
+		if message.Channel == r.expired {
+			if e, ok := ExpireEvent(message.Payload); ok {
+				e.Timestamp = time.Now()
+				if err := r.publish(ctx, e); err != nil {
+					r.bus.failed(e, err)
+				}
+			}
+			continue
+		}
+
+		e, err := r.decodeEvent(message.Payload)
+		if err != nil {
+			continue
+		}
+
+		r.bus.mu.Lock()
+		r.bus.deliver(e)
+		r.bus.mu.Unlock()
+	}
+}
+
+func (r *redisBroker) close() error {
+	close(r.stop)
+	err := r.pubsub.Close()
+	r.wg.Wait()
+	return err
+}
+
+// encodeEvent returns the JSON encoding of a change, with its value sealed when the broker has
+// a keyring.
+func (r *redisBroker) encodeEvent(e Event) (string, error) {
+	wire := wireEvent{
+		Type:      e.Type,
+		Namespace: e.Namespace,
+		Key:       e.Key,
+		Version:   e.Version,
+		ExpiresAt: e.ExpiresAt,
+		Timestamp: e.Timestamp,
+	}
+
+	if r.keyring != nil && e.Value != nil {
+		sealed, err := r.keyring.SealValue(eventKey(e), e.Value)
+		if err != nil {
+			return "", err
+		}
+		e.Value = sealed
+	}
+
+	if blob, ok := e.Value.(storage.Blob); ok {
+		wire.ContentType, wire.Data = blob.ContentType, blob.Data
+	} else if e.Value != nil {
+		value, err := json.Marshal(e.Value)
+		if err != nil {
+			return "", err
+		}
+		wire.Value = value
+	}
+
+	payload, err := json.Marshal(wire)
+	return string(payload), err
+}
+
+func (r *redisBroker) decodeEvent(payload string) (Event, error) {
+	prefix, data, ok := strings.Cut(payload, ":")
+	if !ok {
+		return Event{}, errors.New("malformed watch event")
+	}
+
+	revision, err := strconv.ParseInt(prefix, 10, 64)
+	if err != nil {
+		return Event{}, err
+	}
+
+	var wire wireEvent
+	if err := json.Unmarshal([]byte(data), &wire); err != nil {
+		return Event{}, err
+	}
+
+	e := Event{
+		Revision:  revision,
+		Type:      wire.Type,
+		Namespace: wire.Namespace,
+		Key:       wire.Key,
+		Version:   wire.Version,
+		ExpiresAt: wire.ExpiresAt,
+		Timestamp: wire.Timestamp,
+	}
+	switch {
+	case wire.ContentType == storage.EncryptedContentType && r.keyring != nil:
+		blob := storage.Blob{ContentType: wire.ContentType, Data: wire.Data}
+		if e.Value, err = r.keyring.OpenValue(eventKey(e), blob); err != nil {
+			return Event{}, err
+		}
+	case wire.ContentType != "":
+		e.Value = storage.Blob{ContentType: wire.ContentType, Data: wire.Data}
+	case wire.Value != nil:
+		if err := json.Unmarshal(wire.Value, &e.Value); err != nil {
+			return Event{}, err
+		}
+	}
+	return e, nil
+}
+
+// eventKey is the key the value of a change is sealed for, so it only opens for the same key.
+func eventKey(e Event) string {
+	return e.Namespace + ":" + e.Key
+}
@@ -0,0 +1,156 @@
+//go:build integration
+
+package watch_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/felipeascari/kv-store/pkg/storage"
+	"github.com/felipeascari/kv-store/pkg/watch"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+func TestRedisBus(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	ctx := context.Background()
+	client, cleanup := setupRedis(t, ctx)
+	defer cleanup()
+
+	all := func(watch.Event) bool { return true }
+
+	t.Run("should share changes and revisions between instances", func(t *testing.T) {
+		first, err := watch.NewRedisBus(client, watch.Options{Channel: "test:watch"})
+		require.NoError(t, err)
+		defer func() { _ = first.Close() }()
+		second, err := watch.NewRedisBus(client, watch.Options{Channel: "test:watch"})
+		require.NoError(t, err)
+		defer func() { _ = second.Close() }()
+
+		sub := second.Subscribe(all)
+		defer sub.Close()
+
+		blob := storage.Blob{ContentType: "image/png", Data: []byte{0x89, 'P', 'N', 'G'}}
+		first.Publish(ctx, watch.PutEvent(ctx, "config", storage.Entry{Value: map[string]any{"retries": 3.0}, Version: 2}))
+		first.Publish(ctx, watch.PutEvent(ctx, "avatar", storage.Entry{Value: blob, Version: 1}))
+		second.Publish(ctx, watch.DeleteEvent(ctx, "config"))
+
+		put := receive(t, sub)
+		require.Equal(t, "config", put.Key)
+		require.Equal(t, map[string]any{"retries": 3.0}, put.Value)
+		require.Equal(t, int64(2), put.Version)
+		require.Equal(t, blob, receive(t, sub).Value)
+		deleted := receive(t, sub)
+		require.Equal(t, watch.EventDelete, deleted.Type)
+		require.Equal(t, put.Revision+2, deleted.Revision)
+
+		resumed, err := first.Resume(put.Revision, all)
+		require.NoError(t, err)
+		defer resumed.Close()
+		require.Equal(t, "avatar", receive(t, resumed).Key)
+		require.Equal(t, "config", receive(t, resumed).Key)
+	})
+
+	t.Run("should publish expirations once", func(t *testing.T) {
+		require.NoError(t, client.ConfigSet(ctx, "notify-keyspace-events", "Ex").Err())
+
+		first, err := watch.NewRedisBus(client, watch.Options{Channel: "test:expire"})
+		require.NoError(t, err)
+		defer func() { _ = first.Close() }()
+		second, err := watch.NewRedisBus(client, watch.Options{Channel: "test:expire"})
+		require.NoError(t, err)
+		defer func() { _ = second.Close() }()
+
+		sub := first.Subscribe(watch.Matcher("", "session"))
+		defer sub.Close()
+
+		require.NoError(t, client.Set(ctx, "session", "x", 50*time.Millisecond).Err())
+
+		e := receive(t, sub)
+		require.Equal(t, watch.EventExpire, e.Type)
+		require.Equal(t, "session", e.Key)
+
+		select {
+		case e := <-sub.Events():
+			require.Failf(t, "expiration published twice", "%+v", e)
+		case <-time.After(200 * time.Millisecond):
+		}
+	})
+
+	t.Run("should encrypt values on the channel", func(t *testing.T) {
+		keyring, err := storage.NewKeyring("k", map[string][]byte{"k": bytes.Repeat([]byte{1}, storage.EncryptionKeySize)})
+		require.NoError(t, err)
+
+		bus, err := watch.NewRedisBus(client, watch.Options{Channel: "test:encrypted", Keyring: keyring})
+		require.NoError(t, err)
+		defer func() { _ = bus.Close() }()
+
+		sub := bus.Subscribe(all)
+		defer sub.Close()
+		raw := client.Subscribe(ctx, "test:encrypted")
+		defer func() { _ = raw.Close() }()
+		_, err = raw.Receive(ctx)
+		require.NoError(t, err)
+
+		bus.Publish(ctx, watch.PutEvent(ctx, "user", storage.Entry{Value: "ada@example.com", Version: 1}))
+
+		msg, err := raw.ReceiveMessage(ctx)
+		require.NoError(t, err)
+		require.NotContains(t, msg.Payload, "ada@example.com")
+		require.Equal(t, "ada@example.com", receive(t, sub).Value)
+	})
+
+	t.Run("should count the changes it fails to publish", func(t *testing.T) {
+		other := redis.NewClient(client.Options())
+		bus, err := watch.NewRedisBus(other, watch.Options{Channel: "test:failures"})
+		require.NoError(t, err)
+		defer func() { _ = bus.Close() }()
+
+		require.NoError(t, other.Close())
+		bus.Publish(ctx, watch.DeleteEvent(ctx, "config"))
+
+		require.Equal(t, uint64(1), bus.Stats().Failures)
+	})
+}
+
+func setupRedis(t *testing.T, ctx context.Context) (*redis.Client, func()) {
+	t.Helper()
+
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        "redis:7-alpine",
+			ExposedPorts: []string{"6379/tcp"},
+			WaitingFor:   wait.ForLog("Ready to accept connections"),
+		},
+		Started: true,
+	})
+	require.NoError(t, err)
+
+	host, err := container.Host(ctx)
+	require.NoError(t, err)
+
+	port, err := container.MappedPort(ctx, "6379")
+	require.NoError(t, err)
+
+	client := redis.NewClient(&redis.Options{
+		Addr: host + ":" + port.Port(),
+	})
+
+	err = client.Ping(ctx).Err()
+	require.NoError(t, err)
+
+	cleanup := func() {
+		_ = client.Close()
+		_ = container.Terminate(ctx)
+	}
+
+	return client, cleanup
+}
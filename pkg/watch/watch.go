@@ -0,0 +1,349 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/felipeascari/kv-store/pkg/logger"
+	"github.com/felipeascari/kv-store/pkg/storage"
+	"go.uber.org/zap"
+)
+
+// Every change published on a bus gets a revision, one more than the change before it.
+// Buses keep the last changes in memory, so a watcher that reconnects can resume after
+// the last revision it saw as long as the changes it missed are still kept.
+const (
+	EventPut    EventType = "put"
+	EventDelete EventType = "delete"
+	EventExpire EventType = "expire"
+
+	// DefaultHistory is the number of changes kept for resuming watchers when none is set.
+	DefaultHistory = 1000
+	// DefaultBuffer is the number of changes a watcher can fall behind when none is set.
+	DefaultBuffer = 256
+)
+
+var (
+	// ErrCompacted is returned when resuming after a revision whose following changes are
+	// no longer kept. The watcher should read the keys again and watch from the current revision.
+	ErrCompacted = errors.New("revision is no longer available")
+	// ErrLagging closes the subscriptions of watchers that do not keep up with the changes.
+	ErrLagging = errors.New("watcher fell behind")
+	// ErrClosed closes the subscriptions of a bus when it is closed.
+	ErrClosed = errors.New("watch bus closed")
+)
+
+type (
+	EventType string
+
+	// Event is a change to a key. Value, Version and ExpiresAt are only set for puts.
+	Event struct {
+		Revision  int64
+		Type      EventType
+		Namespace string
+		Key       string
+		Value     any
+		Version   int64
+		ExpiresAt time.Time
+		Timestamp time.Time
+	}
+
+	// Publisher is where the use cases announce the changes they make.
+	Publisher interface {
+		// Publish announces a change. It is best effort: the change is already made, and
+		// watchers that miss one see a gap in revisions and are asked to resume. A change
+		// that cannot be published at all gets no revision and is never seen by watchers,
+		// the bus logs it and counts it in its Stats.
+		Publish(ctx context.Context, e Event)
+	}
+
+	// Options configures a Bus.
+	Options struct {
+		// History is the number of changes kept for resuming watchers. Defaults to DefaultHistory.
+		History int
+		// Buffer is the number of changes a watcher can fall behind before it is dropped
+		// with ErrLagging. Defaults to DefaultBuffer.
+		Buffer int
+		// Channel is the pub/sub channel of a Redis bus. Defaults to DefaultChannel.
+		Channel string
+		// Keyring encrypts the values a Redis bus sends over pub/sub, as the store does.
+		// Instances sharing a channel must share the keyring.
+		Keyring *storage.Keyring
+	}
+
+	// Stats describes the changes published on a bus.
+	Stats struct {
+		// Revision is the revision of the last change delivered to this instance.
+		Revision int64
+		// Failures counts the changes this instance could not publish.
+		Failures uint64
+	}
+
+	// Bus fans the changes out to the watchers of this instance. Changes are delivered by
+	// a broker, which assigns their revisions: the bus itself for a single instance, or Redis
+	// pub/sub to share changes and revisions between instances.
+	Bus struct {
+		mu sync.Mutex
+		// events holds the kept changes, in revision order.
+		events  []Event
+		history int
+		buffer  int
+		// revision is the last revision delivered and compacted the last one not kept.
+		revision    int64
+		compacted   int64
+		subscribers map[*Subscription]struct{}
+		closed      bool
+		failures    atomic.Uint64
+
+		publish func(ctx context.Context, e Event) error
+		close   func() error
+	}
+
+	// Subscription receives the changes matching a watch until it is closed.
+	Subscription struct {
+		events chan Event
+		match  func(Event) bool
+		// after skips the changes a resuming watcher has already seen.
+		after int64
+		bus   *Bus
+		err   error
+	}
+)
+
+// NewBus returns a bus for a single instance, assigning revisions itself.
+func NewBus(opts Options) *Bus {
+	b := newBus(opts)
+	b.publish = func(_ context.Context, e Event) error {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		e.Revision = b.revision + 1
+		b.deliver(e)
+		return nil
+	}
+	return b
+}
+
+func newBus(opts Options) *Bus {
+	if opts.History <= 0 {
+		opts.History = DefaultHistory
+	}
+	if opts.Buffer <= 0 {
+		opts.Buffer = DefaultBuffer
+	}
+
+	return &Bus{
+		history:     opts.History,
+		buffer:      opts.Buffer,
+		subscribers: make(map[*Subscription]struct{}),
+		close:       func() error { return nil },
+	}
+}
+
+// PutEvent returns the change of a write to key, in the namespace of ctx.
+func PutEvent(ctx context.Context, key string, entry storage.Entry) Event {
+	return Event{
+		Type:      EventPut,
+		Namespace: storage.NamespaceFromContext(ctx),
+		Key:       key,
+		Value:     entry.Value,
+		Version:   entry.Version,
+		ExpiresAt: entry.ExpiresAt,
+	}
+}
+
+// DeleteEvent returns the change of a delete of key, in the namespace of ctx.
+func DeleteEvent(ctx context.Context, key string) Event {
+	return Event{Type: EventDelete, Namespace: storage.NamespaceFromContext(ctx), Key: key}
+}
+
+// ExpireEvent returns the change of the expiration of a stored key, and false for the
+// store's own bookkeeping keys, which are not watched.
+func ExpireEvent(stored string) (Event, bool) {
+	namespace, key, ok := storage.LocalKey(stored)
+	if !ok {
+		return Event{}, false
+	}
+	return Event{Type: EventExpire, Namespace: namespace, Key: key}, true
+}
+
+// Matcher returns a match for the changes of the keys of namespace starting with prefix.
+func Matcher(namespace, prefix string) func(Event) bool {
+	return func(e Event) bool {
+		return e.Namespace == namespace && strings.HasPrefix(e.Key, prefix)
+	}
+}
+
+func (b *Bus) Publish(ctx context.Context, e Event) {
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now()
+	}
+	if err := b.publish(context.WithoutCancel(ctx), e); err != nil {
+		b.failed(e, err)
+	}
+}
+
+// Stats returns the revision of this instance and how many changes it failed to publish.
+func (b *Bus) Stats() Stats {
+	return Stats{Revision: b.Revision(), Failures: b.failures.Load()}
+}
+
+// Revision returns the revision of the last change delivered to this instance.
+func (b *Bus) Revision() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.revision
+}
+
+// Subscribe watches the changes matching match from now on.
+func (b *Bus) Subscribe(match func(Event) bool) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.subscribe(match, b.revision, nil)
+}
+
+// Resume watches the changes matching match after the given revision, starting with the
+// kept ones. It fails with ErrCompacted when changes after revision are no longer kept.
+func (b *Bus) Resume(after int64, match func(Event) bool) (*Subscription, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if after < b.compacted {
+		return nil, ErrCompacted
+	}
+
+	var replay []Event
+	for _, e := range b.events {
+		if e.Revision > after && match(e) {
+			replay = append(replay, e)
+		}
+	}
+	return b.subscribe(match, after, replay), nil
+}
+
+// Close ends every subscription with ErrClosed and stops the broker.
+func (b *Bus) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	for s := range b.subscribers {
+		b.drop(s, ErrClosed)
+	}
+	b.mu.Unlock()
+
+	return b.close()
+}
+
+// failed accounts for a change that could not be published, which no watcher will see.
+func (b *Bus) failed(e Event, err error) {
+	b.failures.Add(1)
+	if log := logger.Logger(); log != nil {
+		log.Warn("failed to publish change",
+			zap.String("type", string(e.Type)),
+			zap.String("namespace", e.Namespace),
+			zap.String("key", e.Key),
+			zap.Error(err),
+		)
+	}
+}
+
+// subscribe registers a subscription starting with replay. Callers must hold b.mu.
+func (b *Bus) subscribe(match func(Event) bool, after int64, replay []Event) *Subscription {
+	s := &Subscription{
+		events: make(chan Event, len(replay)+b.buffer),
+		match:  match,
+		after:  after,
+		bus:    b,
+	}
+	for _, e := range replay {
+		s.events <- e
+	}
+
+	if b.closed {
+		s.err = ErrClosed
+		close(s.events)
+		return s
+	}
+	b.subscribers[s] = struct{}{}
+	return s
+}
+
+// deliver keeps a change and hands it to the matching subscribers. A change that skips
+// revisions means the broker lost changes: they cannot be replayed, so every subscriber
+// is dropped to resume and fail with ErrCompacted. Callers must hold b.mu.
+func (b *Bus) deliver(e Event) {
+	if e.Revision <= b.revision {
+		return
+	}
+	if e.Revision > b.revision+1 {
+		b.reset(e.Revision - 1)
+	}
+
+	if len(b.events) == b.history {
+		b.compacted = b.events[0].Revision
+		b.events = append(b.events[:0], b.events[1:]...)
+	}
+	b.events = append(b.events, e)
+	b.revision = e.Revision
+
+	for s := range b.subscribers {
+		if e.Revision <= s.after || !s.match(e) {
+			continue
+		}
+		select {
+		case s.events <- e:
+		default:
+			b.drop(s, ErrLagging)
+		}
+	}
+}
+
+// reset forgets the kept changes and starts over at revision, as when a broker cannot tell
+// which changes it missed. Callers must hold b.mu.
+func (b *Bus) reset(revision int64) {
+	b.events = b.events[:0]
+	b.revision = revision
+	b.compacted = revision
+	for s := range b.subscribers {
+		b.drop(s, ErrCompacted)
+	}
+}
+
+// drop ends a subscription with err. Callers must hold b.mu.
+func (b *Bus) drop(s *Subscription, err error) {
+	if _, ok := b.subscribers[s]; !ok {
+		return
+	}
+	delete(b.subscribers, s)
+	s.err = err
+	close(s.events)
+}
+
+// Events returns the changes of the subscription. The channel is closed when the
+// subscription ends, see Err.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Revision returns the revision the subscription starts after.
+func (s *Subscription) Revision() int64 {
+	return s.after
+}
+
+// Err returns why the subscription ended, once Events is closed. It is nil if it was closed by Close.
+func (s *Subscription) Err() error {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	return s.err
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	s.bus.drop(s, nil)
+}
@@ -0,0 +1,111 @@
+package watch_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/felipeascari/kv-store/pkg/storage"
+	"github.com/felipeascari/kv-store/pkg/watch"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBus(t *testing.T) {
+	ctx := context.Background()
+	all := func(watch.Event) bool { return true }
+
+	t.Run("should deliver matching changes in revision order", func(t *testing.T) {
+		bus := watch.NewBus(watch.Options{})
+		defer func() { _ = bus.Close() }()
+
+		sub := bus.Subscribe(watch.Matcher("", "config:"))
+		defer sub.Close()
+
+		bus.Publish(ctx, watch.PutEvent(ctx, "config:a", storage.Entry{Value: "one", Version: 1}))
+		bus.Publish(ctx, watch.PutEvent(ctx, "other", storage.Entry{Value: "two", Version: 1}))
+		bus.Publish(storage.WithNamespace(ctx, "billing"), watch.DeleteEvent(storage.WithNamespace(ctx, "billing"), "config:a"))
+		bus.Publish(ctx, watch.DeleteEvent(ctx, "config:a"))
+
+		e := receive(t, sub)
+		require.Equal(t, int64(1), e.Revision)
+		require.Equal(t, watch.EventPut, e.Type)
+		require.Equal(t, "one", e.Value)
+		require.False(t, e.Timestamp.IsZero())
+
+		e = receive(t, sub)
+		require.Equal(t, int64(4), e.Revision)
+		require.Equal(t, watch.EventDelete, e.Type)
+		require.Equal(t, int64(4), bus.Revision())
+	})
+
+	t.Run("should resume after a revision", func(t *testing.T) {
+		bus := watch.NewBus(watch.Options{History: 3})
+		defer func() { _ = bus.Close() }()
+
+		for _, key := range []string{"a", "b", "c", "d"} {
+			bus.Publish(ctx, watch.DeleteEvent(ctx, key))
+		}
+
+		sub, err := bus.Resume(2, all)
+		require.NoError(t, err)
+		defer sub.Close()
+		require.Equal(t, int64(2), sub.Revision())
+
+		require.Equal(t, "c", receive(t, sub).Key)
+		require.Equal(t, "d", receive(t, sub).Key)
+
+		bus.Publish(ctx, watch.DeleteEvent(ctx, "e"))
+		require.Equal(t, "e", receive(t, sub).Key)
+
+		_, err = bus.Resume(0, all)
+		require.ErrorIs(t, err, watch.ErrCompacted)
+	})
+
+	t.Run("should drop watchers that fall behind", func(t *testing.T) {
+		bus := watch.NewBus(watch.Options{Buffer: 2})
+		defer func() { _ = bus.Close() }()
+
+		sub := bus.Subscribe(all)
+		for _, key := range []string{"a", "b", "c"} {
+			bus.Publish(ctx, watch.DeleteEvent(ctx, key))
+		}
+
+		receive(t, sub)
+		receive(t, sub)
+		_, ok := <-sub.Events()
+		require.False(t, ok)
+		require.ErrorIs(t, sub.Err(), watch.ErrLagging)
+	})
+
+	t.Run("should end subscriptions when closed", func(t *testing.T) {
+		bus := watch.NewBus(watch.Options{})
+		sub := bus.Subscribe(all)
+		require.NoError(t, bus.Close())
+
+		_, ok := <-sub.Events()
+		require.False(t, ok)
+		require.ErrorIs(t, sub.Err(), watch.ErrClosed)
+	})
+
+	t.Run("should report expirations of the keys only", func(t *testing.T) {
+		e, ok := watch.ExpireEvent("kv-store:ns:billing:config")
+		require.True(t, ok)
+		require.Equal(t, watch.Event{Type: watch.EventExpire, Namespace: "billing", Key: "config"}, e)
+
+		_, ok = watch.ExpireEvent("kv-store:history:config")
+		require.False(t, ok)
+	})
+}
+
+func receive(t *testing.T, sub *watch.Subscription) watch.Event {
+	t.Helper()
+
+	select {
+	case e, ok := <-sub.Events():
+		require.True(t, ok, "subscription ended: %v", sub.Err())
+		return e
+	case <-time.After(time.Second):
+		require.FailNow(t, "no event received")
+		return watch.Event{}
+	}
+}